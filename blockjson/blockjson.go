@@ -0,0 +1,189 @@
+// Package blockjson marshals Stellar blocks to JSON with their XDR
+// decoded: transaction envelopes and results, events, and the ledger
+// entry changes of the TransactionMeta when it is given. It is what
+// tool-decode-block prints. `firecore tools print` does not print the
+// same: firehose-core v1.14 has no hook for a chain to register JSON
+// marshalers with it, so it prints the XDR of a block as bytes.
+//
+// FIXME(go-json-experiment): like tool-decode-block, this package uses
+// the old names of the Oct 2023 snapshot firehose-core still pins
+// (NewMarshalers, MarshalFuncV2), see cmd/firestellar/tool_decode_block.go.
+package blockjson
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"github.com/stellar/go-stellar-sdk/xdr"
+	"github.com/streamingfast/firehose-stellar/decoder"
+	pbstellar "github.com/streamingfast/firehose-stellar/pb/sf/stellar/type/v1"
+	"github.com/streamingfast/firehose-stellar/toid"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Options tune the output of Marshalers.
+type Options struct {
+	// StripNonDeterministic drops the diagnostic events that differ
+	// between two runs of the same ledger, see
+	// utils.IsNonDeterministicDiagnosticEvent, so that blocks from two
+	// fetchers print the same.
+	StripNonDeterministic bool
+
+	// Metas holds the TransactionMeta of the transactions by hex-encoded
+	// hash. When not nil, the ledger entry changes of each transaction
+	// are printed under its Meta, and a transaction missing from it
+	// fails the marshaling.
+	Metas map[string]xdr.TransactionMeta
+}
+
+// Marshalers returns the JSON marshalers of the types of a block.
+func Marshalers(options Options) *json.Marshalers {
+	return json.NewMarshalers(
+		valueMarshalers,
+		json.MarshalFuncV2(func(e *jsontext.Encoder, value *pbstellar.Transaction, _ json.Options) error {
+			return marshalTransaction(e, value, options)
+		}),
+	)
+}
+
+// valueMarshalers are the marshalers of the XDR values in a decoded
+// transaction.
+var valueMarshalers = json.NewMarshalers(
+	json.MarshalFuncV2(marshalBytes),
+	json.MarshalFuncV2(marshalAccountId),
+	json.MarshalFuncV2(marshalMuxedAccount),
+	json.MarshalFuncV2(marshalScVal),
+	json.MarshalFuncV2(marshalScAddress),
+	json.MarshalFuncV2(marshalContractId),
+)
+
+// Marshal marshals block with Marshalers(options).
+func Marshal(block *pbstellar.Block, options Options, jsonOptions ...json.Options) ([]byte, error) {
+	return json.Marshal(block, append([]json.Options{json.WithMarshalers(Marshalers(options))}, jsonOptions...)...)
+}
+
+func marshalBytes(e *jsontext.Encoder, value []byte, options json.Options) error {
+	return e.WriteToken(jsontext.String(hex.EncodeToString(value[:])))
+}
+
+func marshalAccountId(e *jsontext.Encoder, value xdr.AccountId, options json.Options) error {
+	return e.WriteToken(jsontext.String(value.Address()))
+}
+
+func marshalMuxedAccount(e *jsontext.Encoder, value xdr.MuxedAccount, options json.Options) error {
+	return e.WriteToken(jsontext.String(value.Address()))
+}
+
+// DecodedTransaction is the JSON form of a pbstellar.Transaction.
+type DecodedTransaction struct {
+	Hash             []byte
+	Status           pbstellar.TransactionStatus
+	CreatedAt        *timestamppb.Timestamp
+	ApplicationOrder uint64
+	// Toid and OperationToids are the Horizon ids of the transaction
+	// and of its operations, in envelope order. Contract events carry
+	// their stellar-rpc id in Events.
+	Toid           string
+	OperationToids []string
+	EnvelopeXdr    *xdr.TransactionEnvelope
+	ResultXdr      *xdr.TransactionResult
+	Events         *DecodedEvents
+	Meta           *DecodedMeta `json:",omitempty"`
+}
+
+// DecodedMeta holds the ledger entry changes of a transaction, rendered
+// by the decoder package.
+type DecodedMeta struct {
+	Before     []string
+	Operations [][]string
+	After      []string
+}
+
+func marshalTransaction(e *jsontext.Encoder, value *pbstellar.Transaction, options Options) error {
+	xdrDecoder := decoder.NewDecoder(zap.NewNop())
+
+	transactionEnvelope, err := xdrDecoder.DecodeTransactionEnvelopeFromBytes(value.EnvelopeXdr)
+	if err != nil {
+		return fmt.Errorf("unable to decode transaction envelope: %w", err)
+	}
+
+	transactionResult, err := xdrDecoder.DecodeTransactionResultFromBytes(value.ResultXdr)
+	if err != nil {
+		return fmt.Errorf("unable to decode transaction result: %w", err)
+	}
+
+	id, err := toid.FromUint64(value.Toid)
+	if err != nil {
+		return fmt.Errorf("invalid transaction id: %w", err)
+	}
+	operationToids := make([]string, len(transactionEnvelope.Operations()))
+	for i := range operationToids {
+		operationID, err := toid.Operation(id.LedgerSequence, id.TransactionOrder, i)
+		if err != nil {
+			return fmt.Errorf("operation %d id: %w", i, err)
+		}
+		operationToids[i] = operationID.String()
+	}
+
+	events, err := decodeEvents(value.Events, options.StripNonDeterministic)
+	if err != nil {
+		return fmt.Errorf("transaction %x events: %w", value.Hash, err)
+	}
+	for i, operationEvents := range events.Contract {
+		for j, event := range operationEvents {
+			eventID, err := toid.Event(id.LedgerSequence, id.TransactionOrder, uint32(i), uint32(j))
+			if err != nil {
+				return fmt.Errorf("event %d of operation %d id: %w", j, i, err)
+			}
+			event.Id = eventID.String()
+		}
+	}
+
+	trx := &DecodedTransaction{
+		Hash:             value.Hash,
+		Status:           value.Status,
+		CreatedAt:        value.CreatedAt,
+		ApplicationOrder: value.ApplicationOrder,
+		Toid:             id.String(),
+		OperationToids:   operationToids,
+		EnvelopeXdr:      transactionEnvelope,
+		ResultXdr:        transactionResult,
+		Events:           events,
+	}
+
+	if options.Metas != nil {
+		meta, found := options.Metas[hex.EncodeToString(value.Hash)]
+		if !found {
+			return fmt.Errorf("no meta for transaction %x", value.Hash)
+		}
+		if trx.Meta, err = decodeMeta(meta); err != nil {
+			return fmt.Errorf("transaction %x meta: %w", value.Hash, err)
+		}
+	}
+
+	out, err := json.Marshal(trx, json.WithMarshalers(valueMarshalers))
+	if err != nil {
+		return fmt.Errorf("unable to marshal: %w", err)
+	}
+
+	return e.WriteValue(out)
+}
+
+func decodeMeta(meta xdr.TransactionMeta) (*DecodedMeta, error) {
+	changes, err := decoder.TransactionMetaChanges(meta)
+	if err != nil {
+		return nil, err
+	}
+	decoded := &DecodedMeta{
+		Before:     decoder.RenderLedgerEntryChanges(changes.Before),
+		Operations: make([][]string, len(changes.Operations)),
+		After:      decoder.RenderLedgerEntryChanges(changes.After),
+	}
+	for i, operationChanges := range changes.Operations {
+		decoded.Operations[i] = decoder.RenderLedgerEntryChanges(operationChanges)
+	}
+	return decoded, nil
+}
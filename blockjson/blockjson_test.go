@@ -0,0 +1,203 @@
+package blockjson
+
+import (
+	"encoding/base64"
+	stdjson "encoding/json"
+	"testing"
+
+	"github.com/go-json-experiment/json"
+	"github.com/stellar/go-stellar-sdk/strkey"
+	"github.com/stellar/go-stellar-sdk/xdr"
+	pbstellar "github.com/streamingfast/firehose-stellar/pb/sf/stellar/type/v1"
+	"github.com/streamingfast/firehose-stellar/toid"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testEnvelope = "AAAAAgAAAADyvToLvJe06EXPqSQgBWS0Rr++8SCKj8AlBdBDyKGwBQAPQkAAAADHAAAAXQAAAAEAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAEAAAABAAAAABB90WssODNIgi6BHveqzxTRmIpvAFRyVNM+Hm2GVuCcAAAAAAAAAABmkq+rlsv67UKM7Q84yVjMbo6QHeQfZqz28PRJN8VcNAAAABdIdugAAAAAAAAAAALIobAFAAAAQNHROVl4rVJucGsenB+iXDjIcTqsh9UIelxGuou+N55kNiFXlj6MQc7eUSjBj3cYXlwVDycLNZlSZTHqh0b+MQ2GVuCcAAAAQH1XAOqFZxkpsTiPPcg2J0A/BI96Wpp+8OBa69Gaxri0xQ4pLdn6x6D5YrhDLY1gLQdBhi3gpf5LLO5XLw0Aawc="
+	testResult   = "AAAAAAAB87QAAAABMRrsYI1BEqwI4GO6w/9gAfK09viIbnzgeXRwnzHcn/YAAAAAAAHy7AAAAAAAAAABAAAAAAAAABgAAAAAzpZL2MoXXhiy0nNuPZ2ek5xivqUtxs8FlWwhTfxytwsAAAAAAAAAAA=="
+	testAccount  = "GAAZI4TCR3TY5OJHCTJC2A4QSY6CJWJH5IAJTGKIN2ER7LBNVKOCCWN7"
+	testContract = "CA3D5KRYM6CB7OWQ6TWYRR3Z4T7GNZLKERYNZGGA5SOAOPIFY6YQGAXE"
+)
+
+func symbol(name string) xdr.ScVal {
+	sym := xdr.ScSymbol(name)
+	return xdr.ScVal{Type: xdr.ScValTypeScvSymbol, Sym: &sym}
+}
+
+func u64(value uint64) xdr.ScVal {
+	v := xdr.Uint64(value)
+	return xdr.ScVal{Type: xdr.ScValTypeScvU64, U64: &v}
+}
+
+func contractEvent(t *testing.T, topics []xdr.ScVal, data xdr.ScVal) xdr.ContractEvent {
+	raw, err := strkey.Decode(strkey.VersionByteContract, testContract)
+	require.NoError(t, err)
+	var id xdr.ContractId
+	copy(id[:], raw)
+	return xdr.ContractEvent{
+		ContractId: &id,
+		Type:       xdr.ContractEventTypeContract,
+		Body:       xdr.ContractEventBody{V0: &xdr.ContractEventV0{Topics: topics, Data: data}},
+	}
+}
+
+func mustMarshalBinary(t *testing.T, value interface{ MarshalBinary() ([]byte, error) }) []byte {
+	out, err := value.MarshalBinary()
+	require.NoError(t, err)
+	return out
+}
+
+func testTransaction(t *testing.T) *pbstellar.Transaction {
+	envelope, err := base64.StdEncoding.DecodeString(testEnvelope)
+	require.NoError(t, err)
+	result, err := base64.StdEncoding.DecodeString(testResult)
+	require.NoError(t, err)
+	id, err := toid.Transaction(100, 1)
+	require.NoError(t, err)
+
+	account := xdr.MustAddress(testAccount)
+	accountAddress := xdr.ScVal{Type: xdr.ScValTypeScvAddress, Address: &xdr.ScAddress{Type: xdr.ScAddressTypeScAddressTypeAccount, AccountId: &account}}
+	amount := xdr.ScVal{Type: xdr.ScValTypeScvI128, I128: &xdr.Int128Parts{Lo: 1000}}
+	transfer := contractEvent(t, []xdr.ScVal{symbol("transfer"), accountAddress}, amount)
+
+	invokeTime := xdr.DiagnosticEvent{Event: contractEvent(t, []xdr.ScVal{symbol("core_metrics"), symbol("invoke_time_nsecs")}, u64(12345))}
+	invokeTime.Event.Type = xdr.ContractEventTypeDiagnostic
+	cpu := xdr.DiagnosticEvent{Event: contractEvent(t, []xdr.ScVal{symbol("core_metrics"), symbol("cpu_insns")}, u64(42))}
+	cpu.Event.Type = xdr.ContractEventTypeDiagnostic
+	cpu.InSuccessfulContractCall = true
+
+	fee := xdr.TransactionEvent{Stage: xdr.TransactionEventStageTransactionEventStageAfterTx, Event: contractEvent(t, []xdr.ScVal{symbol("fee")}, amount)}
+
+	return &pbstellar.Transaction{
+		Hash:             []byte{0xaa},
+		Status:           pbstellar.TransactionStatus_SUCCESS,
+		ApplicationOrder: 1,
+		Toid:             id.Uint64(),
+		EnvelopeXdr:      envelope,
+		ResultXdr:        result,
+		Events: &pbstellar.Events{
+			DiagnosticEventsXdr:  [][]byte{mustMarshalBinary(t, invokeTime), mustMarshalBinary(t, cpu)},
+			TransactionEventsXdr: [][]byte{mustMarshalBinary(t, fee)},
+			ContractEventsXdr:    []*pbstellar.ContractEvent{{Events: [][]byte{mustMarshalBinary(t, transfer)}}},
+		},
+	}
+}
+
+// decodedEvents marshals a block of trx with options and returns the
+// events of trx.
+func decodedEvents(t *testing.T, trx *pbstellar.Transaction, options Options) map[string]any {
+	out, err := Marshal(&pbstellar.Block{Number: 100, Transactions: []*pbstellar.Transaction{trx}}, options)
+	require.NoError(t, err)
+
+	var block struct {
+		Transactions []map[string]any
+	}
+	require.NoError(t, stdjson.Unmarshal(out, &block))
+	require.Len(t, block.Transactions, 1)
+	return block.Transactions[0]["Events"].(map[string]any)
+}
+
+func toJSON(t *testing.T, value any) string {
+	out, err := stdjson.Marshal(value)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func Test_Marshal_Events(t *testing.T) {
+	events := decodedEvents(t, testTransaction(t), Options{})
+
+	require.JSONEq(t, `[[{
+		"Id": "0000000429496733696-0000000000",
+		"Type": "contract",
+		"ContractId": "`+testContract+`",
+		"Topics": [{"symbol": "transfer"}, {"address": "`+testAccount+`"}],
+		"Data": {"i128": "1000"}
+	}]]`, toJSON(t, events["Contract"]))
+
+	require.JSONEq(t, `[{
+		"Stage": "after_tx",
+		"Event": {"Type": "contract", "ContractId": "`+testContract+`", "Topics": [{"symbol": "fee"}], "Data": {"i128": "1000"}}
+	}]`, toJSON(t, events["Transaction"]))
+
+	diagnostic := events["Diagnostic"].([]any)
+	require.Len(t, diagnostic, 2)
+	require.JSONEq(t, `{
+		"InSuccessfulContractCall": true,
+		"Event": {"Type": "diagnostic", "ContractId": "`+testContract+`", "Topics": [{"symbol": "core_metrics"}, {"symbol": "cpu_insns"}], "Data": {"u64": "42"}}
+	}`, toJSON(t, diagnostic[1]))
+}
+
+func Test_Marshal_Ids(t *testing.T) {
+	// Horizon's transaction 12884905984 and its operation 12884905985,
+	// the example of the Horizon paging docs, at ledger 3.
+	trx := testTransaction(t)
+	id, err := toid.Transaction(3, 1)
+	require.NoError(t, err)
+	trx.Toid = id.Uint64()
+
+	out, err := Marshal(&pbstellar.Block{Number: 3, Transactions: []*pbstellar.Transaction{trx}}, Options{})
+	require.NoError(t, err)
+	require.Contains(t, string(out), `"Toid":"12884905984","OperationToids":["12884905985"]`)
+
+	// The sixth event of transaction 200 of ledger 55253347 on pubnet,
+	// as stellar-rpc getEvents ids it.
+	id, err = toid.Transaction(55253347, 200)
+	require.NoError(t, err)
+	trx.Toid = id.Uint64()
+	raw := trx.Events.ContractEventsXdr[0].Events[0]
+	for range 5 {
+		trx.Events.ContractEventsXdr[0].Events = append(trx.Events.ContractEventsXdr[0].Events, raw)
+	}
+
+	events := decodedEvents(t, trx, Options{})
+	contract := events["Contract"].([]any)[0].([]any)
+	require.Len(t, contract, 6)
+	require.Equal(t, "0237311318360358912-0000000000", contract[0].(map[string]any)["Id"])
+	require.Equal(t, "0237311318360358912-0000000005", contract[5].(map[string]any)["Id"])
+	require.NotContains(t, toJSON(t, events["Diagnostic"]), `"Id"`)
+}
+
+func Test_Marshal_StripNonDeterministic(t *testing.T) {
+	events := decodedEvents(t, testTransaction(t), Options{StripNonDeterministic: true})
+
+	diagnostic := events["Diagnostic"].([]any)
+	require.Len(t, diagnostic, 1)
+	require.Equal(t, "cpu_insns", diagnostic[0].(map[string]any)["Event"].(map[string]any)["Topics"].([]any)[1].(map[string]any)["symbol"])
+}
+
+func Test_Marshal_Meta(t *testing.T) {
+	trx := testTransaction(t)
+
+	_, err := Marshal(&pbstellar.Block{Transactions: []*pbstellar.Transaction{trx}}, Options{Metas: map[string]xdr.TransactionMeta{}})
+	require.ErrorContains(t, err, "no meta for transaction aa")
+
+	out, err := Marshal(&pbstellar.Block{Transactions: []*pbstellar.Transaction{trx}}, Options{Metas: map[string]xdr.TransactionMeta{
+		"aa": {V: 3, V3: &xdr.TransactionMetaV3{Operations: []xdr.OperationMeta{{}}}},
+	}})
+	require.NoError(t, err)
+	require.Contains(t, string(out), `"Meta":{"Before":[],"Operations":[[]],"After":[]}`)
+}
+
+func Test_MarshalScVal(t *testing.T) {
+	str := xdr.ScString("rent")
+	bytes := xdr.ScBytes{0xca, 0xfe}
+	vec := &xdr.ScVec{u64(1), {Type: xdr.ScValTypeScvVoid}}
+	scMap := &xdr.ScMap{{Key: symbol("memo"), Val: xdr.ScVal{Type: xdr.ScValTypeScvString, Str: &str}}}
+	code := xdr.ScErrorCodeScecInvalidAction
+
+	for _, test := range []struct {
+		value    xdr.ScVal
+		expected string
+	}{
+		{xdr.ScVal{Type: xdr.ScValTypeScvBytes, Bytes: &bytes}, `{"bytes":"cafe"}`},
+		{xdr.ScVal{Type: xdr.ScValTypeScvVec, Vec: &vec}, `{"vec":[{"u64":"1"},{"void":null}]}`},
+		{xdr.ScVal{Type: xdr.ScValTypeScvMap, Map: &scMap}, `{"map":[{"key":{"symbol":"memo"},"val":{"string":"rent"}}]}`},
+		{xdr.ScVal{Type: xdr.ScValTypeScvError, Error: &xdr.ScError{Type: xdr.ScErrorTypeSceWasmVm, Code: &code}}, `{"error":{"wasm_vm":"invalid_action"}}`},
+	} {
+		decoded := &DecodedEvent{Type: "contract", Topics: []xdr.ScVal{test.value}, Data: test.value}
+		out, err := json.Marshal(decoded, json.WithMarshalers(valueMarshalers))
+		require.NoError(t, err)
+		require.JSONEq(t, `{"Type":"contract","Topics":[`+test.expected+`],"Data":`+test.expected+`}`, string(out))
+	}
+}
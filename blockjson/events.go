@@ -0,0 +1,101 @@
+package blockjson
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stellar/go-stellar-sdk/xdr"
+	pbstellar "github.com/streamingfast/firehose-stellar/pb/sf/stellar/type/v1"
+	"github.com/streamingfast/firehose-stellar/utils"
+)
+
+// DecodedEvents are the events of a transaction: the contract events of
+// each of its operations, its diagnostic events and its transaction
+// events, like fees, in the order of pbstellar.Events.
+type DecodedEvents struct {
+	Contract    [][]*DecodedEvent
+	Diagnostic  []*DecodedDiagnosticEvent
+	Transaction []*DecodedTransactionEvent
+}
+
+// DecodedEvent is a decoded xdr.ContractEvent. Its topics and data
+// marshal as JSON ScVals, its contract id as a C... strkey. Id is the
+// stellar-rpc id of contract events, see toid.EventID, empty for
+// diagnostic and transaction events.
+type DecodedEvent struct {
+	Id         string `json:",omitempty"`
+	Type       string
+	ContractId *xdr.ContractId `json:",omitempty"`
+	Topics     []xdr.ScVal
+	Data       xdr.ScVal
+}
+
+type DecodedDiagnosticEvent struct {
+	InSuccessfulContractCall bool
+	Event                    *DecodedEvent
+}
+
+type DecodedTransactionEvent struct {
+	Stage string
+	Event *DecodedEvent
+}
+
+func decodeEvents(events *pbstellar.Events, stripNonDeterministic bool) (*DecodedEvents, error) {
+	decoded := &DecodedEvents{}
+	if events == nil {
+		return decoded, nil
+	}
+
+	decoded.Contract = make([][]*DecodedEvent, len(events.ContractEventsXdr))
+	for i, operationEvents := range events.ContractEventsXdr {
+		decoded.Contract[i] = make([]*DecodedEvent, len(operationEvents.Events))
+		for j, raw := range operationEvents.Events {
+			var event xdr.ContractEvent
+			if err := event.UnmarshalBinary(raw); err != nil {
+				return nil, fmt.Errorf("decoding contract event %d of operation %d: %w", j, i, err)
+			}
+			decoded.Contract[i][j] = decodeEvent(event)
+		}
+	}
+
+	for i, raw := range events.DiagnosticEventsXdr {
+		if stripNonDeterministic && utils.IsNonDeterministicDiagnosticEventBytes(raw) {
+			continue
+		}
+		var event xdr.DiagnosticEvent
+		if err := event.UnmarshalBinary(raw); err != nil {
+			return nil, fmt.Errorf("decoding diagnostic event %d: %w", i, err)
+		}
+		decoded.Diagnostic = append(decoded.Diagnostic, &DecodedDiagnosticEvent{
+			InSuccessfulContractCall: event.InSuccessfulContractCall,
+			Event:                    decodeEvent(event.Event),
+		})
+	}
+
+	for i, raw := range events.TransactionEventsXdr {
+		var event xdr.TransactionEvent
+		if err := event.UnmarshalBinary(raw); err != nil {
+			return nil, fmt.Errorf("decoding transaction event %d: %w", i, err)
+		}
+		decoded.Transaction = append(decoded.Transaction, &DecodedTransactionEvent{
+			Stage: snakeCase(strings.TrimPrefix(event.Stage.String(), "TransactionEventStageTransactionEventStage")),
+			Event: decodeEvent(event.Event),
+		})
+	}
+
+	return decoded, nil
+}
+
+func decodeEvent(event xdr.ContractEvent) *DecodedEvent {
+	decoded := &DecodedEvent{
+		Type:       snakeCase(strings.TrimPrefix(event.Type.String(), "ContractEventType")),
+		ContractId: event.ContractId,
+		Topics:     []xdr.ScVal{},
+		Data:       xdr.ScVal{Type: xdr.ScValTypeScvVoid},
+	}
+	if body := event.Body.V0; body != nil {
+		decoded.Topics = body.Topics
+		decoded.Data = body.Data
+	}
+	return decoded
+}
@@ -0,0 +1,152 @@
+package blockjson
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"github.com/stellar/go-stellar-sdk/strkey"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// A ScVal marshals as an object with a single member named after its
+// type, like the JSON of stellar-xdr: {"symbol":"transfer"},
+// {"i128":"-5"}, {"vec":[...]}, {"map":[{"key":...,"val":...}]}.
+// Integers of 64 bits and more, timepoints and durations included, are
+// decimal strings, bytes are hex, addresses are strkeys.
+
+type scMapEntryJSON struct {
+	Key xdr.ScVal `json:"key"`
+	Val xdr.ScVal `json:"val"`
+}
+
+type scContractInstanceJSON struct {
+	Executable any              `json:"executable"`
+	Storage    []scMapEntryJSON `json:"storage,omitempty"`
+}
+
+func marshalScVal(e *jsontext.Encoder, value xdr.ScVal, options json.Options) error {
+	var name string
+	var member any
+	switch value.Type {
+	case xdr.ScValTypeScvBool:
+		name, member = "bool", *value.B
+	case xdr.ScValTypeScvVoid:
+		name, member = "void", nil
+	case xdr.ScValTypeScvError:
+		name, member = "error", scErrorJSON(*value.Error)
+	case xdr.ScValTypeScvU32:
+		name, member = "u32", uint32(*value.U32)
+	case xdr.ScValTypeScvI32:
+		name, member = "i32", int32(*value.I32)
+	case xdr.ScValTypeScvU64:
+		name, member = "u64", strconv.FormatUint(uint64(*value.U64), 10)
+	case xdr.ScValTypeScvI64:
+		name, member = "i64", strconv.FormatInt(int64(*value.I64), 10)
+	case xdr.ScValTypeScvTimepoint:
+		name, member = "timepoint", strconv.FormatUint(uint64(*value.Timepoint), 10)
+	case xdr.ScValTypeScvDuration:
+		name, member = "duration", strconv.FormatUint(uint64(*value.Duration), 10)
+	case xdr.ScValTypeScvU128:
+		name, member = "u128", value.String()
+	case xdr.ScValTypeScvI128:
+		name, member = "i128", value.String()
+	case xdr.ScValTypeScvU256:
+		name, member = "u256", value.String()
+	case xdr.ScValTypeScvI256:
+		name, member = "i256", value.String()
+	case xdr.ScValTypeScvBytes:
+		name, member = "bytes", hex.EncodeToString(*value.Bytes)
+	case xdr.ScValTypeScvString:
+		name, member = "string", string(*value.Str)
+	case xdr.ScValTypeScvSymbol:
+		name, member = "symbol", string(*value.Sym)
+	case xdr.ScValTypeScvVec:
+		items := []xdr.ScVal{}
+		if *value.Vec != nil {
+			items = **value.Vec
+		}
+		name, member = "vec", items
+	case xdr.ScValTypeScvMap:
+		var entries xdr.ScMap
+		if *value.Map != nil {
+			entries = **value.Map
+		}
+		name, member = "map", scMapJSON(entries)
+	case xdr.ScValTypeScvAddress:
+		name, member = "address", *value.Address
+	case xdr.ScValTypeScvContractInstance:
+		instance := scContractInstanceJSON{Executable: "stellar_asset"}
+		if executable := value.Instance.Executable; executable.Type == xdr.ContractExecutableTypeContractExecutableWasm {
+			instance.Executable = map[string]string{"wasm": hex.EncodeToString(executable.WasmHash[:])}
+		}
+		if value.Instance.Storage != nil {
+			instance.Storage = scMapJSON(*value.Instance.Storage)
+		}
+		name, member = "contract_instance", instance
+	case xdr.ScValTypeScvLedgerKeyContractInstance:
+		name, member = "ledger_key_contract_instance", nil
+	case xdr.ScValTypeScvLedgerKeyNonce:
+		name, member = "ledger_key_nonce", map[string]string{"nonce": strconv.FormatInt(int64(value.NonceKey.Nonce), 10)}
+	default:
+		return fmt.Errorf("unknown ScVal type %d", value.Type)
+	}
+
+	out, err := json.Marshal(map[string]any{name: member}, options)
+	if err != nil {
+		return err
+	}
+	return e.WriteValue(out)
+}
+
+func scMapJSON(entries xdr.ScMap) []scMapEntryJSON {
+	out := make([]scMapEntryJSON, len(entries))
+	for i, entry := range entries {
+		out[i] = scMapEntryJSON{Key: entry.Key, Val: entry.Val}
+	}
+	return out
+}
+
+func scErrorJSON(scError xdr.ScError) map[string]any {
+	kind := snakeCase(strings.TrimPrefix(scError.Type.String(), "ScErrorTypeSce"))
+	if scError.Type == xdr.ScErrorTypeSceContract {
+		return map[string]any{kind: uint32(*scError.ContractCode)}
+	}
+	return map[string]any{kind: snakeCase(strings.TrimPrefix(scError.Code.String(), "ScErrorCodeScec"))}
+}
+
+func marshalScAddress(e *jsontext.Encoder, value xdr.ScAddress, options json.Options) error {
+	address, err := value.String()
+	if err != nil {
+		return err
+	}
+	return e.WriteToken(jsontext.String(address))
+}
+
+func marshalContractId(e *jsontext.Encoder, value xdr.ContractId, options json.Options) error {
+	address, err := strkey.Encode(strkey.VersionByteContract, value[:])
+	if err != nil {
+		return err
+	}
+	return e.WriteToken(jsontext.String(address))
+}
+
+// snakeCase turns the CamelCase name of an XDR enum value into the
+// snake_case of stellar-xdr's JSON, e.g. WasmVm into wasm_vm.
+func snakeCase(name string) string {
+	var out strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				out.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
@@ -5,8 +5,11 @@
 //  1. Fetcher — converter from xdr.LedgerCloseMeta to pbbstream.Block.
 //     Stateless apart from network passphrase + logger.
 //
-//  2. Backend — wraps *ledgerbackend.CaptiveStellarCore (the stellar-core
-//     subprocess) and offers PrepareRange / GetBlock / Close.
+//  2. Backend — wraps a ledgerbackend.LedgerBackend, the stellar-core
+//     subprocess (*ledgerbackend.CaptiveStellarCore) outside of tests,
+//     and offers PrepareRange / GetLedgerCloseMeta / GetBlock / Close.
+//     It is a source.LedgerSource, see Supervisor. Tests use the
+//     in-memory ledger backend from the ledgerbackendtest package.
 package captivecore
 
 import (
@@ -26,9 +29,14 @@ import (
 	"github.com/stellar/go-stellar-sdk/support/log"
 	"github.com/stellar/go-stellar-sdk/xdr"
 	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/firehose-stellar/metrics"
 	pbstellar "github.com/streamingfast/firehose-stellar/pb/sf/stellar/type/v1"
+	"github.com/streamingfast/firehose-stellar/protocol"
+	"github.com/streamingfast/firehose-stellar/toid"
+	"github.com/streamingfast/firehose-stellar/tracing"
 	"github.com/streamingfast/firehose-stellar/types"
 	"github.com/streamingfast/firehose-stellar/utils"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -48,6 +56,12 @@ type Config struct {
 	// one required.
 	HistoryArchiveURLs []string
 
+	// UnverifiedHistoryArchiveURLs are the archives VerifyNetwork could
+	// not read the network of. New reads it again and adds those of
+	// NetworkPassphrase to HistoryArchiveURLs, so that an archive down
+	// at startup is used from the next stellar-core start on.
+	UnverifiedHistoryArchiveURLs []string
+
 	// StellarCoreConfPath to a stellar-core.cfg on disk. If empty,
 	// DefaultTomlData must be set.
 	StellarCoreConfPath string
@@ -73,6 +87,11 @@ type Config struct {
 
 	// Logger receives high-level fetcher events. Required.
 	Logger *zap.Logger
+
+	// HaltOnUnsupportedProtocol makes GetBlock fail on a ledger whose
+	// protocol version is newer than protocol.MaxSupportedVersion,
+	// instead of converting it with a warning.
+	HaltOnUnsupportedProtocol bool
 }
 
 // ResolveNetwork fills NetworkPassphrase, HistoryArchiveURLs, and
@@ -128,10 +147,14 @@ func (c *Config) validate() error {
 	return nil
 }
 
-// Backend drives a stellar-core subprocess and converts each fetched
-// ledger to pbbstream.Block via the embedded Fetcher.
+// MetricsBackend labels the metrics of the captive-core backend.
+const MetricsBackend = "captive-core"
+
+// Backend drives a ledger backend, normally a stellar-core subprocess,
+// and converts each fetched ledger to pbbstream.Block via the embedded
+// Fetcher.
 type Backend struct {
-	core    *ledgerbackend.CaptiveStellarCore
+	core    ledgerbackend.LedgerBackend
 	fetcher *Fetcher
 	logger  *zap.Logger
 }
@@ -142,6 +165,7 @@ func New(cfg Config) (*Backend, error) {
 	if err := cfg.validate(); err != nil {
 		return nil, err
 	}
+	historyArchiveURLs := cfg.historyArchiveURLs(context.Background())
 
 	// Match what soroban-rpc emits so blocks are byte-equivalent across
 	// both fetchers. All three flags require stellar-core protocol >= 23:
@@ -151,7 +175,7 @@ func New(cfg Config) (*Backend, error) {
 	//   - EnforceSorobanTransactionMetaExtV1: extra Soroban meta ext.
 	params := ledgerbackend.CaptiveCoreTomlParams{
 		NetworkPassphrase:                  cfg.NetworkPassphrase,
-		HistoryArchiveURLs:                 cfg.HistoryArchiveURLs,
+		HistoryArchiveURLs:                 historyArchiveURLs,
 		CoreBinaryPath:                     cfg.BinaryPath,
 		EmitUnifiedEvents:                  true,
 		EnforceSorobanDiagnosticEvents:     true,
@@ -186,7 +210,7 @@ func New(cfg Config) (*Backend, error) {
 	core, err := ledgerbackend.NewCaptive(ledgerbackend.CaptiveCoreConfig{
 		BinaryPath:         cfg.BinaryPath,
 		NetworkPassphrase:  cfg.NetworkPassphrase,
-		HistoryArchiveURLs: cfg.HistoryArchiveURLs,
+		HistoryArchiveURLs: historyArchiveURLs,
 		StoragePath:        cfg.StoragePath,
 		Toml:               toml,
 		Log:                coreLogger,
@@ -195,11 +219,32 @@ func New(cfg Config) (*Backend, error) {
 		return nil, fmt.Errorf("captivecore: setting up captive-core backend: %w", err)
 	}
 
+	return newBackend(core, cfg), nil
+}
+
+// NewWithLedgerBackend constructs a Backend over core instead of a
+// stellar-core subprocess. Only NetworkPassphrase, Logger and
+// HaltOnUnsupportedProtocol of cfg are used. The Backend closes core.
+func NewWithLedgerBackend(core ledgerbackend.LedgerBackend, cfg Config) (*Backend, error) {
+	if cfg.NetworkPassphrase == "" {
+		return nil, errors.New("captivecore: NetworkPassphrase is required")
+	}
+	if cfg.Logger == nil {
+		return nil, errors.New("captivecore: Logger is required")
+	}
+	return newBackend(core, cfg), nil
+}
+
+func newBackend(core ledgerbackend.LedgerBackend, cfg Config) *Backend {
 	return &Backend{
-		core:    core,
-		fetcher: &Fetcher{NetworkPassphrase: cfg.NetworkPassphrase, Logger: cfg.Logger},
-		logger:  cfg.Logger,
-	}, nil
+		core: core,
+		fetcher: &Fetcher{
+			NetworkPassphrase: cfg.NetworkPassphrase,
+			Logger:            cfg.Logger,
+			Protocol:          protocol.NewGuard(MetricsBackend, cfg.HaltOnUnsupportedProtocol, cfg.Logger),
+		},
+		logger: cfg.Logger,
+	}
 }
 
 // PrepareRange launches stellar-core and catches up to startLedger.
@@ -212,32 +257,98 @@ func (b *Backend) PrepareRange(ctx context.Context, startLedger uint64) error {
 	if startLedger > math.MaxUint32 {
 		return fmt.Errorf("captivecore: start ledger %d exceeds stellar ledger sequence range (uint32)", startLedger)
 	}
+	if b.core == nil {
+		return errBackendClosed
+	}
 	b.logger.Info("captivecore preparing range", zap.Uint64("start_block", startLedger))
+	metrics.CaptiveCorePreparing.Set(1)
+	defer metrics.CaptiveCorePreparing.Set(0)
 	if err := b.core.PrepareRange(ctx, ledgerbackend.UnboundedRange(uint32(startLedger))); err != nil {
-		return fmt.Errorf("captivecore: prepare range from %d: %w", startLedger, err)
+		return &BackendError{Op: "prepare range from", Ledger: startLedger, Err: err}
 	}
 	b.logger.Info("captivecore range prepared")
 	return nil
 }
 
-// GetBlock returns one ledger as pbbstream.Block. Blocks until the
-// ledger is available or ctx fires.
-func (b *Backend) GetBlock(ctx context.Context, ledgerSeq uint64) (*pbbstream.Block, error) {
+// GetLedgerCloseMeta returns one ledger. Blocks until the ledger is
+// available or ctx fires. Waiting for and getting it are traced as
+// children of the span in ctx.
+func (b *Backend) GetLedgerCloseMeta(ctx context.Context, ledgerSeq uint64) (xdr.LedgerCloseMeta, error) {
 	if ledgerSeq > math.MaxUint32 {
-		return nil, fmt.Errorf("captivecore: ledger %d exceeds uint32", ledgerSeq)
+		return xdr.LedgerCloseMeta{}, fmt.Errorf("captivecore: ledger %d exceeds uint32", ledgerSeq)
 	}
-	meta, err := b.core.GetLedger(ctx, uint32(ledgerSeq))
+	if b.core == nil {
+		return xdr.LedgerCloseMeta{}, errBackendClosed
+	}
+
+	// stellar-core streams each ledger once it closes: reading one it
+	// has not closed yet is mostly waiting for it.
+	spanName := tracing.SpanGetLedger
+	if latest, err := b.core.GetLatestLedgerSequence(ctx); err == nil {
+		metrics.CaptiveCoreLatestLedger.Set(float64(latest))
+		if uint64(latest) < ledgerSeq {
+			spanName = tracing.SpanWaitForLedger
+		}
+	}
+
+	fetchStart := time.Now()
+	getCtx, getSpan := tracing.Start(ctx, spanName, ledgerSeq)
+	meta, err := b.core.GetLedger(getCtx, uint32(ledgerSeq))
+	tracing.End(getSpan, err)
 	if err != nil {
-		return nil, fmt.Errorf("captivecore: get ledger %d: %w", ledgerSeq, err)
+		return xdr.LedgerCloseMeta{}, &BackendError{Op: "get ledger", Ledger: ledgerSeq, Err: err}
 	}
+	metrics.FetchDuration.WithLabelValues(MetricsBackend).Observe(time.Since(fetchStart).Seconds())
+
+	return meta, nil
+}
+
+// GetBlock returns one ledger as pbbstream.Block, see
+// GetLedgerCloseMeta. Its steps are traced as children of the span in
+// ctx.
+func (b *Backend) GetBlock(ctx context.Context, ledgerSeq uint64) (*pbbstream.Block, error) {
+	meta, err := b.GetLedgerCloseMeta(ctx, ledgerSeq)
+	if err != nil {
+		return nil, err
+	}
+
+	convertStart := time.Now()
+	convertCtx, convertSpan := tracing.Start(ctx, tracing.SpanConvert, ledgerSeq)
 	blk, err := b.fetcher.ConvertLedgerCloseMetaToBstreamBlock(&meta)
 	if err != nil {
+		tracing.End(convertSpan, err)
 		return nil, fmt.Errorf("captivecore: convert ledger %d: %w", ledgerSeq, err)
 	}
+	// Only counted once converted: the count panics on a LedgerCloseMeta
+	// version the converter rejects.
+	tracing.SetTransactionCount(convertCtx, trace.SpanFromContext(ctx), meta.CountTransactions())
+	convertSpan.End()
+	metrics.ConvertDuration.WithLabelValues(MetricsBackend).Observe(time.Since(convertStart).Seconds())
+
 	return blk, nil
 }
 
-// Close terminates the stellar-core subprocess. Idempotent.
+var errBackendClosed = errors.New("captivecore: backend closed")
+
+// BackendError is a failure of the ledger backend itself, typically the
+// stellar-core subprocess exiting, as opposed to a ledger that does not
+// convert. A fresh backend may get past it, see Supervisor.
+type BackendError struct {
+	Op     string
+	Ledger uint64
+	Err    error
+}
+
+func (e *BackendError) Error() string {
+	return fmt.Sprintf("captivecore: %s %d: %s", e.Op, e.Ledger, e.Err)
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// Close terminates the stellar-core subprocess, or closes the ledger
+// backend. Idempotent.
 func (b *Backend) Close() error {
 	if b.core == nil {
 		return nil
@@ -249,34 +360,29 @@ func (b *Backend) Close() error {
 
 // Fetcher converts xdr.LedgerCloseMeta to the pbbstream.Block shape the
 // RPC fetcher emits. NetworkPassphrase is used to recompute tx hashes.
+// Protocol, when set, follows the protocol version of the converted
+// ledgers; without it only the LedgerCloseMeta version is checked.
 type Fetcher struct {
 	NetworkPassphrase string
 	Logger            *zap.Logger
+	Protocol          *protocol.Guard
 }
 
 // ConvertLedgerCloseMetaToBstreamBlock converts one ledger to a
 // pbbstream.Block.
 func (f *Fetcher) ConvertLedgerCloseMetaToBstreamBlock(ledgerMetadata *xdr.LedgerCloseMeta) (*pbbstream.Block, error) {
 	var ledgerHeader xdr.LedgerHeaderHistoryEntry
-	var ledgerSeq uint32
-	var ledgerHash xdr.Hash
-
-	switch {
-	case ledgerMetadata.V0 != nil:
-		ledgerHeader = ledgerMetadata.V0.LedgerHeader
-		ledgerSeq = uint32(ledgerHeader.Header.LedgerSeq)
-		ledgerHash = ledgerMetadata.V0.LedgerHeader.Hash
-	case ledgerMetadata.V1 != nil:
-		ledgerHeader = ledgerMetadata.V1.LedgerHeader
-		ledgerSeq = uint32(ledgerHeader.Header.LedgerSeq)
-		ledgerHash = ledgerMetadata.V1.LedgerHeader.Hash
-	case ledgerMetadata.V2 != nil:
-		ledgerHeader = ledgerMetadata.V2.LedgerHeader
-		ledgerSeq = uint32(ledgerHeader.Header.LedgerSeq)
-		ledgerHash = ledgerMetadata.V2.LedgerHeader.Hash
-	default:
-		return nil, fmt.Errorf("unsupported LedgerCloseMeta version")
+	var err error
+	if f.Protocol != nil {
+		ledgerHeader, err = f.Protocol.Check(ledgerMetadata)
+	} else {
+		ledgerHeader, err = protocol.LedgerHeader(ledgerMetadata)
 	}
+	if err != nil {
+		return nil, err
+	}
+	ledgerSeq := uint32(ledgerHeader.Header.LedgerSeq)
+	ledgerHash := ledgerHeader.Hash
 
 	ledgerCloseTime := int64(ledgerHeader.Header.ScpValue.CloseTime)
 
@@ -340,11 +446,17 @@ func (f *Fetcher) ConvertLedgerCloseMetaToBstreamBlock(ledgerMetadata *xdr.Ledge
 			events.ContractEventsXdr = contractEvents
 		}
 
+		id, err := toid.Transaction(ledgerSeq, uint32(i+1))
+		if err != nil {
+			return nil, fmt.Errorf("transaction %s id: %w", trx.TxHash, err)
+		}
+
 		stellarTransactions = append(stellarTransactions, &pbstellar.Transaction{
 			Hash:             txHashBytes,
 			Status:           utils.ConvertTransactionStatus(trx.Status),
 			CreatedAt:        timestamppb.New(time.Unix(ledgerCloseTime, 0)),
 			ApplicationOrder: uint64(i + 1),
+			Toid:             id.Uint64(),
 			EnvelopeXdr:      envelopeXdr,
 			ResultXdr:        resultXdr,
 			Events:           events,
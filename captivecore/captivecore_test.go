@@ -0,0 +1,206 @@
+package captivecore
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stellar/go-stellar-sdk/ingest/ledgerbackend"
+	"github.com/stellar/go-stellar-sdk/network"
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/firehose-stellar/captivecore/ledgerbackendtest"
+	"github.com/streamingfast/firehose-stellar/cursor"
+	pbstellar "github.com/streamingfast/firehose-stellar/pb/sf/stellar/type/v1"
+	"github.com/streamingfast/firehose-stellar/source"
+	"github.com/streamingfast/firehose-stellar/toid"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// recordedLedger is testnet ledger 519386, protocol 22, 6 transactions.
+const recordedLedger = 519386
+
+func newFakeCore(t *testing.T, count int) *ledgerbackendtest.Backend {
+	t.Helper()
+
+	recorded, err := ledgerbackendtest.Load("testdata/testnet-519386.xdr.b64")
+	require.NoError(t, err)
+	require.Len(t, recorded, 1)
+
+	metas, err := ledgerbackendtest.Chain(recorded[0], recordedLedger, count)
+	require.NoError(t, err)
+	return ledgerbackendtest.New(metas...)
+}
+
+func newTestBackend(t *testing.T, core ledgerbackend.LedgerBackend) *Backend {
+	t.Helper()
+
+	backend, err := NewWithLedgerBackend(core, Config{NetworkPassphrase: network.TestNetworkPassphrase, Logger: zap.NewNop()})
+	require.NoError(t, err)
+	return backend
+}
+
+func Test_Backend_GetBlock(t *testing.T) {
+	core := newFakeCore(t, 3)
+	backend := newTestBackend(t, core)
+	ctx := context.Background()
+
+	require.NoError(t, backend.PrepareRange(ctx, recordedLedger))
+	require.Equal(t, []ledgerbackend.Range{ledgerbackend.UnboundedRange(recordedLedger)}, core.Prepares())
+
+	previousID := ""
+	for seq := uint64(recordedLedger); seq < recordedLedger+3; seq++ {
+		blk, err := backend.GetBlock(ctx, seq)
+		require.NoError(t, err)
+		require.Equal(t, seq, blk.Number)
+		if previousID != "" {
+			require.Equal(t, previousID, blk.ParentId)
+		}
+		previousID = blk.Id
+
+		stellarBlock := &pbstellar.Block{}
+		require.NoError(t, blk.Payload.UnmarshalTo(stellarBlock))
+		require.Equal(t, uint32(22), stellarBlock.Header.LedgerVersion)
+		require.Len(t, stellarBlock.Transactions, 6)
+		for _, tx := range stellarBlock.Transactions {
+			id, err := toid.FromUint64(tx.Toid)
+			require.NoError(t, err)
+			require.Equal(t, toid.ID{LedgerSequence: uint32(seq), TransactionOrder: uint32(tx.ApplicationOrder)}, id)
+		}
+	}
+
+	require.NoError(t, backend.Close())
+	require.True(t, core.Closed())
+	require.NoError(t, backend.Close(), "Close must be idempotent")
+	_, err := backend.GetBlock(ctx, recordedLedger+3)
+	require.ErrorIs(t, err, errBackendClosed)
+}
+
+func Test_Backend_Errors(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("invalid start ledger", func(t *testing.T) {
+		backend := newTestBackend(t, newFakeCore(t, 1))
+		require.ErrorContains(t, backend.PrepareRange(ctx, 0), "start ledger must be >= 1")
+		require.ErrorContains(t, backend.PrepareRange(ctx, math.MaxUint32+1), "exceeds stellar ledger sequence range")
+	})
+
+	t.Run("prepare range failure", func(t *testing.T) {
+		core := newFakeCore(t, 1)
+		crash := errors.New("stellar-core process exited unexpectedly")
+		core.FailPrepareRange(crash)
+
+		err := newTestBackend(t, core).PrepareRange(ctx, recordedLedger)
+		var backendErr *BackendError
+		require.ErrorAs(t, err, &backendErr)
+		require.ErrorIs(t, err, crash)
+		require.EqualError(t, err, "captivecore: prepare range from 519386: stellar-core process exited unexpectedly")
+	})
+
+	t.Run("get ledger failure", func(t *testing.T) {
+		core := newFakeCore(t, 1)
+		crash := errors.New("stellar-core process exited unexpectedly")
+		core.FailGetLedger(recordedLedger, crash)
+		backend := newTestBackend(t, core)
+		require.NoError(t, backend.PrepareRange(ctx, recordedLedger))
+
+		_, err := backend.GetBlock(ctx, recordedLedger)
+		var backendErr *BackendError
+		require.ErrorAs(t, err, &backendErr)
+		require.Equal(t, uint64(recordedLedger), backendErr.Ledger)
+	})
+
+	t.Run("ledger not closed yet", func(t *testing.T) {
+		backend := newTestBackend(t, newFakeCore(t, 1))
+		require.NoError(t, backend.PrepareRange(ctx, recordedLedger))
+
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		_, err := backend.GetBlock(ctx, recordedLedger+1)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("conversion failure", func(t *testing.T) {
+		core := newFakeCore(t, 1)
+		backend, err := NewWithLedgerBackend(core, Config{NetworkPassphrase: network.PublicNetworkPassphrase, Logger: zap.NewNop()})
+		require.NoError(t, err)
+		require.NoError(t, backend.PrepareRange(ctx, recordedLedger))
+
+		// Transaction hashes do not match under another network.
+		_, err = backend.GetBlock(ctx, recordedLedger)
+		require.ErrorContains(t, err, "unknown tx hash in LedgerCloseMeta")
+		var backendErr *BackendError
+		require.False(t, errors.As(err, &backendErr), "a ledger failing to convert is not a backend failure")
+	})
+}
+
+// firedBlocks is a source.BlockHandler recording the blocks fired,
+// cancelling the run once it has fired until.
+type firedBlocks struct {
+	blocks []*pbbstream.Block
+	until  uint64
+	cancel context.CancelFunc
+}
+
+func (h *firedBlocks) Init() {}
+
+func (h *firedBlocks) Handle(blk *pbbstream.Block) error {
+	h.blocks = append(h.blocks, blk)
+	if blk.Number >= h.until {
+		h.cancel()
+	}
+	return nil
+}
+
+// Test_Supervisor_ResumesFromCursor runs the shared fetch loop over fake
+// stellar-cores, the first one crashing midway.
+func Test_Supervisor_ResumesFromCursor(t *testing.T) {
+	store := cursor.NewDirStore(t.TempDir())
+	cores := []*ledgerbackendtest.Backend{newFakeCore(t, 6), newFakeCore(t, 6)}
+	cores[0].FailGetLedger(recordedLedger+3, errors.New("stellar-core process exited unexpectedly"))
+
+	created := 0
+	config := testSupervisorConfig()
+	config.Resume = func(requested uint64) (uint64, error) {
+		persisted, _, err := store.Load(context.Background())
+		if err != nil || persisted == nil {
+			return requested, err
+		}
+		return persisted.LastFiredBlock.Num + 1, nil
+	}
+	supervisor := NewSupervisor(func() (source.LedgerSource, error) {
+		core := cores[created]
+		created++
+		return newTestBackend(t, core), nil
+	}, config, zap.NewNop())
+	supervisor.sleep = func(context.Context, time.Duration) error { return nil }
+	defer supervisor.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := &firedBlocks{until: recordedLedger + 5, cancel: cancel}
+	converter := &Fetcher{NetworkPassphrase: network.TestNetworkPassphrase, Logger: zap.NewNop()}
+	driver := source.NewDriver(supervisor, converter, handler, source.Config{
+		Backend:    MetricsBackend,
+		StartBlock: recordedLedger,
+		Cursor:     store,
+	}, zap.NewNop())
+	require.ErrorIs(t, driver.Run(ctx), context.Canceled)
+
+	require.Len(t, handler.blocks, 6)
+	for i, blk := range handler.blocks {
+		require.Equal(t, uint64(recordedLedger+i), blk.Number)
+		if i > 0 {
+			require.Equal(t, handler.blocks[i-1].Id, blk.ParentId)
+		}
+	}
+
+	require.True(t, cores[0].Closed())
+	require.Equal(t, []ledgerbackend.Range{ledgerbackend.UnboundedRange(recordedLedger + 3)}, cores[1].Prepares())
+
+	persisted, _, err := store.Load(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, uint64(recordedLedger+5), persisted.LastFiredBlock.Num)
+}
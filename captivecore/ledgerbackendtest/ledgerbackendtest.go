@@ -0,0 +1,260 @@
+// Package ledgerbackendtest provides an in-memory
+// ledgerbackend.LedgerBackend serving recorded LedgerCloseMeta, so code
+// driving captive-core can be tested without a stellar-core binary.
+//
+// Like captive-core, it streams an unbounded range forward from the
+// ledger it was prepared at, blocks on ledgers not closed yet and
+// refuses to go back. Failures can be scripted to stand for stellar-core
+// crashing.
+package ledgerbackendtest
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/stellar/go-stellar-sdk/ingest/ledgerbackend"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// ErrClosed is returned once the backend is closed.
+var ErrClosed = errors.New("ledger backend closed")
+
+// Backend is an in-memory ledgerbackend.LedgerBackend. It is safe for
+// concurrent use.
+type Backend struct {
+	mu      sync.Mutex
+	ledgers map[uint32]xdr.LedgerCloseMeta
+	// added is closed and replaced whenever ledgers are added.
+	added chan struct{}
+
+	prepared   *ledgerbackend.Range
+	next       uint32
+	closed     bool
+	prepares   []ledgerbackend.Range
+	prepareErr []error
+	ledgerErr  map[uint32]error
+}
+
+var _ ledgerbackend.LedgerBackend = (*Backend)(nil)
+
+// New returns a Backend serving metas.
+func New(metas ...xdr.LedgerCloseMeta) *Backend {
+	b := &Backend{
+		ledgers:   make(map[uint32]xdr.LedgerCloseMeta),
+		added:     make(chan struct{}),
+		ledgerErr: make(map[uint32]error),
+	}
+	b.Add(metas...)
+	return b
+}
+
+// Load reads recorded ledgers from path, one base64 LedgerCloseMeta per
+// line as getLedgers returns in metadataXdr.
+func Load(path string) ([]xdr.LedgerCloseMeta, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var metas []xdr.LedgerCloseMeta
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var meta xdr.LedgerCloseMeta
+		if err := xdr.SafeUnmarshalBase64(line, &meta); err != nil {
+			return nil, fmt.Errorf("decoding ledger %d of %s: %w", len(metas)+1, path, err)
+		}
+		metas = append(metas, meta)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return metas, nil
+}
+
+// Chain returns count copies of meta as consecutive ledgers starting at
+// from, each with its own hash and linked to the previous one, so a
+// single recorded ledger can stand for a stretch of chain.
+func Chain(meta xdr.LedgerCloseMeta, from uint32, count int) ([]xdr.LedgerCloseMeta, error) {
+	raw, err := meta.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("encoding ledger: %w", err)
+	}
+
+	header, err := ledgerHeader(&meta)
+	if err != nil {
+		return nil, err
+	}
+	previousHash := header.Header.PreviousLedgerHash
+
+	metas := make([]xdr.LedgerCloseMeta, 0, count)
+	for i := range count {
+		var copied xdr.LedgerCloseMeta
+		if err := copied.UnmarshalBinary(raw); err != nil {
+			return nil, fmt.Errorf("decoding ledger: %w", err)
+		}
+		header, _ := ledgerHeader(&copied)
+		sequence := from + uint32(i)
+		header.Header.LedgerSeq = xdr.Uint32(sequence)
+		header.Header.PreviousLedgerHash = previousHash
+		binary.BigEndian.PutUint32(header.Hash[:4], sequence)
+		previousHash = header.Hash
+
+		metas = append(metas, copied)
+	}
+	return metas, nil
+}
+
+func ledgerHeader(meta *xdr.LedgerCloseMeta) (*xdr.LedgerHeaderHistoryEntry, error) {
+	switch {
+	case meta.V == 0 && meta.V0 != nil:
+		return &meta.V0.LedgerHeader, nil
+	case meta.V == 1 && meta.V1 != nil:
+		return &meta.V1.LedgerHeader, nil
+	case meta.V == 2 && meta.V2 != nil:
+		return &meta.V2.LedgerHeader, nil
+	}
+	return nil, fmt.Errorf("unsupported LedgerCloseMeta version %d", meta.V)
+}
+
+// Add makes metas available, waking up GetLedger calls waiting for them.
+func (b *Backend) Add(metas ...xdr.LedgerCloseMeta) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, meta := range metas {
+		b.ledgers[meta.LedgerSequence()] = meta
+	}
+	close(b.added)
+	b.added = make(chan struct{})
+}
+
+// FailPrepareRange makes the next PrepareRange call fail with err.
+func (b *Backend) FailPrepareRange(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prepareErr = append(b.prepareErr, err)
+}
+
+// FailGetLedger makes the next GetLedger call for sequence fail with err.
+func (b *Backend) FailGetLedger(sequence uint32, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ledgerErr[sequence] = err
+}
+
+// Prepares returns the ranges PrepareRange was called with.
+func (b *Backend) Prepares() []ledgerbackend.Range {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]ledgerbackend.Range(nil), b.prepares...)
+}
+
+// Closed reports whether Close was called.
+func (b *Backend) Closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.closed
+}
+
+func (b *Backend) PrepareRange(_ context.Context, ledgerRange ledgerbackend.Range) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+	b.prepares = append(b.prepares, ledgerRange)
+	if len(b.prepareErr) > 0 {
+		err := b.prepareErr[0]
+		b.prepareErr = b.prepareErr[1:]
+		return err
+	}
+	b.prepared = &ledgerRange
+	b.next = ledgerRange.From()
+	return nil
+}
+
+func (b *Backend) IsPrepared(_ context.Context, ledgerRange ledgerbackend.Range) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.prepared != nil && b.prepared.Contains(ledgerRange), nil
+}
+
+// GetLatestLedgerSequence returns the highest ledger available.
+func (b *Backend) GetLatestLedgerSequence(_ context.Context) (uint32, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.prepared == nil {
+		return 0, errors.New("ledger backend not prepared")
+	}
+	var latest uint32
+	for sequence := range b.ledgers {
+		latest = max(latest, sequence)
+	}
+	return latest, nil
+}
+
+// GetLedger returns ledger sequence, blocking until it is added or ctx
+// is done.
+func (b *Backend) GetLedger(ctx context.Context, sequence uint32) (xdr.LedgerCloseMeta, error) {
+	for {
+		b.mu.Lock()
+		switch {
+		case b.closed:
+			b.mu.Unlock()
+			return xdr.LedgerCloseMeta{}, ErrClosed
+		case b.prepared == nil:
+			b.mu.Unlock()
+			return xdr.LedgerCloseMeta{}, errors.New("ledger backend not prepared")
+		case sequence < b.next || (b.prepared.Bounded() && sequence > b.prepared.To()):
+			b.mu.Unlock()
+			return xdr.LedgerCloseMeta{}, fmt.Errorf("ledger %d is outside of the prepared range %s (next ledger %d)", sequence, b.prepared, b.next)
+		}
+		if err, found := b.ledgerErr[sequence]; found {
+			delete(b.ledgerErr, sequence)
+			b.mu.Unlock()
+			return xdr.LedgerCloseMeta{}, err
+		}
+		if meta, found := b.ledgers[sequence]; found {
+			b.next = sequence + 1
+			b.mu.Unlock()
+			return meta, nil
+		}
+		added := b.added
+		b.mu.Unlock()
+
+		select {
+		case <-added:
+		case <-ctx.Done():
+			return xdr.LedgerCloseMeta{}, ctx.Err()
+		}
+	}
+}
+
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	close(b.added)
+	b.added = make(chan struct{})
+	return nil
+}
@@ -0,0 +1,111 @@
+package captivecore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/streamingfast/firehose-stellar/networkcheck"
+	"go.uber.org/zap"
+)
+
+// historyArchiveStatePath is the root History Archive State of an
+// archive, it names the network the archive belongs to.
+const historyArchiveStatePath = ".well-known/stellar-history.json"
+
+var archiveHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// VerifyNetwork reads the network passphrase of every history archive in
+// HistoryArchiveURLs and fails when one of them belongs to another
+// network than NetworkPassphrase. An empty NetworkPassphrase is instead
+// discovered from the archives, which must then all agree on it.
+// Archives that cannot be read are moved to UnverifiedHistoryArchiveURLs,
+// as their network is unknown, see networkcheck.Verify.
+func (c *Config) VerifyNetwork(ctx context.Context) error {
+	if c.Logger == nil {
+		return errors.New("captivecore: Logger is required")
+	}
+	if len(c.HistoryArchiveURLs) == 0 {
+		return errors.New("captivecore: HistoryArchiveURLs is required (at least one)")
+	}
+
+	passphrase, unverified, err := networkcheck.Verify(ctx, "history archive", c.HistoryArchiveURLs, func(archiveURL string) string { return archiveURL }, fetchArchiveNetworkPassphrase, c.NetworkPassphrase, c.Logger)
+	if err != nil {
+		return fmt.Errorf("captivecore: %w", err)
+	}
+	c.NetworkPassphrase = passphrase
+	// Not filtered in place: HistoryArchiveURLs may be the defaults of
+	// the SDK.
+	var verified []string
+	for _, archiveURL := range c.HistoryArchiveURLs {
+		if !slices.Contains(unverified, archiveURL) {
+			verified = append(verified, archiveURL)
+		}
+	}
+	c.HistoryArchiveURLs = verified
+	c.UnverifiedHistoryArchiveURLs = unverified
+	return nil
+}
+
+// historyArchiveURLs returns HistoryArchiveURLs, along with the archives
+// of UnverifiedHistoryArchiveURLs that now read as belonging to
+// NetworkPassphrase.
+func (c *Config) historyArchiveURLs(ctx context.Context) []string {
+	urls := slices.Clone(c.HistoryArchiveURLs)
+	for _, archiveURL := range c.UnverifiedHistoryArchiveURLs {
+		passphrase, err := fetchArchiveNetworkPassphrase(ctx, archiveURL)
+		switch {
+		case err != nil:
+			c.Logger.Warn("unable to read the network of history archive, not using it", zap.String("archive", archiveURL), zap.Error(err))
+		case passphrase != c.NetworkPassphrase:
+			c.Logger.Error("history archive belongs to another network, not using it", zap.String("archive", archiveURL), zap.String("network_passphrase", passphrase))
+		default:
+			c.Logger.Info("history archive belongs to the expected network, using it", zap.String("archive", archiveURL))
+			urls = append(urls, archiveURL)
+		}
+	}
+	return urls
+}
+
+func fetchArchiveNetworkPassphrase(ctx context.Context, archiveURL string) (string, error) {
+	u, err := url.Parse(archiveURL)
+	if err != nil {
+		return "", fmt.Errorf("parsing url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme %q, only http and https archives can be verified", u.Scheme)
+	}
+	u = u.JoinPath(historyArchiveStatePath)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := archiveHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching %s: %w", historyArchiveStatePath, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s: unexpected status %s", historyArchiveStatePath, resp.Status)
+	}
+
+	var state struct {
+		NetworkPassphrase string `json:"networkPassphrase"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		return "", fmt.Errorf("decoding %s: %w", historyArchiveStatePath, err)
+	}
+	if state.NetworkPassphrase == "" {
+		return "", fmt.Errorf("%s has no networkPassphrase", historyArchiveStatePath)
+	}
+
+	return state.NetworkPassphrase, nil
+}
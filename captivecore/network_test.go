@@ -0,0 +1,73 @@
+package captivecore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newHistoryArchive serves the root History Archive State of an archive
+// belonging to the network named by passphrase.
+func newHistoryArchive(t *testing.T, passphrase string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+historyArchiveStatePath {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"version":2,"server":"stellar-core 23.0.0","currentLedger":1023,"networkPassphrase":"` + passphrase + `","currentBuckets":[]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+func Test_Config_VerifyNetwork(t *testing.T) {
+	testnet := newHistoryArchive(t, network.TestNetworkPassphrase)
+	pubnet := newHistoryArchive(t, network.PublicNetworkPassphrase)
+
+	cfg := Config{NetworkPassphrase: network.TestNetworkPassphrase, HistoryArchiveURLs: []string{"http://127.0.0.1:1", testnet}, Logger: zap.NewNop()}
+	require.NoError(t, cfg.VerifyNetwork(context.Background()))
+	require.Equal(t, []string{testnet}, cfg.HistoryArchiveURLs)
+	require.Equal(t, []string{"http://127.0.0.1:1"}, cfg.UnverifiedHistoryArchiveURLs)
+
+	cfg.HistoryArchiveURLs = []string{"http://127.0.0.1:1"}
+	require.ErrorContains(t, cfg.VerifyNetwork(context.Background()), "unable to read the network of any history archive")
+
+	cfg.HistoryArchiveURLs = []string{testnet, pubnet}
+	require.ErrorContains(t, cfg.VerifyNetwork(context.Background()), pubnet+` belongs to "`+network.PublicNetworkPassphrase+`"`)
+}
+
+func Test_Config_VerifyNetwork_Discovery(t *testing.T) {
+	custom := "Standalone Network ; February 2017"
+
+	cfg := Config{HistoryArchiveURLs: []string{newHistoryArchive(t, custom), newHistoryArchive(t, custom)}, Logger: zap.NewNop()}
+	require.NoError(t, cfg.VerifyNetwork(context.Background()))
+	require.Equal(t, custom, cfg.NetworkPassphrase)
+
+	cfg = Config{HistoryArchiveURLs: []string{newHistoryArchive(t, custom), newHistoryArchive(t, network.TestNetworkPassphrase)}, Logger: zap.NewNop()}
+	require.ErrorContains(t, cfg.VerifyNetwork(context.Background()), "history archives belong to different networks")
+}
+
+func Test_Config_historyArchiveURLs(t *testing.T) {
+	testnet := newHistoryArchive(t, network.TestNetworkPassphrase)
+	backup := newHistoryArchive(t, network.TestNetworkPassphrase)
+	pubnet := newHistoryArchive(t, network.PublicNetworkPassphrase)
+
+	// Archives down at startup are read again on each stellar-core start,
+	// and only used when they belong to the network.
+	cfg := Config{
+		NetworkPassphrase:            network.TestNetworkPassphrase,
+		HistoryArchiveURLs:           []string{testnet},
+		UnverifiedHistoryArchiveURLs: []string{"http://127.0.0.1:1", backup, pubnet},
+		Logger:                       zap.NewNop(),
+	}
+	require.Equal(t, []string{testnet, backup}, cfg.historyArchiveURLs(context.Background()))
+	require.Equal(t, []string{testnet}, cfg.HistoryArchiveURLs)
+}
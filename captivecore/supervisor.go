@@ -0,0 +1,215 @@
+package captivecore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stellar/go-stellar-sdk/xdr"
+	"github.com/streamingfast/firehose-stellar/metrics"
+	"github.com/streamingfast/firehose-stellar/source"
+	"go.uber.org/zap"
+)
+
+// SupervisorConfig bounds how the Supervisor restarts a failed backend.
+type SupervisorConfig struct {
+	// MaxRestarts within RestartWindow before giving up. 0 disables
+	// restarts, the first backend failure is returned.
+	MaxRestarts   int
+	RestartWindow time.Duration
+
+	// InitialBackoff before the first restart, doubled on each
+	// consecutive one up to MaxBackoff. A block served resets it.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Resume returns the ledger to prepare the new backend from, the
+	// last fired block plus one. It gets the ledger that was requested
+	// when the backend failed, to fall back to without a cursor.
+	Resume func(requested uint64) (uint64, error)
+
+	// OnPrepare and OnPrepared, when set, are called around every
+	// PrepareRange, the first one and the ones after a restart.
+	OnPrepare  func(startLedger uint64)
+	OnPrepared func()
+}
+
+// DefaultSupervisorConfig restarts up to 5 times per 10 minutes, waiting
+// from 5s to 1m in between.
+func DefaultSupervisorConfig() SupervisorConfig {
+	return SupervisorConfig{
+		MaxRestarts:    5,
+		RestartWindow:  10 * time.Minute,
+		InitialBackoff: 5 * time.Second,
+		MaxBackoff:     time.Minute,
+	}
+}
+
+// Supervisor is a source.LedgerSource recreating the backend when it
+// fails with a *BackendError, typically stellar-core crashing, instead
+// of letting the whole reader die. The new backend is prepared from
+// SupervisorConfig.Resume and serves the requested ledger as if nothing
+// happened. Other failures are not retried. A Supervisor is not safe
+// for concurrent use.
+type Supervisor struct {
+	newSource func() (source.LedgerSource, error)
+	config    SupervisorConfig
+	logger    *zap.Logger
+
+	source   source.LedgerSource
+	restarts []time.Time
+	backoff  time.Duration
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewSupervisor returns a Supervisor creating its backends with
+// newSource, e.g. a closure around New.
+func NewSupervisor(newSource func() (source.LedgerSource, error), config SupervisorConfig, logger *zap.Logger) *Supervisor {
+	return &Supervisor{
+		newSource: newSource,
+		config:    config,
+		logger:    logger,
+		backoff:   config.InitialBackoff,
+		now:       time.Now,
+		sleep:     sleepContext,
+	}
+}
+
+// PrepareRange creates the backend and prepares it from startLedger,
+// restarting it when that fails.
+func (s *Supervisor) PrepareRange(ctx context.Context, startLedger uint64) error {
+	if err := s.start(ctx, startLedger); err != nil {
+		return s.recover(ctx, startLedger, err)
+	}
+	return nil
+}
+
+// GetLedgerCloseMeta returns ledgerSeq from the backend, restarting the
+// backend when it fails.
+func (s *Supervisor) GetLedgerCloseMeta(ctx context.Context, ledgerSeq uint64) (xdr.LedgerCloseMeta, error) {
+	for {
+		if s.source == nil {
+			return xdr.LedgerCloseMeta{}, errors.New("captivecore: supervisor has no backend, call PrepareRange first")
+		}
+
+		meta, err := s.source.GetLedgerCloseMeta(ctx, ledgerSeq)
+		if err == nil {
+			s.backoff = s.config.InitialBackoff
+			return meta, nil
+		}
+		if err := s.recover(ctx, ledgerSeq, err); err != nil {
+			return xdr.LedgerCloseMeta{}, err
+		}
+	}
+}
+
+// Close closes the current backend.
+func (s *Supervisor) Close() error {
+	if s.source == nil {
+		return nil
+	}
+	err := s.source.Close()
+	s.source = nil
+	return err
+}
+
+func (s *Supervisor) start(ctx context.Context, startLedger uint64) error {
+	source, err := s.newSource()
+	if err != nil {
+		return err
+	}
+	s.source = source
+
+	if s.config.OnPrepare != nil {
+		s.config.OnPrepare(startLedger)
+	}
+	if err := source.PrepareRange(ctx, startLedger); err != nil {
+		return err
+	}
+	if s.config.OnPrepared != nil {
+		s.config.OnPrepared()
+	}
+	return nil
+}
+
+// recover replaces the backend that failed with cause while serving
+// requested, until a new one is prepared or the restart budget is spent.
+// It returns cause as is when it is not a backend failure.
+func (s *Supervisor) recover(ctx context.Context, requested uint64, cause error) error {
+	for isBackendFailure(ctx, cause) {
+		if err := s.Close(); err != nil {
+			s.logger.Warn("closing failed captive-core backend", zap.Error(err))
+		}
+		if s.config.MaxRestarts <= 0 {
+			return cause
+		}
+
+		now := s.now()
+		recent := s.restarts[:0]
+		for _, at := range s.restarts {
+			if now.Sub(at) < s.config.RestartWindow {
+				recent = append(recent, at)
+			}
+		}
+		s.restarts = recent
+		if len(s.restarts) >= s.config.MaxRestarts {
+			return fmt.Errorf("captive-core failed %d times within %s, giving up: %w", len(s.restarts)+1, s.config.RestartWindow, cause)
+		}
+		s.restarts = append(s.restarts, now)
+
+		resumeFrom := requested
+		if s.config.Resume != nil {
+			from, err := s.config.Resume(requested)
+			if err != nil {
+				return fmt.Errorf("resolving captive-core resume ledger: %w", err)
+			}
+			resumeFrom = from
+		}
+		if resumeFrom > requested {
+			return fmt.Errorf("captive-core resume ledger %d is past the requested ledger %d", resumeFrom, requested)
+		}
+
+		s.logger.Warn("captive-core failed, restarting",
+			zap.Error(cause),
+			zap.Uint64("requested_ledger", requested),
+			zap.Uint64("resume_ledger", resumeFrom),
+			zap.Int("restart", len(s.restarts)),
+			zap.Int("max_restarts", s.config.MaxRestarts),
+			zap.Duration("backoff", s.backoff),
+		)
+		metrics.CaptiveCoreRestarts.Inc()
+
+		if err := s.sleep(ctx, s.backoff); err != nil {
+			return err
+		}
+		s.backoff = min(2*s.backoff, s.config.MaxBackoff)
+
+		cause = s.start(ctx, resumeFrom)
+	}
+	return cause
+}
+
+// isBackendFailure reports whether err calls for a new backend: one
+// from the backend itself, not from a cancelled ctx.
+func isBackendFailure(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var backendErr *BackendError
+	return errors.As(err, &backendErr)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
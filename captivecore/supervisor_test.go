@@ -0,0 +1,200 @@
+package captivecore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stellar/go-stellar-sdk/xdr"
+	"github.com/streamingfast/firehose-stellar/source"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeCore creates fakeSources, each failing on the next scripted
+// failure, like stellar-core crashing, until the failures run out.
+type fakeCore struct {
+	failures []fakeFailure
+	prepared []uint64
+	closed   int
+}
+
+type fakeFailure struct {
+	// ledger fails GetLedgerCloseMeta, 0 fails PrepareRange.
+	ledger uint64
+	err    error
+}
+
+func (c *fakeCore) newSource() (source.LedgerSource, error) {
+	return &fakeSource{core: c}, nil
+}
+
+type fakeSource struct {
+	core *fakeCore
+	next uint64
+}
+
+func (s *fakeSource) fail(ledger uint64) error {
+	if len(s.core.failures) == 0 || s.core.failures[0].ledger != ledger {
+		return nil
+	}
+	err := s.core.failures[0].err
+	s.core.failures = s.core.failures[1:]
+	return err
+}
+
+func (s *fakeSource) PrepareRange(_ context.Context, startLedger uint64) error {
+	s.core.prepared = append(s.core.prepared, startLedger)
+	if err := s.fail(0); err != nil {
+		return err
+	}
+	s.next = startLedger
+	return nil
+}
+
+func (s *fakeSource) GetLedgerCloseMeta(_ context.Context, ledgerSeq uint64) (xdr.LedgerCloseMeta, error) {
+	if ledgerSeq < s.next {
+		return xdr.LedgerCloseMeta{}, fmt.Errorf("ledger %d is before the prepared range", ledgerSeq)
+	}
+	if err := s.fail(ledgerSeq); err != nil {
+		return xdr.LedgerCloseMeta{}, err
+	}
+	s.next = ledgerSeq + 1
+	return xdr.LedgerCloseMeta{V: 1, V1: &xdr.LedgerCloseMetaV1{
+		LedgerHeader: xdr.LedgerHeaderHistoryEntry{Header: xdr.LedgerHeader{LedgerSeq: xdr.Uint32(ledgerSeq)}},
+	}}, nil
+}
+
+func (s *fakeSource) Close() error {
+	s.core.closed++
+	return nil
+}
+
+func crash(ledger uint64) fakeFailure {
+	return fakeFailure{ledger: ledger, err: &BackendError{Op: "get ledger", Ledger: ledger, Err: errors.New("stellar-core process exited unexpectedly")}}
+}
+
+func newTestSupervisor(core *fakeCore, config SupervisorConfig) (*Supervisor, *[]time.Duration, *time.Time) {
+	s := NewSupervisor(core.newSource, config, zap.NewNop())
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	sleeps := []time.Duration{}
+	s.now = func() time.Time { return now }
+	s.sleep = func(_ context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return nil
+	}
+	return s, &sleeps, &now
+}
+
+func testSupervisorConfig() SupervisorConfig {
+	return SupervisorConfig{MaxRestarts: 2, RestartWindow: time.Minute, InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}
+}
+
+func Test_Supervisor_RestartsAndResumes(t *testing.T) {
+	core := &fakeCore{failures: []fakeFailure{crash(102)}}
+	config := testSupervisorConfig()
+	lastFired := uint64(0)
+	config.Resume = func(requested uint64) (uint64, error) {
+		require.Equal(t, uint64(102), requested)
+		return lastFired + 1, nil
+	}
+	s, sleeps, _ := newTestSupervisor(core, config)
+	ctx := context.Background()
+
+	require.NoError(t, s.PrepareRange(ctx, 100))
+	for seq := uint64(100); seq <= 104; seq++ {
+		meta, err := s.GetLedgerCloseMeta(ctx, seq)
+		require.NoError(t, err)
+		require.Equal(t, uint32(seq), meta.LedgerSequence())
+		lastFired = seq
+	}
+
+	require.Equal(t, []uint64{100, 102}, core.prepared)
+	require.Equal(t, 1, core.closed)
+	require.Equal(t, []time.Duration{time.Second}, *sleeps)
+}
+
+func Test_Supervisor_RestartBudget(t *testing.T) {
+	core := &fakeCore{failures: []fakeFailure{crash(100), crash(100), crash(100), crash(100)}}
+	s, sleeps, now := newTestSupervisor(core, testSupervisorConfig())
+	ctx := context.Background()
+
+	require.NoError(t, s.PrepareRange(ctx, 100))
+	_, err := s.GetLedgerCloseMeta(ctx, 100)
+	require.ErrorContains(t, err, "captive-core failed 3 times within 1m0s, giving up")
+	var backendErr *BackendError
+	require.ErrorAs(t, err, &backendErr)
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second}, *sleeps)
+	require.Equal(t, []uint64{100, 100, 100}, core.prepared)
+
+	// Restarts older than the window no longer count.
+	*now = now.Add(2 * time.Minute)
+	require.NoError(t, s.PrepareRange(ctx, 100))
+	meta, err := s.GetLedgerCloseMeta(ctx, 100)
+	require.NoError(t, err)
+	require.Equal(t, uint32(100), meta.LedgerSequence())
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, *sleeps)
+}
+
+func Test_Supervisor_PrepareRangeFailure(t *testing.T) {
+	core := &fakeCore{failures: []fakeFailure{{err: &BackendError{Op: "prepare range from", Ledger: 100, Err: errors.New("catchup failed")}}}}
+	prepares := 0
+	config := testSupervisorConfig()
+	config.OnPrepared = func() { prepares++ }
+	s, sleeps, _ := newTestSupervisor(core, config)
+
+	require.NoError(t, s.PrepareRange(context.Background(), 100))
+	require.Equal(t, []uint64{100, 100}, core.prepared)
+	require.Equal(t, 1, prepares)
+	require.Len(t, *sleeps, 1)
+}
+
+func Test_Supervisor_DoesNotRestart(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("not a backend failure", func(t *testing.T) {
+		ledgerErr := errors.New("captivecore: ledger 4294967296 exceeds uint32")
+		core := &fakeCore{failures: []fakeFailure{{ledger: 100, err: ledgerErr}}}
+		s, sleeps, _ := newTestSupervisor(core, testSupervisorConfig())
+
+		require.NoError(t, s.PrepareRange(ctx, 100))
+		_, err := s.GetLedgerCloseMeta(ctx, 100)
+		require.ErrorIs(t, err, ledgerErr)
+		require.Empty(t, *sleeps)
+	})
+
+	t.Run("restarts disabled", func(t *testing.T) {
+		core := &fakeCore{failures: []fakeFailure{crash(100)}}
+		s, sleeps, _ := newTestSupervisor(core, SupervisorConfig{})
+
+		require.NoError(t, s.PrepareRange(ctx, 100))
+		_, err := s.GetLedgerCloseMeta(ctx, 100)
+		require.EqualError(t, err, "captivecore: get ledger 100: stellar-core process exited unexpectedly")
+		require.Empty(t, *sleeps)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		core := &fakeCore{failures: []fakeFailure{crash(100)}}
+		s, sleeps, _ := newTestSupervisor(core, testSupervisorConfig())
+		ctx, cancel := context.WithCancel(ctx)
+
+		require.NoError(t, s.PrepareRange(ctx, 100))
+		cancel()
+		_, err := s.GetLedgerCloseMeta(ctx, 100)
+		require.Error(t, err)
+		require.Empty(t, *sleeps)
+	})
+
+	t.Run("resume past requested ledger", func(t *testing.T) {
+		core := &fakeCore{failures: []fakeFailure{crash(100)}}
+		config := testSupervisorConfig()
+		config.Resume = func(uint64) (uint64, error) { return 150, nil }
+		s, _, _ := newTestSupervisor(core, config)
+
+		require.NoError(t, s.PrepareRange(ctx, 100))
+		_, err := s.GetLedgerCloseMeta(ctx, 100)
+		require.EqualError(t, err, "captive-core resume ledger 150 is past the requested ledger 100")
+	})
+}
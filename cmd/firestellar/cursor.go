@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/cli"
+	"github.com/streamingfast/cli/sflags"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/firehose-stellar/cursor"
+	"go.uber.org/zap"
+)
+
+// The cursor commands take the --state-dir of a fetcher, a local
+// directory or a dstore URL. Except show, they lock a local one, so they
+// fail while a fetcher runs on it.
+
+func NewCursorShowCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show <state-dir>",
+		Short: "Print the cursor of a fetcher state dir, its age and the network it belongs to",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runCursorShowE,
+	}
+}
+
+func NewCursorSetCmd(logger *zap.Logger) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set <state-dir> <block-num> [<block-id>]",
+		Short: "Move the cursor of a fetcher state dir, so its next run starts after <block-num>",
+		Long: cli.Dedent(`
+			Move the cursor of a fetcher state dir, so its next run starts after
+			<block-num>. The id of the block, its hex-encoded ledger hash, is
+			looked up in --merged-blocks-store when omitted. The network
+			recorded in the state dir is kept.
+		`),
+		Args: cobra.RangeArgs(2, 3),
+		RunE: runCursorSetE(logger),
+	}
+
+	cmd.Flags().String("merged-blocks-store", "", "merged blocks store URL to look the id of <block-num> up in when it is omitted")
+
+	return cmd
+}
+
+func NewCursorResetCmd(logger *zap.Logger) *cobra.Command {
+	return &cobra.Command{
+		Use:   "reset <state-dir>",
+		Short: "Delete the cursor and the network recorded in a fetcher state dir, so its next run starts from its first streamable block",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runCursorResetE(logger),
+	}
+}
+
+func NewCursorMigrateCmd(logger *zap.Logger) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate <from-state-dir> <to-state-dir>",
+		Short: "Convert the state dir of an rpc fetcher that ran the firehose-core blockpoller into the layout of firestellar's fetchers",
+		Long: cli.Dedent(`
+			Convert the state dir of an rpc fetcher that ran the firehose-core
+			blockpoller into the layout of firestellar's fetchers, rpc and
+			captive-core, e.g. to move it to a captive-core fetcher.
+
+			The blockpoller keeps a LIB behind the last fired block and the
+			blocks since in a fork history. The fetchers of firestellar keep
+			the LIB on the last fired block, no fork history, and the last
+			cursors next to cursor.json instead: the history is rebuilt from
+			the fork history blocks leading to the last fired block, forks
+			and blocks fetched but not fired are dropped. The network
+			recorded, if any, is copied. Migrating a state dir to itself
+			converts it in place.
+
+			The other way needs no conversion, the blockpoller reads the
+			cursor.json of firestellar's fetchers as it is.
+		`),
+		Args: cobra.ExactArgs(2),
+		RunE: runCursorMigrateE(logger),
+	}
+
+	cmd.Flags().Bool("force", false, "overwrite the cursor of <to-state-dir> when it has one")
+
+	return cmd
+}
+
+func runCursorShowE(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	store, err := cursor.Open(args[0], 0)
+	if err != nil {
+		return err
+	}
+	state, report, err := store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	network, err := store.LoadNetwork(ctx)
+	if err != nil {
+		return err
+	}
+
+	if network == "" {
+		network = "none recorded"
+	}
+	if state == nil {
+		fmt.Printf("No cursor in %s, the next run starts from its first streamable block\n", store)
+		fmt.Printf("  Network:          %s\n", network)
+		return nil
+	}
+
+	fmt.Printf("Cursor:             %s\n", report.Path)
+	fmt.Printf("  Last fired block: #%d (%s)\n", state.LastFiredBlock.Num, state.LastFiredBlock.Id)
+	fmt.Printf("  Previous block:   %s\n", orNone(state.LastFiredBlock.PrevBlockId))
+	fmt.Printf("  LIB:              #%d (%s)\n", state.Lib.Num, state.Lib.Id)
+	if len(state.Blocks) > 0 {
+		fmt.Printf("  Fork history:     %d blocks\n", len(state.Blocks))
+	}
+	if report.SavedAt.IsZero() {
+		fmt.Printf("  Saved:            unknown\n")
+	} else {
+		fmt.Printf("  Saved:            %s (%s ago)\n", report.SavedAt.UTC().Format(time.RFC3339), time.Since(report.SavedAt).Round(time.Second))
+	}
+	fmt.Printf("  Network:          %s\n", network)
+	fmt.Printf("  Next run starts:  #%d\n", state.LastFiredBlock.Num+1)
+	for _, invalid := range report.Invalid {
+		fmt.Printf("  Skipped:          %s\n", invalid)
+	}
+	return nil
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
+
+func runCursorSetE(logger *zap.Logger) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		num, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("parsing block number %q: %w", args[1], err)
+		}
+
+		var blk *pbbstream.Block
+		if len(args) == 3 {
+			if hash, err := hex.DecodeString(args[2]); err != nil || len(hash) != 32 {
+				return fmt.Errorf("block id %q is not a hex-encoded 32 bytes ledger hash", args[2])
+			}
+			blk = &pbbstream.Block{Number: num, Id: args[2]}
+		} else {
+			mergedBlocksStore := sflags.MustGetString(cmd, "merged-blocks-store")
+			if mergedBlocksStore == "" {
+				return fmt.Errorf("the id of block %d is required without --merged-blocks-store to look it up in", num)
+			}
+			blk, err = lookUpMergedBlock(ctx, mergedBlocksStore, num)
+			if err != nil {
+				return err
+			}
+		}
+
+		store, closeStore, err := openCursorStore(args[0], 0, logger)
+		if err != nil {
+			return err
+		}
+		defer closeStore()
+
+		network, err := store.LoadNetwork(ctx)
+		if err != nil {
+			return err
+		}
+		// Deleting first drops the history, which could otherwise bring a
+		// cursor past blk back.
+		if err := store.Delete(ctx); err != nil {
+			return err
+		}
+		if err := store.Save(ctx, blk); err != nil {
+			return err
+		}
+		if network != "" {
+			if err := store.SaveNetwork(ctx, network); err != nil {
+				return err
+			}
+		}
+
+		fmt.Printf("Cursor of %s set to block #%d (%s), the next run starts at #%d\n", store, blk.Number, blk.Id, blk.Number+1)
+		return nil
+	}
+}
+
+// lookUpMergedBlock returns block num from the merged blocks at storeURL.
+func lookUpMergedBlock(ctx context.Context, storeURL string, num uint64) (*pbbstream.Block, error) {
+	store, err := dstore.NewDBinStore(storeURL)
+	if err != nil {
+		return nil, fmt.Errorf("creating merged blocks store: %w", err)
+	}
+
+	bundle := num / mergedBundleSize * mergedBundleSize
+	blocks, err := readMergedBundle(ctx, store, fmt.Sprintf("%010d", bundle), num, num+1, false, false)
+	if err != nil {
+		return nil, fmt.Errorf("looking block %d up in %s: %w", num, storeURL, err)
+	}
+	blk, found := blocks[num]
+	if !found {
+		return nil, fmt.Errorf("block %d is not in merged blocks bundle %010d of %s", num, bundle, storeURL)
+	}
+	return blk, nil
+}
+
+func runCursorResetE(logger *zap.Logger) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		store, closeStore, err := openCursorStore(args[0], 0, logger)
+		if err != nil {
+			return err
+		}
+		defer closeStore()
+
+		if err := store.Delete(cmd.Context()); err != nil {
+			return err
+		}
+
+		fmt.Printf("Cursor of %s deleted, the next run starts from its first streamable block\n", store)
+		return nil
+	}
+}
+
+func runCursorMigrateE(logger *zap.Logger) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		from, closeFrom, err := openCursorStore(args[0], 0, logger)
+		if err != nil {
+			return err
+		}
+		defer closeFrom()
+
+		// A state dir is locked once, migrating it to itself converts it
+		// in place.
+		to := from
+		if args[1] != args[0] {
+			var closeTo func()
+			to, closeTo, err = openCursorStore(args[1], 0, logger)
+			if err != nil {
+				return err
+			}
+			defer closeTo()
+
+			existing, _, err := to.Load(ctx)
+			if err != nil && !sflags.MustGetBool(cmd, "force") {
+				return fmt.Errorf("%w, pass --force to overwrite it", err)
+			}
+			if existing != nil && !sflags.MustGetBool(cmd, "force") {
+				return fmt.Errorf("%s already has a cursor at block #%d, pass --force to overwrite it", to, existing.LastFiredBlock.Num)
+			}
+		}
+
+		state, _, err := from.Load(ctx)
+		if err != nil {
+			return err
+		}
+		if state == nil {
+			return fmt.Errorf("no cursor in %s", from)
+		}
+		migration, err := cursor.MigrateBlockpoller(state)
+		if err != nil {
+			return fmt.Errorf("migrating the cursor of %s: %w", from, err)
+		}
+		network, err := from.LoadNetwork(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err := to.Delete(ctx); err != nil {
+			return err
+		}
+		for _, blk := range migration.Blocks {
+			if err := to.Save(ctx, blk); err != nil {
+				return err
+			}
+		}
+		if network != "" {
+			if err := to.SaveNetwork(ctx, network); err != nil {
+				return err
+			}
+		}
+
+		last := state.LastFiredBlock
+		fmt.Printf("Cursor of %s at block #%d migrated to %s, the next run starts at #%d\n", from, last.Num, to, last.Num+1)
+		fmt.Printf("  LIB:              #%d, was #%d\n", last.Num, state.Lib.Num)
+		fmt.Printf("  History:          #%d to #%d\n", migration.Blocks[0].Number, last.Num)
+		for _, blk := range migration.Dropped {
+			fmt.Printf("  Dropped:          #%d (%s)\n", blk.Num, blk.Id)
+		}
+		return nil
+	}
+}
@@ -10,8 +10,11 @@ import (
 	"github.com/streamingfast/cli/sflags"
 	firecore "github.com/streamingfast/firehose-core"
 	"github.com/streamingfast/firehose-core/blockpoller"
-	firecoreRPC "github.com/streamingfast/firehose-core/rpc"
+	"github.com/streamingfast/firehose-stellar/captivecore"
+	"github.com/streamingfast/firehose-stellar/cursor"
+	"github.com/streamingfast/firehose-stellar/protocol"
 	"github.com/streamingfast/firehose-stellar/rpc"
+	"github.com/streamingfast/firehose-stellar/source"
 	"github.com/streamingfast/logging"
 	"go.uber.org/zap"
 )
@@ -24,15 +27,30 @@ func NewFetchRpcCmd(logger *zap.Logger, tracer logging.Tracer) *cobra.Command {
 		RunE:  fetchRpcRunE(logger, tracer),
 	}
 
-	cmd.Flags().StringArray("endpoints", []string{}, "List of endpoints to use to fetch different method calls")
-	cmd.Flags().String("state-dir", "/data/poller", "directory used to persist poller state between runs")
+	cmd.Flags().StringArray("endpoints", []string{}, "List of endpoints to use to fetch different method calls, each '<url>[;<option>...]' with options header=<Name>:<secret>, query=<name>:<secret>, ca-file=<path>, cert-file=<path>, key-file=<path> and proxy=<url>, where <secret> is env:<VARIABLE> or file:<path>")
+	cmd.Flags().String("state-dir", "/data/poller", "directory, or dstore URL (file://, s3://, gs://...) for readers without a persistent disk, used to persist poller state between runs")
+	cmd.Flags().Duration("state-save-interval", 5*time.Second, "minimum delay between two cursor writes when --state-dir is a dstore URL, so a restart replays at most that much; a local --state-dir is written after every block")
 	cmd.Flags().Duration("interval-between-fetch", 0, "interval between fetch attempts when the chain head has not advanced")
 	cmd.Flags().Duration("latest-block-retry-interval", time.Second, "interval to wait before retrying after a failed latest-block fetch")
-	cmd.Flags().Duration("max-block-fetch-duration", 3*time.Second, "maximum delay before considering a block fetch as failed")
-	cmd.Flags().Int("block-fetch-batch-size", 1, "Number of blocks to fetch in a single batch")
+	cmd.Flags().Duration("max-block-fetch-duration", 3*time.Second, "maximum delay before considering a block fetch from one endpoint as failed and trying the next one, not counting the wait for the block to be produced")
+	cmd.Flags().Int("block-fetch-batch-size", 1, "Number of ledgers requested per getLedgers call")
+	cmd.Flags().Int("block-prefetch-depth", 0, "number of ledgers to fetch ahead in the background while the current one is converted, 0 disables read-ahead; read-ahead pages are cut to it when it is below --block-fetch-batch-size")
 	cmd.Flags().Int("transaction-fetch-limit", 200, "Maximum number of transactions to fetch at the same time")
+	cmd.Flags().String("endpoint-selection-strategy", "sticky", "how getLedgers calls are routed across --endpoints: 'sticky' keeps the current endpoint until it fails, 'scored' picks the endpoint with the best recent latency, error rate and head height")
+	cmd.Flags().Duration("endpoint-head-refresh-interval", 5*time.Second, "interval between getLatestLedger probes of every endpoint, used to score endpoints and report their head height")
+	cmd.Flags().Int("rpc-retry-max-attempts", rpc.DefaultRetryPolicy.MaxAttempts, "maximum number of attempts of an rpc request failing with a retryable error (429, 5xx, connection error, JSON-RPC internal error), 1 disables retries")
+	cmd.Flags().Duration("rpc-retry-initial-backoff", rpc.DefaultRetryPolicy.InitialBackoff, "upper bound of the jittered wait before the first retry, doubled on each following retry")
+	cmd.Flags().Duration("rpc-retry-max-backoff", rpc.DefaultRetryPolicy.MaxBackoff, "maximum jittered wait between two retries")
+	cmd.Flags().Duration("rpc-retry-max-retry-after", rpc.DefaultRetryPolicy.MaxRetryAfter, "longest Retry-After asked by an endpoint we wait for in place, longer ones fail the request so another endpoint is tried")
+	cmd.Flags().Int("rpc-circuit-breaker-failures", rpc.DefaultCircuitBreakerFailures, "consecutive retryable failures after which an endpoint is skipped for --rpc-circuit-breaker-cooldown, 0 disables the circuit breaker")
+	cmd.Flags().Duration("rpc-circuit-breaker-cooldown", rpc.DefaultCircuitBreakerCooldown, "how long an endpoint is skipped once its circuit breaker opens, before a single probe request is let through")
 	cmd.Flags().String("stellar-rpc-network", "mainnet", "stellar network the rpc endpoint serves (mainnet, testnet, or custom)")
-	cmd.Flags().String("stellar-rpc-network-passphrase", "", "override network passphrase (required for custom; overrides the value derived from --stellar-rpc-network when set)")
+	cmd.Flags().String("stellar-rpc-network-passphrase", "", "override network passphrase (required for custom; overrides the value derived from --stellar-rpc-network when set); 'auto' discovers it from the endpoints through getNetwork")
+	cmd.Flags().Bool("halt-on-unsupported-protocol", false, "fail on the first ledger whose protocol version is newer than the latest one firestellar supports, instead of converting it with a warning")
+	cmd.Flags().Bool("skip-network-passphrase-check", false, "do not verify at startup, through getNetwork, that every endpoint serves the configured network passphrase")
+	cmd.Flags().Bool("ignore-cursor", false, "ignore any persisted cursor.json and start from <first-streamable-block>")
+	addHTTPFlags(cmd)
+	addTracingFlags(cmd)
 
 	// Deprecated: --is-mainnet was the original flag and is kept for
 	// backwards compatibility. Prefer --stellar-rpc-network=mainnet|testnet
@@ -47,6 +65,11 @@ func NewFetchRpcCmd(logger *zap.Logger, tracer logging.Tracer) *cobra.Command {
 func fetchRpcRunE(logger *zap.Logger, tracer logging.Tracer) firecore.CommandExecutor {
 	return func(cmd *cobra.Command, args []string) (err error) {
 		stateDir := sflags.MustGetString(cmd, "state-dir")
+		store, closeStore, err := openCursorStore(stateDir, sflags.MustGetDuration(cmd, "state-save-interval"), logger)
+		if err != nil {
+			return err
+		}
+		defer closeStore()
 
 		startBlock, err := strconv.ParseUint(args[0], 10, 64)
 		if err != nil {
@@ -62,6 +85,11 @@ func fetchRpcRunE(logger *zap.Logger, tracer logging.Tracer) firecore.CommandExe
 			return err
 		}
 
+		selectionStrategy, err := rpc.ParseSelectionStrategy(sflags.MustGetString(cmd, "endpoint-selection-strategy"))
+		if err != nil {
+			return err
+		}
+
 		logger.Info(
 			"launching firehose-stellar poller",
 			zap.String("state_dir", stateDir),
@@ -69,40 +97,150 @@ func fetchRpcRunE(logger *zap.Logger, tracer logging.Tracer) firecore.CommandExe
 			zap.Duration("interval_between_fetch", fetchInterval),
 			zap.Duration("latest_block_retry_interval", latestBlockRetryInterval),
 			zap.String("network_passphrase", networkPassphrase),
+			zap.String("endpoint_selection_strategy", string(selectionStrategy)),
 		)
 
-		rollingStrategy := firecoreRPC.NewStickyRollingStrategy[*rpc.Client]()
+		reporter := newHealthReporter(cmd, rpc.MetricsBackend)
+		if err := serveHTTP(cmd, reporter, logger); err != nil {
+			return err
+		}
+
+		flushTraces, err := setupTracing(cmd, logger)
+		if err != nil {
+			return err
+		}
+		defer flushTraces()
+
+		retryPolicy := rpc.RetryPolicy{
+			MaxAttempts:    sflags.MustGetInt(cmd, "rpc-retry-max-attempts"),
+			InitialBackoff: sflags.MustGetDuration(cmd, "rpc-retry-initial-backoff"),
+			MaxBackoff:     sflags.MustGetDuration(cmd, "rpc-retry-max-backoff"),
+			MaxRetryAfter:  sflags.MustGetDuration(cmd, "rpc-retry-max-retry-after"),
+		}
+		circuitBreakerFailures := sflags.MustGetInt(cmd, "rpc-circuit-breaker-failures")
+		circuitBreakerCooldown := sflags.MustGetDuration(cmd, "rpc-circuit-breaker-cooldown")
 
 		rpcEndpoints := sflags.MustGetStringArray(cmd, "endpoints")
-		rpcClients := firecoreRPC.NewClients(maxBlockFetchDuration, rollingStrategy, logger)
+		clients := make([]*rpc.Client, 0, len(rpcEndpoints))
 		for _, rpcEndpoint := range rpcEndpoints {
-			client := rpc.NewClient(rpcEndpoint, logger, tracer)
-			rpcClients.Add(client)
+			endpointConfig, err := rpc.ParseEndpointConfig(rpcEndpoint)
+			if err != nil {
+				return err
+			}
+			client, err := rpc.NewClientFromConfig(endpointConfig, logger, tracer)
+			if err != nil {
+				return err
+			}
+			client.SetRetryPolicy(retryPolicy)
+			client.SetCircuitBreaker(circuitBreakerFailures, circuitBreakerCooldown)
+			clients = append(clients, client)
+
+			// Informational only: it labels the version metrics and logs,
+			// an endpoint too old to answer getVersionInfo still works.
+			if _, err := client.LoadVersionInfo(cmd.Context()); err != nil {
+				logger.Warn("unable to get rpc endpoint version", zap.String("endpoint", client.Endpoint()), zap.Error(err))
+			}
+		}
+
+		var unverified []*rpc.Client
+		if sflags.MustGetBool(cmd, "skip-network-passphrase-check") {
+			if networkPassphrase == "" {
+				return fmt.Errorf("--stellar-rpc-network-passphrase=auto cannot be used with --skip-network-passphrase-check")
+			}
+		} else {
+			// A wrong passphrase otherwise only shows up much later as
+			// "unknown tx hash in LedgerCloseMeta" while decoding ledgers.
+			networkPassphrase, unverified, err = rpc.VerifyNetworkPassphrase(cmd.Context(), clients, networkPassphrase, logger)
+			if err != nil {
+				return fmt.Errorf("%w, check --stellar-rpc-network and --stellar-rpc-network-passphrase", err)
+			}
+			logger.Info("rpc endpoints serve the expected network", zap.String("network_passphrase", networkPassphrase))
 		}
 
+		// The ledger source fails over between endpoints in order; the
+		// pool collects per-endpoint statistics and, in scored mode,
+		// overrides which client getLedgers goes to. Endpoints whose
+		// network could not be read are only used once it is.
+		endpointPool := rpc.NewEndpointPool(selectionStrategy, clients, logger)
+		endpointPool.RequireNetwork(networkPassphrase, unverified)
+		go endpointPool.Run(cmd.Context(), sflags.MustGetDuration(cmd, "endpoint-head-refresh-interval"))
+
 		transactionFetchLimit := sflags.MustGetInt(cmd, "transaction-fetch-limit")
 
-		poller := blockpoller.New(
-			rpc.NewFetcher(fetchInterval, latestBlockRetryInterval, transactionFetchLimit, networkPassphrase, logger),
-			blockpoller.NewFireBlockHandler("type.googleapis.com/sf.stellar.type.v1.Block"),
-			rpcClients,
-			blockpoller.WithStoringState[*rpc.Client](stateDir),
-			blockpoller.WithLogger[*rpc.Client](logger),
-		)
+		fetcher := rpc.NewFetcher(fetchInterval, latestBlockRetryInterval, transactionFetchLimit, networkPassphrase, logger)
+		fetcher.SetEndpointPool(endpointPool)
+		fetcher.SetLedgerPrefetch(sflags.MustGetInt(cmd, "block-fetch-batch-size"), sflags.MustGetInt(cmd, "block-prefetch-depth"))
+		ledgerSource := rpc.NewLedgerSource(fetcher, clients, maxBlockFetchDuration)
+		defer ledgerSource.Close()
 
-		err = poller.Run(startBlock, nil, sflags.MustGetInt(cmd, "block-fetch-batch-size"))
-		if err != nil {
-			return fmt.Errorf("running poller: %w", err)
+		converter := &captivecore.Fetcher{
+			NetworkPassphrase: networkPassphrase,
+			Logger:            logger,
+			Protocol:          protocol.NewGuard(rpc.MetricsBackend, sflags.MustGetBool(cmd, "halt-on-unsupported-protocol"), logger),
+		}
+		// The resume ledger is checked against the retention window of
+		// the endpoints when the source is prepared.
+		driver := source.NewDriver(ledgerSource, converter, blockpoller.NewFireBlockHandler("type.googleapis.com/sf.stellar.type.v1.Block"), source.Config{
+			Backend:           rpc.MetricsBackend,
+			StartBlock:        startBlock,
+			Cursor:            store,
+			NetworkPassphrase: networkPassphrase,
+			IgnoreCursor:      sflags.MustGetBool(cmd, "ignore-cursor"),
+			Reporter:          reporter,
+		}, logger)
+		if err := driver.Run(cmd.Context()); err != nil {
+			return fmt.Errorf("running rpc fetcher: %w", err)
 		}
 
 		return nil
 	}
 }
 
+// openCursorStore opens the cursor store at location, see cursor.Open. A
+// local state dir is locked, so that no other fetcher uses it while this
+// one runs, e.g. during a rolling deploy. The returned func writes the
+// cursor the store held back and releases the lock.
+func openCursorStore(location string, saveInterval time.Duration, logger *zap.Logger) (cursor.Store, func(), error) {
+	store, err := cursor.Open(location, saveInterval)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var lock *cursor.Lock
+	if dirStore, ok := store.(*cursor.DirStore); ok {
+		lock, err = cursor.Acquire(dirStore.Dir())
+		if err != nil {
+			return nil, nil, err
+		}
+		if lock != nil && lock.Reclaimed != nil {
+			logger.Warn("reclaimed the stale lock of a fetcher that did not release the state dir",
+				zap.String("state_dir", dirStore.Dir()),
+				zap.Int("previous_pid", lock.Reclaimed.PID),
+				zap.String("previous_host", lock.Reclaimed.Host),
+				zap.Time("previous_started_at", lock.Reclaimed.StartedAt),
+			)
+		}
+	}
+
+	return store, func() {
+		if err := store.Close(); err != nil {
+			logger.Error("unable to save the last cursor", zap.Stringer("state_dir", store), zap.Error(err))
+		}
+		if err := lock.Release(); err != nil {
+			logger.Warn("unable to release the state dir lock", zap.Error(err))
+		}
+	}, nil
+}
+
+// autoNetworkPassphrase as a passphrase flag value asks for the
+// passphrase to be discovered from the network itself.
+const autoNetworkPassphrase = "auto"
+
 // resolveRPCNetworkPassphrase derives the network passphrase to use for
 // the rpc fetcher. Resolution order, highest precedence first:
 //
-//  1. --stellar-rpc-network-passphrase=<string>  (explicit override)
+//  1. --stellar-rpc-network-passphrase=<string>  (explicit override, an
+//     empty passphrase is returned for 'auto', the caller discovers it)
 //  2. --stellar-rpc-network=mainnet|testnet|custom
 //  3. --is-mainnet  (deprecated; only consulted if the new flags are
 //     untouched)
@@ -112,6 +250,10 @@ func resolveRPCNetworkPassphrase(cmd *cobra.Command) (string, error) {
 	networkName := sflags.MustGetString(cmd, "stellar-rpc-network")
 	override := sflags.MustGetString(cmd, "stellar-rpc-network-passphrase")
 
+	if override == autoNetworkPassphrase {
+		return "", nil
+	}
+
 	// Explicit override always wins.
 	if override != "" {
 		return override, nil
@@ -132,7 +274,7 @@ func resolveRPCNetworkPassphrase(cmd *cobra.Command) (string, error) {
 	case "testnet":
 		return network.TestNetworkPassphrase, nil
 	case "custom":
-		return "", fmt.Errorf("--stellar-rpc-network-passphrase is required when --stellar-rpc-network=custom")
+		return "", fmt.Errorf("--stellar-rpc-network-passphrase is required when --stellar-rpc-network=custom, set it to 'auto' to discover it from the endpoints")
 	default:
 		return "", fmt.Errorf("unsupported stellar rpc network: %s (want mainnet|testnet|custom)", networkName)
 	}
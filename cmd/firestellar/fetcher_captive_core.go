@@ -1,13 +1,14 @@
 // Cobra wrapper around the captivecore package. All meaningful logic
-// lives in github.com/streamingfast/firehose-stellar/captivecore — this
-// file just parses flags into captivecore.Config and runs the
-// PrepareRange + GetBlock loop that firecore expects.
+// lives in github.com/streamingfast/firehose-stellar/captivecore and the
+// fetch loop shared with the rpc fetcher in .../source — this file just
+// parses flags into captivecore.Config and wires them together.
 package main
 
 import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -15,7 +16,9 @@ import (
 	firecore "github.com/streamingfast/firehose-core"
 	"github.com/streamingfast/firehose-core/blockpoller"
 	"github.com/streamingfast/firehose-stellar/captivecore"
-	"github.com/streamingfast/firehose-stellar/cursor"
+	"github.com/streamingfast/firehose-stellar/health"
+	"github.com/streamingfast/firehose-stellar/protocol"
+	"github.com/streamingfast/firehose-stellar/source"
 	"github.com/streamingfast/logging"
 	"go.uber.org/zap"
 )
@@ -31,10 +34,19 @@ func NewFetchCaptiveCoreCmd(logger *zap.Logger, tracer logging.Tracer) *cobra.Co
 	cmd.Flags().String("stellar-core-bin", "/usr/bin/stellar-core", "path to stellar-core binary")
 	cmd.Flags().String("stellar-core-conf", "", "path to stellar-core config file (empty = use bundled SDF default for the network; required for custom)")
 	cmd.Flags().String("stellar-core-network", "testnet", "stellar network (mainnet, testnet, or custom)")
-	cmd.Flags().String("stellar-core-network-passphrase", "", "override network passphrase (required for custom; overrides the value derived from --stellar-core-network when set)")
+	cmd.Flags().String("stellar-core-network-passphrase", "", "override network passphrase (required for custom; overrides the value derived from --stellar-core-network when set); 'auto' discovers it from the history archives")
+	cmd.Flags().Bool("skip-network-passphrase-check", false, "do not verify at startup that every history archive's .well-known/stellar-history.json names the configured network passphrase")
 	cmd.Flags().StringSlice("stellar-core-history-archive-urls", nil, "override history archive URLs (required for custom; overrides the values derived from --stellar-core-network when set)")
 	cmd.Flags().String("stellar-core-log-level", "info", "log level for stellar-core subprocess (debug, info, warn, error)")
-	cmd.Flags().String("state-dir", "/data/work", "directory used to persist the last-fired block (cursor.json) so restarts resume where they stopped")
+	cmd.Flags().Int("stellar-core-max-restarts", 5, "restarts of a failed stellar-core allowed within --stellar-core-restart-window before giving up, 0 exits on the first failure")
+	cmd.Flags().Duration("stellar-core-restart-window", 10*time.Minute, "window over which --stellar-core-max-restarts is counted")
+	cmd.Flags().Duration("stellar-core-restart-backoff", 5*time.Second, "delay before restarting a failed stellar-core, doubled on each consecutive restart")
+	cmd.Flags().Duration("stellar-core-restart-max-backoff", time.Minute, "upper bound of the delay between stellar-core restarts")
+	cmd.Flags().Bool("halt-on-unsupported-protocol", false, "stop with an error on the first ledger whose protocol version is newer than the latest one firestellar supports, instead of converting it with a warning")
+	cmd.Flags().String("state-dir", "/data/work", "directory, or dstore URL (file://, s3://, gs://...) for readers without a persistent disk, used to persist the last-fired block (cursor.json) so restarts resume where they stopped")
+	cmd.Flags().Duration("state-save-interval", 5*time.Second, "minimum delay between two cursor writes when --state-dir is a dstore URL, so a restart replays at most that much; a local --state-dir is written after every block")
+	addHTTPFlags(cmd)
+	addTracingFlags(cmd)
 	cmd.Flags().Bool("ignore-cursor", false, "ignore any persisted cursor.json and start from <first-streamable-block>")
 
 	return cmd
@@ -60,17 +72,40 @@ func fetchCaptiveCoreRunE(logger *zap.Logger, _ logging.Tracer) firecore.Command
 			StellarCoreConfPath: sflags.MustGetString(cmd, "stellar-core-conf"),
 			LogLevel:            logLevel,
 			Logger:              logger,
+
+			HaltOnUnsupportedProtocol: sflags.MustGetBool(cmd, "halt-on-unsupported-protocol"),
 		}
 		if err := cfg.ResolveNetwork(sflags.MustGetString(cmd, "stellar-core-network")); err != nil {
 			return err
 		}
-		if pass := sflags.MustGetString(cmd, "stellar-core-network-passphrase"); pass != "" {
+		pass := sflags.MustGetString(cmd, "stellar-core-network-passphrase")
+		switch pass {
+		case "":
+		case autoNetworkPassphrase:
+			cfg.NetworkPassphrase = ""
+		default:
 			cfg.NetworkPassphrase = pass
 		}
 		if urls := sflags.MustGetStringSlice(cmd, "stellar-core-history-archive-urls"); len(urls) > 0 {
 			cfg.HistoryArchiveURLs = urls
 		}
 
+		if sflags.MustGetBool(cmd, "skip-network-passphrase-check") {
+			if pass == autoNetworkPassphrase {
+				return fmt.Errorf("--stellar-core-network-passphrase=auto cannot be used with --skip-network-passphrase-check")
+			}
+		} else {
+			if cfg.NetworkPassphrase == "" && pass != autoNetworkPassphrase {
+				return fmt.Errorf("--stellar-core-network-passphrase is required when --stellar-core-network=custom, set it to 'auto' to discover it from the history archives")
+			}
+			// A wrong passphrase otherwise only shows up much later as
+			// "unknown tx hash in LedgerCloseMeta" while decoding ledgers.
+			if err := cfg.VerifyNetwork(cmd.Context()); err != nil {
+				return err
+			}
+			logger.Info("history archives belong to the expected network", zap.String("network_passphrase", cfg.NetworkPassphrase))
+		}
+
 		// For custom networks, the bundled toml data is nil. The user
 		// must supply --stellar-core-conf in that case (captivecore
 		// validation also enforces this).
@@ -78,68 +113,62 @@ func fetchCaptiveCoreRunE(logger *zap.Logger, _ logging.Tracer) firecore.Command
 			return fmt.Errorf("--stellar-core-conf is required for custom network (no bundled default)")
 		}
 
-		backend, err := captivecore.New(cfg)
-		if err != nil {
+		reporter := newHealthReporter(cmd, captivecore.MetricsBackend)
+		if err := serveHTTP(cmd, reporter, logger); err != nil {
 			return err
 		}
-		defer backend.Close()
-
-		handler := blockpoller.NewFireBlockHandler("type.googleapis.com/sf.stellar.type.v1.Block")
-		handler.Init()
 
-		stateDir := sflags.MustGetString(cmd, "state-dir")
-		ignoreCursor := sflags.MustGetBool(cmd, "ignore-cursor")
-
-		seq := startBlock
-		if !ignoreCursor {
-			persisted, err := cursor.Load(stateDir)
-			if err != nil {
-				return fmt.Errorf("loading cursor: %w", err)
-			}
-			if persisted != nil {
-				resumeFrom := persisted.LastFiredBlock.Num + 1
-				if resumeFrom > startBlock {
-					seq = resumeFrom
-					logger.Info("resuming from persisted cursor",
-						zap.String("state_dir", stateDir),
-						zap.Uint64("last_fired_block", persisted.LastFiredBlock.Num),
-						zap.Uint64("resume_block", seq),
-					)
-				} else {
-					logger.Info("persisted cursor is below first streamable block, ignoring",
-						zap.Uint64("last_fired_block", persisted.LastFiredBlock.Num),
-						zap.Uint64("first_streamable_block", startBlock),
-					)
-				}
-			}
+		flushTraces, err := setupTracing(cmd, logger)
+		if err != nil {
+			return err
 		}
+		defer flushTraces()
 
-		ctx := cmd.Context()
-		if err := backend.PrepareRange(ctx, seq); err != nil {
+		store, closeStore, err := openCursorStore(sflags.MustGetString(cmd, "state-dir"), sflags.MustGetDuration(cmd, "state-save-interval"), logger)
+		if err != nil {
 			return err
 		}
+		defer closeStore()
+
+		// stellar-core crashing is not fatal: the supervisor starts a new
+		// one, resuming after the last block whose cursor was saved.
+		supervisorConfig := captivecore.SupervisorConfig{
+			MaxRestarts:    sflags.MustGetInt(cmd, "stellar-core-max-restarts"),
+			RestartWindow:  sflags.MustGetDuration(cmd, "stellar-core-restart-window"),
+			InitialBackoff: sflags.MustGetDuration(cmd, "stellar-core-restart-backoff"),
+			MaxBackoff:     sflags.MustGetDuration(cmd, "stellar-core-restart-max-backoff"),
+			Resume: func(requested uint64) (uint64, error) {
+				persisted, _, err := store.Load(cmd.Context())
+				if err != nil {
+					return 0, fmt.Errorf("loading cursor: %w", err)
+				}
+				if persisted == nil || persisted.LastFiredBlock.Num+1 > requested {
+					return requested, nil
+				}
+				return persisted.LastFiredBlock.Num + 1, nil
+			},
+			OnPrepare:  func(uint64) { reporter.SetState(health.StatePreparing) },
+			OnPrepared: func() { reporter.SetState(health.StateStreaming) },
+		}
+		backend := captivecore.NewSupervisor(func() (source.LedgerSource, error) {
+			return captivecore.New(cfg)
+		}, supervisorConfig, logger)
+		defer backend.Close()
 
-		for {
-			if err := ctx.Err(); err != nil {
-				return err
-			}
-
-			blk, err := backend.GetBlock(ctx, seq)
-			if err != nil {
-				return fmt.Errorf("get block %d: %w", seq, err)
-			}
-
-			logger.Info("processing block", zap.Uint64("seq", seq), zap.String("hash", blk.Id))
-			if err := handler.Handle(blk); err != nil {
-				return fmt.Errorf("handling block %d: %w", blk.Number, err)
-			}
-
-			if err := cursor.Save(stateDir, blk); err != nil {
-				return fmt.Errorf("saving cursor at block %d: %w", blk.Number, err)
-			}
-
-			seq++
+		converter := &captivecore.Fetcher{
+			NetworkPassphrase: cfg.NetworkPassphrase,
+			Logger:            logger,
+			Protocol:          protocol.NewGuard(captivecore.MetricsBackend, cfg.HaltOnUnsupportedProtocol, logger),
 		}
+		driver := source.NewDriver(backend, converter, blockpoller.NewFireBlockHandler("type.googleapis.com/sf.stellar.type.v1.Block"), source.Config{
+			Backend:           captivecore.MetricsBackend,
+			StartBlock:        startBlock,
+			Cursor:            store,
+			NetworkPassphrase: cfg.NetworkPassphrase,
+			IgnoreCursor:      sflags.MustGetBool(cmd, "ignore-cursor"),
+			Reporter:          reporter,
+		}, logger)
+		return driver.Run(cmd.Context())
 	}
 }
 
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/cli/sflags"
+	"github.com/streamingfast/firehose-stellar/health"
+	"go.uber.org/zap"
+)
+
+func addHTTPFlags(cmd *cobra.Command) {
+	cmd.Flags().String("metrics-listen-addr", "", "address serving Prometheus metrics on /metrics (e.g. ':9103'), empty disables it; keep it apart from the firecore metrics address when running under firecore")
+	cmd.Flags().String("health-listen-addr", "", "address serving /healthz and /readyz (e.g. ':9104'), empty disables them; may be the same as --metrics-listen-addr")
+	cmd.Flags().Duration("readiness-max-block-age", health.DefaultStaleAfter, "/readyz fails when the last fired block closed longer ago than this, and /healthz when no block was fired for this long; ledgers close about every 5s")
+}
+
+// newHealthReporter returns the reporter fed by the command, serving
+// /healthz and /readyz once serveHTTP runs.
+func newHealthReporter(cmd *cobra.Command, backend string) *health.Reporter {
+	return health.NewReporter(backend, sflags.MustGetDuration(cmd, "readiness-max-block-age"))
+}
+
+// serveHTTP starts the metrics and health listeners that are enabled,
+// sharing one listener when they use the same address. They stop with
+// the command context.
+func serveHTTP(cmd *cobra.Command, reporter *health.Reporter, logger *zap.Logger) error {
+	muxes := map[string]*http.ServeMux{}
+	mux := func(addr string) *http.ServeMux {
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		return muxes[addr]
+	}
+
+	if addr := sflags.MustGetString(cmd, "metrics-listen-addr"); addr != "" {
+		mux(addr).Handle("/metrics", promhttp.Handler())
+	}
+	if addr := sflags.MustGetString(cmd, "health-listen-addr"); addr != "" {
+		reporter.Register(mux(addr))
+	}
+
+	for addr, handler := range muxes {
+		if err := listenAndServe(cmd.Context(), addr, handler, logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listenAndServe serves handler on addr until ctx is done. It returns
+// once the listener is bound, so a bad address fails the caller right
+// away.
+func listenAndServe(ctx context.Context, addr string, handler http.Handler, logger *zap.Logger) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", addr, err)
+	}
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("http server stopped", zap.String("addr", addr), zap.Error(err))
+		}
+	}()
+
+	logger.Info("serving http", zap.String("addr", listener.Addr().String()))
+	return nil
+}
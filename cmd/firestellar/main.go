@@ -43,11 +43,21 @@ func main() {
 			CobraCmd(NewFetchCaptiveCoreCmd(logger, tracer)),
 		),
 
+		Group("cursor", "Inspect and move the cursor persisted in a fetcher state dir",
+			CobraCmd(NewCursorShowCmd()),
+			CobraCmd(NewCursorSetCmd(logger)),
+			CobraCmd(NewCursorResetCmd(logger)),
+			CobraCmd(NewCursorMigrateCmd(logger)),
+		),
+
 		Group("fix", "One-shot maintenance commands for stored blocks",
 			CobraCmd(fix.NewToolsFixBlockHashesCmd(logger)),
 		),
 
 		CobraCmd(NewToolDecodeBlockCmd()),
+		CobraCmd(NewToolBuildTxIndexCmd(logger)),
+		CobraCmd(NewToolCompactTxIndexCmd(logger)),
+		CobraCmd(NewToolFindTxCmd(logger)),
 		CobraCmd(NewToolCreateAccountCmd()),
 		CobraCmd(NewToolSendPaymentCmd()),
 		CobraCmd(NewToolIssueAssetCmd()),
@@ -116,8 +116,15 @@ func runCompareFetcherBlocksE(cmd *cobra.Command, args []string) error {
 }
 
 func fetchBlockViaFetcher(ctx context.Context, blockNum uint64, rpcEndpoint, networkName string, logger *zap.Logger) (*pbbstream.Block, error) {
-	// Create a client
-	client := rpc.NewClient(rpcEndpoint, logger, nil)
+	// Create a client, accepting the same endpoint options as --endpoints
+	endpointConfig, err := rpc.ParseEndpointConfig(rpcEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	client, err := rpc.NewClientFromConfig(endpointConfig, logger, tracer)
+	if err != nil {
+		return nil, err
+	}
 
 	// Resolve the network passphrase from the --network flag (was previously
 	// hardcoded to mainnet, regardless of the flag value).
@@ -133,6 +140,7 @@ func fetchBlockViaFetcher(ctx context.Context, blockNum uint64, rpcEndpoint, net
 
 	// Create a Fetcher instance
 	fetcher := rpc.NewFetcher(0, time.Second, 200, passphrase, logger)
+	defer fetcher.Close()
 
 	// Fetch the block
 	block, skipped, err := fetcher.Fetch(ctx, client, blockNum)
@@ -6,41 +6,77 @@ package main
 // firehose-core@v1.14.x still expects in its own
 // firehose-core/json/marshallers.go. The Jan 2025+ snapshots renamed
 // NewMarshalers -> JoinMarshalers and MarshalFuncV2 -> MarshalToFunc;
-// keeping the old names here is a temporary shim. When firehose-core
-// bumps its pin / renames its call sites, flip the names below back
-// to JoinMarshalers / MarshalToFunc and bump go-json-experiment in
-// go.mod.
+// keeping the old names in package blockjson is a temporary shim. When
+// firehose-core bumps its pin / renames its call sites, flip the names
+// there back to JoinMarshalers / MarshalToFunc and bump
+// go-json-experiment in go.mod.
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
 
 	"github.com/bobg/go-generics/v3/slices"
-	"github.com/go-json-experiment/json"
-	"github.com/go-json-experiment/json/jsontext"
 	"github.com/spf13/cobra"
 	xdrTypes "github.com/stellar/go-stellar-sdk/xdr"
 	"github.com/streamingfast/bstream"
 	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
 	"github.com/streamingfast/cli"
+	"github.com/streamingfast/cli/sflags"
 	"github.com/streamingfast/dstore"
 	"github.com/streamingfast/firehose-core/types"
+	"github.com/streamingfast/firehose-stellar/blockjson"
 	"github.com/streamingfast/firehose-stellar/decoder"
 	pbstellar "github.com/streamingfast/firehose-stellar/pb/sf/stellar/type/v1"
-	"go.uber.org/zap"
-	"google.golang.org/protobuf/types/known/timestamppb"
+	"github.com/streamingfast/firehose-stellar/rpc"
+	"github.com/streamingfast/firehose-stellar/toid"
+	"github.com/streamingfast/firehose-stellar/txfilter"
 )
 
 func NewToolDecodeBlockCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "tool-decode-block <store> <block-range>",
 		Short: "Tool to decode a firehose block",
-		Args:  cobra.ExactArgs(2),
-		RunE:  runDecodeBlockE,
+		Long: cli.Dedent(`
+			Decode firehose blocks to JSON Lines, one block per line, their
+			transactions included: the envelope, the result and the contract,
+			diagnostic and transaction events, topics and data as JSON ScVals.
+			The marshalers are the ones of package blockjson. 'firecore tools
+			print' prints the XDR as bytes instead, firecore has no hook to
+			register them.
+
+			The filter flags keep the transactions matching all of them, and one
+			of the values of each, given comma-separated or repeated. Blocks left
+			without transactions are not printed. An account matches the
+			transactions it is the source of or takes part in, a G... address
+			including its muxed M... accounts. For example, the transfers of a
+			contract to an account:
+
+			  firestellar tool-decode-block <store> 100000:101000 \
+			    --contract-id C... --event-topic transfer --account G... \
+			    | jq '.Transactions[].Hash'
+
+			Blocks do not carry the TransactionMeta of their transactions. With
+			--meta-rpc-endpoint, the meta of each ledger is fetched from
+			stellar-rpc and the ledger entry changes of each transaction are
+			printed under its Meta: the ones before its operations, the ones of
+			each operation and the ones after. The ledgers must still be in the
+			retention window of the endpoint.
+		`),
+		Args: cobra.ExactArgs(2),
+		RunE: runDecodeBlockE,
 	}
 
 	cmd.Flags().String("trx-hash", "", "Transaction hash to filter block on")
+	cmd.Flags().StringSlice("account", nil, "Keep the transactions of these G... or M... accounts, as source, fee source, operation source or participant, like a destination, a trustor, a Soroban authorizer or an address topic of a contract event")
+	cmd.Flags().StringSlice("operation-type", nil, "Keep the transactions with operations of these types, in snake_case, like payment or invoke_host_function")
+	cmd.Flags().StringSlice("contract-id", nil, "Keep the transactions invoking these C... contracts, directly or in sub-invocations, or with contract events they emitted")
+	cmd.Flags().StringSlice("event-topic", nil, "Keep the transactions with contract events having one of these topics: symbols and strings by their text, addresses by their strkey")
+	cmd.Flags().StringSlice("asset", nil, "Keep the transactions moving, trading or trusting these assets, native, CODE:ISSUER or CODE for any issuer")
+	cmd.Flags().StringSlice("status", nil, "Keep the transactions with these statuses, success or failed")
+	cmd.Flags().Bool("strip-nondeterministic", false, "Drop the diagnostic events that differ between two runs of the same ledger, like core_metrics invoke_time_nsecs, to compare the output of two fetchers")
+	cmd.Flags().String("meta-rpc-endpoint", "", "stellar-rpc endpoint to fetch the TransactionMeta of the transactions from, with the options of --endpoints, to print their ledger entry changes")
 
 	return cmd
 }
@@ -69,39 +105,78 @@ func runDecodeBlockE(cmd *cobra.Command, args []string) error {
 		options = append(options, bstream.FileSourceWithStopBlock(blockRange.MustGetStopBlock()))
 	}
 
-	trxHash := cmd.Flag("trx-hash").Value.String()
+	filterConfig := txfilter.Config{
+		Accounts:       sflags.MustGetStringSlice(cmd, "account"),
+		OperationTypes: sflags.MustGetStringSlice(cmd, "operation-type"),
+		ContractIds:    sflags.MustGetStringSlice(cmd, "contract-id"),
+		EventTopics:    sflags.MustGetStringSlice(cmd, "event-topic"),
+		Assets:         sflags.MustGetStringSlice(cmd, "asset"),
+		Statuses:       sflags.MustGetStringSlice(cmd, "status"),
+	}
+	if trxHash := sflags.MustGetString(cmd, "trx-hash"); trxHash != "" {
+		filterConfig.Hashes = []string{trxHash}
+	}
+	filter, err := txfilter.New(filterConfig)
+	if err != nil {
+		return err
+	}
+
+	stripNonDeterministic := sflags.MustGetBool(cmd, "strip-nondeterministic")
+
+	var metaClient *rpc.Client
+	if endpoint := sflags.MustGetString(cmd, "meta-rpc-endpoint"); endpoint != "" {
+		endpointConfig, err := rpc.ParseEndpointConfig(endpoint)
+		if err != nil {
+			return err
+		}
+		metaClient, err = rpc.NewClientFromConfig(endpointConfig, logger, tracer)
+		if err != nil {
+			return err
+		}
+	}
 
 	source := bstream.NewFileSource(store, uint64(blockRange.GetStartBlock()), bstream.HandlerFunc(func(blk *pbbstream.Block, obj any) error {
 		if !blockRange.Contains(blk.Number, types.RangeBoundaryExclusive) {
 			return nil
 		}
 
-		msg, err := blk.Payload.UnmarshalNew()
+		stellarBlock, err := decodeStellarBlock(blk)
 		if err != nil {
-			return fmt.Errorf("unmarshalling block payload: %w", err)
+			return err
 		}
-		stellarBlock, ok := msg.(*pbstellar.Block)
-		if !ok {
-			return fmt.Errorf("unexpected type %T", msg)
+
+		if err := stampTransactionToids(stellarBlock, blk.Number); err != nil {
+			return err
 		}
 
-		if trxHash != "" {
+		if !filter.IsEmpty() {
+			var matchErr error
 			stellarBlock.Transactions = slices.Filter(stellarBlock.Transactions, func(tx *pbstellar.Transaction) bool {
-				return hex.EncodeToString(tx.Hash) == trxHash
+				matched, err := filter.Match(tx)
+				if err != nil && matchErr == nil {
+					matchErr = err
+				}
+				return matched
 			})
+			if matchErr != nil {
+				return fmt.Errorf("filtering block %d: %w", blk.Number, matchErr)
+			}
+			if len(stellarBlock.Transactions) == 0 {
+				return nil
+			}
+		}
+
+		marshalOptions := blockjson.Options{StripNonDeterministic: stripNonDeterministic}
+		if metaClient != nil {
+			marshalOptions.Metas, err = fetchTransactionMetas(cmd.Context(), metaClient, blk.Number)
+			if err != nil {
+				return err
+			}
 		}
 
-		out, err := json.Marshal(stellarBlock,
-			json.WithMarshalers(json.NewMarshalers(
-				json.MarshalFuncV2(marshalBytes),
-				json.MarshalFuncV2(marshalAccountId),
-				json.MarshalFuncV2(marshalTransaction),
-				json.MarshalFuncV2(marshalMuxedAccount),
-			)),
-			jsontext.WithIndent("  "),
-		)
+		out, err := blockjson.Marshal(stellarBlock, marshalOptions)
 		if err != nil {
-			return fmt.Errorf("unable to marshal: %w", err)
+			return fmt.Errorf("unable to marshal block %d: %w", blk.Number, err)
 		}
 
 		fmt.Println(string(out))
@@ -119,95 +194,42 @@ func runDecodeBlockE(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func marshalBytes(e *jsontext.Encoder, value []byte, options json.Options) error {
-	return e.WriteToken(jsontext.String(hex.EncodeToString(value[:])))
-}
-
-func marshalAccountId(e *jsontext.Encoder, value xdrTypes.AccountId, options json.Options) error {
-	return e.WriteToken(jsontext.String(value.Address()))
-}
-
-func marshalMuxedAccount(e *jsontext.Encoder, value xdrTypes.MuxedAccount, options json.Options) error {
-	return e.WriteToken(jsontext.String(value.Address()))
-}
-
-func marshalTransaction(e *jsontext.Encoder, value *pbstellar.Transaction, options json.Options) error {
-	decoder := decoder.NewDecoder(zap.NewNop())
-
-	transactionEnvelope, err := decoder.DecodeTransactionEnvelopeFromBytes(value.EnvelopeXdr)
-	if err != nil {
-		return fmt.Errorf("unable to decode transaction envelope: %w", err)
+// stampTransactionToids sets the TOID of the transactions of block num
+// merged before transactions carried it.
+func stampTransactionToids(block *pbstellar.Block, num uint64) error {
+	for _, tx := range block.Transactions {
+		if tx.Toid != 0 {
+			continue
+		}
+		id, err := toid.Transaction(uint32(num), uint32(tx.ApplicationOrder))
+		if err != nil {
+			return fmt.Errorf("transaction %x id: %w", tx.Hash, err)
+		}
+		tx.Toid = id.Uint64()
 	}
+	return nil
+}
 
-	transactionResult, err := decoder.DecodeTransactionResultFromBytes(value.ResultXdr)
+// fetchTransactionMetas returns the TransactionMeta of the transactions
+// of ledger num, by hex-encoded hash, as client serves them.
+func fetchTransactionMetas(ctx context.Context, client *rpc.Client, num uint64) (map[string]xdrTypes.TransactionMeta, error) {
+	result, err := client.GetLedgers(ctx, num, 1, "")
 	if err != nil {
-		return fmt.Errorf("unable to decode transaction result: %w", err)
+		return nil, fmt.Errorf("fetching the meta of ledger %d from %s: %w", num, client.Endpoint(), err)
 	}
-
-	type DecodedTransaction struct {
-		Hash             []byte
-		Status           pbstellar.TransactionStatus
-		CreatedAt        *timestamppb.Timestamp
-		ApplicationOrder uint64
-		EnvelopeXdr      *xdrTypes.TransactionEnvelope
-		ResultXdr        *xdrTypes.TransactionResult
+	if len(result.Ledgers) == 0 || result.Ledgers[0].Sequence != num {
+		return nil, fmt.Errorf("ledger %d is not served by %s, its retention window is %d to %d", num, client.Endpoint(), result.OldestLedger, result.LatestLedger)
 	}
 
-	// // FIXME: once the transaction hash is fixed, we can remove this
-	// fixHash := base64.StdEncoding.EncodeToString(value.Hash)
-	// fixHashB, err := hex.DecodeString(fixHash)
-	// if err != nil {
-	// 	return fmt.Errorf("unable to decode hash: %w", err)
-	// }
-	trx := &DecodedTransaction{
-		Hash:             value.Hash,
-		Status:           value.Status,
-		CreatedAt:        value.CreatedAt,
-		ApplicationOrder: value.ApplicationOrder,
-		EnvelopeXdr:      transactionEnvelope,
-		ResultXdr:        transactionResult,
-	}
-
-	out, err := json.Marshal(trx,
-		json.WithMarshalers(json.NewMarshalers(
-			json.MarshalFuncV2(marshalBytes),
-			json.MarshalFuncV2(marshalAccountId),
-			json.MarshalFuncV2(marshalMuxedAccount),
-		)),
-	)
+	closeMeta, err := decoder.NewDecoder(logger).DecodeLedgerMetadata(result.Ledgers[0].MetadataXdr)
 	if err != nil {
-		return fmt.Errorf("unable to marshal: %w", err)
+		return nil, fmt.Errorf("decoding the meta of ledger %d: %w", num, err)
 	}
 
-	return e.WriteValue(out)
-}
-
-func printLedgerEntryChanges(changes []xdrTypes.LedgerEntryChange) {
-	for _, change := range changes {
-		fmt.Printf("LedgerEntryChange: %s\n", change.Type)
-
-		if change.Created != nil {
-			fmt.Printf("\tCreated %s\n", printLedgerEntry(change.Created))
-		}
-
-		if change.Updated != nil {
-			fmt.Printf("\tUpdated %s\n", printLedgerEntry(change.Updated))
-		}
-
-		if change.Removed != nil {
-			fmt.Printf("\tRemoved %s\n", printLedgerKey(change.Removed))
-		}
-
-		if change.State != nil {
-			fmt.Printf("\tState %s\n", printLedgerEntry(change.State))
-		}
+	metas := make(map[string]xdrTypes.TransactionMeta, closeMeta.CountTransactions())
+	for i := 0; i < closeMeta.CountTransactions(); i++ {
+		hash := closeMeta.TransactionHash(i)
+		metas[hex.EncodeToString(hash[:])] = closeMeta.TxApplyProcessing(i)
 	}
-}
-
-func printLedgerEntry(ledgerEntry *xdrTypes.LedgerEntry) string {
-	return fmt.Sprintf("Type: %v Account: %v Balance: %v\n", ledgerEntry.Data.Type, ledgerEntry.Data.Account.AccountId.Address(), ledgerEntry.Data.Account.Balance)
-}
-
-func printLedgerKey(ledgerKey *xdrTypes.LedgerKey) string {
-	return fmt.Sprintf("Type: %v Account: %v\n", ledgerKey.Type, ledgerKey.Account.AccountId.Address())
+	return metas, nil
 }
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"github.com/spf13/cobra"
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/cli"
+	"github.com/streamingfast/cli/sflags"
+	"github.com/streamingfast/dstore"
+	"github.com/streamingfast/firehose-core/cmd/tools/check"
+	"github.com/streamingfast/firehose-core/types"
+	"github.com/streamingfast/firehose-stellar/blockjson"
+	pbstellar "github.com/streamingfast/firehose-stellar/pb/sf/stellar/type/v1"
+	"github.com/streamingfast/firehose-stellar/txindex"
+	"go.uber.org/zap"
+)
+
+// The transaction index has one file per merged blocks bundle and the
+// shard files looked up by hash, see package txindex, in a store of its
+// own.
+
+func NewToolBuildTxIndexCmd(logger *zap.Logger) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tool-build-tx-index <store> <block-range>",
+		Short: "Index the transactions of merged blocks by hash, for tool-find-tx",
+		Long: cli.Dedent(`
+			Write to --index-store the transaction index file of each merged
+			blocks bundle of <store> in <block-range>, mapping the hash of each
+			transaction to its block and position. Bundles are indexed whole,
+			including the blocks of the range's first and last bundles outside
+			of it. An open range, e.g. 60000000:, goes up to the last bundle of
+			<store>.
+
+			The transactions of the bundles are then added to the shards of the
+			index, one per 12-bit hash prefix, --batch-bundles bundles at a
+			time: each batch writes a segment of each shard it touches, which
+			is most of them, without reading or rewriting the shard files.
+			Builds over other bundles may run at the same time. A lookup reads
+			every segment of its shard, so merge them into the shard files
+			with tool-compact-tx-index once in a while, and use large batches
+			for a backfill, at the cost of memory, about 150 bytes per
+			transaction.
+
+			Bundles already indexed are skipped, so running it again over an
+			open range indexes the bundles merged since, e.g. from a cron job.
+			--overwrite indexes them again, replacing their transactions in
+			the shard files.
+		`),
+		Example: cli.Dedent(`
+			firestellar tool-build-tx-index gs://my-bucket/stellar/merged-blocks 60000000: --index-store gs://my-bucket/stellar/tx-index
+		`),
+		Args: cobra.ExactArgs(2),
+		RunE: runBuildTxIndexE(logger),
+	}
+
+	cmd.Flags().String("index-store", "", "dstore URL to write the transaction index files to")
+	cmd.Flags().Bool("overwrite", false, "rebuild the index files of the bundles already indexed")
+	cmd.Flags().Int("concurrency", 8, "number of bundles indexed, and of index files written, at a time")
+	cmd.Flags().Int("batch-bundles", 1000, "number of bundles indexed before adding their transactions to the shards, as one segment of each")
+
+	return cmd
+}
+
+func NewToolCompactTxIndexCmd(logger *zap.Logger) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tool-compact-tx-index <index-store>",
+		Short: "Merge the shard segments tool-build-tx-index wrote into the shard files of the index",
+		Long: cli.Dedent(`
+			Merge the segments of each shard of the transaction index at
+			<index-store>, one per tool-build-tx-index batch, into the shard
+			file and delete them, so lookups read a single file again.
+
+			It holds shards/compact.lock in <index-store> while it runs and
+			fails when another compaction holds it; delete it when that
+			compaction died. The store has no conditional write, so do not
+			start two compactions at the same time either, nor run it along a
+			build with --overwrite, whose segments it could delete. Other
+			builds may run, their segments are merged by the next compaction.
+		`),
+		Args: cobra.ExactArgs(1),
+		RunE: runCompactTxIndexE(logger),
+	}
+
+	cmd.Flags().Int("concurrency", 8, "number of shards merged at a time")
+
+	return cmd
+}
+
+func NewToolFindTxCmd(logger *zap.Logger) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tool-find-tx <store> <index-store> <trx-hash>",
+		Short: "Print a transaction of merged blocks, decoded, looking it up in the index of tool-build-tx-index",
+		Long: cli.Dedent(`
+			Look the hex-encoded transaction hash up in the transaction index
+			files of <index-store>, read its block from the merged blocks of
+			<store> and print it, decoded like tool-decode-block does, with the
+			number and id of its block.
+
+			A lookup reads the shard file of the hash and its segments written
+			since the last tool-compact-tx-index, then only the bundle of the
+			transaction.
+		`),
+		Args: cobra.ExactArgs(3),
+		RunE: runFindTxE(logger),
+	}
+
+	cmd.Flags().Bool("strip-nondeterministic", false, "Drop the diagnostic events that differ between two runs of the same ledger, like core_metrics invoke_time_nsecs")
+
+	return cmd
+}
+
+func runBuildTxIndexE(logger *zap.Logger) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		store, err := dstore.NewDBinStore(args[0])
+		if err != nil {
+			return fmt.Errorf("creating merged blocks store: %w", err)
+		}
+		indexStoreURL := sflags.MustGetString(cmd, "index-store")
+		if indexStoreURL == "" {
+			return fmt.Errorf("--index-store is required")
+		}
+		indexStore, err := dstore.NewSimpleStore(indexStoreURL, dstore.AllowOverwrite())
+		if err != nil {
+			return fmt.Errorf("creating index store: %w", err)
+		}
+
+		blockRange, err := types.GetBlockRangeFromArg(args[1])
+		if err != nil {
+			return fmt.Errorf("parsing block range: %w", err)
+		}
+		startBlock := uint64(blockRange.GetStartBlock())
+		overwrite := sflags.MustGetBool(cmd, "overwrite")
+		concurrency := max(sflags.MustGetInt(cmd, "concurrency"), 1)
+		batchSize := max(sflags.MustGetInt(cmd, "batch-bundles"), 1)
+
+		var bases []uint64
+		skippedBundles := 0
+		err = store.Walk(ctx, check.WalkBlockPrefix(blockRange, mergedBundleSize), func(filename string) error {
+			base, err := strconv.ParseUint(filename, 10, 64)
+			if err != nil {
+				// Not a bundle, e.g. a one-block file.
+				return nil
+			}
+			if !blockRange.IsOpen() && base >= blockRange.MustGetStopBlock() {
+				return dstore.StopIteration
+			}
+			if base+mergedBundleSize <= startBlock {
+				return nil
+			}
+
+			if !overwrite {
+				exists, err := txindex.Exists(ctx, indexStore, base)
+				if err != nil {
+					return err
+				}
+				if exists {
+					skippedBundles++
+					return nil
+				}
+			}
+			bases = append(bases, base)
+			return nil
+		})
+		if err != nil && !errors.Is(err, dstore.StopIteration) {
+			return fmt.Errorf("walking merged blocks: %w", err)
+		}
+
+		transactions := 0
+		for len(bases) > 0 {
+			batch := bases[:min(batchSize, len(bases))]
+			bases = bases[len(batch):]
+
+			builders := make([]*txindex.Builder, len(batch))
+			indexes := make([]*txindex.Index, len(batch))
+			err := forEachConcurrently(ctx, len(batch), concurrency, func(ctx context.Context, i int) error {
+				builder, err := indexBundle(ctx, store, batch[i])
+				if err != nil {
+					return err
+				}
+				index, err := txindex.Parse(builder.Bytes())
+				if err != nil {
+					return err
+				}
+				builders[i], indexes[i] = builder, index
+				logger.Debug("indexed bundle", zap.Uint64("base", batch[i]), zap.Int("transactions", index.Len()))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			// The bundle files are written last: a bundle with one is
+			// in the shard files and skipped by the next run.
+			if err := txindex.UpdateShards(ctx, indexStore, indexes, concurrency); err != nil {
+				return fmt.Errorf("updating index shards: %w", err)
+			}
+			err = forEachConcurrently(ctx, len(batch), concurrency, func(ctx context.Context, i int) error {
+				return txindex.Write(ctx, indexStore, builders[i])
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, index := range indexes {
+				transactions += index.Len()
+			}
+			logger.Info("indexed bundles", zap.Uint64("first", batch[0]), zap.Uint64("last", batch[len(batch)-1]), zap.Int("remaining", len(bases)))
+		}
+
+		fmt.Printf("Indexed %d transactions to %s, skipped %d bundles already indexed\n", transactions, indexStoreURL, skippedBundles)
+		return nil
+	}
+}
+
+func runCompactTxIndexE(logger *zap.Logger) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		indexStore, err := dstore.NewSimpleStore(args[0], dstore.AllowOverwrite())
+		if err != nil {
+			return fmt.Errorf("creating index store: %w", err)
+		}
+
+		merged, err := txindex.CompactShards(cmd.Context(), indexStore, max(sflags.MustGetInt(cmd, "concurrency"), 1))
+		if err != nil {
+			return fmt.Errorf("compacting index shards: %w", err)
+		}
+		logger.Debug("compacted index shards", zap.String("index_store", args[0]), zap.Int("segments", merged))
+
+		fmt.Printf("Merged %d shard segments into the shard files of %s\n", merged, args[0])
+		return nil
+	}
+}
+
+// forEachConcurrently calls f for 0 to n-1, concurrency calls at a time,
+// and returns the first error, canceling the ctx of the others.
+func forEachConcurrently(ctx context.Context, n, concurrency int, f func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		lock     sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	work := make(chan int)
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if err := f(ctx, i); err != nil {
+					lock.Lock()
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := range n {
+		select {
+		case work <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// indexBundle returns the index of the transactions of the bundle
+// starting at base.
+func indexBundle(ctx context.Context, store dstore.Store, base uint64) (*txindex.Builder, error) {
+	blocks, err := readMergedBundle(ctx, store, fmt.Sprintf("%010d", base), base, base+mergedBundleSize, false, false)
+	if err != nil {
+		return nil, fmt.Errorf("reading bundle %010d: %w", base, err)
+	}
+
+	builder, err := txindex.NewBuilder(base)
+	if err != nil {
+		return nil, err
+	}
+	for num, blk := range blocks {
+		stellarBlock, err := decodeStellarBlock(blk)
+		if err != nil {
+			return nil, err
+		}
+		for position, tx := range stellarBlock.Transactions {
+			if err := builder.Add(tx.Hash, num, uint32(position)); err != nil {
+				return nil, fmt.Errorf("indexing block %d: %w", num, err)
+			}
+		}
+	}
+	return builder, nil
+}
+
+// foundTransaction is what tool-find-tx prints.
+type foundTransaction struct {
+	BlockNumber uint64
+	BlockId     string
+	Transaction *pbstellar.Transaction
+}
+
+func runFindTxE(logger *zap.Logger) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		indexStore, err := dstore.NewSimpleStore(args[1])
+		if err != nil {
+			return fmt.Errorf("creating index store: %w", err)
+		}
+		hash, err := hex.DecodeString(args[2])
+		if err != nil {
+			return fmt.Errorf("transaction hash %q is not hex-encoded: %w", args[2], err)
+		}
+
+		location, err := txindex.Find(ctx, indexStore, hash)
+		if err != nil {
+			return fmt.Errorf("looking transaction %s up in %s: %w", args[2], args[1], err)
+		}
+		logger.Debug("transaction found in index", zap.Uint64("block_num", location.BlockNum), zap.Uint32("position", location.Position))
+
+		blk, err := lookUpMergedBlock(ctx, args[0], location.BlockNum)
+		if err != nil {
+			return err
+		}
+		stellarBlock, err := decodeStellarBlock(blk)
+		if err != nil {
+			return err
+		}
+		if int(location.Position) >= len(stellarBlock.Transactions) || !bytes.Equal(stellarBlock.Transactions[location.Position].Hash, hash) {
+			return fmt.Errorf("transaction %s is not at position %d of block %d as indexed, rebuild the index of its bundle with tool-build-tx-index --overwrite", args[2], location.Position, location.BlockNum)
+		}
+		if err := stampTransactionToids(stellarBlock, blk.Number); err != nil {
+			return err
+		}
+
+		marshalOptions := blockjson.Options{StripNonDeterministic: sflags.MustGetBool(cmd, "strip-nondeterministic")}
+		out, err := json.Marshal(&foundTransaction{
+			BlockNumber: blk.Number,
+			BlockId:     blk.Id,
+			Transaction: stellarBlock.Transactions[location.Position],
+		}, json.WithMarshalers(blockjson.Marshalers(marshalOptions)), jsontext.WithIndent("  "))
+		if err != nil {
+			return fmt.Errorf("unable to marshal transaction %s: %w", args[2], err)
+		}
+
+		fmt.Println(string(out))
+		return nil
+	}
+}
+
+// decodeStellarBlock returns the pbstellar.Block payload of blk.
+func decodeStellarBlock(blk *pbbstream.Block) (*pbstellar.Block, error) {
+	msg, err := blk.Payload.UnmarshalNew()
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling block %d payload: %w", blk.Number, err)
+	}
+	stellarBlock, ok := msg.(*pbstellar.Block)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %T in block %d", msg, blk.Number)
+	}
+	return stellarBlock, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/cli/sflags"
+	"github.com/streamingfast/firehose-stellar/tracing"
+	"go.uber.org/zap"
+)
+
+func addTracingFlags(cmd *cobra.Command) {
+	cmd.Flags().String("tracing-exporter", tracing.ExporterNone, "OpenTelemetry exporter for the per-ledger traces (none, stdout or otlp); stdout pretty-prints spans on stderr, otlp sends them over OTLP/HTTP as configured by the OTEL_EXPORTER_OTLP_* environment variables")
+	cmd.Flags().Float64("tracing-sample-ratio", 1, "fraction of ledgers traced when --tracing-exporter is set")
+}
+
+// setupTracing installs the exporter picked by the tracing flags. The
+// returned func flushes the spans not exported yet.
+func setupTracing(cmd *cobra.Command, logger *zap.Logger) (func(), error) {
+	shutdown, err := tracing.Setup(cmd.Context(), sflags.MustGetString(cmd, "tracing-exporter"), sflags.MustGetFloat64(cmd, "tracing-sample-ratio"), logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			logger.Warn("unable to flush traces", zap.Error(err))
+		}
+	}, nil
+}
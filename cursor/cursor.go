@@ -4,13 +4,22 @@
 // between fetchers that use this package and ones backed by the
 // upstream blockpoller. Stellar is final at close, so Lib ==
 // LastFiredBlock and the Blocks fork-history slice stays empty.
+//
+// Saves are atomic: cursor.json is replaced by renaming a fully written
+// and synced temporary file over it. The last HistorySize cursors are
+// also kept in cursor-history.<slot>.json files, which Load falls back
+// to when cursor.json is corrupted. network.json records the network
+// the cursors belong to, and cursor.lock the fetcher that Acquired the
+// state dir.
 package cursor
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
 )
@@ -36,32 +45,163 @@ type State struct {
 	Blocks         []BlockRefWithPrev
 }
 
+// validate rejects states no Save could have written, e.g. a file
+// zeroed by a crash that still decodes.
+func (s *State) validate() error {
+	if s.LastFiredBlock.Id == "" {
+		return errors.New("last fired block has no id")
+	}
+	if s.Lib.Num > s.LastFiredBlock.Num {
+		return fmt.Errorf("lib %d is past last fired block %d", s.Lib.Num, s.LastFiredBlock.Num)
+	}
+	return nil
+}
+
+// HistorySize is the number of previous cursors kept next to
+// cursor.json.
+const HistorySize = 5
+
 const fileName = "cursor.json"
 
 func path(stateDir string) string {
 	return filepath.Join(stateDir, fileName)
 }
 
+func historyPath(stateDir string, slot int) string {
+	return filepath.Join(stateDir, fmt.Sprintf("cursor-history.%d.json", slot))
+}
+
+// Report tells where Load found the state.
+type Report struct {
+	// Path of the file the state was read from.
+	Path string
+
+	// SavedAt is when the state was written, zero when the store cannot
+	// tell.
+	SavedAt time.Time
+
+	// Invalid lists the files that were skipped because they could not
+	// be read or decoded. Not empty means cursor.json was corrupted and
+	// the state comes from the history, up to HistorySize-1 blocks
+	// behind.
+	Invalid []error
+}
+
 // Load returns the persisted state, or (nil, nil) if stateDir is empty
-// or the file does not yet exist.
+// or the file does not yet exist. See LoadReport.
 func Load(stateDir string) (*State, error) {
+	s, _, err := LoadReport(stateDir)
+	return s, err
+}
+
+// LoadReport returns the persisted state, from cursor.json or, when it
+// exists but is unreadable, the newest valid cursor of the history. It
+// fails when none of them is valid. Without cursor.json, nothing was
+// saved yet and the history, if any, is left over from a cursor deleted
+// on purpose.
+func LoadReport(stateDir string) (*State, *Report, error) {
 	if stateDir == "" {
-		return nil, nil
+		return nil, nil, nil
+	}
+
+	s, err := read(path(stateDir))
+	if err == nil {
+		return s, newReport(path(stateDir)), nil
+	}
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+
+	invalid := []error{err}
+	var newest *State
+	var newestPath string
+	for slot := range HistorySize {
+		candidate, err := read(historyPath(stateDir, slot))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				invalid = append(invalid, err)
+			}
+			continue
+		}
+		if newest == nil || candidate.LastFiredBlock.Num > newest.LastFiredBlock.Num {
+			newest = candidate
+			newestPath = historyPath(stateDir, slot)
+		}
+	}
+
+	if newest == nil {
+		return nil, nil, fmt.Errorf("no valid cursor in %s: %w", stateDir, errors.Join(invalid...))
+	}
+	report := newReport(newestPath)
+	report.Invalid = invalid
+	return newest, report, nil
+}
+
+func newReport(filePath string) *Report {
+	report := &Report{Path: filePath}
+	if info, err := os.Stat(filePath); err == nil {
+		report.SavedAt = info.ModTime()
 	}
-	data, err := os.ReadFile(path(stateDir))
+	return report
+}
+
+// Delete removes the cursor of stateDir, its history and the network
+// recorded, so the next run starts over. The lock is left alone. No-op
+// when stateDir is empty.
+func Delete(stateDir string) error {
+	if stateDir == "" {
+		return nil
+	}
+	files := []string{path(stateDir), filepath.Join(stateDir, networkFileName)}
+	for slot := range HistorySize {
+		files = append(files, historyPath(stateDir, slot))
+	}
+
+	// cursor.json goes first: without it, Load ignores a history left
+	// behind by a failed Delete.
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("delete cursor: %w", err)
+		}
+	}
+	return nil
+}
+
+func read(filePath string) (*State, error) {
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, nil
+			return nil, err
 		}
-		return nil, fmt.Errorf("read cursor: %w", err)
+		return nil, fmt.Errorf("read cursor %s: %w", filePath, err)
 	}
+	return decode(data, filePath)
+}
+
+// decode returns the state in data, read from location.
+func decode(data []byte, location string) (*State, error) {
 	var s State
 	if err := json.Unmarshal(data, &s); err != nil {
-		return nil, fmt.Errorf("decode cursor: %w", err)
+		return nil, fmt.Errorf("decode cursor %s: %w", location, err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid cursor %s: %w", location, err)
 	}
 	return &s, nil
 }
 
+// newState returns the state recording blk as the last fired block.
+func newState(blk *pbbstream.Block) *State {
+	return &State{
+		Lib: BlockRef{Id: blk.Id, Num: blk.Number},
+		LastFiredBlock: BlockRefWithPrev{
+			BlockRef:    BlockRef{Id: blk.Id, Num: blk.Number},
+			PrevBlockId: blk.ParentId,
+		},
+		Blocks: []BlockRefWithPrev{},
+	}
+}
+
 // Save records blk as the last fired block. No-op when stateDir is
 // empty.
 func Save(stateDir string, blk *pbbstream.Block) error {
@@ -71,20 +211,114 @@ func Save(stateDir string, blk *pbbstream.Block) error {
 	if err := os.MkdirAll(stateDir, 0o755); err != nil {
 		return fmt.Errorf("mkdir state dir: %w", err)
 	}
-	s := State{
-		Lib: BlockRef{Id: blk.Id, Num: blk.Number},
-		LastFiredBlock: BlockRefWithPrev{
-			BlockRef:    BlockRef{Id: blk.Id, Num: blk.Number},
-			PrevBlockId: blk.ParentId,
-		},
-		Blocks: []BlockRefWithPrev{},
-	}
-	data, err := json.Marshal(s)
+	data, err := json.Marshal(newState(blk))
 	if err != nil {
 		return fmt.Errorf("marshal cursor: %w", err)
 	}
-	if err := os.WriteFile(path(stateDir), data, 0o644); err != nil {
+
+	// The history only backs cursor.json up, a copy lost in a crash is
+	// skipped by Load: it is not synced.
+	if err := writeAtomic(historyPath(stateDir, int(blk.Number%HistorySize)), data, false); err != nil {
+		return fmt.Errorf("write cursor history: %w", err)
+	}
+	if err := writeAtomic(path(stateDir), data, true); err != nil {
 		return fmt.Errorf("write cursor: %w", err)
 	}
 	return nil
 }
+
+const networkFileName = "network.json"
+
+// network is the content of network.json, kept apart from cursor.json
+// whose schema is the blockpoller's.
+type network struct {
+	NetworkPassphrase string `json:"network_passphrase"`
+}
+
+// SaveNetwork records the passphrase of the network the cursors of
+// stateDir belong to. No-op when stateDir is empty.
+func SaveNetwork(stateDir, passphrase string) error {
+	if stateDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return fmt.Errorf("mkdir state dir: %w", err)
+	}
+	data, err := json.Marshal(network{NetworkPassphrase: passphrase})
+	if err != nil {
+		return fmt.Errorf("marshal network: %w", err)
+	}
+	if err := writeAtomic(filepath.Join(stateDir, networkFileName), data, true); err != nil {
+		return fmt.Errorf("write network: %w", err)
+	}
+	return nil
+}
+
+// LoadNetwork returns the passphrase SaveNetwork recorded, empty when
+// stateDir is empty or none was recorded, e.g. by a fetcher predating
+// it.
+func LoadNetwork(stateDir string) (string, error) {
+	if stateDir == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(filepath.Join(stateDir, networkFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("read network: %w", err)
+	}
+	return decodeNetwork(data)
+}
+
+func decodeNetwork(data []byte) (string, error) {
+	var n network
+	if err := json.Unmarshal(data, &n); err != nil {
+		return "", fmt.Errorf("decode network: %w", err)
+	}
+	return n.NetworkPassphrase, nil
+}
+
+// writeAtomic replaces filePath with data, so that readers see either
+// the previous content or data, never a mix. With durable, data is also
+// on disk when writeAtomic returns.
+func writeAtomic(filePath string, data []byte, durable bool) error {
+	dir := filepath.Dir(filePath)
+	tmp, err := os.CreateTemp(dir, filepath.Base(filePath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if durable {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return err
+	}
+	if !durable {
+		return nil
+	}
+
+	// Persist the rename itself.
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,128 @@
+package cursor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func saveBlocks(t *testing.T, stateDir string, from, to uint64) {
+	t.Helper()
+
+	for num := from; num <= to; num++ {
+		require.NoError(t, Save(stateDir, &pbbstream.Block{
+			Number:   num,
+			Id:       fmt.Sprintf("%064x", num),
+			ParentId: fmt.Sprintf("%064x", num-1),
+		}))
+	}
+}
+
+func Test_SaveLoad(t *testing.T) {
+	stateDir := filepath.Join(t.TempDir(), "state")
+
+	s, err := Load(stateDir)
+	require.NoError(t, err)
+	require.Nil(t, s)
+
+	saveBlocks(t, stateDir, 100, 110)
+
+	s, report, err := LoadReport(stateDir)
+	require.NoError(t, err)
+	require.Equal(t, uint64(110), s.LastFiredBlock.Num)
+	require.Equal(t, fmt.Sprintf("%064x", 109), s.LastFiredBlock.PrevBlockId)
+	require.Equal(t, s.LastFiredBlock.BlockRef, s.Lib)
+	require.Equal(t, filepath.Join(stateDir, "cursor.json"), report.Path)
+	require.WithinDuration(t, time.Now(), report.SavedAt, time.Minute)
+	require.Empty(t, report.Invalid)
+
+	// The blockpoller schema, untouched.
+	data, err := os.ReadFile(filepath.Join(stateDir, "cursor.json"))
+	require.NoError(t, err)
+	require.JSONEq(t, fmt.Sprintf(`{
+		"Lib": {"id": "%064x", "num": 110},
+		"LastFiredBlock": {"id": "%064x", "num": 110, "previous_ref_id": "%064x"},
+		"Blocks": []
+	}`, 110, 110, 109), string(data))
+
+	entries, err := os.ReadDir(stateDir)
+	require.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.ElementsMatch(t, []string{
+		"cursor.json",
+		"cursor-history.0.json", "cursor-history.1.json", "cursor-history.2.json", "cursor-history.3.json", "cursor-history.4.json",
+	}, names, "no temporary file is left behind")
+}
+
+func Test_Load_FallsBackToHistory(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"truncated", `{"Lib":{"id":"00`},
+		{"empty", ``},
+		{"zeroed", "\x00\x00\x00\x00"},
+		{"no block", `{"Lib":{"id":"","num":0},"LastFiredBlock":{"id":"","num":0,"previous_ref_id":""},"Blocks":[]}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stateDir := t.TempDir()
+			saveBlocks(t, stateDir, 100, 107)
+			require.NoError(t, os.WriteFile(filepath.Join(stateDir, "cursor.json"), []byte(test.content), 0o644))
+
+			// A torn history copy is skipped too.
+			require.NoError(t, os.WriteFile(filepath.Join(stateDir, "cursor-history.2.json"), nil, 0o644))
+
+			s, report, err := LoadReport(stateDir)
+			require.NoError(t, err)
+			require.Equal(t, uint64(106), s.LastFiredBlock.Num)
+			require.Equal(t, filepath.Join(stateDir, "cursor-history.1.json"), report.Path)
+			require.Len(t, report.Invalid, 2)
+		})
+	}
+
+	t.Run("no valid cursor", func(t *testing.T) {
+		stateDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(stateDir, "cursor.json"), []byte(`{`), 0o644))
+
+		_, err := Load(stateDir)
+		require.ErrorContains(t, err, "no valid cursor in "+stateDir)
+	})
+
+	t.Run("cursor deleted", func(t *testing.T) {
+		stateDir := t.TempDir()
+		saveBlocks(t, stateDir, 100, 103)
+		require.NoError(t, os.Remove(filepath.Join(stateDir, "cursor.json")))
+
+		s, err := Load(stateDir)
+		require.NoError(t, err)
+		require.Nil(t, s, "the history does not bring back a cursor deleted on purpose")
+	})
+}
+
+func Test_Delete(t *testing.T) {
+	stateDir := t.TempDir()
+	saveBlocks(t, stateDir, 100, 107)
+	require.NoError(t, SaveNetwork(stateDir, "Test SDF Network ; September 2015"))
+	lock, err := Acquire(stateDir)
+	require.NoError(t, err)
+	defer lock.Release()
+
+	require.NoError(t, Delete(stateDir))
+
+	entries, err := os.ReadDir(stateDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "cursor.lock", entries[0].Name(), "the lock is left alone")
+
+	require.NoError(t, Delete(stateDir), "deleting twice is fine")
+}
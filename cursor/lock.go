@@ -0,0 +1,135 @@
+package cursor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const lockFileName = "cursor.lock"
+
+// errLocked is returned by lockFile when another process holds the lock.
+var errLocked = errors.New("locked")
+
+// Owner identifies the process holding the lock of a state dir. It is
+// written to the lock file for operators, the lock itself is the
+// advisory lock the operating system holds on the file.
+type Owner struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+func (o *Owner) String() string {
+	return fmt.Sprintf("pid %d on %s since %s", o.PID, o.Host, o.StartedAt.Format(time.RFC3339))
+}
+
+// LockedError is returned by Acquire when another process holds the
+// lock of the state dir.
+type LockedError struct {
+	StateDir string
+
+	// Owner is the process holding the lock, nil when it could not be
+	// read, e.g. a lock taken before it was written.
+	Owner *Owner
+}
+
+func (e *LockedError) Error() string {
+	owner := "another process"
+	if e.Owner != nil {
+		owner = e.Owner.String()
+	}
+	return fmt.Sprintf("state dir %s is locked by %s: another fetcher is already using it, point --state-dir elsewhere or stop it first", e.StateDir, owner)
+}
+
+// Lock is the exclusive hold of a process on a state dir, so that two
+// fetchers never overwrite each other's cursor. The operating system
+// drops it when the process exits, however it exits: a lock file left
+// behind is stale and reclaimed by the next Acquire.
+type Lock struct {
+	file *os.File
+
+	// Reclaimed is the owner of the stale lock this one replaced, nil
+	// when the previous owner released it.
+	Reclaimed *Owner
+}
+
+// Acquire locks stateDir for this process, failing with a *LockedError
+// when another process holds it. It returns a nil Lock when stateDir is
+// empty.
+func Acquire(stateDir string) (*Lock, error) {
+	if stateDir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return nil, fmt.Errorf("mkdir state dir: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(stateDir, lockFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open lock: %w", err)
+	}
+	if err := lockFile(file); err != nil {
+		owner, _ := readOwner(file)
+		file.Close()
+		if errors.Is(err, errLocked) {
+			return nil, &LockedError{StateDir: stateDir, Owner: owner}
+		}
+		return nil, fmt.Errorf("lock %s: %w", file.Name(), err)
+	}
+
+	// Release empties the file: an owner still in it never released it.
+	reclaimed, _ := readOwner(file)
+
+	host, _ := os.Hostname()
+	data, err := json.Marshal(Owner{PID: os.Getpid(), Host: host, StartedAt: time.Now().UTC()})
+	if err == nil {
+		err = rewrite(file, data)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("write lock owner: %w", err)
+	}
+	return &Lock{file: file, Reclaimed: reclaimed}, nil
+}
+
+// Release empties the lock file and unlocks it. The file is not removed:
+// a process that opened it meanwhile would lock a file no longer there.
+// No-op on a nil Lock.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	err := rewrite(l.file, nil)
+	// Closing the file drops the lock.
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readOwner returns the owner written in file, nil when it is empty.
+func readOwner(file *os.File) (*Owner, error) {
+	data, err := os.ReadFile(file.Name())
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	var owner Owner
+	if err := json.Unmarshal(data, &owner); err != nil {
+		return nil, err
+	}
+	return &owner, nil
+}
+
+func rewrite(file *os.File, data []byte) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package cursor
+
+import "os"
+
+// lockFile does not lock on platforms without flock: the lock file only
+// records the owner there.
+func lockFile(*os.File) error {
+	return nil
+}
@@ -0,0 +1,58 @@
+package cursor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Acquire(t *testing.T) {
+	stateDir := filepath.Join(t.TempDir(), "state")
+
+	lock, err := Acquire(stateDir)
+	require.NoError(t, err)
+	require.Nil(t, lock.Reclaimed)
+
+	_, err = Acquire(stateDir)
+	var locked *LockedError
+	require.ErrorAs(t, err, &locked)
+	require.Equal(t, os.Getpid(), locked.Owner.PID)
+	require.ErrorContains(t, err, "state dir "+stateDir+" is locked by pid")
+
+	require.NoError(t, lock.Release())
+
+	lock, err = Acquire(stateDir)
+	require.NoError(t, err)
+	require.Nil(t, lock.Reclaimed, "a released lock is not stale")
+	require.NoError(t, lock.Release())
+
+	lock, err = Acquire("")
+	require.NoError(t, err)
+	require.Nil(t, lock)
+	require.NoError(t, lock.Release())
+}
+
+func Test_Acquire_ReclaimsStaleLock(t *testing.T) {
+	stateDir := t.TempDir()
+
+	// Left behind by a process that died holding the lock.
+	stale := Owner{PID: 4242, Host: "reader-0", StartedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	data, err := json.Marshal(stale)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(stateDir, "cursor.lock"), data, 0o644))
+
+	lock, err := Acquire(stateDir)
+	require.NoError(t, err)
+	defer lock.Release()
+	require.Equal(t, &stale, lock.Reclaimed)
+
+	data, err = os.ReadFile(filepath.Join(stateDir, "cursor.lock"))
+	require.NoError(t, err)
+	var owner Owner
+	require.NoError(t, json.Unmarshal(data, &owner))
+	require.Equal(t, os.Getpid(), owner.PID)
+}
@@ -0,0 +1,74 @@
+package cursor
+
+import (
+	"errors"
+	"fmt"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+)
+
+// Migration is the conversion of the state a firehose-core blockpoller
+// saved, see MigrateBlockpoller.
+type Migration struct {
+	// Blocks are the blocks to Save, oldest first, the last one being the
+	// last fired block: saved in order, they leave the state dir as a
+	// fetcher of this package firing them would have.
+	Blocks []*pbbstream.Block
+
+	// Dropped are the blocks of the fork history that are not ancestors
+	// of the last fired block: forks, and blocks fetched but not fired.
+	Dropped []BlockRefWithPrev
+}
+
+// MigrateBlockpoller converts state, saved by the firehose-core
+// blockpoller the rpc fetcher used to run on, into the cursors the
+// fetchers of this package save. The blockpoller keeps its LIB behind
+// the last fired block and the blocks since in a fork history, Blocks.
+// Stellar is final at close, so the fetchers of this package keep the
+// LIB on the last fired block, no fork history, and instead the last
+// HistorySize cursors next to cursor.json. The history is rebuilt from
+// the chain of Blocks ending on the last fired block, the other blocks
+// are dropped. It fails when that chain skips a ledger.
+//
+// The other way needs no conversion: the blockpoller reads the
+// cursor.json of a fetcher of this package as it is.
+func MigrateBlockpoller(state *State) (*Migration, error) {
+	if state == nil {
+		return nil, errors.New("no cursor to migrate")
+	}
+
+	byID := make(map[string]BlockRefWithPrev, len(state.Blocks))
+	for _, blk := range state.Blocks {
+		byID[blk.Id] = blk
+	}
+
+	last := state.LastFiredBlock
+	chain := []BlockRefWithPrev{last}
+	kept := map[string]bool{last.Id: true}
+	for len(chain) < HistorySize {
+		oldest := chain[len(chain)-1]
+		parent, found := byID[oldest.PrevBlockId]
+		if !found {
+			break
+		}
+		if parent.Num+1 != oldest.Num {
+			return nil, fmt.Errorf("fork history is not a chain: block %d (%s) has block %d (%s) for parent", oldest.Num, oldest.Id, parent.Num, parent.Id)
+		}
+		chain = append(chain, parent)
+		kept[parent.Id] = true
+	}
+
+	migration := &Migration{}
+	for i := len(chain) - 1; i >= 0; i-- {
+		migration.Blocks = append(migration.Blocks, &pbbstream.Block{Number: chain[i].Num, Id: chain[i].Id, ParentId: chain[i].PrevBlockId})
+	}
+	oldest := chain[len(chain)-1].Num
+	for _, blk := range state.Blocks {
+		// Blocks older than the history are ancestors past HistorySize,
+		// final, not dropped.
+		if !kept[blk.Id] && blk.Num >= oldest {
+			migration.Dropped = append(migration.Dropped, blk)
+		}
+	}
+	return migration, nil
+}
@@ -0,0 +1,102 @@
+package cursor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func blockpollerRef(num uint64, id, prevID string) string {
+	return fmt.Sprintf(`{"id": %q, "num": %d, "previous_ref_id": %q}`, id, num, prevID)
+}
+
+func Test_MigrateBlockpoller(t *testing.T) {
+	id := func(num uint64) string { return fmt.Sprintf("%064x", num) }
+	fork := fmt.Sprintf("%064x", 0xf102)
+
+	// A blockpoller state dir: LIB behind the last fired block 103, a fork
+	// at 102 and block 104 fetched but not fired.
+	from := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(from, "cursor.json"), fmt.Appendf(nil, `{
+		"Lib": {"id": %q, "num": 100},
+		"LastFiredBlock": %s,
+		"Blocks": [%s, %s, %s, %s, %s]
+	}`, id(100),
+		blockpollerRef(103, id(103), id(102)),
+		blockpollerRef(101, id(101), id(100)),
+		blockpollerRef(102, id(102), id(101)),
+		blockpollerRef(102, fork, id(101)),
+		blockpollerRef(103, id(103), id(102)),
+		blockpollerRef(104, id(104), id(103)),
+	), 0o644))
+
+	state, err := Load(from)
+	require.NoError(t, err)
+
+	migration, err := MigrateBlockpoller(state)
+	require.NoError(t, err)
+	nums := make([]uint64, 0, len(migration.Blocks))
+	for _, blk := range migration.Blocks {
+		nums = append(nums, blk.Number)
+	}
+	require.Equal(t, []uint64{101, 102, 103}, nums)
+	require.Equal(t, id(100), migration.Blocks[0].ParentId)
+	require.Equal(t, []BlockRefWithPrev{
+		{BlockRef: BlockRef{Id: fork, Num: 102}, PrevBlockId: id(101)},
+		{BlockRef: BlockRef{Id: id(104), Num: 104}, PrevBlockId: id(103)},
+	}, migration.Dropped)
+
+	to := filepath.Join(t.TempDir(), "state")
+	for _, blk := range migration.Blocks {
+		require.NoError(t, Save(to, blk))
+	}
+
+	migrated, err := Load(to)
+	require.NoError(t, err)
+	require.Equal(t, BlockRef{Id: id(103), Num: 103}, migrated.LastFiredBlock.BlockRef)
+	require.Equal(t, migrated.LastFiredBlock.BlockRef, migrated.Lib)
+	require.Empty(t, migrated.Blocks)
+
+	// The history is rebuilt: a corrupted cursor.json falls back to it.
+	previous, err := read(historyPath(to, 102%HistorySize))
+	require.NoError(t, err)
+	require.Equal(t, id(102), previous.LastFiredBlock.Id)
+
+	t.Run("without fork history", func(t *testing.T) {
+		migration, err := MigrateBlockpoller(newState(migration.Blocks[2]))
+		require.NoError(t, err)
+		require.Len(t, migration.Blocks, 1)
+		require.Empty(t, migration.Dropped)
+	})
+
+	t.Run("history capped", func(t *testing.T) {
+		state := &State{LastFiredBlock: BlockRefWithPrev{BlockRef: BlockRef{Id: id(110), Num: 110}, PrevBlockId: id(109)}}
+		for num := uint64(101); num <= 110; num++ {
+			state.Blocks = append(state.Blocks, BlockRefWithPrev{BlockRef: BlockRef{Id: id(num), Num: num}, PrevBlockId: id(num - 1)})
+		}
+
+		migration, err := MigrateBlockpoller(state)
+		require.NoError(t, err)
+		require.Len(t, migration.Blocks, HistorySize)
+		require.Equal(t, uint64(106), migration.Blocks[0].Number)
+		require.Empty(t, migration.Dropped)
+	})
+
+	t.Run("not a chain", func(t *testing.T) {
+		state := &State{
+			LastFiredBlock: BlockRefWithPrev{BlockRef: BlockRef{Id: id(103), Num: 103}, PrevBlockId: id(101)},
+			Blocks:         []BlockRefWithPrev{{BlockRef: BlockRef{Id: id(101), Num: 101}, PrevBlockId: id(100)}},
+		}
+
+		_, err := MigrateBlockpoller(state)
+		require.ErrorContains(t, err, "fork history is not a chain")
+	})
+
+	t.Run("no cursor", func(t *testing.T) {
+		_, err := MigrateBlockpoller(nil)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,254 @@
+package cursor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
+)
+
+// ObjectStore is the part of dstore.Store a RemoteStore uses.
+type ObjectStore interface {
+	OpenObject(ctx context.Context, name string) (io.ReadCloser, error)
+	WriteObject(ctx context.Context, base string, f io.Reader) error
+	DeleteObject(ctx context.Context, base string) error
+}
+
+// ConflictError is returned by RemoteStore.Save when the remote cursor
+// is no longer the one the store last read or wrote: another reader
+// writes to the same location.
+type ConflictError struct {
+	Location string
+
+	// Expected is the last fired block of the cursor last read or
+	// written, nil when there was none.
+	Expected *BlockRef
+
+	// Found is the last fired block of the remote cursor, nil when it is
+	// gone.
+	Found *BlockRef
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("cursor %s was moved by another reader: it is at %s, expected %s", e.Location, describeRef(e.Found), describeRef(e.Expected))
+}
+
+func describeRef(ref *BlockRef) string {
+	if ref == nil {
+		return "no block"
+	}
+	return fmt.Sprintf("block %d (%s)", ref.Num, ref.Id)
+}
+
+// RemoteStore is a Store over an object store, e.g. a bucket, for
+// readers that lose their disk when rescheduled. Objects are replaced
+// whole, so unlike a DirStore it needs no history.
+//
+// Writes are throttled: Save writes the cursor only when saveInterval
+// elapsed since the last write and holds it back otherwise, so a restart
+// replays up to saveInterval of ledgers. Each write first checks the
+// remote cursor is still the one last read or written, a
+// compare-and-swap on the previous block that stops two readers from
+// silently overwriting each other. dstore offers no conditional write,
+// so when two writes race between the check and the write both succeed:
+// the reader whose write was lost only notices at its next write.
+type RemoteStore struct {
+	objects      ObjectStore
+	location     string
+	saveInterval time.Duration
+
+	mu sync.Mutex
+	// known is whether remote was read or written: before that, the
+	// first write takes over whatever cursor is there, e.g. when the
+	// cursor is ignored.
+	known  bool
+	remote *BlockRef
+	// pending is the state saved but not written yet.
+	pending   *State
+	lastWrite time.Time
+}
+
+// NewRemoteStore returns the store of the objects at location, writing
+// the cursor at most every saveInterval.
+func NewRemoteStore(objects ObjectStore, location string, saveInterval time.Duration) *RemoteStore {
+	return &RemoteStore{
+		objects:      objects,
+		location:     strings.TrimSuffix(location, "/"),
+		saveInterval: saveInterval,
+	}
+}
+
+// Load returns the state last saved through the store, written or not,
+// and the remote cursor before the first Save.
+func (s *RemoteStore) Load(ctx context.Context) (*State, *Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := &Report{Path: s.objectURL(fileName)}
+	if s.pending != nil {
+		return s.pending, report, nil
+	}
+
+	state, err := s.read(ctx)
+	if err != nil || state == nil {
+		return nil, nil, err
+	}
+	return state, report, nil
+}
+
+// read returns the remote cursor, nil when there is none, and records
+// its block as the one to compare to.
+func (s *RemoteStore) read(ctx context.Context) (*State, error) {
+	data, err := s.readObject(ctx, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("read cursor %s: %w", s.objectURL(fileName), err)
+	}
+
+	s.known = true
+	s.remote = nil
+	if data == nil {
+		return nil, nil
+	}
+	state, err := decode(data, s.objectURL(fileName))
+	if err != nil {
+		return nil, err
+	}
+	s.remote = &state.LastFiredBlock.BlockRef
+	return state, nil
+}
+
+func (s *RemoteStore) Save(ctx context.Context, blk *pbbstream.Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending = newState(blk)
+	if time.Since(s.lastWrite) < s.saveInterval {
+		return nil
+	}
+	return s.flush(ctx)
+}
+
+// flush writes the pending state, if the remote cursor did not move
+// since it was last read or written.
+func (s *RemoteStore) flush(ctx context.Context) error {
+	if s.pending == nil {
+		return nil
+	}
+
+	if s.known {
+		expected := s.remote
+		current, err := s.readObject(ctx, fileName)
+		if err != nil {
+			return fmt.Errorf("read cursor %s: %w", s.objectURL(fileName), err)
+		}
+		var found *BlockRef
+		if current != nil {
+			// A cursor that does not decode was not written by a reader:
+			// it is a conflict too.
+			found = &BlockRef{}
+			if state, err := decode(current, s.objectURL(fileName)); err == nil {
+				found = &state.LastFiredBlock.BlockRef
+			}
+		}
+		if !sameRef(expected, found) {
+			return &ConflictError{Location: s.objectURL(fileName), Expected: expected, Found: found}
+		}
+	}
+
+	data, err := json.Marshal(s.pending)
+	if err != nil {
+		return fmt.Errorf("marshal cursor: %w", err)
+	}
+	if err := s.objects.WriteObject(ctx, fileName, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("write cursor %s: %w", s.objectURL(fileName), err)
+	}
+	s.known = true
+	s.remote = &s.pending.LastFiredBlock.BlockRef
+	s.pending = nil
+	s.lastWrite = time.Now()
+	return nil
+}
+
+func sameRef(a, b *BlockRef) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (s *RemoteStore) LoadNetwork(ctx context.Context) (string, error) {
+	data, err := s.readObject(ctx, networkFileName)
+	if err != nil {
+		return "", fmt.Errorf("read network %s: %w", s.objectURL(networkFileName), err)
+	}
+	if data == nil {
+		return "", nil
+	}
+	return decodeNetwork(data)
+}
+
+func (s *RemoteStore) SaveNetwork(ctx context.Context, passphrase string) error {
+	data, err := json.Marshal(network{NetworkPassphrase: passphrase})
+	if err != nil {
+		return fmt.Errorf("marshal network: %w", err)
+	}
+	if err := s.objects.WriteObject(ctx, networkFileName, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("write network %s: %w", s.objectURL(networkFileName), err)
+	}
+	return nil
+}
+
+// Delete removes the cursor and the network, dropping the state held
+// back. The next Save writes whatever is there then.
+func (s *RemoteStore) Delete(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, name := range []string{fileName, networkFileName} {
+		if err := s.objects.DeleteObject(ctx, name); err != nil && !errors.Is(err, dstore.ErrNotFound) {
+			return fmt.Errorf("delete %s: %w", s.objectURL(name), err)
+		}
+	}
+	s.known = false
+	s.remote = nil
+	s.pending = nil
+	return nil
+}
+
+// Close writes the state held back by Save, if any.
+func (s *RemoteStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flush(context.Background())
+}
+
+func (s *RemoteStore) String() string {
+	return s.location
+}
+
+// readObject returns the content of object name, nil when it does not
+// exist.
+func (s *RemoteStore) readObject(ctx context.Context, name string) ([]byte, error) {
+	reader, err := s.objects.OpenObject(ctx, name)
+	if err != nil {
+		if errors.Is(err, dstore.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func (s *RemoteStore) objectURL(name string) string {
+	return s.location + "/" + name
+}
@@ -0,0 +1,127 @@
+package cursor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryObjects is an in-memory ObjectStore counting the writes.
+type memoryObjects struct {
+	objects map[string][]byte
+	writes  int
+}
+
+func (m *memoryObjects) OpenObject(_ context.Context, name string) (io.ReadCloser, error) {
+	data, found := m.objects[name]
+	if !found {
+		return nil, dstore.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memoryObjects) WriteObject(_ context.Context, base string, f io.Reader) error {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	m.objects[base] = data
+	m.writes++
+	return nil
+}
+
+func (m *memoryObjects) DeleteObject(_ context.Context, base string) error {
+	if _, found := m.objects[base]; !found {
+		return dstore.ErrNotFound
+	}
+	delete(m.objects, base)
+	return nil
+}
+
+func block(num uint64) *pbbstream.Block {
+	return &pbbstream.Block{Number: num, Id: fmt.Sprintf("%064x", num), ParentId: fmt.Sprintf("%064x", num-1)}
+}
+
+func Test_RemoteStore(t *testing.T) {
+	ctx := context.Background()
+	objects := &memoryObjects{objects: map[string][]byte{}}
+	store := NewRemoteStore(objects, "s3://bucket/reader-0/", time.Hour)
+
+	s, _, err := store.Load(ctx)
+	require.NoError(t, err)
+	require.Nil(t, s)
+
+	for num := uint64(100); num <= 110; num++ {
+		require.NoError(t, store.Save(ctx, block(num)))
+	}
+	require.Equal(t, 1, objects.writes, "saves within the interval are held back")
+
+	s, report, err := store.Load(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(110), s.LastFiredBlock.Num, "the held back state is loaded")
+	require.Equal(t, "s3://bucket/reader-0/cursor.json", report.Path)
+
+	require.NoError(t, store.Close())
+	require.Equal(t, 2, objects.writes)
+	require.JSONEq(t, fmt.Sprintf(`{
+		"Lib": {"id": "%064x", "num": 110},
+		"LastFiredBlock": {"id": "%064x", "num": 110, "previous_ref_id": "%064x"},
+		"Blocks": []
+	}`, 110, 110, 109), string(objects.objects["cursor.json"]))
+
+	// A new reader, e.g. the pod rescheduled, resumes from it.
+	store = NewRemoteStore(objects, "s3://bucket/reader-0", 0)
+	s, _, err = store.Load(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(110), s.LastFiredBlock.Num)
+	require.NoError(t, store.Save(ctx, block(111)))
+	require.NoError(t, store.Save(ctx, block(112)))
+	require.Equal(t, 4, objects.writes, "saves are not held back without interval")
+
+	require.NoError(t, store.SaveNetwork(ctx, "Test SDF Network ; September 2015"))
+	passphrase, err := store.LoadNetwork(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "Test SDF Network ; September 2015", passphrase)
+
+	require.NoError(t, store.Delete(ctx))
+	require.Empty(t, objects.objects)
+	s, _, err = store.Load(ctx)
+	require.NoError(t, err)
+	require.Nil(t, s)
+}
+
+func Test_RemoteStore_Conflict(t *testing.T) {
+	ctx := context.Background()
+	objects := &memoryObjects{objects: map[string][]byte{}}
+
+	first := NewRemoteStore(objects, "gs://bucket/reader", 0)
+	_, _, err := first.Load(ctx)
+	require.NoError(t, err)
+	require.NoError(t, first.Save(ctx, block(100)))
+
+	second := NewRemoteStore(objects, "gs://bucket/reader", 0)
+	_, _, err = second.Load(ctx)
+	require.NoError(t, err)
+	require.NoError(t, second.Save(ctx, block(101)))
+
+	err = first.Save(ctx, block(101))
+	var conflict *ConflictError
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, &BlockRef{Id: fmt.Sprintf("%064x", 100), Num: 100}, conflict.Expected)
+	require.Equal(t, &BlockRef{Id: fmt.Sprintf("%064x", 101), Num: 101}, conflict.Found)
+	require.ErrorContains(t, err, "cursor gs://bucket/reader/cursor.json was moved by another reader: it is at block 101")
+
+	t.Run("cursor ignored", func(t *testing.T) {
+		// Without loading it first, the remote cursor is taken over.
+		third := NewRemoteStore(objects, "gs://bucket/reader", 0)
+		require.NoError(t, third.Save(ctx, block(50)))
+		require.NoError(t, third.Save(ctx, block(51)))
+	})
+}
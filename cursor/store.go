@@ -0,0 +1,101 @@
+package cursor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/dstore"
+)
+
+// Store is where a fetcher persists its cursor: a local state dir, see
+// DirStore, or a dstore URL for readers without a persistent disk, see
+// RemoteStore. Both keep the cursor.json and network.json schemas.
+type Store interface {
+	// Load returns the cursor, nil when none was saved yet, and where it
+	// was read from.
+	Load(ctx context.Context) (*State, *Report, error)
+
+	// Save records blk as the last fired block. A store may hold the
+	// write back until a later Save or Close.
+	Save(ctx context.Context, blk *pbbstream.Block) error
+
+	// LoadNetwork returns the network passphrase SaveNetwork recorded,
+	// empty when none was.
+	LoadNetwork(ctx context.Context) (string, error)
+
+	SaveNetwork(ctx context.Context, passphrase string) error
+
+	// Delete removes the cursor and the network recorded, so the next
+	// run starts over.
+	Delete(ctx context.Context) error
+
+	// Close writes the Save held back, if any.
+	Close() error
+
+	// String is the location of the store, for logs and errors.
+	String() string
+}
+
+// Open returns the store at location: a RemoteStore writing at most
+// every saveInterval when location is a dstore URL (file://, s3://,
+// gs://, ...), a DirStore otherwise. An empty location disables the
+// cursor.
+func Open(location string, saveInterval time.Duration) (Store, error) {
+	if !strings.Contains(location, "://") {
+		return NewDirStore(location), nil
+	}
+
+	objects, err := dstore.NewSimpleStore(location, dstore.AllowOverwrite())
+	if err != nil {
+		return nil, fmt.Errorf("opening cursor store %s: %w", location, err)
+	}
+	return NewRemoteStore(objects, location, saveInterval), nil
+}
+
+// DirStore is a Store over a local state dir, written on every Save. See
+// Load, Save, LoadNetwork, SaveNetwork and Delete.
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore returns the store of the state dir dir. Empty disables the
+// cursor: nothing is loaded or saved.
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{dir: dir}
+}
+
+// Dir is the state dir, to Acquire it.
+func (s *DirStore) Dir() string {
+	return s.dir
+}
+
+func (s *DirStore) Load(_ context.Context) (*State, *Report, error) {
+	return LoadReport(s.dir)
+}
+
+func (s *DirStore) Save(_ context.Context, blk *pbbstream.Block) error {
+	return Save(s.dir, blk)
+}
+
+func (s *DirStore) LoadNetwork(_ context.Context) (string, error) {
+	return LoadNetwork(s.dir)
+}
+
+func (s *DirStore) SaveNetwork(_ context.Context, passphrase string) error {
+	return SaveNetwork(s.dir, passphrase)
+}
+
+func (s *DirStore) Delete(_ context.Context) error {
+	return Delete(s.dir)
+}
+
+func (s *DirStore) Close() error {
+	return nil
+}
+
+func (s *DirStore) String() string {
+	return s.dir
+}
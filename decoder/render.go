@@ -0,0 +1,320 @@
+package decoder
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stellar/go-stellar-sdk/amount"
+	"github.com/stellar/go-stellar-sdk/strkey"
+	"github.com/stellar/go-stellar-sdk/xdr"
+)
+
+// The Render functions print ledger entries, their keys and the values
+// they hold on one line, for people: addresses and ids as strkeys,
+// assets as CODE:ISSUER, amounts of classic entries in units. They never
+// fail, a value they cannot render shows as such in their output.
+
+// MetaChanges are the ledger entry changes of a TransactionMeta: the
+// ones of the transaction before its operations, typically fees and
+// sequence numbers, the ones of each operation and the ones after, like
+// refunds.
+type MetaChanges struct {
+	Before     []xdr.LedgerEntryChange
+	Operations [][]xdr.LedgerEntryChange
+	After      []xdr.LedgerEntryChange
+}
+
+// TransactionMetaChanges returns the ledger entry changes of meta,
+// whatever its version.
+func TransactionMetaChanges(meta xdr.TransactionMeta) (*MetaChanges, error) {
+	changes := &MetaChanges{}
+	switch meta.V {
+	case 0:
+		changes.Operations = operationChanges(*meta.Operations)
+	case 1:
+		changes.Before = meta.V1.TxChanges
+		changes.Operations = operationChanges(meta.V1.Operations)
+	case 2:
+		changes.Before = meta.V2.TxChangesBefore
+		changes.Operations = operationChanges(meta.V2.Operations)
+		changes.After = meta.V2.TxChangesAfter
+	case 3:
+		changes.Before = meta.V3.TxChangesBefore
+		changes.Operations = operationChanges(meta.V3.Operations)
+		changes.After = meta.V3.TxChangesAfter
+	case 4:
+		changes.Before = meta.V4.TxChangesBefore
+		changes.Operations = make([][]xdr.LedgerEntryChange, len(meta.V4.Operations))
+		for i, operation := range meta.V4.Operations {
+			changes.Operations[i] = operation.Changes
+		}
+		changes.After = meta.V4.TxChangesAfter
+	default:
+		return nil, fmt.Errorf("unsupported transaction meta version %d", meta.V)
+	}
+	return changes, nil
+}
+
+func operationChanges(operations []xdr.OperationMeta) [][]xdr.LedgerEntryChange {
+	changes := make([][]xdr.LedgerEntryChange, len(operations))
+	for i, operation := range operations {
+		changes[i] = operation.Changes
+	}
+	return changes
+}
+
+// RenderLedgerEntryChanges renders changes, one per element.
+func RenderLedgerEntryChanges(changes []xdr.LedgerEntryChange) []string {
+	rendered := make([]string, len(changes))
+	for i, change := range changes {
+		rendered[i] = RenderLedgerEntryChange(change)
+	}
+	return rendered
+}
+
+// RenderLedgerEntryChange renders change as its kind followed by the
+// entry, or by the key of a removed one, e.g. "updated account G... balance
+// 99.9999900 seq 4294967297 subentries 0 (last modified ledger 2)".
+func RenderLedgerEntryChange(change xdr.LedgerEntryChange) string {
+	switch change.Type {
+	case xdr.LedgerEntryChangeTypeLedgerEntryCreated:
+		return "created " + RenderLedgerEntry(*change.Created)
+	case xdr.LedgerEntryChangeTypeLedgerEntryUpdated:
+		return "updated " + RenderLedgerEntry(*change.Updated)
+	case xdr.LedgerEntryChangeTypeLedgerEntryRemoved:
+		return "removed " + RenderLedgerKey(*change.Removed)
+	case xdr.LedgerEntryChangeTypeLedgerEntryState:
+		return "state " + RenderLedgerEntry(*change.State)
+	case xdr.LedgerEntryChangeTypeLedgerEntryRestored:
+		return "restored " + RenderLedgerEntry(*change.Restored)
+	}
+	return fmt.Sprintf("unknown change type %d", change.Type)
+}
+
+// RenderLedgerEntry renders entry, whatever its type.
+func RenderLedgerEntry(entry xdr.LedgerEntry) string {
+	var out string
+	data := entry.Data
+	switch data.Type {
+	case xdr.LedgerEntryTypeAccount:
+		account := data.Account
+		out = fmt.Sprintf("account %s balance %s seq %d subentries %d", renderAccountId(account.AccountId), amount.String(account.Balance), account.SeqNum, account.NumSubEntries)
+		if account.Flags != 0 {
+			out += fmt.Sprintf(" flags %d", account.Flags)
+		}
+		if account.HomeDomain != "" {
+			out += fmt.Sprintf(" home domain %s", account.HomeDomain)
+		}
+		if account.InflationDest != nil {
+			out += " inflation dest " + renderAccountId(*account.InflationDest)
+		}
+		if len(account.Signers) > 0 {
+			out += fmt.Sprintf(" signers %d", len(account.Signers))
+		}
+	case xdr.LedgerEntryTypeTrustline:
+		trustLine := data.TrustLine
+		out = fmt.Sprintf("trustline %s %s balance %s limit %s", renderAccountId(trustLine.AccountId), RenderTrustLineAsset(trustLine.Asset), amount.String(trustLine.Balance), amount.String(trustLine.Limit))
+		if trustLine.Flags != 0 {
+			out += fmt.Sprintf(" flags %d", trustLine.Flags)
+		}
+	case xdr.LedgerEntryTypeOffer:
+		offer := data.Offer
+		out = fmt.Sprintf("offer %d by %s selling %s %s for %s at %d/%d", offer.OfferId, renderAccountId(offer.SellerId), amount.String(offer.Amount), RenderAsset(offer.Selling), RenderAsset(offer.Buying), offer.Price.N, offer.Price.D)
+	case xdr.LedgerEntryTypeData:
+		dataEntry := data.Data
+		out = fmt.Sprintf("data %s %q = %q", renderAccountId(dataEntry.AccountId), dataEntry.DataName, []byte(dataEntry.DataValue))
+	case xdr.LedgerEntryTypeClaimableBalance:
+		balance := data.ClaimableBalance
+		claimants := make([]string, len(balance.Claimants))
+		for i, claimant := range balance.Claimants {
+			if claimant.V0 == nil {
+				claimants[i] = fmt.Sprintf("unknown claimant type %d", claimant.Type)
+				continue
+			}
+			claimants[i] = renderAccountId(claimant.V0.Destination)
+		}
+		out = fmt.Sprintf("claimable balance %s of %s %s claimable by %s", renderClaimableBalanceId(balance.BalanceId), amount.String(balance.Amount), RenderAsset(balance.Asset), strings.Join(claimants, ", "))
+	case xdr.LedgerEntryTypeLiquidityPool:
+		pool := data.LiquidityPool
+		out = "liquidity pool " + renderPoolId(pool.LiquidityPoolId)
+		if product := pool.Body.ConstantProduct; product != nil {
+			out += fmt.Sprintf(" %s/%s reserves %s/%s shares %s fee %dbp", RenderAsset(product.Params.AssetA), RenderAsset(product.Params.AssetB), amount.String(product.ReserveA), amount.String(product.ReserveB), amount.String(product.TotalPoolShares), product.Params.Fee)
+		}
+	case xdr.LedgerEntryTypeContractData:
+		contractData := data.ContractData
+		out = fmt.Sprintf("contract data %s %s key %s = %s", RenderScAddress(contractData.Contract), renderDurability(contractData.Durability), RenderScVal(contractData.Key), RenderScVal(contractData.Val))
+	case xdr.LedgerEntryTypeContractCode:
+		code := data.ContractCode
+		out = fmt.Sprintf("contract code %s (%d bytes)", hex.EncodeToString(code.Hash[:]), len(code.Code))
+	case xdr.LedgerEntryTypeConfigSetting:
+		out = "config setting " + renderConfigSettingId(data.ConfigSetting.ConfigSettingId)
+	case xdr.LedgerEntryTypeTtl:
+		ttl := data.Ttl
+		out = fmt.Sprintf("ttl %s live until ledger %d", hex.EncodeToString(ttl.KeyHash[:]), ttl.LiveUntilLedgerSeq)
+	default:
+		out = fmt.Sprintf("unknown entry type %d", data.Type)
+	}
+	return fmt.Sprintf("%s (last modified ledger %d)", out, entry.LastModifiedLedgerSeq)
+}
+
+// RenderLedgerKey renders key, whatever the type of its entry.
+func RenderLedgerKey(key xdr.LedgerKey) string {
+	switch key.Type {
+	case xdr.LedgerEntryTypeAccount:
+		return "account " + renderAccountId(key.Account.AccountId)
+	case xdr.LedgerEntryTypeTrustline:
+		return fmt.Sprintf("trustline %s %s", renderAccountId(key.TrustLine.AccountId), RenderTrustLineAsset(key.TrustLine.Asset))
+	case xdr.LedgerEntryTypeOffer:
+		return fmt.Sprintf("offer %d by %s", key.Offer.OfferId, renderAccountId(key.Offer.SellerId))
+	case xdr.LedgerEntryTypeData:
+		return fmt.Sprintf("data %s %q", renderAccountId(key.Data.AccountId), key.Data.DataName)
+	case xdr.LedgerEntryTypeClaimableBalance:
+		return "claimable balance " + renderClaimableBalanceId(key.ClaimableBalance.BalanceId)
+	case xdr.LedgerEntryTypeLiquidityPool:
+		return "liquidity pool " + renderPoolId(key.LiquidityPool.LiquidityPoolId)
+	case xdr.LedgerEntryTypeContractData:
+		return fmt.Sprintf("contract data %s %s key %s", RenderScAddress(key.ContractData.Contract), renderDurability(key.ContractData.Durability), RenderScVal(key.ContractData.Key))
+	case xdr.LedgerEntryTypeContractCode:
+		return "contract code " + hex.EncodeToString(key.ContractCode.Hash[:])
+	case xdr.LedgerEntryTypeConfigSetting:
+		return "config setting " + renderConfigSettingId(key.ConfigSetting.ConfigSettingId)
+	case xdr.LedgerEntryTypeTtl:
+		return "ttl " + hex.EncodeToString(key.Ttl.KeyHash[:])
+	}
+	return fmt.Sprintf("unknown entry type %d", key.Type)
+}
+
+// RenderAsset renders asset as native or CODE:ISSUER.
+func RenderAsset(asset xdr.Asset) string {
+	switch asset.Type {
+	case xdr.AssetTypeAssetTypeNative, xdr.AssetTypeAssetTypeCreditAlphanum4, xdr.AssetTypeAssetTypeCreditAlphanum12:
+		return asset.StringCanonical()
+	}
+	return fmt.Sprintf("unknown asset type %d", asset.Type)
+}
+
+// RenderTrustLineAsset renders asset like RenderAsset, or as the strkey
+// of its pool for pool shares.
+func RenderTrustLineAsset(asset xdr.TrustLineAsset) string {
+	if asset.Type == xdr.AssetTypeAssetTypePoolShare {
+		return "pool share " + renderPoolId(*asset.LiquidityPoolId)
+	}
+	return RenderAsset(asset.ToAsset())
+}
+
+// RenderScAddress renders address as a strkey.
+func RenderScAddress(address xdr.ScAddress) string {
+	out, err := address.String()
+	if err != nil {
+		return fmt.Sprintf("invalid address: %s", err)
+	}
+	return out
+}
+
+// RenderScVal renders val like a literal: strings quoted, symbols bare,
+// bytes in hex, vectors in brackets and maps in braces, e.g.
+// {amount: 100, to: GABC..., memo: "rent"}.
+func RenderScVal(val xdr.ScVal) string {
+	switch val.Type {
+	case xdr.ScValTypeScvBool:
+		return strconv.FormatBool(*val.B)
+	case xdr.ScValTypeScvVoid:
+		return "void"
+	case xdr.ScValTypeScvError:
+		return renderScError(*val.Error)
+	case xdr.ScValTypeScvU32, xdr.ScValTypeScvI32, xdr.ScValTypeScvU64, xdr.ScValTypeScvI64,
+		xdr.ScValTypeScvU128, xdr.ScValTypeScvI128, xdr.ScValTypeScvU256, xdr.ScValTypeScvI256:
+		return val.String()
+	case xdr.ScValTypeScvTimepoint:
+		return time.Unix(int64(*val.Timepoint), 0).UTC().Format(time.RFC3339)
+	case xdr.ScValTypeScvDuration:
+		return fmt.Sprintf("%ds", *val.Duration)
+	case xdr.ScValTypeScvBytes:
+		return "0x" + hex.EncodeToString(*val.Bytes)
+	case xdr.ScValTypeScvString:
+		return strconv.Quote(string(*val.Str))
+	case xdr.ScValTypeScvSymbol:
+		return string(*val.Sym)
+	case xdr.ScValTypeScvVec:
+		if *val.Vec == nil {
+			return "[]"
+		}
+		items := make([]string, len(**val.Vec))
+		for i, item := range **val.Vec {
+			items[i] = RenderScVal(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case xdr.ScValTypeScvMap:
+		if *val.Map == nil {
+			return "{}"
+		}
+		return renderScMap(**val.Map)
+	case xdr.ScValTypeScvAddress:
+		return RenderScAddress(*val.Address)
+	case xdr.ScValTypeScvContractInstance:
+		out := "instance(stellar asset)"
+		if executable := val.Instance.Executable; executable.Type == xdr.ContractExecutableTypeContractExecutableWasm {
+			out = "instance(wasm " + hex.EncodeToString(executable.WasmHash[:]) + ")"
+		}
+		if val.Instance.Storage != nil && len(*val.Instance.Storage) > 0 {
+			out += " " + renderScMap(*val.Instance.Storage)
+		}
+		return out
+	case xdr.ScValTypeScvLedgerKeyContractInstance:
+		return "instance key"
+	case xdr.ScValTypeScvLedgerKeyNonce:
+		return fmt.Sprintf("nonce(%d)", val.NonceKey.Nonce)
+	}
+	return fmt.Sprintf("unknown value type %d", val.Type)
+}
+
+func renderScMap(entries xdr.ScMap) string {
+	items := make([]string, len(entries))
+	for i, entry := range entries {
+		items[i] = RenderScVal(entry.Key) + ": " + RenderScVal(entry.Val)
+	}
+	return "{" + strings.Join(items, ", ") + "}"
+}
+
+func renderScError(scError xdr.ScError) string {
+	kind := strings.TrimPrefix(scError.Type.String(), "ScErrorTypeSce")
+	if scError.Type == xdr.ScErrorTypeSceContract {
+		return fmt.Sprintf("error(%s %d)", kind, *scError.ContractCode)
+	}
+	return fmt.Sprintf("error(%s %s)", kind, strings.TrimPrefix(scError.Code.String(), "ScErrorCodeScec"))
+}
+
+func renderAccountId(id xdr.AccountId) string {
+	address, err := id.GetAddress()
+	if err != nil {
+		return fmt.Sprintf("invalid account: %s", err)
+	}
+	return address
+}
+
+func renderClaimableBalanceId(id xdr.ClaimableBalanceId) string {
+	out, err := id.EncodeToStrkey()
+	if err != nil {
+		return fmt.Sprintf("invalid claimable balance id: %s", err)
+	}
+	return out
+}
+
+func renderPoolId(id xdr.PoolId) string {
+	out, err := strkey.Encode(strkey.VersionByteLiquidityPool, id[:])
+	if err != nil {
+		return fmt.Sprintf("invalid pool id: %s", err)
+	}
+	return out
+}
+
+func renderDurability(durability xdr.ContractDataDurability) string {
+	return strings.ToLower(strings.TrimPrefix(durability.String(), "ContractDataDurability"))
+}
+
+func renderConfigSettingId(id xdr.ConfigSettingId) string {
+	return strings.TrimPrefix(id.String(), "ConfigSettingIdConfigSetting")
+}
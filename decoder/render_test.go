@@ -0,0 +1,218 @@
+package decoder
+
+import (
+	"testing"
+
+	"github.com/stellar/go-stellar-sdk/strkey"
+	"github.com/stellar/go-stellar-sdk/xdr"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testAccount  = "GAAZI4TCR3TY5OJHCTJC2A4QSY6CJWJH5IAJTGKIN2ER7LBNVKOCCWN7"
+	testIssuer   = "GBRPYHIL2CI3FNQ4BXLFMNDLFJUNPU2HY3ZMFSHONUCEOASW7QC7OX2H"
+	testContract = "CA3D5KRYM6CB7OWQ6TWYRR3Z4T7GNZLKERYNZGGA5SOAOPIFY6YQGAXE"
+)
+
+func testContractAddress(t *testing.T) xdr.ScAddress {
+	raw, err := strkey.Decode(strkey.VersionByteContract, testContract)
+	require.NoError(t, err)
+	var id xdr.ContractId
+	copy(id[:], raw)
+	return xdr.ScAddress{Type: xdr.ScAddressTypeScAddressTypeContract, ContractId: &id}
+}
+
+func Test_RenderLedgerEntry(t *testing.T) {
+	usd := xdr.MustNewCreditAsset("USD", testIssuer)
+	symbol := xdr.ScSymbol("balance")
+	amount := xdr.Int64(42)
+
+	tests := []struct {
+		name     string
+		data     xdr.LedgerEntryData
+		expected string
+	}{
+		{
+			name: "account",
+			data: xdr.LedgerEntryData{Type: xdr.LedgerEntryTypeAccount, Account: &xdr.AccountEntry{
+				AccountId:     xdr.MustAddress(testAccount),
+				Balance:       1000000000,
+				SeqNum:        7,
+				NumSubEntries: 1,
+			}},
+			expected: "account " + testAccount + " balance 100.0000000 seq 7 subentries 1",
+		},
+		{
+			name: "trustline",
+			data: xdr.LedgerEntryData{Type: xdr.LedgerEntryTypeTrustline, TrustLine: &xdr.TrustLineEntry{
+				AccountId: xdr.MustAddress(testAccount),
+				Asset:     usd.ToTrustLineAsset(),
+				Balance:   5,
+				Limit:     10000000,
+			}},
+			expected: "trustline " + testAccount + " USD:" + testIssuer + " balance 0.0000005 limit 1.0000000",
+		},
+		{
+			name: "offer",
+			data: xdr.LedgerEntryData{Type: xdr.LedgerEntryTypeOffer, Offer: &xdr.OfferEntry{
+				SellerId: xdr.MustAddress(testAccount),
+				OfferId:  12,
+				Selling:  xdr.MustNewNativeAsset(),
+				Buying:   usd,
+				Amount:   20000000,
+				Price:    xdr.Price{N: 1, D: 2},
+			}},
+			expected: "offer 12 by " + testAccount + " selling 2.0000000 native for USD:" + testIssuer + " at 1/2",
+		},
+		{
+			name: "data",
+			data: xdr.LedgerEntryData{Type: xdr.LedgerEntryTypeData, Data: &xdr.DataEntry{
+				AccountId: xdr.MustAddress(testAccount),
+				DataName:  "config",
+				DataValue: xdr.DataValue("on"),
+			}},
+			expected: "data " + testAccount + ` "config" = "on"`,
+		},
+		{
+			name: "contract data",
+			data: xdr.LedgerEntryData{Type: xdr.LedgerEntryTypeContractData, ContractData: &xdr.ContractDataEntry{
+				Contract:   testContractAddress(t),
+				Key:        xdr.ScVal{Type: xdr.ScValTypeScvSymbol, Sym: &symbol},
+				Durability: xdr.ContractDataDurabilityPersistent,
+				Val:        xdr.ScVal{Type: xdr.ScValTypeScvI64, I64: &amount},
+			}},
+			expected: "contract data " + testContract + " persistent key balance = 42",
+		},
+		{
+			name: "contract code",
+			data: xdr.LedgerEntryData{Type: xdr.LedgerEntryTypeContractCode, ContractCode: &xdr.ContractCodeEntry{
+				Hash: xdr.Hash{0xab},
+				Code: []byte{0, 1, 2},
+			}},
+			expected: "contract code ab00000000000000000000000000000000000000000000000000000000000000 (3 bytes)",
+		},
+		{
+			name: "config setting",
+			data: xdr.LedgerEntryData{Type: xdr.LedgerEntryTypeConfigSetting, ConfigSetting: &xdr.ConfigSettingEntry{
+				ConfigSettingId: xdr.ConfigSettingIdConfigSettingContractMaxSizeBytes,
+			}},
+			expected: "config setting ContractMaxSizeBytes",
+		},
+		{
+			name: "ttl",
+			data: xdr.LedgerEntryData{Type: xdr.LedgerEntryTypeTtl, Ttl: &xdr.TtlEntry{
+				KeyHash:            xdr.Hash{0x01},
+				LiveUntilLedgerSeq: 100,
+			}},
+			expected: "ttl 0100000000000000000000000000000000000000000000000000000000000000 live until ledger 100",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := xdr.LedgerEntry{LastModifiedLedgerSeq: 3, Data: test.data}
+			require.Equal(t, test.expected+" (last modified ledger 3)", RenderLedgerEntry(entry))
+		})
+	}
+}
+
+func Test_RenderLedgerEntry_PoolsAndBalances(t *testing.T) {
+	var poolID xdr.PoolId
+	poolID[0] = 0x01
+	poolStrkey, err := strkey.Encode(strkey.VersionByteLiquidityPool, poolID[:])
+	require.NoError(t, err)
+
+	balanceID := xdr.ClaimableBalanceId{Type: xdr.ClaimableBalanceIdTypeClaimableBalanceIdTypeV0, V0: &xdr.Hash{0x02}}
+	balanceStrkey, err := balanceID.EncodeToStrkey()
+	require.NoError(t, err)
+
+	pool := xdr.LedgerEntry{Data: xdr.LedgerEntryData{Type: xdr.LedgerEntryTypeLiquidityPool, LiquidityPool: &xdr.LiquidityPoolEntry{
+		LiquidityPoolId: poolID,
+		Body: xdr.LiquidityPoolEntryBody{Type: xdr.LiquidityPoolTypeLiquidityPoolConstantProduct, ConstantProduct: &xdr.LiquidityPoolEntryConstantProduct{
+			Params:          xdr.LiquidityPoolConstantProductParameters{AssetA: xdr.MustNewNativeAsset(), AssetB: xdr.MustNewCreditAsset("USDC", testIssuer), Fee: 30},
+			ReserveA:        10000000,
+			ReserveB:        20000000,
+			TotalPoolShares: 30000000,
+		}},
+	}}}
+	require.Equal(t, "liquidity pool "+poolStrkey+" native/USDC:"+testIssuer+" reserves 1.0000000/2.0000000 shares 3.0000000 fee 30bp (last modified ledger 0)", RenderLedgerEntry(pool))
+
+	balance := xdr.LedgerEntry{Data: xdr.LedgerEntryData{Type: xdr.LedgerEntryTypeClaimableBalance, ClaimableBalance: &xdr.ClaimableBalanceEntry{
+		BalanceId: balanceID,
+		Claimants: []xdr.Claimant{{Type: xdr.ClaimantTypeClaimantTypeV0, V0: &xdr.ClaimantV0{Destination: xdr.MustAddress(testAccount)}}},
+		Asset:     xdr.MustNewNativeAsset(),
+		Amount:    10000000,
+	}}}
+	require.Equal(t, "claimable balance "+balanceStrkey+" of 1.0000000 native claimable by "+testAccount+" (last modified ledger 0)", RenderLedgerEntry(balance))
+
+	shares := xdr.LedgerKey{Type: xdr.LedgerEntryTypeTrustline, TrustLine: &xdr.LedgerKeyTrustLine{
+		AccountId: xdr.MustAddress(testAccount),
+		Asset:     xdr.TrustLineAsset{Type: xdr.AssetTypeAssetTypePoolShare, LiquidityPoolId: &poolID},
+	}}
+	require.Equal(t, "trustline "+testAccount+" pool share "+poolStrkey, RenderLedgerKey(shares))
+}
+
+func Test_RenderLedgerEntryChange(t *testing.T) {
+	account := xdr.LedgerEntry{LastModifiedLedgerSeq: 9, Data: xdr.LedgerEntryData{Type: xdr.LedgerEntryTypeAccount, Account: &xdr.AccountEntry{
+		AccountId: xdr.MustAddress(testAccount),
+		Balance:   1,
+	}}}
+	removed := xdr.LedgerKey{Type: xdr.LedgerEntryTypeOffer, Offer: &xdr.LedgerKeyOffer{SellerId: xdr.MustAddress(testAccount), OfferId: 12}}
+
+	require.Equal(t, []string{
+		"state account " + testAccount + " balance 0.0000001 seq 0 subentries 0 (last modified ledger 9)",
+		"removed offer 12 by " + testAccount,
+	}, RenderLedgerEntryChanges([]xdr.LedgerEntryChange{
+		{Type: xdr.LedgerEntryChangeTypeLedgerEntryState, State: &account},
+		{Type: xdr.LedgerEntryChangeTypeLedgerEntryRemoved, Removed: &removed},
+	}))
+}
+
+func Test_RenderScVal(t *testing.T) {
+	str := xdr.ScString("rent")
+	symbol := xdr.ScSymbol("memo")
+	bytes := xdr.ScBytes{0xca, 0xfe}
+	u32 := xdr.Uint32(7)
+	flag := true
+	address := testContractAddress(t)
+	vec := &xdr.ScVec{
+		{Type: xdr.ScValTypeScvU32, U32: &u32},
+		{Type: xdr.ScValTypeScvBytes, Bytes: &bytes},
+		{Type: xdr.ScValTypeScvVoid},
+	}
+	scMap := &xdr.ScMap{
+		{Key: xdr.ScVal{Type: xdr.ScValTypeScvSymbol, Sym: &symbol}, Val: xdr.ScVal{Type: xdr.ScValTypeScvString, Str: &str}},
+		{Key: xdr.ScVal{Type: xdr.ScValTypeScvAddress, Address: &address}, Val: xdr.ScVal{Type: xdr.ScValTypeScvBool, B: &flag}},
+		{Key: xdr.ScVal{Type: xdr.ScValTypeScvSymbol, Sym: &symbol}, Val: xdr.ScVal{Type: xdr.ScValTypeScvVec, Vec: &vec}},
+	}
+	code := xdr.Uint32(3)
+
+	require.Equal(t, `{memo: "rent", `+testContract+`: true, memo: [7, 0xcafe, void]}`, RenderScVal(xdr.ScVal{Type: xdr.ScValTypeScvMap, Map: &scMap}))
+	require.Equal(t, "error(Contract 3)", RenderScVal(xdr.ScVal{Type: xdr.ScValTypeScvError, Error: &xdr.ScError{Type: xdr.ScErrorTypeSceContract, ContractCode: &code}}))
+	require.Equal(t, "instance key", RenderScVal(xdr.ScVal{Type: xdr.ScValTypeScvLedgerKeyContractInstance}))
+}
+
+func Test_TransactionMetaChanges(t *testing.T) {
+	before := []xdr.LedgerEntryChange{{Type: xdr.LedgerEntryChangeTypeLedgerEntryRemoved}}
+	operation := []xdr.LedgerEntryChange{{Type: xdr.LedgerEntryChangeTypeLedgerEntryCreated}, {Type: xdr.LedgerEntryChangeTypeLedgerEntryUpdated}}
+	after := []xdr.LedgerEntryChange{{Type: xdr.LedgerEntryChangeTypeLedgerEntryState}}
+
+	changes, err := TransactionMetaChanges(xdr.TransactionMeta{V: 3, V3: &xdr.TransactionMetaV3{
+		TxChangesBefore: before,
+		Operations:      []xdr.OperationMeta{{Changes: operation}},
+		TxChangesAfter:  after,
+	}})
+	require.NoError(t, err)
+	require.Equal(t, &MetaChanges{Before: before, Operations: [][]xdr.LedgerEntryChange{operation}, After: after}, changes)
+
+	changes, err = TransactionMetaChanges(xdr.TransactionMeta{V: 4, V4: &xdr.TransactionMetaV4{
+		TxChangesBefore: before,
+		Operations:      []xdr.OperationMetaV2{{Changes: operation}},
+		TxChangesAfter:  after,
+	}})
+	require.NoError(t, err)
+	require.Equal(t, &MetaChanges{Before: before, Operations: [][]xdr.LedgerEntryChange{operation}, After: after}, changes)
+
+	_, err = TransactionMetaChanges(xdr.TransactionMeta{V: 9})
+	require.Error(t, err)
+}
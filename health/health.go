@@ -0,0 +1,223 @@
+// Package health reports whether a fetcher is alive and keeping up with
+// the chain, over the /healthz and /readyz HTTP endpoints orchestrators
+// such as Kubernetes probe.
+//
+// Stellar closes a ledger about every 5 seconds. A fetcher is ready when
+// the last block it fired closed less than the staleness threshold ago;
+// it is stalled when it fired no block at all for that long. A fetcher
+// behind the chain but still firing blocks is catching up, not stalled.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LedgerCloseInterval is the nominal time between two Stellar ledgers.
+const LedgerCloseInterval = 5 * time.Second
+
+// DefaultStaleAfter is the default staleness threshold: six missed
+// ledgers.
+const DefaultStaleAfter = 6 * LedgerCloseInterval
+
+// State is the lifecycle stage of the fetcher's block source.
+type State string
+
+const (
+	// StateStarting until the block source is set up.
+	StateStarting State = "starting"
+	// StatePreparing while stellar-core catches up to the start ledger
+	// from the history archives, which can take hours.
+	StatePreparing State = "preparing"
+	// StateStreaming once blocks flow.
+	StateStreaming State = "streaming"
+	// StateStopped after the block source failed or was closed.
+	StateStopped State = "stopped"
+)
+
+// Reporter collects what the fetcher does and serves it as health
+// status. It is safe for concurrent use.
+type Reporter struct {
+	backend    string
+	staleAfter time.Duration
+	now        func() time.Time
+
+	mu         sync.Mutex
+	state      State
+	stateSince time.Time
+	lastBlock  *firedBlock
+	cursor     *BlockRef
+}
+
+type firedBlock struct {
+	BlockRef
+	time    time.Time
+	firedAt time.Time
+}
+
+// NewReporter returns a Reporter in StateStarting. staleAfter is the
+// staleness threshold, DefaultStaleAfter when 0.
+func NewReporter(backend string, staleAfter time.Duration) *Reporter {
+	if staleAfter <= 0 {
+		staleAfter = DefaultStaleAfter
+	}
+	r := &Reporter{
+		backend:    backend,
+		staleAfter: staleAfter,
+		now:        time.Now,
+	}
+	r.SetState(StateStarting)
+	return r
+}
+
+// SetState records the block source entering state.
+func (r *Reporter) SetState(state State) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.state != state {
+		r.state = state
+		r.stateSince = r.now()
+	}
+}
+
+// BlockFired records a block handed to firecore, which closed at
+// blockTime.
+func (r *Reporter) BlockFired(num uint64, id string, blockTime time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastBlock = &firedBlock{BlockRef: BlockRef{Num: num, ID: id}, time: blockTime, firedAt: r.now()}
+}
+
+// CursorSaved records the block the persisted cursor now points at.
+func (r *Reporter) CursorSaved(num uint64, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cursor = &BlockRef{Num: num, ID: id}
+}
+
+// BlockRef identifies a block.
+type BlockRef struct {
+	Num uint64 `json:"num"`
+	ID  string `json:"id"`
+}
+
+// Status is the JSON body of /healthz and /readyz.
+type Status struct {
+	Backend string `json:"backend"`
+	State   State  `json:"state"`
+	// StateAge is how long the fetcher has been in State.
+	StateAge Duration `json:"state_age"`
+	Healthy  bool     `json:"healthy"`
+	Ready    bool     `json:"ready"`
+	// Stalled is set when streaming and no block was fired within the
+	// staleness threshold.
+	Stalled bool `json:"stalled"`
+	// CatchingUp is set when blocks are fired but the last one is older
+	// than the staleness threshold.
+	CatchingUp bool `json:"catching_up"`
+	// StaleAfter is the staleness threshold.
+	StaleAfter     Duration        `json:"stale_after"`
+	LastFiredBlock *FiredBlockInfo `json:"last_fired_block,omitempty"`
+	Cursor         *BlockRef       `json:"cursor,omitempty"`
+	Reason         string          `json:"reason,omitempty"`
+}
+
+// FiredBlockInfo describes the last fired block. Age is the time since
+// the block closed on chain, SinceFired the time since it was fired.
+type FiredBlockInfo struct {
+	BlockRef
+	Time       time.Time `json:"time"`
+	Age        Duration  `json:"age"`
+	SinceFired Duration  `json:"since_fired"`
+}
+
+// Duration marshals as a Go duration string, e.g. "1m30s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).Round(time.Millisecond).String())
+}
+
+// Status returns the current health status.
+func (r *Reporter) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	status := Status{
+		Backend:    r.backend,
+		State:      r.state,
+		StateAge:   Duration(now.Sub(r.stateSince)),
+		StaleAfter: Duration(r.staleAfter),
+	}
+	if r.cursor != nil {
+		cursor := *r.cursor
+		status.Cursor = &cursor
+	}
+	if r.lastBlock != nil {
+		status.LastFiredBlock = &FiredBlockInfo{
+			BlockRef:   r.lastBlock.BlockRef,
+			Time:       r.lastBlock.time.UTC(),
+			Age:        Duration(now.Sub(r.lastBlock.time)),
+			SinceFired: Duration(now.Sub(r.lastBlock.firedAt)),
+		}
+	}
+
+	switch r.state {
+	case StateStarting, StatePreparing:
+		status.Reason = "block source is " + string(r.state)
+	case StateStopped:
+		status.Reason = "block source stopped"
+	case StateStreaming:
+		// Streaming started at stateSince, give the first block the same
+		// grace as the following ones.
+		lastProgress := r.stateSince
+		if r.lastBlock != nil && r.lastBlock.firedAt.After(lastProgress) {
+			lastProgress = r.lastBlock.firedAt
+		}
+
+		switch {
+		case now.Sub(lastProgress) > r.staleAfter:
+			status.Stalled = true
+			status.Reason = "no block fired for " + now.Sub(lastProgress).Round(time.Second).String()
+		case r.lastBlock == nil:
+			status.Reason = "no block fired yet"
+		case now.Sub(r.lastBlock.time) > r.staleAfter:
+			status.CatchingUp = true
+			status.Reason = "last fired block is " + now.Sub(r.lastBlock.time).Round(time.Second).String() + " old"
+		default:
+			status.Ready = true
+		}
+	}
+	status.Healthy = r.state != StateStopped && !status.Stalled
+
+	return status
+}
+
+// Register serves /healthz and /readyz on mux. /healthz fails once the
+// block source stopped or stalled, /readyz fails unless the fetcher
+// streams blocks closed within the staleness threshold. Both answer the
+// Status as JSON.
+func (r *Reporter) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		status := r.Status()
+		writeStatus(w, status, status.Healthy)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		status := r.Status()
+		writeStatus(w, status, status.Ready)
+	})
+}
+
+func writeStatus(w http.ResponseWriter, status Status, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,110 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestReporter() (*Reporter, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := NewReporter("captive-core", 30*time.Second)
+	r.now = func() time.Time { return now }
+	r.stateSince = now
+	return r, &now
+}
+
+func Test_Reporter_Lifecycle(t *testing.T) {
+	r, now := newTestReporter()
+
+	status := r.Status()
+	require.Equal(t, StateStarting, status.State)
+	require.True(t, status.Healthy)
+	require.False(t, status.Ready)
+
+	// Catching up from history archives for hours is not a stall.
+	r.SetState(StatePreparing)
+	*now = now.Add(3 * time.Hour)
+	status = r.Status()
+	require.True(t, status.Healthy)
+	require.False(t, status.Ready)
+	require.False(t, status.Stalled)
+	require.Equal(t, Duration(3*time.Hour), status.StateAge)
+
+	r.SetState(StateStreaming)
+	require.Equal(t, "no block fired yet", r.Status().Reason)
+
+	// Replaying old ledgers: blocks flow but are old.
+	r.BlockFired(100, "aa", now.Add(-time.Hour))
+	r.CursorSaved(100, "aa")
+	status = r.Status()
+	require.True(t, status.Healthy)
+	require.False(t, status.Ready)
+	require.True(t, status.CatchingUp)
+	require.Equal(t, &BlockRef{Num: 100, ID: "aa"}, status.Cursor)
+
+	// At the chain head.
+	*now = now.Add(5 * time.Second)
+	r.BlockFired(101, "bb", now.Add(-2*time.Second))
+	status = r.Status()
+	require.True(t, status.Ready)
+	require.Equal(t, uint64(101), status.LastFiredBlock.Num)
+	require.Equal(t, Duration(2*time.Second), status.LastFiredBlock.Age)
+
+	// Nothing fired past the threshold.
+	*now = now.Add(31 * time.Second)
+	status = r.Status()
+	require.True(t, status.Stalled)
+	require.False(t, status.Healthy)
+	require.False(t, status.Ready)
+
+	r.SetState(StateStopped)
+	require.False(t, r.Status().Healthy)
+}
+
+func Test_Reporter_Register(t *testing.T) {
+	r, now := newTestReporter()
+	mux := http.NewServeMux()
+	r.Register(mux)
+
+	get := func(path string) (int, Status) {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+		var body struct {
+			State          State `json:"state"`
+			Ready          bool  `json:"ready"`
+			LastFiredBlock *struct {
+				Num uint64 `json:"num"`
+				Age string `json:"age"`
+			} `json:"last_fired_block"`
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+		status := Status{State: body.State, Ready: body.Ready}
+		if body.LastFiredBlock != nil {
+			age, err := time.ParseDuration(body.LastFiredBlock.Age)
+			require.NoError(t, err)
+			status.LastFiredBlock = &FiredBlockInfo{BlockRef: BlockRef{Num: body.LastFiredBlock.Num}, Age: Duration(age)}
+		}
+		return recorder.Code, status
+	}
+
+	code, status := get("/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, StateStarting, status.State)
+
+	code, _ = get("/healthz")
+	require.Equal(t, http.StatusOK, code)
+
+	r.SetState(StateStreaming)
+	r.BlockFired(42, "cc", now.Add(-4*time.Second))
+	code, status = get("/readyz")
+	require.Equal(t, http.StatusOK, code)
+	require.True(t, status.Ready)
+	require.Equal(t, uint64(42), status.LastFiredBlock.Num)
+	require.Equal(t, Duration(4*time.Second), status.LastFiredBlock.Age)
+}
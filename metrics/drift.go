@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// headDriftCollector exports how far behind the wall clock the last
+// fired block of each backend is.
+type headDriftCollector struct {
+	desc *prometheus.Desc
+	now  func() time.Time
+
+	mu         sync.Mutex
+	blockTimes map[string]time.Time
+}
+
+func newHeadDriftCollector() *headDriftCollector {
+	return &headDriftCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "fetcher", "head_drift_seconds"),
+			"Seconds between the wall clock and the close time of the last fired block",
+			[]string{"backend"}, nil,
+		),
+		now:        time.Now,
+		blockTimes: make(map[string]time.Time),
+	}
+}
+
+func (c *headDriftCollector) observe(backend string, blockTime time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.blockTimes[backend] = blockTime
+}
+
+func (c *headDriftCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *headDriftCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for backend, blockTime := range c.blockTimes {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, now.Sub(blockTime).Seconds(), backend)
+	}
+}
@@ -0,0 +1,207 @@
+// Package metrics holds the Prometheus collectors shared by the
+// firestellar fetchers. Collectors register against the default
+// Prometheus registry at init time so any package can update them
+// without threading a registry around.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "firestellar"
+
+// Per-endpoint rpc statistics, labelled by the (redacted) endpoint URL.
+var (
+	EndpointRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "endpoint_requests_total",
+		Help:      "Number of getLedgers requests routed to an rpc endpoint",
+	}, []string{"endpoint"})
+
+	EndpointErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "endpoint_errors_total",
+		Help:      "Number of failed requests against an rpc endpoint",
+	}, []string{"endpoint"})
+
+	EndpointLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "endpoint_latency_seconds",
+		Help:      "Exponentially weighted moving average of getLedgers latency per rpc endpoint",
+	}, []string{"endpoint"})
+
+	EndpointErrorRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "endpoint_error_rate",
+		Help:      "Exponentially weighted moving average of the error rate (0..1) per rpc endpoint",
+	}, []string{"endpoint"})
+
+	EndpointHeadLedger = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "endpoint_head_ledger",
+		Help:      "Latest ledger sequence reported by getLatestLedger per rpc endpoint",
+	}, []string{"endpoint"})
+
+	EndpointOldestLedger = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "endpoint_oldest_ledger",
+		Help:      "Oldest ledger still in the retention window of an rpc endpoint, as reported by getLedgers",
+	}, []string{"endpoint"})
+
+	EndpointSelected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "endpoint_selected",
+		Help:      "1 for the rpc endpoint the last getLedgers call was routed to, 0 otherwise",
+	}, []string{"endpoint"})
+
+	EndpointProtocolVersion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "endpoint_protocol_version",
+		Help:      "Stellar protocol version reported by getLatestLedger per rpc endpoint",
+	}, []string{"endpoint"})
+
+	EndpointInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "endpoint_info",
+		Help:      "Always 1, labelled with the stellar-rpc and captive-core versions getVersionInfo reports per rpc endpoint",
+	}, []string{"endpoint", "version", "captive_core_version"})
+)
+
+// RPCUnknownFields counts rpc responses carrying fields our types do not
+// know about. Responses are only checked once per method and endpoint
+// version, so this tracks which fields appeared, not how often.
+var RPCUnknownFields = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "rpc",
+	Name:      "unknown_response_fields_total",
+	Help:      "Number of times a field unknown to firestellar was found in an rpc response, by method and field path",
+}, []string{"method", "field"})
+
+// Protocol versions of the ledgers converted to blocks, labelled by the
+// fetcher backend (rpc or captive-core).
+var (
+	LedgerProtocolVersion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "ledger",
+		Name:      "protocol_version",
+		Help:      "Stellar protocol version of the last ledger converted to a block",
+	}, []string{"backend"})
+
+	ProtocolVersionChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "ledger",
+		Name:      "protocol_version_changes_total",
+		Help:      "Number of protocol version changes seen between two consecutive ledgers",
+	}, []string{"backend", "from", "to"})
+
+	UnsupportedProtocolLedgers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "ledger",
+		Name:      "unsupported_protocol_total",
+		Help:      "Number of ledgers converted while their protocol version is outside of the supported range",
+	}, []string{"backend", "protocol_version"})
+)
+
+// Block production of the fetchers, labelled by backend (rpc or
+// captive-core).
+var (
+	HeadBlock = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "fetcher",
+		Name:      "head_block_number",
+		Help:      "Number of the last block fired",
+	}, []string{"backend"})
+
+	BlocksFired = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "fetcher",
+		Name:      "blocks_fired_total",
+		Help:      "Number of blocks fired",
+	}, []string{"backend"})
+
+	FetchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "fetcher",
+		Name:      "fetch_duration_seconds",
+		Help:      "Time to get a ledger from the backend, waiting for the chain head excluded",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"backend"})
+
+	ConvertDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "fetcher",
+		Name:      "convert_duration_seconds",
+		Help:      "Time to convert a ledger to a block",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+	}, []string{"backend"})
+
+	// HeadDrift is computed when scraped, so it keeps growing while no
+	// block is fired.
+	HeadDrift = newHeadDriftCollector()
+
+	CaptiveCorePreparing = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "captive_core",
+		Name:      "preparing_range",
+		Help:      "1 while stellar-core catches up to the start ledger from the history archives, 0 once it streams ledgers",
+	})
+
+	CaptiveCoreLatestLedger = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "captive_core",
+		Name:      "latest_ledger",
+		Help:      "Latest ledger stellar-core made available, ahead of the head block while ledgers are buffered",
+	})
+
+	CaptiveCoreRestarts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "captive_core",
+		Name:      "restarts_total",
+		Help:      "Number of times the supervisor recreated the captive-core backend after it failed",
+	})
+)
+
+// ObserveFiredBlock records the block number, closed at blockTime, as
+// the new head of backend.
+func ObserveFiredBlock(backend string, number uint64, blockTime time.Time) {
+	HeadBlock.WithLabelValues(backend).Set(float64(number))
+	BlocksFired.WithLabelValues(backend).Inc()
+	HeadDrift.observe(backend, blockTime)
+}
+
+func init() {
+	prometheus.MustRegister(
+		EndpointRequests,
+		EndpointErrors,
+		EndpointLatency,
+		EndpointErrorRate,
+		EndpointHeadLedger,
+		EndpointOldestLedger,
+		EndpointSelected,
+		EndpointProtocolVersion,
+		EndpointInfo,
+		RPCUnknownFields,
+		LedgerProtocolVersion,
+		ProtocolVersionChanges,
+		UnsupportedProtocolLedgers,
+		HeadBlock,
+		BlocksFired,
+		FetchDuration,
+		ConvertDuration,
+		HeadDrift,
+		CaptiveCorePreparing,
+		CaptiveCoreLatestLedger,
+		CaptiveCoreRestarts,
+	)
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_headDriftCollector(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 30, 0, time.UTC)
+	c := newHeadDriftCollector()
+	c.now = func() time.Time { return now }
+
+	require.Zero(t, testutil.CollectAndCount(c))
+
+	c.observe("rpc", now.Add(-6*time.Second))
+	require.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP firestellar_fetcher_head_drift_seconds Seconds between the wall clock and the close time of the last fired block
+# TYPE firestellar_fetcher_head_drift_seconds gauge
+firestellar_fetcher_head_drift_seconds{backend="rpc"} 6
+`)))
+
+	// Nothing fired since, the drift keeps growing.
+	now = now.Add(time.Minute)
+	require.Equal(t, 66.0, testutil.ToFloat64(c))
+}
@@ -0,0 +1,69 @@
+// Package networkcheck checks that the rpc endpoints or history archives
+// a fetcher reads from belong to the Stellar network it expects, before
+// a wrong network passphrase shows up as "unknown tx hash in
+// LedgerCloseMeta" while decoding ledgers.
+package networkcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Verify reads the network passphrase of every source, named by name,
+// and fails when one of them belongs to another network than expected.
+// With an empty expected passphrase, it is discovered instead: all
+// sources must then agree on it. It returns the passphrase and the
+// sources whose network cannot be read, in order, which the caller must
+// check again before using them. Verify fails when no source can be
+// read. kind names a source in messages, e.g. "rpc endpoint".
+func Verify[S any](ctx context.Context, kind string, sources []S, name func(S) string, passphraseOf func(context.Context, S) (string, error), expected string, logger *zap.Logger) (string, []S, error) {
+	var readErrs []error
+	var mismatches []string
+	var unverified []S
+	served := map[string][]string{}
+
+	for _, source := range sources {
+		passphrase, err := passphraseOf(ctx, source)
+		if err != nil {
+			logger.Warn("unable to read the network of "+kind+", checking it again before using it", zap.String("source", name(source)), zap.Error(err))
+			readErrs = append(readErrs, fmt.Errorf("%s: %w", name(source), err))
+			unverified = append(unverified, source)
+			continue
+		}
+
+		served[passphrase] = append(served[passphrase], name(source))
+		if expected != "" && passphrase != expected {
+			mismatches = append(mismatches, fmt.Sprintf("%s belongs to %q", name(source), passphrase))
+		}
+	}
+
+	if len(served) == 0 {
+		return "", nil, fmt.Errorf("unable to read the network of any %s: %w", kind, errors.Join(readErrs...))
+	}
+
+	if expected != "" {
+		if len(mismatches) > 0 {
+			return "", nil, fmt.Errorf("%ss do not belong to network %q: %s", kind, expected, strings.Join(mismatches, ", "))
+		}
+		return expected, unverified, nil
+	}
+
+	var discovered string
+	var networks []string
+	for passphrase, names := range served {
+		discovered = passphrase
+		networks = append(networks, fmt.Sprintf("%q by %s", passphrase, strings.Join(names, ", ")))
+	}
+	if len(served) > 1 {
+		sort.Strings(networks)
+		return "", nil, fmt.Errorf("%ss belong to different networks, cannot discover the network passphrase: %s", kind, strings.Join(networks, "; "))
+	}
+
+	logger.Info("discovered network passphrase from "+kind+"s", zap.String("network_passphrase", discovered))
+	return discovered, unverified, nil
+}
@@ -0,0 +1,46 @@
+package networkcheck
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func Test_Verify(t *testing.T) {
+	networks := map[string]string{"a": "testnet", "b": "testnet", "c": "pubnet"}
+	passphraseOf := func(_ context.Context, source string) (string, error) {
+		if network, found := networks[source]; found {
+			return network, nil
+		}
+		return "", errors.New("unreachable")
+	}
+	verify := func(sources []string, expected string) (string, []string, error) {
+		return Verify(context.Background(), "source", sources, func(s string) string { return s }, passphraseOf, expected, zap.NewNop())
+	}
+
+	passphrase, unverified, err := verify([]string{"down", "a", "b"}, "testnet")
+	require.NoError(t, err)
+	require.Equal(t, "testnet", passphrase)
+	require.Equal(t, []string{"down"}, unverified)
+
+	passphrase, unverified, err = verify([]string{"a", "down", "b"}, "")
+	require.NoError(t, err)
+	require.Equal(t, "testnet", passphrase)
+	require.Equal(t, []string{"down"}, unverified)
+
+	_, unverified, err = verify([]string{"a", "b"}, "testnet")
+	require.NoError(t, err)
+	require.Empty(t, unverified)
+
+	_, _, err = verify([]string{"a", "c"}, "testnet")
+	require.ErrorContains(t, err, `sources do not belong to network "testnet": c belongs to "pubnet"`)
+
+	_, _, err = verify([]string{"a", "c"}, "")
+	require.ErrorContains(t, err, `sources belong to different networks, cannot discover the network passphrase: "pubnet" by c; "testnet" by a`)
+
+	_, _, err = verify([]string{"down"}, "testnet")
+	require.ErrorContains(t, err, "unable to read the network of any source: down: unreachable")
+}
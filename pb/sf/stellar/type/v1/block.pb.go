@@ -240,8 +240,12 @@ type Transaction struct {
 	EnvelopeXdr      []byte                 `protobuf:"bytes,6,opt,name=envelope_xdr,json=envelopeXdr,proto3" json:"envelope_xdr,omitempty"`
 	ResultXdr        []byte                 `protobuf:"bytes,8,opt,name=result_xdr,json=resultXdr,proto3" json:"result_xdr,omitempty"`
 	Events           *Events                `protobuf:"bytes,9,opt,name=events,proto3" json:"events,omitempty"`
-	unknownFields    protoimpl.UnknownFields
-	sizeCache        protoimpl.SizeCache
+	// Total order id of the transaction, its id on Horizon and the prefix
+	// of its stellar-rpc event ids: ledger sequence, application order and
+	// operation 0, see package toid.
+	Toid          uint64 `protobuf:"varint,10,opt,name=toid,proto3" json:"toid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Transaction) Reset() {
@@ -323,6 +327,13 @@ func (x *Transaction) GetEvents() *Events {
 	return nil
 }
 
+func (x *Transaction) GetToid() uint64 {
+	if x != nil {
+		return x.Toid
+	}
+	return 0
+}
+
 // As per: https://github.com/stellar/stellar-rpc/pull/455
 type Events struct {
 	state                protoimpl.MessageState `protogen:"open.v1"`
@@ -447,7 +458,7 @@ const file_sf_stellar_type_v1_block_proto_rawDesc = "" +
 	"\vtotal_coins\x18\x03 \x01(\x03R\n" +
 	"totalCoins\x12\x19\n" +
 	"\bbase_fee\x18\x04 \x01(\rR\abaseFee\x12!\n" +
-	"\fbase_reserve\x18\x05 \x01(\rR\vbaseReserve\"\xbe\x02\n" +
+	"\fbase_reserve\x18\x05 \x01(\rR\vbaseReserve\"\xd2\x02\n" +
 	"\vTransaction\x12\x12\n" +
 	"\x04hash\x18\x01 \x01(\fR\x04hash\x12=\n" +
 	"\x06status\x18\x02 \x01(\x0e2%.sf.stellar.type.v1.TransactionStatusR\x06status\x129\n" +
@@ -457,7 +468,9 @@ const file_sf_stellar_type_v1_block_proto_rawDesc = "" +
 	"\fenvelope_xdr\x18\x06 \x01(\fR\venvelopeXdr\x12\x1d\n" +
 	"\n" +
 	"result_xdr\x18\b \x01(\fR\tresultXdr\x122\n" +
-	"\x06events\x18\t \x01(\v2\x1a.sf.stellar.type.v1.EventsR\x06events\"\xc5\x01\n" +
+	"\x06events\x18\t \x01(\v2\x1a.sf.stellar.type.v1.EventsR\x06events\x12\x12\n" +
+	"\x04toid\x18\n" +
+	" \x01(\x04R\x04toid\"\xc5\x01\n" +
 	"\x06Events\x122\n" +
 	"\x15diagnostic_events_xdr\x18\x01 \x03(\fR\x13diagnosticEventsXdr\x124\n" +
 	"\x16transaction_events_xdr\x18\x02 \x03(\fR\x14transactionEventsXdr\x12Q\n" +
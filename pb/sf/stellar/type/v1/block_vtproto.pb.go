@@ -87,6 +87,7 @@ func (m *Transaction) CloneVT() *Transaction {
 	r.CreatedAt = (*timestamppb.Timestamp)((*timestamppb1.Timestamp)(m.CreatedAt).CloneVT())
 	r.ApplicationOrder = m.ApplicationOrder
 	r.Events = m.Events.CloneVT()
+	r.Toid = m.Toid
 	if rhs := m.Hash; rhs != nil {
 		tmpBytes := make([]byte, len(rhs))
 		copy(tmpBytes, rhs)
@@ -285,6 +286,9 @@ func (this *Transaction) EqualVT(that *Transaction) bool {
 	if !this.Events.EqualVT(that.Events) {
 		return false
 	}
+	if this.Toid != that.Toid {
+		return false
+	}
 	return string(this.unknownFields) == string(that.unknownFields)
 }
 
@@ -543,6 +547,11 @@ func (m *Transaction) MarshalToSizedBufferVT(dAtA []byte) (int, error) {
 		i -= len(m.unknownFields)
 		copy(dAtA[i:], m.unknownFields)
 	}
+	if m.Toid != 0 {
+		i = protohelpers.EncodeVarint(dAtA, i, uint64(m.Toid))
+		i--
+		dAtA[i] = 0x50
+	}
 	if m.Events != nil {
 		size, err := m.Events.MarshalToSizedBufferVT(dAtA[:i])
 		if err != nil {
@@ -874,6 +883,11 @@ func (m *Transaction) MarshalToSizedBufferVTStrict(dAtA []byte) (int, error) {
 		i -= len(m.unknownFields)
 		copy(dAtA[i:], m.unknownFields)
 	}
+	if m.Toid != 0 {
+		i = protohelpers.EncodeVarint(dAtA, i, uint64(m.Toid))
+		i--
+		dAtA[i] = 0x50
+	}
 	if m.Events != nil {
 		size, err := m.Events.MarshalToSizedBufferVTStrict(dAtA[:i])
 		if err != nil {
@@ -1125,6 +1139,9 @@ func (m *Transaction) SizeVT() (n int) {
 		l = m.Events.SizeVT()
 		n += 1 + l + protohelpers.SizeOfVarint(uint64(l))
 	}
+	if m.Toid != 0 {
+		n += 1 + protohelpers.SizeOfVarint(uint64(m.Toid))
+	}
 	n += len(m.unknownFields)
 	return n
 }
@@ -1804,6 +1821,25 @@ func (m *Transaction) UnmarshalVT(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 10:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Toid", wireType)
+			}
+			m.Toid = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return protohelpers.ErrIntOverflow
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Toid |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := protohelpers.Skip(dAtA[iNdEx:])
@@ -2674,6 +2710,25 @@ func (m *Transaction) UnmarshalVTUnsafe(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 10:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Toid", wireType)
+			}
+			m.Toid = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return protohelpers.ErrIntOverflow
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Toid |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := protohelpers.Skip(dAtA[iNdEx:])
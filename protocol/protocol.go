@@ -0,0 +1,142 @@
+// Package protocol holds the support matrix of the LedgerCloseMeta and
+// Stellar protocol versions firestellar converts to blocks, and the
+// Guard both fetchers run every ledger through before converting it.
+//
+// A network upgrade can bring a protocol whose ledgers still decode with
+// our XDR definitions while carrying data they do not know about, which
+// would produce degraded blocks without any error. The Guard makes such
+// upgrades visible, and can halt the fetcher on them.
+package protocol
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/stellar/go-stellar-sdk/xdr"
+	"github.com/streamingfast/firehose-stellar/metrics"
+	"go.uber.org/zap"
+)
+
+// MaxSupportedVersion is the newest Stellar protocol version firestellar
+// was validated against. Bump it, along with go-stellar-sdk, once blocks
+// of a new protocol were checked.
+const MaxSupportedVersion = 27
+
+// MetaVersions lists the LedgerCloseMeta union arms the converters read
+// the ledger header and transactions from.
+var MetaVersions = map[int32]string{
+	0: "LedgerCloseMetaV0, classic ledgers",
+	1: "LedgerCloseMetaV1, adds Soroban state archival and fee data",
+	2: "LedgerCloseMetaV2, adds unified events (TransactionMetaV4)",
+}
+
+// IsSupported reports whether ledgers of protocol version are converted
+// without losing data.
+func IsSupported(version uint32) bool {
+	return version <= MaxSupportedVersion
+}
+
+// UnsupportedError is returned for a ledger the support matrix does not
+// cover.
+type UnsupportedError struct {
+	LedgerSeq       uint32
+	MetaVersion     int32
+	ProtocolVersion uint32
+}
+
+func (e *UnsupportedError) Error() string {
+	if _, found := MetaVersions[e.MetaVersion]; !found {
+		return fmt.Sprintf("unsupported LedgerCloseMeta version %d", e.MetaVersion)
+	}
+	return fmt.Sprintf("ledger %d uses protocol %d, newer than the latest supported protocol %d: upgrade firestellar", e.LedgerSeq, e.ProtocolVersion, MaxSupportedVersion)
+}
+
+// LedgerHeader returns the header of meta, or an *UnsupportedError when
+// meta is of a version missing from MetaVersions.
+func LedgerHeader(meta *xdr.LedgerCloseMeta) (xdr.LedgerHeaderHistoryEntry, error) {
+	switch {
+	case meta.V == 0 && meta.V0 != nil:
+		return meta.V0.LedgerHeader, nil
+	case meta.V == 1 && meta.V1 != nil:
+		return meta.V1.LedgerHeader, nil
+	case meta.V == 2 && meta.V2 != nil:
+		return meta.V2.LedgerHeader, nil
+	}
+	return xdr.LedgerHeaderHistoryEntry{}, &UnsupportedError{MetaVersion: meta.V}
+}
+
+// Guard follows the protocol version of consecutive ledgers. It logs and
+// counts every protocol change, and counts the ledgers of an unsupported
+// protocol, which it rejects instead when halting. A Guard is not safe
+// for concurrent use; each fetcher owns one.
+type Guard struct {
+	backend           string
+	haltOnUnsupported bool
+	logger            *zap.Logger
+
+	lastLedger  uint32
+	lastVersion uint32
+}
+
+// NewGuard returns a Guard for the backend ("rpc" or "captive-core")
+// labelling its metrics.
+func NewGuard(backend string, haltOnUnsupported bool, logger *zap.Logger) *Guard {
+	return &Guard{
+		backend:           backend,
+		haltOnUnsupported: haltOnUnsupported,
+		logger:            logger,
+	}
+}
+
+// Check inspects the ledger header of meta before it is converted and
+// returns it. It fails on a LedgerCloseMeta version the converters do
+// not read and, when halting, on a protocol version that is not
+// supported.
+func (g *Guard) Check(meta *xdr.LedgerCloseMeta) (xdr.LedgerHeaderHistoryEntry, error) {
+	header, err := LedgerHeader(meta)
+	if err != nil {
+		return header, err
+	}
+
+	ledgerSeq := uint32(header.Header.LedgerSeq)
+	version := uint32(header.Header.LedgerVersion)
+	supported := IsSupported(version)
+	changed := version != g.lastVersion
+
+	switch {
+	case !changed:
+	case g.lastVersion == 0:
+		g.logger.Info("stellar protocol version",
+			zap.String("backend", g.backend),
+			zap.Uint32("ledger", ledgerSeq),
+			zap.Uint32("protocol_version", version),
+			zap.Bool("supported", supported),
+		)
+	default:
+		metrics.ProtocolVersionChanges.WithLabelValues(g.backend, strconv.FormatUint(uint64(g.lastVersion), 10), strconv.FormatUint(uint64(version), 10)).Inc()
+		g.logger.Warn("stellar protocol version changed",
+			zap.String("backend", g.backend),
+			zap.Uint32("ledger", ledgerSeq),
+			zap.Uint32("previous_ledger", g.lastLedger),
+			zap.Uint32("from", g.lastVersion),
+			zap.Uint32("to", version),
+			zap.Bool("supported", supported),
+		)
+	}
+	g.lastLedger = ledgerSeq
+	g.lastVersion = version
+	metrics.LedgerProtocolVersion.WithLabelValues(g.backend).Set(float64(version))
+
+	if !supported {
+		unsupported := &UnsupportedError{LedgerSeq: ledgerSeq, MetaVersion: meta.V, ProtocolVersion: version}
+		if g.haltOnUnsupported {
+			return header, unsupported
+		}
+		metrics.UnsupportedProtocolLedgers.WithLabelValues(g.backend, strconv.FormatUint(uint64(version), 10)).Inc()
+		if changed {
+			g.logger.Warn("converting ledgers of an unsupported protocol, blocks may miss data", zap.String("backend", g.backend), zap.Error(unsupported))
+		}
+	}
+
+	return header, nil
+}
@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stellar/go-stellar-sdk/xdr"
+	"github.com/streamingfast/firehose-stellar/metrics"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func ledgerCloseMeta(metaVersion int32, ledgerSeq, protocolVersion uint32) *xdr.LedgerCloseMeta {
+	header := xdr.LedgerHeaderHistoryEntry{
+		Header: xdr.LedgerHeader{
+			LedgerSeq:     xdr.Uint32(ledgerSeq),
+			LedgerVersion: xdr.Uint32(protocolVersion),
+		},
+	}
+
+	meta := &xdr.LedgerCloseMeta{V: metaVersion}
+	switch metaVersion {
+	case 0:
+		meta.V0 = &xdr.LedgerCloseMetaV0{LedgerHeader: header}
+	case 1:
+		meta.V1 = &xdr.LedgerCloseMetaV1{LedgerHeader: header}
+	case 2:
+		meta.V2 = &xdr.LedgerCloseMetaV2{LedgerHeader: header}
+	}
+	return meta
+}
+
+func Test_LedgerHeader(t *testing.T) {
+	for version := range MetaVersions {
+		header, err := LedgerHeader(ledgerCloseMeta(version, 100, 22))
+		require.NoError(t, err)
+		require.Equal(t, xdr.Uint32(100), header.Header.LedgerSeq)
+	}
+
+	_, err := LedgerHeader(ledgerCloseMeta(3, 100, 22))
+	var unsupported *UnsupportedError
+	require.ErrorAs(t, err, &unsupported)
+	require.EqualError(t, err, "unsupported LedgerCloseMeta version 3")
+}
+
+func Test_Guard_TracksProtocolChanges(t *testing.T) {
+	g := NewGuard("test-changes", false, zap.NewNop())
+
+	for seq := uint32(100); seq <= 101; seq++ {
+		_, err := g.Check(ledgerCloseMeta(1, seq, 22))
+		require.NoError(t, err)
+	}
+	require.Zero(t, testutil.ToFloat64(metrics.ProtocolVersionChanges.WithLabelValues("test-changes", "22", "23")))
+
+	_, err := g.Check(ledgerCloseMeta(2, 102, 23))
+	require.NoError(t, err)
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.ProtocolVersionChanges.WithLabelValues("test-changes", "22", "23")))
+	require.Equal(t, 23.0, testutil.ToFloat64(metrics.LedgerProtocolVersion.WithLabelValues("test-changes")))
+}
+
+func Test_Guard_UnsupportedProtocol(t *testing.T) {
+	next := uint32(MaxSupportedVersion + 1)
+
+	t.Run("warn", func(t *testing.T) {
+		g := NewGuard("test-warn", false, zap.NewNop())
+
+		_, err := g.Check(ledgerCloseMeta(2, 100, MaxSupportedVersion))
+		require.NoError(t, err)
+		for seq := uint32(101); seq <= 102; seq++ {
+			_, err := g.Check(ledgerCloseMeta(2, seq, next))
+			require.NoError(t, err)
+		}
+		require.Equal(t, 2.0, testutil.ToFloat64(metrics.UnsupportedProtocolLedgers.WithLabelValues("test-warn", strconv.Itoa(MaxSupportedVersion+1))))
+	})
+
+	t.Run("halt", func(t *testing.T) {
+		g := NewGuard("test-halt", true, zap.NewNop())
+
+		_, err := g.Check(ledgerCloseMeta(2, 100, MaxSupportedVersion))
+		require.NoError(t, err)
+
+		_, err = g.Check(ledgerCloseMeta(2, 101, next))
+		var unsupported *UnsupportedError
+		require.ErrorAs(t, err, &unsupported)
+		require.Equal(t, uint32(101), unsupported.LedgerSeq)
+		require.Equal(t, next, unsupported.ProtocolVersion)
+		require.ErrorContains(t, err, "newer than the latest supported protocol")
+	})
+}
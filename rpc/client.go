@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"sync/atomic"
 	"time"
 
 	"github.com/streamingfast/dhttp"
@@ -19,17 +22,74 @@ type Client struct {
 	rpcEndpoint string
 	httpClient  *http.Client
 	logger      *zap.Logger
+
+	retryPolicy RetryPolicy
+	breaker     *circuitBreaker
+
+	schema          *schemaChecker
+	protocolVersion atomic.Int64
+	versionInfo     atomic.Pointer[types.GetVersionInfoResult]
 }
 
 func NewClient(rpcEndpoint string, logger *zap.Logger, tracer logging.Tracer) *Client {
-	return &Client{
+	return newClient(rpcEndpoint, http.DefaultTransport, logger, tracer)
+}
+
+// NewClientFromConfig constructs a client for an endpoint parsed by
+// ParseEndpointConfig, resolving its secrets and loading its
+// certificates.
+func NewClientFromConfig(cfg *EndpointConfig, logger *zap.Logger, tracer logging.Tracer) (*Client, error) {
+	transport, err := cfg.transport()
+	if err != nil {
+		return nil, fmt.Errorf("configuring endpoint %s: %w", cfg.URL, err)
+	}
+	return newClient(cfg.URL, transport, logger, tracer), nil
+}
+
+func newClient(rpcEndpoint string, transport http.RoundTripper, logger *zap.Logger, tracer logging.Tracer) *Client {
+	c := &Client{
 		rpcEndpoint: rpcEndpoint,
 		httpClient: &http.Client{
-			Transport: dhttp.NewLoggingRoundTripper(logger, tracer, http.DefaultTransport),
+			Transport: dhttp.NewLoggingRoundTripper(logger, tracer, transport),
 			Timeout:   60 * time.Second, // Set a reasonable timeout for HTTP requests
 		},
-		logger: logger,
+		logger:      logger,
+		retryPolicy: DefaultRetryPolicy,
+		breaker:     newCircuitBreaker(DefaultCircuitBreakerFailures, DefaultCircuitBreakerCooldown),
 	}
+	c.schema = newSchemaChecker(c.Endpoint(), logger)
+	return c
+}
+
+// Circuit breaker defaults, see SetCircuitBreaker.
+const (
+	DefaultCircuitBreakerFailures = 5
+	DefaultCircuitBreakerCooldown = 30 * time.Second
+)
+
+// SetRetryPolicy replaces DefaultRetryPolicy for this client.
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retryPolicy = policy
+}
+
+// SetCircuitBreaker makes the client fail fast with ErrCircuitOpen for
+// cooldown after failures consecutive retryable failures. A zero
+// failures disables the circuit breaker.
+func (c *Client) SetCircuitBreaker(failures int, cooldown time.Duration) {
+	c.breaker = newCircuitBreaker(failures, cooldown)
+}
+
+// Endpoint returns the rpc endpoint URL with any query string and
+// user info stripped, so it is safe to use in logs and metric labels.
+func (c *Client) Endpoint() string {
+	u, err := url.Parse(c.rpcEndpoint)
+	if err != nil {
+		return c.rpcEndpoint
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
 }
 
 func (c *Client) GetLatestLedger(ctx context.Context) (*types.GetLatestLedgerResult, error) {
@@ -46,23 +106,24 @@ func (c *Client) GetLatestLedger(ctx context.Context) (*types.GetLatestLedgerRes
 	}
 
 	var response types.GetLatestLedgerResponse
-	decoder := json.NewDecoder(bytes.NewBuffer(body))
-	// decoder.DisallowUnknownFields() // On GetLatestLedger, we are only interested in the SequenceNumber
-	err = decoder.Decode(&response)
-	if err != nil {
-		return nil, fmt.Errorf("original body: %s failed to unmarshal JSON: %w", string(body), err)
+	if err := c.decodeResponse("getLatestLedger", body, &response); err != nil {
+		return nil, err
 	}
-
-	if response.Error != nil {
-		return nil, fmt.Errorf("rpc error: %w", response.Error)
+	if err := response.Result.Validate(); err != nil {
+		return nil, &FatalError{Err: err}
 	}
+	c.observeProtocolVersion(response.Result.ProtocolVersion)
 
 	return &response.Result, nil
 }
 
-// GetLedgers returns the ledgers for a given number
-func (c *Client) GetLedgers(ctx context.Context, startLedgerNum uint64) ([]types.Ledger, error) {
-	payload := types.NewLedgerRequest(startLedgerNum, &types.Pagination{Limit: 1})
+// GetLedgers returns up to limit ledgers starting at startLedgerNum, or
+// right after the ledger cursor points to when cursor is set, along with
+// the retention window (oldest and latest ledger) the endpoint reported
+// and the cursor to resume from. A start ledger outside of that window
+// fails with *LedgerOutOfRangeError.
+func (c *Client) GetLedgers(ctx context.Context, startLedgerNum uint64, limit int, cursor string) (*types.GetLedgersResult, error) {
+	payload := types.NewLedgerRequest(startLedgerNum, types.NewPagination(limit, cursor))
 
 	rpcBody, err := json.Marshal(payload)
 	if err != nil {
@@ -71,25 +132,24 @@ func (c *Client) GetLedgers(ctx context.Context, startLedgerNum uint64) ([]types
 
 	body, err := c.makeRequest(ctx, rpcBody)
 	if err != nil {
+		var rpcErr *types.RPCError
+		if errors.As(err, &rpcErr) {
+			if outOfRange := parseLedgerOutOfRange(c.Endpoint(), startLedgerNum, rpcErr); outOfRange != nil {
+				return nil, outOfRange
+			}
+		}
 		return nil, fmt.Errorf("failed to get ledgers: %w", err)
 	}
 
 	var response types.GetLedgersResponse
-	decoder := json.NewDecoder(bytes.NewBuffer(body))
-	decoder.DisallowUnknownFields() // Fail on unknown fields
-	err = decoder.Decode(&response)
-	if err != nil {
-		return nil, fmt.Errorf("original body: %s failed to unmarshal JSON: %w", string(body), err)
+	if err := c.decodeResponse("getLedgers", body, &response); err != nil {
+		return nil, err
 	}
-
-	if response.Error != nil {
-		return nil, fmt.Errorf("rpc error: %w", response.Error)
+	if err := response.Result.Validate(); err != nil {
+		return nil, &FatalError{Err: err}
 	}
 
-	// TODO: The cursor in response.Result.Cursor is available for pagination but not currently used
-	// since we only request 1 ledger at a time
-
-	return response.Result.Ledgers, nil
+	return &response.Result, nil
 }
 
 // GetTransaction returns a single transaction by hash
@@ -107,15 +167,11 @@ func (c *Client) GetTransaction(ctx context.Context, hash string) (*types.GetTra
 	}
 
 	var response types.GetTransactionResponse
-	decoder := json.NewDecoder(bytes.NewBuffer(body))
-	decoder.DisallowUnknownFields() // Fail on unknown fields
-	err = decoder.Decode(&response)
-	if err != nil {
-		return nil, fmt.Errorf("original body: %s failed to unmarshal JSON: %w", string(body), err)
+	if err := c.decodeResponse("getTransaction", body, &response); err != nil {
+		return nil, err
 	}
-
-	if response.Error != nil {
-		return nil, fmt.Errorf("rpc error: %w", response.Error)
+	if err := response.Result.Validate(); err != nil {
+		return nil, &FatalError{Err: err}
 	}
 
 	return &response.Result, nil
@@ -154,7 +210,7 @@ func (c *Client) GetTransactions(ctx context.Context, ledgerNum uint64, limit in
 }
 
 func (c *Client) getTransactions(ctx context.Context, ledgerNum uint64, limit int, cursor string) (string, []types.Transaction, error) {
-	payload := types.NewGetTransactionsRquest(ledgerNum, types.NewPagination(limit, cursor))
+	payload := types.NewGetTransactionsRequest(ledgerNum, types.NewPagination(limit, cursor))
 
 	rpcBody, err := json.Marshal(payload)
 	if err != nil {
@@ -167,38 +223,116 @@ func (c *Client) getTransactions(ctx context.Context, ledgerNum uint64, limit in
 	}
 
 	var response types.GetTransactionsResponse
-	decoder := json.NewDecoder(bytes.NewBuffer(body))
-	decoder.DisallowUnknownFields() // Fail on unknown fields
-	err = decoder.Decode(&response)
-	if err != nil {
-		return cursor, nil, fmt.Errorf("original body: %s failed to unmarshal JSON: %w", string(body), err)
+	if err := c.decodeResponse("getTransactions", body, &response); err != nil {
+		return cursor, nil, err
 	}
-
-	if response.Error != nil {
-		return cursor, nil, fmt.Errorf("rpc error: %w", response.Error)
+	for i := range response.Result.Transactions {
+		if err := response.Result.Transactions[i].Validate(); err != nil {
+			return cursor, nil, &FatalError{Err: fmt.Errorf("getTransactions: %w", err)}
+		}
 	}
 
 	cursor = response.Result.Cursor
 	return cursor, response.Result.Transactions, nil
 }
 
+// decodeResponse decodes body into response. Fields unknown to response
+// are ignored, and reported by the schema checker.
+func (c *Client) decodeResponse(method string, body []byte, response any) error {
+	if err := json.Unmarshal(body, response); err != nil {
+		return &FatalError{Err: fmt.Errorf("original body: %s failed to unmarshal JSON: %w", string(body), err)}
+	}
+	c.schema.Check(method, body, response)
+	return nil
+}
+
+// makeRequest posts reqBody and returns the response body. Retryable
+// failures are retried according to the retry policy, honoring the
+// Retry-After the endpoint asks for, except ErrCircuitOpen which is
+// returned right away so the caller moves to another endpoint. JSON-RPC
+// error responses come back as a *RetryableError or *FatalError wrapping
+// the *types.RPCError.
 func (c *Client) makeRequest(ctx context.Context, reqBody []byte) ([]byte, error) {
+	attempts := max(c.retryPolicy.MaxAttempts, 1)
+
+	for attempt := 1; ; attempt++ {
+		body, err := c.attemptRequest(ctx, reqBody)
+		if err == nil || attempt >= attempts || !IsRetryable(err) || errors.Is(err, ErrCircuitOpen) {
+			return body, err
+		}
+
+		var retryable *RetryableError
+		errors.As(err, &retryable)
+
+		wait := c.retryPolicy.backoff(attempt)
+		if retryable.RetryAfter > 0 {
+			if c.retryPolicy.MaxRetryAfter > 0 && retryable.RetryAfter > c.retryPolicy.MaxRetryAfter {
+				return nil, err
+			}
+			wait = retryable.RetryAfter
+		}
+
+		c.logger.Debug("retrying rpc request",
+			zap.String("endpoint", c.Endpoint()),
+			zap.Int("attempt", attempt),
+			zap.Duration("wait", wait),
+			zap.Error(err),
+		)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) attemptRequest(ctx context.Context, reqBody []byte) ([]byte, error) {
+	if allowed, retryIn := c.breaker.Allow(); !allowed {
+		return nil, &RetryableError{Err: fmt.Errorf("%s: %w, next probe in %s", c.Endpoint(), ErrCircuitOpen, retryIn.Round(time.Second))}
+	}
+
+	body, err := c.doRequest(ctx, reqBody)
+	switch {
+	case ctx.Err() == nil:
+		c.breaker.Record(err)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		// The endpoint did not answer within the time given to the
+		// request.
+		c.breaker.Record(&RetryableError{Err: ctx.Err()})
+	default:
+		// A request we canceled says nothing about the endpoint.
+		c.breaker.Abandon()
+	}
+	return body, err
+}
+
+func (c *Client) doRequest(ctx context.Context, reqBody []byte) ([]byte, error) {
 	req, err := http.NewRequestWithContext(ctx, "POST", c.rpcEndpoint, bytes.NewBuffer(reqBody))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, &FatalError{Err: fmt.Errorf("failed to create request: %w", err)}
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, classifyTransportError(fmt.Errorf("failed to send request: %w", err))
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, classifyTransportError(fmt.Errorf("failed to read response body: %w", err))
+	}
+
+	if err := classifyHTTPStatus(resp, body); err != nil {
+		return nil, err
+	}
+	if err := classifyResponseBody(body); err != nil {
+		return nil, err
 	}
 
 	return body, nil
@@ -22,10 +22,11 @@ func Test_GetLedgers(t *testing.T) {
 	c := NewClient(RPC_MAINNET_ENDPOINT, testLog, testTracer)
 	ledger, err := c.GetLatestLedger(context.Background())
 	require.NoError(t, err)
-	ledgers, err := c.GetLedgers(context.Background(), uint64(ledger.Sequence))
+	result, err := c.GetLedgers(context.Background(), uint64(ledger.Sequence), 1, "")
 	require.NoError(t, err)
-	require.NotEmpty(t, ledgers)
-	require.Equal(t, 1, len(ledgers))
+	require.NotEmpty(t, result.Ledgers)
+	require.Equal(t, 1, len(result.Ledgers))
+	require.LessOrEqual(t, result.OldestLedger, uint64(ledger.Sequence))
 }
 
 func Test_GetTransactions(t *testing.T) {
@@ -0,0 +1,226 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stellar/go-stellar-sdk/xdr"
+	"github.com/streamingfast/firehose-stellar/types"
+	"github.com/stretchr/testify/require"
+)
+
+// Test_Client_Compatibility replays the responses under
+// testdata/compat/<dir> and checks every stellar-rpc release we support
+// decodes and validates, without fields unknown to our types. The
+// handwritten-unreleased directory stands for a future release adding
+// fields: it must decode all the same, reporting what
+// unknown_fields.json lists.
+func Test_Client_Compatibility(t *testing.T) {
+	versions, err := os.ReadDir("testdata/compat")
+	require.NoError(t, err)
+
+	for _, version := range versions {
+		if !version.IsDir() {
+			continue
+		}
+
+		t.Run(version.Name(), func(t *testing.T) {
+			dir := filepath.Join("testdata/compat", version.Name())
+			c := newFixtureServer(t, dir)
+			ctx := context.Background()
+
+			expectedUnknown := map[string][]string{}
+			if content, err := os.ReadFile(filepath.Join(dir, "unknown_fields.json")); err == nil {
+				require.NoError(t, json.Unmarshal(content, &expectedUnknown))
+			}
+
+			checks := map[string]struct {
+				call     func() error
+				response any
+			}{
+				"getLatestLedger": {
+					call: func() error {
+						ledger, err := c.GetLatestLedger(ctx)
+						if err == nil {
+							require.Equal(t, ledger.ProtocolVersion, c.ProtocolVersion())
+						}
+						return err
+					},
+					response: &types.GetLatestLedgerResponse{},
+				},
+				"getLedgers": {
+					call: func() error {
+						_, err := c.GetLedgers(ctx, 0, 1, "")
+						return err
+					},
+					response: &types.GetLedgersResponse{},
+				},
+				"getTransaction": {
+					call: func() error {
+						_, err := c.GetTransaction(ctx, "8a3c")
+						return err
+					},
+					response: &types.GetTransactionResponse{},
+				},
+				"getTransactions": {
+					call: func() error {
+						_, err := c.GetTransactionsPage(ctx, 1, 1, "")
+						return err
+					},
+					response: &types.GetTransactionsResponse{},
+				},
+				"getVersionInfo": {
+					call: func() error {
+						info, err := c.LoadVersionInfo(ctx)
+						if err == nil {
+							require.NotEmpty(t, info.Version)
+							require.NotEmpty(t, info.CaptiveCoreVersion)
+							require.NotZero(t, info.ProtocolVersion)
+						}
+						return err
+					},
+					response: &types.Response[types.GetVersionInfoResult]{},
+				},
+				"getNetwork": {
+					call: func() error {
+						_, err := c.GetNetwork(ctx)
+						return err
+					},
+					response: &types.Response[types.GetNetworkResult]{},
+				},
+				"getHealth": {
+					call: func() error {
+						_, err := c.GetHealth(ctx)
+						return err
+					},
+					response: &types.Response[types.GetHealthResult]{},
+				},
+			}
+
+			for method, check := range checks {
+				body, err := os.ReadFile(filepath.Join(dir, method+".json"))
+				if os.IsNotExist(err) {
+					continue
+				}
+				require.NoError(t, err)
+
+				require.NoError(t, check.call(), method)
+
+				require.NoError(t, json.Unmarshal(body, check.response), method)
+				unknown := newSchemaChecker(dir, testLog).Check(method, body, check.response)
+				require.ElementsMatch(t, expectedUnknown[method], unknown, method)
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, "RECORDED")); err == nil {
+				checkRecordedPayloads(t, c)
+			}
+		})
+	}
+}
+
+// checkRecordedPayloads checks the XDR payloads of a directory recorded
+// by record.sh decode, hand-written ones being placeholders.
+func checkRecordedPayloads(t *testing.T, c *Client) {
+	ctx := context.Background()
+
+	ledgers, err := c.GetLedgers(ctx, 0, 1, "")
+	if err == nil {
+		for _, ledger := range ledgers.Ledgers {
+			var meta xdr.LedgerCloseMeta
+			require.NoError(t, xdr.SafeUnmarshalBase64(ledger.MetadataXdr, &meta), "ledger %d metadataXdr", ledger.Sequence)
+			require.Equal(t, uint32(ledger.Sequence), meta.LedgerSequence())
+		}
+	}
+
+	transactions, err := c.GetTransactionsPage(ctx, 1, 1, "")
+	require.NoError(t, err)
+	for _, trx := range transactions.Transactions {
+		var envelope xdr.TransactionEnvelope
+		require.NoError(t, xdr.SafeUnmarshalBase64(trx.EnvelopeXdr, &envelope), "transaction %s envelopeXdr", trx.TxHash)
+		var result xdr.TransactionResult
+		require.NoError(t, xdr.SafeUnmarshalBase64(trx.ResultXdr, &result), "transaction %s resultXdr", trx.TxHash)
+		if trx.ResultMetaXdr != "" {
+			var meta xdr.TransactionMeta
+			require.NoError(t, xdr.SafeUnmarshalBase64(trx.ResultMetaXdr, &meta), "transaction %s resultMetaXdr", trx.TxHash)
+		}
+	}
+}
+
+// newFixtureServer serves <dir>/<method>.json for every JSON-RPC method.
+func newFixtureServer(t *testing.T, dir string) *Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request types.Request
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		body, err := os.ReadFile(filepath.Join(dir, request.Method+".json"))
+		if err != nil {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"method not found"}}`))
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+
+	c := NewClient(server.URL, testLog, testTracer)
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	return c
+}
+
+func Test_GetVersionInfoResult_LegacyFields(t *testing.T) {
+	var info types.GetVersionInfoResult
+	require.NoError(t, json.Unmarshal([]byte(`{"version":"21.5.1","commit_hash":"4a1b3e2","build_time_stamp":"2024-09-10T12:00:00","captive_core_version":"stellar-core 21.3.1","protocol_version":21}`), &info))
+	require.Equal(t, types.GetVersionInfoResult{
+		Version:            "21.5.1",
+		CommitHash:         "4a1b3e2",
+		BuildTimestamp:     "2024-09-10T12:00:00",
+		CaptiveCoreVersion: "stellar-core 21.3.1",
+		ProtocolVersion:    21,
+	}, info)
+}
+
+func Test_Client_RejectsInvalidResults(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		result string
+		call   func(c *Client) error
+	}{
+		{"latest ledger without sequence", "getLatestLedger", `{"id":"ab","protocolVersion":23}`, func(c *Client) error {
+			_, err := c.GetLatestLedger(context.Background())
+			return err
+		}},
+		{"ledger without metadata", "getLedgers", `{"ledgers":[{"hash":"ab","sequence":10,"ledgerCloseTime":"1"}],"latestLedger":20}`, func(c *Client) error {
+			_, err := c.GetLedgers(context.Background(), 10, 1, "")
+			return err
+		}},
+		{"transaction without envelope", "getTransaction", `{"status":"SUCCESS","resultXdr":"AA=="}`, func(c *Client) error {
+			_, err := c.GetTransaction(context.Background(), "ab")
+			return err
+		}},
+		{"transactions without status", "getTransactions", `{"transactions":[{"envelopeXdr":"AA==","resultXdr":"AA==","ledger":10}]}`, func(c *Client) error {
+			_, err := c.GetTransactions(context.Background(), 10, 1, "")
+			return err
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := newStandInServer(t, map[string]string{test.method: test.result})
+			c.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+
+			err := test.call(c)
+			var fatal *FatalError
+			require.ErrorAs(t, err, &fatal)
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// EndpointConfig describes how to reach one rpc endpoint. It is parsed
+// from an --endpoints value of the form
+//
+//	<url>[;<option>...]
+//
+// with options
+//
+//	header=<Name>:<secret>   add an HTTP header to every request
+//	query=<name>:<secret>    add a query string parameter to every request
+//	ca-file=<path>           trust the PEM CA bundle at path, on top of the system pool
+//	cert-file=<path>         client certificate (PEM) for mutual TLS, requires key-file
+//	key-file=<path>          client certificate key (PEM)
+//	proxy=<url>              send requests through this proxy instead of the environment one
+//
+// where <secret> is env:<VARIABLE> or file:<path>, so credentials never
+// appear in flags, process listings or logs. For example:
+//
+//	https://rpc.example.com;header=Authorization:env:RPC_AUTHORIZATION;ca-file=/etc/ssl/rpc-ca.pem
+type EndpointConfig struct {
+	URL      string
+	Headers  []SecretParam
+	Query    []SecretParam
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	Proxy    string
+}
+
+// SecretParam is a header or query parameter whose value is read from
+// Source, either env:<VARIABLE> or file:<path>.
+type SecretParam struct {
+	Name   string
+	Source string
+}
+
+// ParseEndpointConfig parses an --endpoints value, see EndpointConfig.
+func ParseEndpointConfig(spec string) (*EndpointConfig, error) {
+	parts := strings.Split(spec, ";")
+
+	cfg := &EndpointConfig{URL: strings.TrimSpace(parts[0])}
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("invalid endpoint %q: missing url", spec)
+	}
+
+	for _, option := range parts[1:] {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+
+		key, value, found := strings.Cut(option, "=")
+		if !found || value == "" {
+			return nil, fmt.Errorf("invalid endpoint option %q: expected <option>=<value>", option)
+		}
+
+		switch key {
+		case "header", "query":
+			name, source, found := strings.Cut(value, ":")
+			if !found || name == "" {
+				return nil, fmt.Errorf("invalid endpoint %s %q: expected <name>:env:<VARIABLE> or <name>:file:<path>", key, value)
+			}
+			if !strings.HasPrefix(source, "env:") && !strings.HasPrefix(source, "file:") {
+				return nil, fmt.Errorf("invalid endpoint %s %q: the value must come from env:<VARIABLE> or file:<path>, not be given inline", key, name)
+			}
+			param := SecretParam{Name: name, Source: source}
+			if key == "header" {
+				cfg.Headers = append(cfg.Headers, param)
+			} else {
+				cfg.Query = append(cfg.Query, param)
+			}
+		case "ca-file":
+			cfg.CAFile = value
+		case "cert-file":
+			cfg.CertFile = value
+		case "key-file":
+			cfg.KeyFile = value
+		case "proxy":
+			cfg.Proxy = value
+		default:
+			return nil, fmt.Errorf("unknown endpoint option %q (want header, query, ca-file, cert-file, key-file or proxy)", key)
+		}
+	}
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, fmt.Errorf("invalid endpoint %s: cert-file and key-file must be set together", cfg.URL)
+	}
+
+	return cfg, nil
+}
+
+// resolveSecret reads the value of a SecretParam source.
+func resolveSecret(source string) (string, error) {
+	switch {
+	case strings.HasPrefix(source, "env:"):
+		name := strings.TrimPrefix(source, "env:")
+		value, found := os.LookupEnv(name)
+		if !found {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(source, "file:"):
+		path := strings.TrimPrefix(source, "file:")
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading secret file: %w", err)
+		}
+		// Secret files are usually written with a trailing newline.
+		return strings.TrimSpace(string(content)), nil
+	default:
+		return "", fmt.Errorf("unsupported secret source %q (want env:<VARIABLE> or file:<path>)", source)
+	}
+}
+
+// transport builds the http.RoundTripper reaching the endpoint: TLS and
+// proxy settings first, then the secret headers and query parameters.
+func (c *EndpointConfig) transport() (http.RoundTripper, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+
+	if c.CAFile != "" || c.CertFile != "" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+		if c.CAFile != "" {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			bundle, err := os.ReadFile(c.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading ca-file: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(bundle) {
+				return nil, fmt.Errorf("ca-file %s holds no PEM certificate", c.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+
+		if c.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("loading client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		base.TLSClientConfig = tlsConfig
+	}
+
+	if c.Proxy != "" {
+		proxyURL, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("parsing proxy url: %w", err)
+		}
+		base.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if len(c.Headers) == 0 && len(c.Query) == 0 {
+		return base, nil
+	}
+
+	auth := &authRoundTripper{headers: http.Header{}, query: url.Values{}, next: base}
+	for _, header := range c.Headers {
+		value, err := resolveSecret(header.Source)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", header.Name, err)
+		}
+		auth.headers.Set(header.Name, value)
+	}
+	for _, param := range c.Query {
+		value, err := resolveSecret(param.Source)
+		if err != nil {
+			return nil, fmt.Errorf("query %s: %w", param.Name, err)
+		}
+		auth.query.Set(param.Name, value)
+	}
+
+	return auth, nil
+}
+
+// authRoundTripper adds secret headers and query parameters to requests.
+// It sits below the logging round tripper, so secrets never show up in
+// request logs.
+type authRoundTripper struct {
+	headers http.Header
+	query   url.Values
+	next    http.RoundTripper
+}
+
+func (t *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+
+	for name, values := range t.headers {
+		req.Header[name] = values
+	}
+
+	if len(t.query) > 0 {
+		query := req.URL.Query()
+		for name, values := range t.query {
+			query[name] = values
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,169 @@
+package rpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseEndpointConfig(t *testing.T) {
+	cfg, err := ParseEndpointConfig("https://rpc.example;header=Authorization:env:RPC_TOKEN;query=apiKey:file:/run/secrets/key;ca-file=/ca.pem;cert-file=/c.pem;key-file=/k.pem;proxy=http://proxy:3128")
+	require.NoError(t, err)
+	require.Equal(t, &EndpointConfig{
+		URL:      "https://rpc.example",
+		Headers:  []SecretParam{{Name: "Authorization", Source: "env:RPC_TOKEN"}},
+		Query:    []SecretParam{{Name: "apiKey", Source: "file:/run/secrets/key"}},
+		CAFile:   "/ca.pem",
+		CertFile: "/c.pem",
+		KeyFile:  "/k.pem",
+		Proxy:    "http://proxy:3128",
+	}, cfg)
+
+	cfg, err = ParseEndpointConfig("https://rpc.example")
+	require.NoError(t, err)
+	require.Equal(t, &EndpointConfig{URL: "https://rpc.example"}, cfg)
+
+	for spec, expectedErr := range map[string]string{
+		";ca-file=/ca.pem":                        "missing url",
+		"https://rpc.example;header=X-Key:secret": "must come from env:<VARIABLE> or file:<path>",
+		"https://rpc.example;header=:env:KEY":     "expected <name>:env:<VARIABLE>",
+		"https://rpc.example;cert-file=/c.pem":    "cert-file and key-file must be set together",
+		"https://rpc.example;timeout=5s":          "unknown endpoint option",
+		"https://rpc.example;ca-file":             "expected <option>=<value>",
+	} {
+		_, err := ParseEndpointConfig(spec)
+		require.ErrorContains(t, err, expectedErr, spec)
+	}
+}
+
+func Test_NewClientFromConfig_Secrets(t *testing.T) {
+	var seen *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{"sequence":1000}}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("TEST_RPC_TOKEN", "Bearer s3cret")
+	keyFile := filepath.Join(t.TempDir(), "api-key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("k3y\n"), 0600))
+
+	cfg, err := ParseEndpointConfig(server.URL + "/rpc?network=mainnet;header=Authorization:env:TEST_RPC_TOKEN;query=apiKey:file:" + keyFile)
+	require.NoError(t, err)
+	c, err := NewClientFromConfig(cfg, testLog, testTracer)
+	require.NoError(t, err)
+
+	_, err = c.GetLatestLedger(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "Bearer s3cret", seen.Header.Get("Authorization"))
+	require.Equal(t, "k3y", seen.URL.Query().Get("apiKey"))
+	require.Equal(t, "mainnet", seen.URL.Query().Get("network"))
+	require.NotContains(t, c.Endpoint(), "k3y")
+
+	cfg, err = ParseEndpointConfig(server.URL + ";header=Authorization:env:TEST_RPC_TOKEN_UNSET")
+	require.NoError(t, err)
+	_, err = NewClientFromConfig(cfg, testLog, testTracer)
+	require.ErrorContains(t, err, "environment variable TEST_RPC_TOKEN_UNSET is not set")
+}
+
+func Test_NewClientFromConfig_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCertFile, clientKeyFile, clientCert := writeSelfSignedCert(t, dir, "client")
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{"sequence":1000}}`))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "server-ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	newClient := func(spec string) *Client {
+		cfg, err := ParseEndpointConfig(spec)
+		require.NoError(t, err)
+		c, err := NewClientFromConfig(cfg, testLog, testTracer)
+		require.NoError(t, err)
+		c.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+		return c
+	}
+
+	_, err := newClient(server.URL).GetLatestLedger(context.Background())
+	require.Error(t, err, "server certificate must not be trusted without ca-file")
+	require.False(t, IsRetryable(err))
+
+	_, err = newClient(server.URL + ";ca-file=" + caFile).GetLatestLedger(context.Background())
+	require.Error(t, err, "server must reject clients without a certificate")
+	require.False(t, IsRetryable(err))
+
+	ledger, err := newClient(server.URL + ";ca-file=" + caFile + ";cert-file=" + clientCertFile + ";key-file=" + clientKeyFile).GetLatestLedger(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1000, ledger.Sequence)
+}
+
+func Test_NewClientFromConfig_Proxy(t *testing.T) {
+	var proxiedHost string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedHost = r.URL.Host
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{"sequence":1000}}`))
+	}))
+	defer proxy.Close()
+
+	cfg, err := ParseEndpointConfig("http://rpc.internal.example;proxy=" + proxy.URL)
+	require.NoError(t, err)
+	c, err := NewClientFromConfig(cfg, testLog, testTracer)
+	require.NoError(t, err)
+
+	_, err = c.GetLatestLedger(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "rpc.internal.example", proxiedHost)
+}
+
+func writeSelfSignedCert(t *testing.T, dir, name string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+"-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certFile, keyFile, cert
+}
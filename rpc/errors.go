@@ -0,0 +1,168 @@
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/streamingfast/firehose-stellar/types"
+)
+
+// RetryableError is a failure that may go away by itself: the endpoint
+// is overloaded, rate limiting us, briefly unreachable or returned a
+// truncated body. RetryAfter is the delay the endpoint asked for, if any.
+type RetryableError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryableError) Error() string { return e.Err.Error() }
+func (e *RetryableError) Unwrap() error { return e.Err }
+
+// FatalError is a failure retrying the same request will not fix, like
+// invalid params or a response that does not match the expected schema.
+type FatalError struct {
+	Err error
+}
+
+func (e *FatalError) Error() string { return e.Err.Error() }
+func (e *FatalError) Unwrap() error { return e.Err }
+
+// IsRetryable reports whether err, or an error it wraps, is a
+// *RetryableError.
+func IsRetryable(err error) bool {
+	var retryable *RetryableError
+	return errors.As(err, &retryable)
+}
+
+// HTTPStatusError is a non 2xx HTTP response.
+type HTTPStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("http status %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// ErrCircuitOpen is returned, wrapped in a *RetryableError, while the
+// circuit breaker of an endpoint is open. Client does not retry it in
+// place: callers with other endpoints should move to one of them.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// JSON-RPC 2.0 reserves -32000 to -32099 for implementation defined
+// server errors.
+const (
+	rpcCodeInternalError  = -32603
+	rpcCodeServerErrorMin = -32099
+	rpcCodeServerErrorMax = -32000
+)
+
+// classifyTransportError wraps an error returned by http.Client.Do.
+// Context cancellation is returned as is, the caller gave up.
+func classifyTransportError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	// TLS misconfigurations surface as transport errors too, but no
+	// amount of retrying fixes an untrusted or rejected certificate.
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &certErr) || errors.As(err, &unknownAuthority) || errors.As(err, &hostnameErr) {
+		return &FatalError{Err: err}
+	}
+	// crypto/tls reports alerts sent by the server, like a rejected
+	// client certificate, as a "remote error" net.OpError.
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "remote error" {
+		return &FatalError{Err: err}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &RetryableError{Err: err}
+	}
+	// Anything else failed before reaching the network, e.g. a malformed
+	// endpoint url.
+	return &FatalError{Err: err}
+}
+
+// classifyHTTPStatus returns nil for 2xx responses.
+func classifyHTTPStatus(resp *http.Response, body []byte) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err := &HTTPStatusError{StatusCode: resp.StatusCode, Body: truncate(body, maxBodyInError)}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusServiceUnavailable:
+		return &RetryableError{Err: err, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode >= 500:
+		return &RetryableError{Err: err}
+	default:
+		return &FatalError{Err: err}
+	}
+}
+
+// classifyResponseBody checks the JSON-RPC envelope of a 2xx response.
+// A body that is not even valid JSON was most likely truncated on the
+// way, an error object is classified by its code.
+func classifyResponseBody(body []byte) error {
+	var envelope struct {
+		Error *types.RPCError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return &RetryableError{Err: fmt.Errorf("invalid JSON-RPC response %q: %w", truncate(body, maxBodyInError), err)}
+	}
+
+	if envelope.Error == nil {
+		return nil
+	}
+	if isRetryableRPCCode(envelope.Error.Code) {
+		return &RetryableError{Err: envelope.Error}
+	}
+	return &FatalError{Err: envelope.Error}
+}
+
+func isRetryableRPCCode(code int) bool {
+	return code == rpcCodeInternalError || (code >= rpcCodeServerErrorMin && code <= rpcCodeServerErrorMax)
+}
+
+// parseRetryAfter reads a Retry-After header, either delay seconds or an
+// HTTP date. It returns 0 when the header is absent or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// maxBodyInError bounds how much of a response body ends up in errors.
+const maxBodyInError = 512
+
+func truncate(body []byte, max int) string {
+	if len(body) > max {
+		return string(body[:max]) + "..."
+	}
+	return string(body)
+}
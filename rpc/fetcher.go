@@ -8,13 +8,19 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/stellar/go-stellar-sdk/ingest"
 	"github.com/stellar/go-stellar-sdk/xdr"
 	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
 	"github.com/streamingfast/firehose-stellar/decoder"
+	"github.com/streamingfast/firehose-stellar/metrics"
 	pbstellar "github.com/streamingfast/firehose-stellar/pb/sf/stellar/type/v1"
+	"github.com/streamingfast/firehose-stellar/protocol"
+	"github.com/streamingfast/firehose-stellar/toid"
+	"github.com/streamingfast/firehose-stellar/tracing"
 	"github.com/streamingfast/firehose-stellar/types"
 	"github.com/streamingfast/firehose-stellar/utils"
 	"go.uber.org/zap"
@@ -41,14 +47,29 @@ type Fetcher struct {
 	logger            *zap.Logger
 	networkPassphrase string
 
-	// Statistics
-	acquisitionTimes      []time.Duration
-	conversionTimes       []time.Duration
-	totalTimes            []time.Duration
-	interCallDelays       []time.Duration
-	lastFetchStart        time.Time
-	blocksFetchedInPeriod int
-	statsTicker           *time.Ticker
+	// endpointPool, when set, tracks per-endpoint statistics and decides
+	// which client getLedgers is routed to.
+	endpointPool *EndpointPool
+
+	// client is the one handed to the latest Fetch call, used by
+	// read-ahead which runs outside of any Fetch call.
+	client     atomic.Pointer[Client]
+	prefetcher *ledgerPrefetcher
+
+	// polledClient is the client waitForLedger last polled, and
+	// polledHead the head it reported, see knownHead.
+	polledClient *Client
+	polledHead   uint64
+
+	protocol *protocol.Guard
+	ledgers  *tracing.Ledgers
+
+	lastFetchStart time.Time
+	stats          fetchStats
+
+	closeOnce sync.Once
+	done      chan struct{}
+	stopped   sync.WaitGroup
 }
 
 // NewFetcher constructs an rpc fetcher. networkPassphrase MUST match the
@@ -65,80 +86,123 @@ func NewFetcher(fetchInterval, latestBlockRetryInterval time.Duration, transacti
 		transactionFetchLimit:    transactionFetchLimit,
 		logger:                   logger,
 		networkPassphrase:        networkPassphrase,
-		acquisitionTimes:         make([]time.Duration, 0, 50),
-		conversionTimes:          make([]time.Duration, 0, 50),
-		totalTimes:               make([]time.Duration, 0, 50),
-		blocksFetchedInPeriod:    0,
-		statsTicker:              time.NewTicker(10 * time.Second),
+		protocol:                 protocol.NewGuard(MetricsBackend, false, logger),
+		ledgers:                  tracing.NewLedgers(MetricsBackend),
+		done:                     make(chan struct{}),
 	}
 
-	// Start statistics logging goroutine
-	go f.logStatistics()
+	f.prefetcher = newLedgerPrefetcher(1, 0, f.fetchLedgerPage, logger)
+
+	f.stopped.Add(1)
+	go f.logStatistics(statisticsInterval)
 
 	return f
 }
 
-func (f *Fetcher) Fetch(ctx context.Context, client *Client, requestBlockNum uint64) (b *pbbstream.Block, skipped bool, err error) {
-	fetchStart := time.Now()
-	var interCallDelay time.Duration
-	if !f.lastFetchStart.IsZero() {
-		interCallDelay = fetchStart.Sub(f.lastFetchStart)
-	}
-	f.lastFetchStart = fetchStart
-	sleepDuration := time.Duration(0)
-	for f.lastBlockInfo.blockNum < requestBlockNum {
-		time.Sleep(sleepDuration)
+// Close stops the statistics logging and any ledger read-ahead in
+// flight. The fetcher must not be used afterwards.
+func (f *Fetcher) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.done)
+		f.stopped.Wait()
+		f.prefetcher.Close()
+	})
+	return nil
+}
+
+// SetEndpointPool makes the fetcher record per-endpoint statistics in
+// pool and route getLedgers to the client pool.Pick returns instead of
+// the one handed to Fetch.
+func (f *Fetcher) SetEndpointPool(pool *EndpointPool) {
+	f.endpointPool = pool
+}
+
+// SetLedgerPrefetch makes the fetcher request pageSize ledgers per
+// getLedgers call and keep up to depth ledgers buffered ahead of the one
+// being converted. The defaults, 1 and 0, fetch one ledger at a time.
+func (f *Fetcher) SetLedgerPrefetch(pageSize, depth int) {
+	f.prefetcher.Close()
+	f.prefetcher = newLedgerPrefetcher(pageSize, depth, f.fetchLedgerPage, f.logger)
+}
 
-		latestLedger, err := client.GetLatestLedger(ctx)
+// fetchLedgerPage is the getLedgers call behind the prefetcher. It goes
+// to the client of the latest Fetch call, or the one the endpoint pool
+// picks.
+func (f *Fetcher) fetchLedgerPage(ctx context.Context, startLedger uint64, pageSize int, cursor string) (*types.GetLedgersResult, error) {
+	client := f.client.Load()
+	if client == nil {
+		return nil, errors.New("no rpc client to fetch ledgers from")
+	}
+	if f.endpointPool != nil {
+		picked, err := f.endpointPool.Pick(client, startLedger)
 		if err != nil {
-			return nil, false, fmt.Errorf("fetching latest block num: %w", err)
+			return nil, fmt.Errorf("selecting rpc endpoint: %w", err)
 		}
+		client = picked
+	}
 
-		f.lastBlockInfo.blockNum = uint64(latestLedger.Sequence)
-		f.logger.Info("got latest block num", zap.Uint64("latest_block_num", f.lastBlockInfo.blockNum), zap.Uint64("requested_block_num", requestBlockNum), zap.Bool("keep", false))
+	start := time.Now()
+	result, err := client.GetLedgers(ctx, startLedger, pageSize, cursor)
+	if f.endpointPool != nil {
+		f.endpointPool.Observe(client, time.Since(start), err)
 
-		if f.lastBlockInfo.blockNum >= requestBlockNum {
-			break
+		var outOfRange *LedgerOutOfRangeError
+		switch {
+		case err == nil:
+			f.endpointPool.ObserveWindow(client, result.OldestLedger, result.LatestLedger)
+		case errors.As(err, &outOfRange):
+			f.endpointPool.ObserveWindow(client, outOfRange.OldestLedger, outOfRange.LatestLedger)
 		}
-		sleepDuration = f.latestBlockRetryInterval
 	}
-
-	ledgerStart := time.Now()
-	ledger, err := client.GetLedgers(ctx, requestBlockNum)
 	if err != nil {
-		return nil, false, fmt.Errorf("fetching ledger: %w", err)
+		return nil, fmt.Errorf("fetching ledgers from %s: %w", client.Endpoint(), err)
 	}
-	acquisitionEnd := time.Now()
-	acquisitionTime := acquisitionEnd.Sub(ledgerStart)
 
-	if len(ledger) == 0 {
-		return nil, false, fmt.Errorf("ledger not found %d", requestBlockNum)
+	if cursor == "" && (startLedger < result.OldestLedger || startLedger > result.LatestLedger) {
+		return nil, &LedgerOutOfRangeError{
+			Endpoint:     client.Endpoint(),
+			Ledger:       startLedger,
+			OldestLedger: result.OldestLedger,
+			LatestLedger: result.LatestLedger,
+		}
 	}
 
-	if len(ledger) > 1 {
-		return nil, false, fmt.Errorf("multiple ledgers found for block %d", requestBlockNum)
+	return result, nil
+}
+
+// Fetch returns ledger requestBlockNum from client converted to a
+// block, for the tools and tests that need a single block. The fetch
+// loop goes through GetLedgerCloseMeta instead. The ledger is traced
+// from the wait to the conversion, and its trace ended on return.
+func (f *Fetcher) Fetch(ctx context.Context, client *Client, requestBlockNum uint64) (b *pbbstream.Block, skipped bool, err error) {
+	fetchStart := time.Now()
+	interCallDelay := f.startFetch(client)
+
+	ctx = f.ledgers.Start(ctx, requestBlockNum)
+	defer func() { f.ledgers.End(requestBlockNum, err) }()
+
+	ledger, acquisitionTime, err := f.fetchLedger(ctx, client, requestBlockNum)
+	if err != nil {
+		return nil, false, err
 	}
+	acquisitionEnd := time.Now()
 
-	ledgerTime, err := strconv.ParseInt(ledger[0].LedgerCloseTime, 10, 64)
+	convertCtx, convertSpan := tracing.Start(ctx, tracing.SpanConvert, requestBlockNum)
+	defer func() { tracing.End(convertSpan, err) }()
+
+	ledgerTime, err := strconv.ParseInt(ledger.LedgerCloseTime, 10, 64)
 	if err != nil {
 		return nil, false, fmt.Errorf("parsing ledger time: %w", err)
 	}
 
-	ledgerMetadata, err := f.decoder.DecodeLedgerMetadata(ledger[0].MetadataXdr)
+	ledgerMetadata, err := f.decoder.DecodeLedgerMetadata(ledger.MetadataXdr)
 	if err != nil {
 		return nil, false, fmt.Errorf("decoding ledger metadata: %w", err)
 	}
 
-	var ledgerHeader xdr.LedgerHeaderHistoryEntry
-	switch {
-	case ledgerMetadata.V0 != nil:
-		ledgerHeader = ledgerMetadata.V0.LedgerHeader
-	case ledgerMetadata.V2 != nil:
-		ledgerHeader = ledgerMetadata.V2.LedgerHeader
-	case ledgerMetadata.V1 != nil:
-		ledgerHeader = ledgerMetadata.V1.LedgerHeader
-	default:
-		return nil, false, fmt.Errorf("ledger metadata does not contain V1 or V2 data: %v", ledgerMetadata)
+	ledgerHeader, err := f.protocol.Check(ledgerMetadata)
+	if err != nil {
+		return nil, false, err
 	}
 
 	// Extract transactions directly from ledger metadata (no fallback)
@@ -146,6 +210,7 @@ func (f *Fetcher) Fetch(ctx context.Context, client *Client, requestBlockNum uin
 	if err != nil {
 		return nil, false, fmt.Errorf("extracting transactions from ledger metadata: %w", err)
 	}
+	tracing.SetTransactionCount(convertCtx, f.ledgers.Span(requestBlockNum), len(transactions))
 
 	transactionMetas := make([]*types.TransactionMeta, 0)
 	for _, trx := range transactions {
@@ -212,11 +277,17 @@ func (f *Fetcher) Fetch(ctx context.Context, client *Client, requestBlockNum uin
 
 	stellarTransactions := make([]*pbstellar.Transaction, 0)
 	for i, trx := range transactionMetas {
+		id, err := toid.Transaction(uint32(ledger.Sequence), uint32(i+1))
+		if err != nil {
+			return nil, false, fmt.Errorf("transaction %x id: %w", trx.Hash, err)
+		}
+
 		stellarTransactions = append(stellarTransactions, &pbstellar.Transaction{
 			Hash:             trx.Hash,
 			Status:           utils.ConvertTransactionStatus(trx.Status),
 			CreatedAt:        timestamppb.New(time.Unix(ledgerTime, 0)),
 			ApplicationOrder: uint64(i + 1),
+			Toid:             id.Uint64(),
 			EnvelopeXdr:      trx.EnveloppeXdr,
 			ResultXdr:        trx.ResultXdr,
 			Events:           trx.Events,
@@ -230,7 +301,7 @@ func (f *Fetcher) Fetch(ctx context.Context, client *Client, requestBlockNum uin
 
 	// stellar-rpc returns ledger.hash as a hex string; previous_ledger_hash on
 	// the LedgerHeader is already raw 32 bytes.
-	ledgerHashBytes, err := hex.DecodeString(ledger[0].Hash)
+	ledgerHashBytes, err := hex.DecodeString(ledger.Hash)
 	if err != nil {
 		return nil, false, fmt.Errorf("decoding ledger hash: %w", err)
 	}
@@ -238,7 +309,7 @@ func (f *Fetcher) Fetch(ctx context.Context, client *Client, requestBlockNum uin
 	previousLedgerHashBytes := ledgerHeader.Header.PreviousLedgerHash[:]
 
 	stellarBlk := &pbstellar.Block{
-		Number: ledger[0].Sequence,
+		Number: ledger.Sequence,
 		Hash:   ledgerHashBytes,
 		Header: &pbstellar.Header{
 			LedgerVersion:      uint32(ledgerHeader.Header.LedgerVersion),
@@ -260,58 +331,180 @@ func (f *Fetcher) Fetch(ctx context.Context, client *Client, requestBlockNum uin
 	// reset the cursor
 	f.lastBlockInfo.cursor = ""
 
-	// Update statistics
 	conversionTime := time.Since(acquisitionEnd)
-	totalTime := time.Since(fetchStart)
-	f.updateStatistics(acquisitionTime, conversionTime, totalTime, interCallDelay)
+	metrics.FetchDuration.WithLabelValues(MetricsBackend).Observe(acquisitionTime.Seconds())
+	metrics.ConvertDuration.WithLabelValues(MetricsBackend).Observe(conversionTime.Seconds())
+	f.stats.record(acquisitionTime, conversionTime, time.Since(fetchStart), interCallDelay)
 
 	return bstreamBlock, false, nil
 }
 
-func (f *Fetcher) logStatistics() {
-	for range f.statsTicker.C {
-		f.logger.Info("block fetch statistics",
-			zap.Int("blocks_fetched_in_period", f.blocksFetchedInPeriod),
-			zap.Duration("avg_acquisition_time", f.averageDuration(f.acquisitionTimes)),
-			zap.Duration("avg_conversion_time", f.averageDuration(f.conversionTimes)),
-			zap.Duration("avg_total_time", f.averageDuration(f.totalTimes)),
-			zap.Duration("avg_inter_call_delay", f.averageDuration(f.interCallDelays)),
-		)
-		f.blocksFetchedInPeriod = 0
+// GetLedgerCloseMeta returns ledger requestBlockNum as client serves it,
+// undecoded beyond its LedgerCloseMeta, for the shared fetch loop (see
+// LedgerSource). Waiting for and getting it are traced as children of
+// the span in ctx.
+func (f *Fetcher) GetLedgerCloseMeta(ctx context.Context, client *Client, requestBlockNum uint64) (xdr.LedgerCloseMeta, error) {
+	fetchStart := time.Now()
+	interCallDelay := f.startFetch(client)
+
+	ledger, acquisitionTime, err := f.fetchLedger(ctx, client, requestBlockNum)
+	if err != nil {
+		return xdr.LedgerCloseMeta{}, err
+	}
+
+	ledgerMetadata, err := f.decoder.DecodeLedgerMetadata(ledger.MetadataXdr)
+	if err != nil {
+		return xdr.LedgerCloseMeta{}, fmt.Errorf("decoding ledger metadata: %w", err)
 	}
+
+	// The conversion is timed by the caller.
+	metrics.FetchDuration.WithLabelValues(MetricsBackend).Observe(acquisitionTime.Seconds())
+	f.stats.record(acquisitionTime, 0, time.Since(fetchStart), interCallDelay)
+
+	return *ledgerMetadata, nil
 }
 
-func (f *Fetcher) updateStatistics(acquisitionTime, conversionTime, totalTime, interCallDelay time.Duration) {
-	f.acquisitionTimes = append(f.acquisitionTimes, acquisitionTime)
-	if len(f.acquisitionTimes) > 50 {
-		f.acquisitionTimes = f.acquisitionTimes[1:]
+// startFetch records the start of a Fetch or GetLedgerCloseMeta call
+// served by client and returns the delay since the previous one.
+func (f *Fetcher) startFetch(client *Client) time.Duration {
+	now := time.Now()
+	var interCallDelay time.Duration
+	if !f.lastFetchStart.IsZero() {
+		interCallDelay = now.Sub(f.lastFetchStart)
 	}
-	f.conversionTimes = append(f.conversionTimes, conversionTime)
-	if len(f.conversionTimes) > 50 {
-		f.conversionTimes = f.conversionTimes[1:]
+	f.lastFetchStart = now
+	f.client.Store(client)
+	return interCallDelay
+}
+
+// fetchLedger waits for requestBlockNum to be on client and gets it,
+// from the read-ahead buffer when it is there. It returns the time
+// spent getting it, waiting excluded.
+func (f *Fetcher) fetchLedger(ctx context.Context, client *Client, requestBlockNum uint64) (types.Ledger, time.Duration, error) {
+	// A buffered ledger obviously exists, no need to ask for the head.
+	if !f.prefetcher.Has(requestBlockNum) {
+		if err := f.waitForLedger(ctx, client, requestBlockNum, 0); err != nil {
+			return types.Ledger{}, 0, err
+		}
 	}
-	f.totalTimes = append(f.totalTimes, totalTime)
-	if len(f.totalTimes) > 50 {
-		f.totalTimes = f.totalTimes[1:]
+
+	ledgerStart := time.Now()
+	getCtx, getSpan := tracing.Start(ctx, tracing.SpanGetLedger, requestBlockNum)
+	ledger, err := f.prefetcher.Get(getCtx, requestBlockNum)
+	tracing.End(getSpan, err)
+	acquisitionTime := time.Since(ledgerStart)
+	if err != nil {
+		if errors.Is(err, errLedgerNotInPage) {
+			return types.Ledger{}, 0, fmt.Errorf("ledger not found %d", requestBlockNum)
+		}
+		return types.Ledger{}, 0, err
 	}
-	if interCallDelay > 0 {
-		f.interCallDelays = append(f.interCallDelays, interCallDelay)
-		if len(f.interCallDelays) > 50 {
-			f.interCallDelays = f.interCallDelays[1:]
+	if ledger.Sequence > f.lastBlockInfo.blockNum {
+		f.lastBlockInfo.blockNum = ledger.Sequence
+	}
+	return ledger, acquisitionTime, nil
+}
+
+// waitForLedger polls the head of client every latestBlockRetryInterval
+// until it reaches ledger. With an endpoint pool, the client polled is
+// the one the pool picks for getLedgers. The wait is skipped when the
+// known head of that client is already there, see knownHead: the heads
+// of other endpoints tell nothing of what it serves. pollTimeout, when
+// positive, bounds each poll but not the wait: at the head, ledgers
+// close every 5 seconds or so.
+func (f *Fetcher) waitForLedger(ctx context.Context, client *Client, ledger uint64, pollTimeout time.Duration) (err error) {
+	if f.endpointPool != nil {
+		picked, err := f.endpointPool.Pick(client, ledger)
+		if err != nil {
+			return fmt.Errorf("selecting rpc endpoint: %w", err)
+		}
+		client = picked
+	}
+	if f.knownHead(client) >= ledger {
+		return nil
+	}
+
+	_, waitSpan := tracing.Start(ctx, tracing.SpanWaitForLedger, ledger)
+	defer func() { tracing.End(waitSpan, err) }()
+
+	for {
+		latestLedger, err := f.getLatestLedger(ctx, client, pollTimeout)
+		if err != nil {
+			if f.endpointPool != nil {
+				f.endpointPool.Observe(client, 0, err)
+			}
+			return fmt.Errorf("fetching latest block num: %w", err)
+		}
+		head := uint64(latestLedger.Sequence)
+		if f.endpointPool != nil {
+			f.endpointPool.ObserveHead(client, head)
+		}
+		f.polledClient, f.polledHead = client, head
+
+		if head > f.lastBlockInfo.blockNum {
+			f.lastBlockInfo.blockNum = head
+		}
+		f.prefetcher.ObserveHead(head)
+		f.logger.Info("got latest block num", zap.String("endpoint", client.Endpoint()), zap.Uint64("latest_block_num", head), zap.Uint64("requested_block_num", ledger), zap.Bool("keep", false))
+
+		if head >= ledger {
+			return nil
+		}
+
+		timer := time.NewTimer(f.latestBlockRetryInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
 		}
 	}
-	f.blocksFetchedInPeriod++
 }
 
-func (f *Fetcher) averageDuration(durations []time.Duration) time.Duration {
-	if len(durations) == 0 {
-		return 0
+// knownHead returns the latest ledger client is known to have: the one
+// the endpoint pool last heard of or, without a pool, the one of the
+// latest poll when it was client's. 0 means unknown.
+func (f *Fetcher) knownHead(client *Client) uint64 {
+	if f.endpointPool != nil {
+		return f.endpointPool.Head(client)
 	}
-	var sum time.Duration
-	for _, d := range durations {
-		sum += d
+	if f.polledClient == client {
+		return f.polledHead
+	}
+	return 0
+}
+
+func (f *Fetcher) getLatestLedger(ctx context.Context, client *Client, timeout time.Duration) (*types.GetLatestLedgerResult, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return client.GetLatestLedger(ctx)
+}
+
+func (f *Fetcher) logStatistics(interval time.Duration) {
+	defer f.stopped.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+		}
+
+		fields := f.stats.flush()
+		if f.endpointPool != nil {
+			fields = append(fields,
+				zap.String("endpoint_selection_strategy", string(f.endpointPool.Strategy())),
+				zap.Array("endpoints", endpointStatsList(f.endpointPool.Stats())),
+			)
+		}
+		f.logger.Info("block fetch statistics", fields...)
 	}
-	return sum / time.Duration(len(durations))
 }
 
 func (f *Fetcher) extractTransactionsFromLedgerMetadata(ledgerMetadata *xdr.LedgerCloseMeta) ([]types.Transaction, error) {
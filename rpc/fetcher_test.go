@@ -9,7 +9,12 @@ import (
 	"time"
 
 	pbstellar "github.com/streamingfast/firehose-stellar/pb/sf/stellar/type/v1"
+	"github.com/streamingfast/firehose-stellar/tracing"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func Test_convertBlock_HexEncodesIDs(t *testing.T) {
@@ -53,6 +58,7 @@ func Test_Fetch(t *testing.T) {
 	require.NoError(t, err)
 
 	f := NewFetcher(time.Second, time.Second, 200, passphraseFor(c.rpcEndpoint), testLog)
+	defer f.Close()
 	b, _, err := f.Fetch(context.Background(), c, uint64(ledger.Sequence))
 
 	require.NoError(t, err)
@@ -67,6 +73,7 @@ func Test_FetchSpecificLedger(t *testing.T) {
 
 	c := NewClient(RPC_MAINNET_ENDPOINT, testLog, testTracer)
 	f := NewFetcher(time.Second, time.Second, 200, passphraseFor(c.rpcEndpoint), testLog)
+	defer f.Close()
 	b, _, err := f.Fetch(context.Background(), c, BLOCK_TO_FETCH)
 	require.NoError(t, err)
 
@@ -86,6 +93,7 @@ func Test_FetchSpecificLedger_Testnet(t *testing.T) {
 
 	c := NewClient(RPC_TESTNET_ENDPOINT, testLog, testTracer)
 	f := NewFetcher(time.Second, time.Second, 200, passphraseFor(c.rpcEndpoint), testLog)
+	defer f.Close()
 	b, _, err := f.Fetch(context.Background(), c, BLOCK_TO_FETCH)
 	require.NoError(t, err)
 
@@ -105,6 +113,7 @@ func Test_FetchSpecificLedger_ProtocolUpgrade23_MetadataV2(t *testing.T) {
 
 	c := NewClient(RPC_TESTNET_ENDPOINT, testLog, testTracer)
 	f := NewFetcher(time.Second, time.Second, 200, passphraseFor(c.rpcEndpoint), testLog)
+	defer f.Close()
 	b, _, err := f.Fetch(context.Background(), c, BLOCK_TO_FETCH)
 	require.NoError(t, err)
 
@@ -117,3 +126,48 @@ func Test_FetchSpecificLedger_ProtocolUpgrade23_MetadataV2(t *testing.T) {
 	require.Equal(t, uint32(23), stellarBlock.Header.LedgerVersion)
 	require.Equal(t, 3, len(stellarBlock.Transactions))
 }
+
+func Test_Fetcher_Close(t *testing.T) {
+	f := NewFetcher(time.Second, time.Second, 200, "", testLog)
+	f.SetLedgerPrefetch(5, 10)
+
+	require.NoError(t, f.Close())
+	require.NoError(t, f.Close(), "Close must be idempotent")
+
+	select {
+	case <-f.done:
+	default:
+		t.Fatal("statistics logging still running")
+	}
+}
+
+func Test_Fetch_Traces(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	// The fixture metadata is a placeholder, so conversion fails: the
+	// ledger trace must end all the same, marked failed.
+	c := newFixtureServer(t, "testdata/compat/handwritten-v23")
+	f := NewFetcher(time.Second, time.Millisecond, 200, "", testLog)
+	defer f.Close()
+
+	_, _, err := f.Fetch(context.Background(), c, 58000050)
+	require.Error(t, err)
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+	}
+	require.Equal(t, []string{tracing.SpanWaitForLedger, tracing.SpanGetLedger, tracing.SpanConvert, tracing.SpanLedger}, names)
+
+	ended := recorder.Ended()
+	root := ended[3]
+	require.Equal(t, codes.Error, root.Status().Code)
+	require.Equal(t, codes.Error, ended[2].Status().Code)
+	for _, span := range ended[:3] {
+		require.Equal(t, root.SpanContext().SpanID(), span.Parent().SpanID())
+	}
+	require.False(t, f.ledgers.Span(58000050).SpanContext().IsValid())
+}
@@ -0,0 +1,79 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/streamingfast/firehose-stellar/types"
+)
+
+// call sends a JSON-RPC request for method and decodes its result into
+// R.
+func call[R any](ctx context.Context, c *Client, method string, params any) (*R, error) {
+	rpcBody, err := json.Marshal(types.NewRequest(method, params))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+
+	body, err := c.makeRequest(ctx, rpcBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %s: %w", method, err)
+	}
+
+	var response types.Response[R]
+	if err := c.decodeResponse(method, body, &response); err != nil {
+		return nil, err
+	}
+
+	return &response.Result, nil
+}
+
+// GetNetwork returns the network passphrase and protocol version the
+// endpoint serves.
+func (c *Client) GetNetwork(ctx context.Context) (*types.GetNetworkResult, error) {
+	return call[types.GetNetworkResult](ctx, c, "getNetwork", nil)
+}
+
+// GetHealth returns the endpoint health along with its retention window.
+func (c *Client) GetHealth(ctx context.Context) (*types.GetHealthResult, error) {
+	return call[types.GetHealthResult](ctx, c, "getHealth", nil)
+}
+
+// GetVersionInfo returns the stellar-rpc and captive-core versions the
+// endpoint runs.
+func (c *Client) GetVersionInfo(ctx context.Context) (*types.GetVersionInfoResult, error) {
+	return call[types.GetVersionInfoResult](ctx, c, "getVersionInfo", nil)
+}
+
+// GetFeeStats returns the inclusion fee distribution of recent classic
+// and Soroban transactions.
+func (c *Client) GetFeeStats(ctx context.Context) (*types.GetFeeStatsResult, error) {
+	return call[types.GetFeeStatsResult](ctx, c, "getFeeStats", nil)
+}
+
+// GetEvents returns one page of contract and system events matching
+// params, resume with the returned cursor to get the next one.
+func (c *Client) GetEvents(ctx context.Context, params types.GetEventsParams) (*types.GetEventsResult, error) {
+	return call[types.GetEventsResult](ctx, c, "getEvents", params)
+}
+
+// GetTransactionsPage returns one page of transactions starting at
+// startLedger, or right after cursor when it is set. Use GetTransactions
+// to get all the transactions of a single ledger.
+func (c *Client) GetTransactionsPage(ctx context.Context, startLedger uint64, limit int, cursor string) (*types.GetTransactionsResult, error) {
+	return call[types.GetTransactionsResult](ctx, c, "getTransactions", types.NewParams(startLedger, types.NewPagination(limit, cursor)))
+}
+
+// GetLedgerEntries returns the current value of the ledger entries for
+// the given base64 encoded xdr.LedgerKey values. Keys that do not exist
+// are absent from the result.
+func (c *Client) GetLedgerEntries(ctx context.Context, keys []string) (*types.GetLedgerEntriesResult, error) {
+	return call[types.GetLedgerEntriesResult](ctx, c, "getLedgerEntries", types.GetLedgerEntriesParams{Keys: keys})
+}
+
+// SimulateTransaction simulates a Soroban transaction. A failed
+// simulation is not an error, check the result's Error field.
+func (c *Client) SimulateTransaction(ctx context.Context, params types.SimulateTransactionParams) (*types.SimulateTransactionResult, error) {
+	return call[types.SimulateTransactionResult](ctx, c, "simulateTransaction", params)
+}
@@ -0,0 +1,162 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/streamingfast/firehose-stellar/types"
+	"github.com/stretchr/testify/require"
+)
+
+type recordedRequest struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// newStandInServer answers each JSON-RPC method with the raw JSON result
+// registered for it and records the last request it received.
+// Unregistered methods get a method not found error.
+func newStandInServer(t *testing.T, results map[string]string) (*Client, *recordedRequest) {
+	t.Helper()
+
+	last := &recordedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(last))
+
+		result, found := results[last.Method]
+		if !found {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"method not found"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":` + result + `}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return NewClient(server.URL, testLog, testTracer), last
+}
+
+func Test_GetNetwork(t *testing.T) {
+	c, last := newStandInServer(t, map[string]string{
+		"getNetwork": `{"friendbotUrl":"https://friendbot.stellar.org/","passphrase":"Test SDF Network ; September 2015","protocolVersion":23}`,
+	})
+
+	network, err := c.GetNetwork(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "Test SDF Network ; September 2015", network.Passphrase)
+	require.Equal(t, 23, network.ProtocolVersion)
+	require.Empty(t, last.Params)
+}
+
+func Test_GetHealth(t *testing.T) {
+	c, _ := newStandInServer(t, map[string]string{
+		"getHealth": `{"status":"healthy","latestLedger":2000,"oldestLedger":1000,"ledgerRetentionWindow":17280}`,
+	})
+
+	health, err := c.GetHealth(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, types.GetHealthResult{Status: "healthy", LatestLedger: 2000, OldestLedger: 1000, LedgerRetentionWindow: 17280}, *health)
+}
+
+func Test_GetVersionInfo(t *testing.T) {
+	c, _ := newStandInServer(t, map[string]string{
+		"getVersionInfo": `{"version":"23.0.0","commitHash":"abc","buildTimestamp":"2025-01-01T00:00:00","captiveCoreVersion":"stellar-core 23.0.0","protocolVersion":23,"someNewField":true}`,
+	})
+
+	version, err := c.GetVersionInfo(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "23.0.0", version.Version)
+	require.Equal(t, "stellar-core 23.0.0", version.CaptiveCoreVersion)
+}
+
+func Test_GetFeeStats(t *testing.T) {
+	c, _ := newStandInServer(t, map[string]string{
+		"getFeeStats": `{"sorobanInclusionFee":{"max":"210","min":"100","mode":"100","p50":"100","p99":"210","transactionCount":"12","ledgerCount":50},"inclusionFee":{"max":"1000","min":"100","mode":"100","p50":"100","transactionCount":"40","ledgerCount":10},"latestLedger":2000}`,
+	})
+
+	stats, err := c.GetFeeStats(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "210", stats.SorobanInclusionFee.Max)
+	require.Equal(t, uint32(50), stats.SorobanInclusionFee.LedgerCount)
+	require.Equal(t, "40", stats.InclusionFee.TransactionCount)
+	require.Equal(t, uint64(2000), stats.LatestLedger)
+}
+
+func Test_GetEvents(t *testing.T) {
+	c, last := newStandInServer(t, map[string]string{
+		"getEvents": `{"events":[{"type":"contract","ledger":1500,"ledgerClosedAt":"2025-01-01T00:00:00Z","contractId":"CA","id":"0006442450944000000-0000000001","operationIndex":0,"transactionIndex":2,"txHash":"ab","inSuccessfulContractCall":true,"topic":["AAAADwAAAAh0cmFuc2Zlcg=="],"value":"AAAAAQ=="}],"latestLedger":2000,"oldestLedger":1000,"cursor":"0006442450944000000-0000000001"}`,
+	})
+
+	result, err := c.GetEvents(context.Background(), types.GetEventsParams{
+		StartLedger: 1500,
+		Filters: []types.EventFilter{{
+			Type:        types.EventTypeContract,
+			ContractIDs: []string{"CA"},
+			Topics:      [][]string{{"AAAADwAAAAh0cmFuc2Zlcg==", "**"}},
+		}},
+		Pagination: types.NewPagination(10, ""),
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Events, 1)
+	require.Equal(t, uint32(2), result.Events[0].TransactionIndex)
+	require.Equal(t, "0006442450944000000-0000000001", result.Cursor)
+	require.JSONEq(t, `{"startLedger":1500,"filters":[{"type":"contract","contractIds":["CA"],"topics":[["AAAADwAAAAh0cmFuc2Zlcg==","**"]]}],"pagination":{"limit":10}}`, string(last.Params))
+
+	_, err = c.GetEvents(context.Background(), types.GetEventsParams{Pagination: types.NewPagination(10, result.Cursor)})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"pagination":{"limit":10,"cursor":"0006442450944000000-0000000001"}}`, string(last.Params))
+}
+
+func Test_GetTransactionsPage(t *testing.T) {
+	c, last := newStandInServer(t, map[string]string{
+		"getTransactions": `{"transactions":[{"status":"SUCCESS","txHash":"ab","applicationOrder":1,"feeBump":false,"envelopeXdr":"","resultXdr":"","ledger":1500,"createdAt":1700000000}],"latestLedger":2000,"oldestLedger":1000,"cursor":"6442450944001"}`,
+	})
+
+	result, err := c.GetTransactionsPage(context.Background(), 1500, 5, "")
+	require.NoError(t, err)
+	require.Len(t, result.Transactions, 1)
+	require.Equal(t, "6442450944001", result.Cursor)
+	require.JSONEq(t, `{"startLedger":1500,"pagination":{"limit":5}}`, string(last.Params))
+}
+
+func Test_GetLedgerEntries(t *testing.T) {
+	c, last := newStandInServer(t, map[string]string{
+		"getLedgerEntries": `{"entries":[{"key":"AAAAAA==","xdr":"AAAAAQ==","lastModifiedLedgerSeq":1400,"liveUntilLedgerSeq":3000}],"latestLedger":2000}`,
+	})
+
+	result, err := c.GetLedgerEntries(context.Background(), []string{"AAAAAA==", "AAAAAg=="})
+	require.NoError(t, err)
+	require.Len(t, result.Entries, 1)
+	require.Equal(t, uint32(1400), result.Entries[0].LastModifiedLedgerSeq)
+	require.NotNil(t, result.Entries[0].LiveUntilLedgerSeq)
+	require.Equal(t, uint32(3000), *result.Entries[0].LiveUntilLedgerSeq)
+	require.JSONEq(t, `{"keys":["AAAAAA==","AAAAAg=="]}`, string(last.Params))
+}
+
+func Test_SimulateTransaction(t *testing.T) {
+	c, last := newStandInServer(t, map[string]string{
+		"simulateTransaction": `{"latestLedger":2000,"minResourceFee":"5000","transactionData":"AAAA","results":[{"auth":[],"xdr":"AAAAAQ=="}],"events":["AAAA"],"stateChanges":[{"type":"updated","key":"AAAA","before":"AAAA","after":"AAAB"}]}`,
+	})
+
+	result, err := c.SimulateTransaction(context.Background(), types.SimulateTransactionParams{
+		Transaction:    "AAAAAgAAAAA=",
+		ResourceConfig: &types.ResourceConfig{InstructionLeeway: 1000},
+	})
+	require.NoError(t, err)
+	require.Empty(t, result.Error)
+	require.Equal(t, "5000", result.MinResourceFee)
+	require.Len(t, result.Results, 1)
+	require.Len(t, result.StateChanges, 1)
+	require.JSONEq(t, `{"transaction":"AAAAAgAAAAA=","resourceConfig":{"instructionLeeway":1000}}`, string(last.Params))
+}
+
+func Test_CallSurfacesRPCError(t *testing.T) {
+	c, _ := newStandInServer(t, map[string]string{})
+
+	_, err := c.GetHealth(context.Background())
+	var rpcErr *types.RPCError
+	require.ErrorAs(t, err, &rpcErr)
+	require.Equal(t, -32601, rpcErr.Code)
+}
@@ -0,0 +1,25 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/streamingfast/firehose-stellar/networkcheck"
+	"go.uber.org/zap"
+)
+
+// VerifyNetworkPassphrase asks every client for the network it serves
+// through getNetwork and fails when one of them serves another network
+// than expected. With an empty expected passphrase, it is discovered
+// instead: all endpoints must then agree on it. It returns the
+// passphrase and the clients that cannot be queried, whose network is
+// unknown: EndpointPool.RequireNetwork holds them back until it is read,
+// see networkcheck.Verify.
+func VerifyNetworkPassphrase(ctx context.Context, clients []*Client, expected string, logger *zap.Logger) (string, []*Client, error) {
+	return networkcheck.Verify(ctx, "rpc endpoint", clients, (*Client).Endpoint, func(ctx context.Context, client *Client) (string, error) {
+		network, err := client.GetNetwork(ctx)
+		if err != nil {
+			return "", err
+		}
+		return network.Passphrase, nil
+	}, expected, logger)
+}
@@ -0,0 +1,74 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stellar/go-stellar-sdk/network"
+	"github.com/stretchr/testify/require"
+)
+
+func newNetworkClient(t *testing.T, passphrase string) *Client {
+	t.Helper()
+
+	c, _ := newStandInServer(t, map[string]string{
+		"getNetwork": `{"passphrase":"` + passphrase + `","protocolVersion":23}`,
+	})
+	return c
+}
+
+func Test_VerifyNetworkPassphrase(t *testing.T) {
+	testnet := newNetworkClient(t, network.TestNetworkPassphrase)
+	pubnet := newNetworkClient(t, network.PublicNetworkPassphrase)
+	down, _ := newStandInServer(t, map[string]string{})
+
+	passphrase, unverified, err := VerifyNetworkPassphrase(context.Background(), []*Client{down, testnet}, network.TestNetworkPassphrase, testLog)
+	require.NoError(t, err)
+	require.Equal(t, network.TestNetworkPassphrase, passphrase)
+	require.Equal(t, []*Client{down}, unverified)
+
+	_, _, err = VerifyNetworkPassphrase(context.Background(), []*Client{testnet, pubnet}, network.TestNetworkPassphrase, testLog)
+	require.ErrorContains(t, err, pubnet.Endpoint()+` belongs to "`+network.PublicNetworkPassphrase+`"`)
+
+	_, _, err = VerifyNetworkPassphrase(context.Background(), []*Client{down}, network.TestNetworkPassphrase, testLog)
+	require.ErrorContains(t, err, "unable to read the network of any rpc endpoint")
+}
+
+func Test_VerifyNetworkPassphrase_Discovery(t *testing.T) {
+	custom := "Standalone Network ; February 2017"
+	a := newNetworkClient(t, custom)
+	b := newNetworkClient(t, custom)
+
+	passphrase, unverified, err := VerifyNetworkPassphrase(context.Background(), []*Client{a, b}, "", testLog)
+	require.NoError(t, err)
+	require.Equal(t, custom, passphrase)
+	require.Empty(t, unverified)
+
+	_, _, err = VerifyNetworkPassphrase(context.Background(), []*Client{a, newNetworkClient(t, network.TestNetworkPassphrase)}, "", testLog)
+	require.ErrorContains(t, err, "rpc endpoints belong to different networks")
+}
+
+func Test_EndpointPool_RequireNetwork(t *testing.T) {
+	primary := newNetworkClient(t, network.TestNetworkPassphrase)
+	backupResults := map[string]string{}
+	backup, _ := newStandInServer(t, backupResults)
+	pubnet := newNetworkClient(t, network.PublicNetworkPassphrase)
+
+	pool := NewEndpointPool(SelectionStrategySticky, []*Client{primary, backup, pubnet}, testLog)
+	pool.RequireNetwork(network.TestNetworkPassphrase, []*Client{backup, pubnet})
+
+	// Neither is used before its network is read.
+	requirePick(t, pool, primary, backup, 100)
+	requirePick(t, pool, primary, pubnet, 100)
+
+	// The backup is still down, the other one is on another network.
+	pool.RefreshHeads(context.Background())
+	requirePick(t, pool, primary, backup, 100)
+	requirePick(t, pool, primary, pubnet, 100)
+
+	// Back up, the backup is verified on the next refresh.
+	backupResults["getNetwork"] = `{"passphrase":"` + network.TestNetworkPassphrase + `","protocolVersion":23}`
+	pool.RefreshHeads(context.Background())
+	requirePick(t, pool, backup, backup, 100)
+	requirePick(t, pool, primary, pubnet, 100)
+}
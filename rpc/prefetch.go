@@ -0,0 +1,213 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/firehose-stellar/types"
+	"go.uber.org/zap"
+)
+
+// readAheadTimeout bounds a single background getLedgers page. Read-ahead
+// is not tied to any Fetch call, so it cannot borrow a caller context.
+const readAheadTimeout = 60 * time.Second
+
+// fetchPageFunc returns up to pageSize ledgers starting at startLedger,
+// or right after cursor when it is set.
+type fetchPageFunc func(ctx context.Context, startLedger uint64, pageSize int, cursor string) (*types.GetLedgersResult, error)
+
+// errLedgerNotInPage is returned by ledgerPrefetcher.Get when the page
+// fetched for a ledger does not contain it.
+var errLedgerNotInPage = errors.New("ledger not in getLedgers page")
+
+// ledgerPrefetcher fetches ledgers by pages of pageSize and keeps up to
+// depth ledgers buffered ahead of the last one handed out, so converting
+// ledger N overlaps with fetching N+1…N+depth. With depth 0 it only
+// serves the rest of the page the requested ledger came in.
+type ledgerPrefetcher struct {
+	pageSize  int
+	depth     int
+	fetchPage fetchPageFunc
+	logger    *zap.Logger
+
+	mu       sync.Mutex
+	buffered map[uint64]types.Ledger
+	// next is the first ledger after everything buffered or in flight,
+	// nextCursor the getLedgers cursor resuming at next, if known.
+	next       uint64
+	nextCursor string
+	// head is the highest ledger known to exist, read-ahead stops there.
+	head uint64
+
+	inflight      chan struct{}
+	inflightStart uint64
+	inflightSize  int
+
+	// ctx bounds read-ahead requests, Close cancels it.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newLedgerPrefetcher(pageSize, depth int, fetchPage fetchPageFunc, logger *zap.Logger) *ledgerPrefetcher {
+	if pageSize < 1 {
+		pageSize = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ledgerPrefetcher{
+		pageSize:  pageSize,
+		depth:     depth,
+		fetchPage: fetchPage,
+		logger:    logger,
+		buffered:  make(map[uint64]types.Ledger),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Close cancels the read-ahead in flight, if any, and waits for it.
+func (p *ledgerPrefetcher) Close() {
+	p.cancel()
+
+	p.mu.Lock()
+	inflight := p.inflight
+	p.mu.Unlock()
+	if inflight != nil {
+		<-inflight
+	}
+}
+
+// Has reports whether ledger is already buffered.
+func (p *ledgerPrefetcher) Has(ledger uint64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, found := p.buffered[ledger]
+	return found
+}
+
+// ObserveHead lets read-ahead go up to head.
+func (p *ledgerPrefetcher) ObserveHead(head uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if head > p.head {
+		p.head = head
+	}
+}
+
+// Get returns ledger, from the buffer when read-ahead already fetched it,
+// otherwise by fetching the page starting at it.
+func (p *ledgerPrefetcher) Get(ctx context.Context, ledger uint64) (types.Ledger, error) {
+	for {
+		p.mu.Lock()
+		if l, found := p.buffered[ledger]; found {
+			p.dropUpTo(ledger)
+			p.readAhead()
+			p.mu.Unlock()
+			return l, nil
+		}
+
+		if p.inflight != nil && ledger >= p.inflightStart && ledger < p.inflightStart+uint64(p.inflightSize) {
+			inflight := p.inflight
+			p.mu.Unlock()
+
+			select {
+			case <-inflight:
+				continue
+			case <-ctx.Done():
+				return types.Ledger{}, ctx.Err()
+			}
+		}
+		p.mu.Unlock()
+
+		page, err := p.fetchPage(ctx, ledger, p.pageSize, "")
+		if err != nil {
+			return types.Ledger{}, err
+		}
+
+		p.mu.Lock()
+		// Anything buffered belongs to another stretch of the chain than
+		// the one we are now reading, start over from this page.
+		p.buffered = make(map[uint64]types.Ledger)
+		p.next = ledger
+		p.nextCursor = ""
+		p.store(page)
+		_, found := p.buffered[ledger]
+		p.mu.Unlock()
+
+		if !found {
+			return types.Ledger{}, errLedgerNotInPage
+		}
+	}
+}
+
+// store buffers page and advances next past it. Callers hold p.mu.
+func (p *ledgerPrefetcher) store(page *types.GetLedgersResult) {
+	for _, l := range page.Ledgers {
+		if l.Sequence < p.next {
+			continue
+		}
+		p.buffered[l.Sequence] = l
+		p.next = l.Sequence + 1
+		p.nextCursor = page.Cursor
+	}
+	if page.LatestLedger > p.head {
+		p.head = page.LatestLedger
+	}
+}
+
+// dropUpTo forgets ledger and everything before it. Callers hold p.mu.
+func (p *ledgerPrefetcher) dropUpTo(ledger uint64) {
+	for seq := range p.buffered {
+		if seq <= ledger {
+			delete(p.buffered, seq)
+		}
+	}
+}
+
+// readAhead starts fetching the next page in the background if the
+// buffer has room for it and the chain has ledgers for it. Pages are cut
+// to depth when it is smaller than pageSize. Callers hold p.mu.
+func (p *ledgerPrefetcher) readAhead() {
+	if p.depth <= 0 || p.inflight != nil || p.next == 0 || p.next > p.head || p.ctx.Err() != nil {
+		return
+	}
+	size := min(p.pageSize, p.depth)
+	if len(p.buffered)+size > p.depth {
+		return
+	}
+
+	start, cursor := p.next, p.nextCursor
+	done := make(chan struct{})
+	p.inflight = done
+	p.inflightStart = start
+	p.inflightSize = size
+
+	go func() {
+		ctx, cancel := context.WithTimeout(p.ctx, readAheadTimeout)
+		defer cancel()
+
+		page, err := p.fetchPage(ctx, start, size, cursor)
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		defer close(done)
+
+		p.inflight = nil
+		if err != nil {
+			// The consumer fetches synchronously on a miss and surfaces the
+			// error there, nothing else to do here.
+			p.logger.Debug("ledger read-ahead failed", zap.Uint64("start_ledger", start), zap.Error(err))
+			return
+		}
+		if p.next != start {
+			// The consumer jumped elsewhere while we were fetching.
+			return
+		}
+
+		p.store(page)
+		p.readAhead()
+	}()
+}
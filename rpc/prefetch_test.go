@@ -0,0 +1,156 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/firehose-stellar/types"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLedgerPages serves getLedgers pages out of ledgers [1, head] and
+// records the calls it gets.
+type fakeLedgerPages struct {
+	head uint64
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeLedgerPages) fetchPage(_ context.Context, startLedger uint64, pageSize int, cursor string) (*types.GetLedgersResult, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, fmt.Sprintf("%d/%d/%s", startLedger, pageSize, cursor))
+	f.mu.Unlock()
+
+	if cursor != "" {
+		last, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		startLedger = last + 1
+	}
+
+	result := &types.GetLedgersResult{OldestLedger: 1, LatestLedger: f.head}
+	for seq := startLedger; seq <= f.head && len(result.Ledgers) < pageSize; seq++ {
+		result.Ledgers = append(result.Ledgers, types.Ledger{Sequence: seq})
+		result.Cursor = strconv.FormatUint(seq, 10)
+	}
+	return result, nil
+}
+
+func (f *fakeLedgerPages) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func Test_ledgerPrefetcher_ServesRestOfPage(t *testing.T) {
+	pages := &fakeLedgerPages{head: 100}
+	p := newLedgerPrefetcher(5, 0, pages.fetchPage, testLog)
+
+	for seq := uint64(10); seq < 17; seq++ {
+		requireLedger(t, p, seq)
+	}
+
+	require.Equal(t, []string{"10/5/", "15/5/"}, pages.Calls())
+}
+
+func Test_ledgerPrefetcher_ReadsAheadWithCursor(t *testing.T) {
+	pages := &fakeLedgerPages{head: 100}
+	p := newLedgerPrefetcher(5, 10, pages.fetchPage, testLog)
+	p.ObserveHead(100)
+
+	requireLedger(t, p, 10)
+	require.Eventually(t, func() bool { return p.Has(19) }, time.Second, time.Millisecond)
+
+	for seq := uint64(11); seq < 21; seq++ {
+		requireLedger(t, p, seq)
+	}
+
+	calls := pages.Calls()
+	require.Equal(t, []string{"10/5/", "15/5/14", "20/5/19"}, calls[:3])
+}
+
+func Test_ledgerPrefetcher_DepthBelowPageSize(t *testing.T) {
+	pages := &fakeLedgerPages{head: 100}
+	p := newLedgerPrefetcher(10, 3, pages.fetchPage, testLog)
+	p.ObserveHead(100)
+
+	for seq := uint64(10); seq < 20; seq++ {
+		requireLedger(t, p, seq)
+	}
+	require.Eventually(t, func() bool { return p.Has(22) }, time.Second, time.Millisecond)
+	require.False(t, p.Has(23))
+
+	for seq := uint64(20); seq < 26; seq++ {
+		requireLedger(t, p, seq)
+	}
+	calls := pages.Calls()
+	require.Equal(t, []string{"10/10/", "20/3/19", "23/3/22"}, calls[:3])
+}
+
+func Test_ledgerPrefetcher_StopsAtHead(t *testing.T) {
+	pages := &fakeLedgerPages{head: 12}
+	p := newLedgerPrefetcher(5, 10, pages.fetchPage, testLog)
+
+	requireLedger(t, p, 10)
+	requireLedger(t, p, 11)
+	requireLedger(t, p, 12)
+
+	_, err := p.Get(context.Background(), 13)
+	require.ErrorIs(t, err, errLedgerNotInPage)
+	require.Equal(t, []string{"10/5/", "13/5/"}, pages.Calls())
+}
+
+func Test_ledgerPrefetcher_JumpResetsBuffer(t *testing.T) {
+	pages := &fakeLedgerPages{head: 100}
+	p := newLedgerPrefetcher(5, 0, pages.fetchPage, testLog)
+
+	requireLedger(t, p, 10)
+	requireLedger(t, p, 50)
+	require.False(t, p.Has(11))
+	require.True(t, p.Has(51))
+}
+
+func requireLedger(t *testing.T, p *ledgerPrefetcher, seq uint64) {
+	t.Helper()
+
+	ledger, err := p.Get(context.Background(), seq)
+	require.NoError(t, err)
+	require.Equal(t, seq, ledger.Sequence)
+}
+
+func Test_ledgerPrefetcher_CloseCancelsReadAhead(t *testing.T) {
+	pages := &fakeLedgerPages{head: 100}
+	started := make(chan struct{}, 1)
+	blocking := func(ctx context.Context, startLedger uint64, pageSize int, cursor string) (*types.GetLedgersResult, error) {
+		if cursor == "" {
+			return pages.fetchPage(ctx, startLedger, pageSize, cursor)
+		}
+		started <- struct{}{}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	p := newLedgerPrefetcher(5, 10, blocking, testLog)
+	p.ObserveHead(100)
+	requireLedger(t, p, 10)
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		p.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not cancel the read-ahead in flight")
+	}
+	require.False(t, p.Has(15))
+}
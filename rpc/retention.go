@@ -0,0 +1,158 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/streamingfast/firehose-stellar/types"
+	"go.uber.org/zap"
+)
+
+// LedgerOutOfRangeError reports a ledger that falls outside the sliding
+// window of ledgers an rpc endpoint retains. An empty Endpoint means no
+// endpoint retains it; the window is then the one reaching furthest back.
+type LedgerOutOfRangeError struct {
+	Endpoint     string
+	Ledger       uint64
+	OldestLedger uint64
+	LatestLedger uint64
+}
+
+func (e *LedgerOutOfRangeError) Error() string {
+	if e.Endpoint == "" {
+		return fmt.Sprintf("ledger %d has been pruned by every rpc endpoint, the furthest reaching retention window is [%d, %d]", e.Ledger, e.OldestLedger, e.LatestLedger)
+	}
+	return fmt.Sprintf("ledger %d is outside the retention window [%d, %d] of rpc endpoint %s", e.Ledger, e.OldestLedger, e.LatestLedger, e.Endpoint)
+}
+
+// FirstRetainedLedger returns the oldest ledger still retained, which
+// the source package reads to tell how to move a start ledger that has
+// been pruned.
+func (e *LedgerOutOfRangeError) FirstRetainedLedger() uint64 {
+	return e.OldestLedger
+}
+
+// stellar-rpc answers getLedgers outside of its window with an invalid
+// params error carrying the bounds, e.g. "start ledger must be between
+// the oldest ledger: 100 and the latest ledger: 200 for this rpc
+// instance."
+var outOfRangeMessageRegex = regexp.MustCompile(`oldest ledger:\s*(\d+)\s+and the latest ledger:\s*(\d+)`)
+
+func parseLedgerOutOfRange(endpoint string, ledger uint64, rpcErr *types.RPCError) *LedgerOutOfRangeError {
+	if rpcErr == nil {
+		return nil
+	}
+
+	message := rpcErr.Message
+	if rpcErr.Data != nil {
+		message += " " + *rpcErr.Data
+	}
+
+	matches := outOfRangeMessageRegex.FindStringSubmatch(message)
+	if matches == nil {
+		return nil
+	}
+
+	oldest, err := strconv.ParseUint(matches[1], 10, 64)
+	if err != nil {
+		return nil
+	}
+	latest, err := strconv.ParseUint(matches[2], 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	return &LedgerOutOfRangeError{
+		Endpoint:     endpoint,
+		Ledger:       ledger,
+		OldestLedger: oldest,
+		LatestLedger: latest,
+	}
+}
+
+// RetentionWindow is the [OldestLedger, LatestLedger] range an rpc
+// endpoint can serve.
+type RetentionWindow struct {
+	OldestLedger uint64
+	LatestLedger uint64
+}
+
+// GetRetentionWindow asks the endpoint for its latest ledger and reads
+// the oldest one from the getLedgers response for it.
+func (c *Client) GetRetentionWindow(ctx context.Context) (*RetentionWindow, error) {
+	latest, err := c.GetLatestLedger(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting latest ledger: %w", err)
+	}
+
+	result, err := c.GetLedgers(ctx, uint64(latest.Sequence), 1, "")
+	if err != nil {
+		return nil, fmt.Errorf("getting ledger %d: %w", latest.Sequence, err)
+	}
+
+	return &RetentionWindow{OldestLedger: result.OldestLedger, LatestLedger: result.LatestLedger}, nil
+}
+
+// CheckRetention probes the retention window of every endpoint in the
+// pool, seeding the windows Pick relies on, and fails with a
+// *LedgerOutOfRangeError when none of them still retains ledger.
+// Endpoints that cannot be probed are skipped: when none can, the check
+// is given up with a warning, so that an outage at startup only delays
+// the first ledger.
+func (p *EndpointPool) CheckRetention(ctx context.Context, ledger uint64) error {
+	var probeErrs []error
+	var windows []string
+	var furthest *RetentionWindow
+	served := false
+
+	for _, e := range p.endpoints {
+		window, err := e.client.GetRetentionWindow(ctx)
+		if err != nil {
+			p.logger.Warn("unable to determine rpc endpoint retention window", zap.String("endpoint", e.client.Endpoint()), zap.Error(err))
+			probeErrs = append(probeErrs, fmt.Errorf("%s: %w", e.client.Endpoint(), err))
+			continue
+		}
+
+		p.ObserveWindow(e.client, window.OldestLedger, window.LatestLedger)
+		p.logger.Info("rpc endpoint retention window",
+			zap.String("endpoint", e.client.Endpoint()),
+			zap.Uint64("oldest_ledger", window.OldestLedger),
+			zap.Uint64("latest_ledger", window.LatestLedger),
+		)
+
+		if window.OldestLedger <= ledger {
+			served = true
+		}
+		if furthest == nil || window.OldestLedger < furthest.OldestLedger {
+			furthest = window
+		}
+		windows = append(windows, fmt.Sprintf("%s [%d, %d]", e.client.Endpoint(), window.OldestLedger, window.LatestLedger))
+	}
+
+	if served {
+		return nil
+	}
+
+	if len(windows) == 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p.logger.Warn("unable to determine the retention window of any rpc endpoint, not checking they retain the start ledger",
+			zap.Uint64("start_ledger", ledger),
+			zap.Error(errors.Join(probeErrs...)),
+		)
+		return nil
+	}
+
+	return fmt.Errorf(
+		"ledger %d is older than the retention window of every rpc endpoint (%s), stellar-rpc only keeps a sliding window of recent ledgers: "+
+			"point --endpoints to an rpc with a longer history retention, use 'firestellar fetch captive-core' to backfill from history archives, "+
+			"or start from a retained ledger: %w",
+		ledger, strings.Join(windows, ", "),
+		&LedgerOutOfRangeError{Ledger: ledger, OldestLedger: furthest.OldestLedger, LatestLedger: furthest.LatestLedger},
+	)
+}
@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/streamingfast/firehose-stellar/types"
+	"github.com/stretchr/testify/require"
+)
+
+// newRetentionServer serves getLatestLedger and getLedgers for an rpc
+// instance retaining ledgers [oldest, latest].
+func newRetentionServer(t *testing.T, oldest, latest uint64) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Method string `json:"method"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+
+		switch request.Method {
+		case "getLatestLedger":
+			require.NoError(t, json.NewEncoder(w).Encode(types.GetLatestLedgerResponse{
+				JSONRPC: "2.0",
+				Result:  types.GetLatestLedgerResult{Sequence: int(latest)},
+			}))
+		case "getLedgers":
+			require.NoError(t, json.NewEncoder(w).Encode(types.GetLedgersResponse{
+				JSONRPC: "2.0",
+				Result: types.GetLedgersResult{
+					Ledgers:      []types.Ledger{{Sequence: latest, Hash: "ab", LedgerCloseTime: "1700000000", MetadataXdr: "AAAA"}},
+					LatestLedger: latest,
+					OldestLedger: oldest,
+				},
+			}))
+		default:
+			t.Errorf("unexpected method %q", request.Method)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func Test_EndpointPool_CheckRetention(t *testing.T) {
+	short := NewClient(newRetentionServer(t, 1000, 2000).URL, testLog, testTracer)
+	long := NewClient(newRetentionServer(t, 100, 2000).URL, testLog, testTracer)
+
+	pool := NewEndpointPool(SelectionStrategySticky, []*Client{short, long}, testLog)
+	require.NoError(t, pool.CheckRetention(context.Background(), 500))
+
+	err := pool.CheckRetention(context.Background(), 50)
+	require.ErrorContains(t, err, "ledger 50 is older than the retention window of every rpc endpoint")
+	require.ErrorContains(t, err, "start from a retained ledger")
+	var outOfRange *LedgerOutOfRangeError
+	require.ErrorAs(t, err, &outOfRange)
+	require.Equal(t, uint64(100), outOfRange.FirstRetainedLedger())
+
+	// The probe seeds the windows Pick relies on.
+	requirePick(t, pool, long, short, 500)
+}
+
+func Test_EndpointPool_CheckRetention_Unreachable(t *testing.T) {
+	server := httptest.NewServer(httpStatus(http.StatusServiceUnavailable))
+	t.Cleanup(server.Close)
+	down := NewClient(server.URL, testLog, testTracer)
+	down.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+
+	// An outage at startup is not worth failing over: the check is given
+	// up, and fetching retries.
+	pool := NewEndpointPool(SelectionStrategySticky, []*Client{down}, testLog)
+	require.NoError(t, pool.CheckRetention(context.Background(), 50))
+}
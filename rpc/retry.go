@@ -0,0 +1,145 @@
+package rpc
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// RetryPolicy controls how Client retries requests failing with a
+// *RetryableError. MaxAttempts counts the first try, 1 disables retries.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// MaxRetryAfter caps how long a Retry-After header can make us wait
+	// in place. Beyond it the error is returned, so the caller can move
+	// to another endpoint instead.
+	MaxRetryAfter time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 250 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	MaxRetryAfter:  30 * time.Second,
+}
+
+// backoff returns how long to wait before the attempt following the
+// given one, counted from 1. It uses full jitter: a random delay up to
+// the exponential backoff, so clients hitting the same endpoint spread
+// out instead of retrying in lockstep.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.InitialBackoff
+	for i := 1; i < attempt && ceiling < p.MaxBackoff; i++ {
+		ceiling *= 2
+	}
+	if ceiling > p.MaxBackoff {
+		ceiling = p.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling + 1)
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker stops sending requests to an endpoint after
+// failureThreshold consecutive retryable failures. Once cooldown has
+// elapsed a single probe request goes through: it closes the circuit
+// when it succeeds and opens it for another cooldown otherwise. Every
+// request Allow lets through must end with Record or Abandon, or the
+// circuit stays half-open for good.
+type circuitBreaker struct {
+	failureThreshold int
+	cooldown         time.Duration
+	now              func() time.Time
+
+	mu                  sync.Mutex
+	state               circuitState
+	consecutiveFailures int
+	openedAt            time.Time
+}
+
+func newCircuitBreaker(failureThreshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+		now:              time.Now,
+	}
+}
+
+// Allow reports whether a request may go through and, when it may not,
+// how long until the next probe.
+func (b *circuitBreaker) Allow() (bool, time.Duration) {
+	if b.failureThreshold <= 0 {
+		return true, 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		elapsed := b.now().Sub(b.openedAt)
+		if elapsed < b.cooldown {
+			return false, b.cooldown - elapsed
+		}
+		b.state = circuitHalfOpen
+		return true, 0
+	case circuitHalfOpen:
+		// The probe is in flight.
+		return false, b.cooldown
+	default:
+		return true, 0
+	}
+}
+
+// Record reports the outcome of a request Allow let through. Fatal
+// errors say nothing about the endpoint health, only retryable ones
+// count as failures.
+func (b *circuitBreaker) Record(err error) {
+	if b.failureThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err != nil && IsRetryable(err) {
+		b.consecutiveFailures++
+		if b.state == circuitHalfOpen || b.consecutiveFailures >= b.failureThreshold {
+			b.state = circuitOpen
+			b.openedAt = b.now()
+		}
+		return
+	}
+
+	b.consecutiveFailures = 0
+	b.state = circuitClosed
+}
+
+// Abandon reports that a request Allow let through ended without telling
+// anything about the endpoint, its caller having canceled it. An
+// abandoned probe opens the circuit again, with its cooldown elapsed so
+// that the next request probes the endpoint.
+func (b *circuitBreaker) Abandon() {
+	if b.failureThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitHalfOpen {
+		b.state = circuitOpen
+		b.openedAt = b.now().Add(-b.cooldown)
+	}
+}
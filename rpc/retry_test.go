@@ -0,0 +1,320 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/firehose-stellar/types"
+	"github.com/stretchr/testify/require"
+)
+
+// faultServer answers getLatestLedger, injecting one fault per request
+// until it runs out of them.
+type faultServer struct {
+	mu       sync.Mutex
+	faults   []http.HandlerFunc
+	requests int
+}
+
+func newFaultServer(t *testing.T, faults ...http.HandlerFunc) (*faultServer, *Client) {
+	t.Helper()
+
+	s := &faultServer{faults: faults}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests++
+		var fault http.HandlerFunc
+		if len(s.faults) > 0 {
+			fault, s.faults = s.faults[0], s.faults[1:]
+		}
+		s.mu.Unlock()
+
+		if fault != nil {
+			fault(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{"id":"ab","protocolVersion":23,"sequence":1000}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	c := NewClient(server.URL, testLog, testTracer)
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 4, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, MaxRetryAfter: 2 * time.Second})
+	c.SetCircuitBreaker(0, 0)
+	return s, c
+}
+
+func (s *faultServer) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func httpStatus(code int, headers ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		for i := 0; i+1 < len(headers); i += 2 {
+			w.Header().Set(headers[i], headers[i+1])
+		}
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte("upstream says no"))
+	}
+}
+
+func rpcError(code int) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":` + strconv.Itoa(code) + `,"message":"boom"}}`))
+	}
+}
+
+func truncatedBody(w http.ResponseWriter, _ *http.Request) {
+	_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{"sequ`))
+}
+
+func droppedConnection(w http.ResponseWriter, _ *http.Request) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		_ = conn.Close()
+	}
+}
+
+func Test_Client_RetriesRetryableFailures(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault http.HandlerFunc
+	}{
+		{"service unavailable", httpStatus(http.StatusServiceUnavailable)},
+		{"bad gateway", httpStatus(http.StatusBadGateway)},
+		{"too many requests", httpStatus(http.StatusTooManyRequests)},
+		{"json-rpc internal error", rpcError(-32603)},
+		{"json-rpc server error", rpcError(-32001)},
+		{"truncated body", truncatedBody},
+		{"dropped connection", droppedConnection},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, c := newFaultServer(t, test.fault, test.fault)
+
+			ledger, err := c.GetLatestLedger(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, 1000, ledger.Sequence)
+			require.Equal(t, 3, server.Requests())
+		})
+	}
+}
+
+func Test_Client_GivesUpAfterMaxAttempts(t *testing.T) {
+	fault := httpStatus(http.StatusServiceUnavailable)
+	server, c := newFaultServer(t, fault, fault, fault, fault, fault)
+
+	_, err := c.GetLatestLedger(context.Background())
+	require.True(t, IsRetryable(err))
+
+	var statusErr *HTTPStatusError
+	require.ErrorAs(t, err, &statusErr)
+	require.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+	require.Equal(t, 4, server.Requests())
+}
+
+func Test_Client_DoesNotRetryFatalFailures(t *testing.T) {
+	t.Run("invalid params", func(t *testing.T) {
+		server, c := newFaultServer(t, rpcError(-32602))
+
+		_, err := c.GetLatestLedger(context.Background())
+		require.False(t, IsRetryable(err))
+
+		var fatal *FatalError
+		require.ErrorAs(t, err, &fatal)
+		var rpcErr *types.RPCError
+		require.ErrorAs(t, err, &rpcErr)
+		require.Equal(t, -32602, rpcErr.Code)
+		require.Equal(t, 1, server.Requests())
+	})
+
+	t.Run("bad request", func(t *testing.T) {
+		server, c := newFaultServer(t, httpStatus(http.StatusBadRequest))
+
+		_, err := c.GetLatestLedger(context.Background())
+		require.False(t, IsRetryable(err))
+		require.Equal(t, 1, server.Requests())
+	})
+
+	t.Run("schema mismatch", func(t *testing.T) {
+		server, c := newFaultServer(t, func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{"sequence":"not a number"}}`))
+		})
+
+		_, err := c.GetLatestLedger(context.Background())
+		var fatal *FatalError
+		require.ErrorAs(t, err, &fatal)
+		require.Equal(t, 1, server.Requests())
+	})
+}
+
+func Test_Client_HonorsRetryAfter(t *testing.T) {
+	server, c := newFaultServer(t, httpStatus(http.StatusTooManyRequests, "Retry-After", "1"))
+
+	start := time.Now()
+	_, err := c.GetLatestLedger(context.Background())
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), time.Second)
+	require.Equal(t, 2, server.Requests())
+}
+
+func Test_Client_ReturnsRetryAfterBeyondMax(t *testing.T) {
+	server, c := newFaultServer(t, httpStatus(http.StatusTooManyRequests, "Retry-After", "120"))
+
+	_, err := c.GetLatestLedger(context.Background())
+	var retryable *RetryableError
+	require.ErrorAs(t, err, &retryable)
+	require.Equal(t, 120*time.Second, retryable.RetryAfter)
+	require.Equal(t, 1, server.Requests())
+}
+
+func Test_Client_RetryStopsOnContextCancel(t *testing.T) {
+	server, c := newFaultServer(t, httpStatus(http.StatusTooManyRequests, "Retry-After", "2"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := c.GetLatestLedger(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, 1, server.Requests())
+}
+
+func Test_Client_CircuitBreaker(t *testing.T) {
+	fault := httpStatus(http.StatusServiceUnavailable)
+	server, c := newFaultServer(t, fault, fault, fault)
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	c.SetCircuitBreaker(2, time.Minute)
+
+	now := time.Now()
+	c.breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		_, err := c.GetLatestLedger(context.Background())
+		require.True(t, IsRetryable(err))
+	}
+
+	// Open: fail fast without reaching the endpoint.
+	_, err := c.GetLatestLedger(context.Background())
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.True(t, IsRetryable(err))
+	require.Equal(t, 2, server.Requests())
+
+	// Half-open: the failing probe opens the circuit again.
+	now = now.Add(time.Minute)
+	_, err = c.GetLatestLedger(context.Background())
+	require.NotErrorIs(t, err, ErrCircuitOpen)
+	_, err = c.GetLatestLedger(context.Background())
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, 3, server.Requests())
+
+	// A successful probe closes it.
+	now = now.Add(time.Minute)
+	_, err = c.GetLatestLedger(context.Background())
+	require.NoError(t, err)
+	_, err = c.GetLatestLedger(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 5, server.Requests())
+}
+
+func Test_Client_CircuitBreaker_FailsFast(t *testing.T) {
+	fault := httpStatus(http.StatusServiceUnavailable)
+	server, c := newFaultServer(t, fault)
+	c.SetCircuitBreaker(1, 10*time.Second)
+
+	_, err := c.GetLatestLedger(context.Background())
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, 1, server.Requests())
+
+	// The cooldown is below MaxRetryAfter, but an open circuit is not
+	// waited for.
+	start := time.Now()
+	_, err = c.GetLatestLedger(context.Background())
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Less(t, time.Since(start), time.Second)
+	require.Equal(t, 1, server.Requests())
+}
+
+func Test_Client_CircuitBreaker_InterruptedProbe(t *testing.T) {
+	released := make(chan struct{})
+	hang := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-released:
+		}
+	}
+	server, c := newFaultServer(t, httpStatus(http.StatusServiceUnavailable), hang, hang)
+	t.Cleanup(func() { close(released) })
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	c.SetCircuitBreaker(1, time.Minute)
+
+	now := time.Now()
+	c.breaker.now = func() time.Time { return now }
+
+	_, err := c.GetLatestLedger(context.Background())
+	require.True(t, IsRetryable(err))
+
+	// A canceled probe leaves the next request free to probe.
+	now = now.Add(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for server.Requests() < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	_, err = c.GetLatestLedger(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+
+	// A probe timing out fails: the circuit opens for another cooldown.
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = c.GetLatestLedger(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, 3, server.Requests())
+
+	_, err = c.GetLatestLedger(context.Background())
+	require.ErrorIs(t, err, ErrCircuitOpen)
+
+	// The probe after that cooldown succeeds and closes the circuit.
+	now = now.Add(time.Minute)
+	_, err = c.GetLatestLedger(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 4, server.Requests())
+}
+
+func Test_CircuitBreaker_IgnoresFatalErrors(t *testing.T) {
+	b := newCircuitBreaker(1, time.Minute)
+	b.Record(&FatalError{Err: errors.New("invalid params")})
+
+	allowed, _ := b.Allow()
+	require.True(t, allowed)
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	require.Equal(t, 5*time.Second, parseRetryAfter("5", now))
+	require.Equal(t, 90*time.Second, parseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now))
+	require.Zero(t, parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now))
+	require.Zero(t, parseRetryAfter("-1", now))
+	require.Zero(t, parseRetryAfter("soon", now))
+	require.Zero(t, parseRetryAfter("", now))
+}
+
+func Test_RetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	for i := 0; i < 100; i++ {
+		require.LessOrEqual(t, policy.backoff(1), 100*time.Millisecond)
+		require.LessOrEqual(t, policy.backoff(3), 400*time.Millisecond)
+		require.LessOrEqual(t, policy.backoff(10), time.Second)
+	}
+}
@@ -0,0 +1,148 @@
+package rpc
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/streamingfast/firehose-stellar/metrics"
+	"go.uber.org/zap"
+)
+
+// schemaChecker reports response fields our types do not know about.
+// stellar-rpc adds fields to its responses over releases; they are
+// decoded away silently, but we want to hear about them. Each method is
+// checked on its first response, and again once the endpoint reports a
+// new version or protocol, keeping the extra decoding off the hot path.
+type schemaChecker struct {
+	endpoint string
+	logger   *zap.Logger
+
+	mu      sync.Mutex
+	checked map[string]bool
+}
+
+func newSchemaChecker(endpoint string, logger *zap.Logger) *schemaChecker {
+	return &schemaChecker{
+		endpoint: endpoint,
+		logger:   logger,
+		checked:  make(map[string]bool),
+	}
+}
+
+// Reset makes the next response of every method checked again.
+func (s *schemaChecker) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checked = make(map[string]bool)
+}
+
+// Check reports the fields of body unknown to response, the value body
+// was decoded into, unless method was already checked.
+func (s *schemaChecker) Check(method string, body []byte, response any) []string {
+	s.mu.Lock()
+	if s.checked[method] {
+		s.mu.Unlock()
+		return nil
+	}
+	s.checked[method] = true
+	s.mu.Unlock()
+
+	var raw any
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil
+	}
+
+	found := map[string]bool{}
+	collectUnknownFields(raw, reflect.TypeOf(response), "", found)
+
+	fields := make([]string, 0, len(found))
+	for field := range found {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		metrics.RPCUnknownFields.WithLabelValues(method, field).Inc()
+	}
+	if len(fields) > 0 {
+		s.logger.Warn("rpc response has fields unknown to firestellar, they are ignored",
+			zap.String("endpoint", s.endpoint),
+			zap.String("method", method),
+			zap.Strings("fields", fields),
+		)
+	}
+
+	return fields
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// collectUnknownFields walks value, as decoded by encoding/json into an
+// any, alongside typ and records the path of every object key typ has no
+// field for. Types with their own UnmarshalJSON deal with their
+// variations themselves and are not walked.
+func collectUnknownFields(value any, typ reflect.Type, path string, found map[string]bool) {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if reflect.PointerTo(typ).Implements(jsonUnmarshalerType) {
+		return
+	}
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return
+		}
+
+		fields := jsonFields(typ)
+		for key, child := range object {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+
+			field, known := fields[strings.ToLower(key)]
+			if !known {
+				found[childPath] = true
+				continue
+			}
+			collectUnknownFields(child, field.Type, childPath, found)
+		}
+	case reflect.Slice, reflect.Array:
+		items, ok := value.([]any)
+		if !ok {
+			return
+		}
+		for _, item := range items {
+			collectUnknownFields(item, typ.Elem(), path+"[]", found)
+		}
+	}
+}
+
+// jsonFields indexes the fields of a struct type by their lower cased
+// JSON name, encoding/json matches keys case-insensitively.
+func jsonFields(typ reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[strings.ToLower(name)] = field
+	}
+	return fields
+}
@@ -0,0 +1,435 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/firehose-stellar/metrics"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type SelectionStrategy string
+
+const (
+	// SelectionStrategySticky keeps using whichever client the caller
+	// hands to Fetch or GetLedgerCloseMeta, only rolling to the next one
+	// on errors. This is the historical behavior.
+	SelectionStrategySticky SelectionStrategy = "sticky"
+
+	// SelectionStrategyScored routes every getLedgers call to the
+	// endpoint with the best score (see EndpointPool.score).
+	SelectionStrategyScored SelectionStrategy = "scored"
+)
+
+func ParseSelectionStrategy(s string) (SelectionStrategy, error) {
+	switch SelectionStrategy(s) {
+	case SelectionStrategySticky, SelectionStrategyScored:
+		return SelectionStrategy(s), nil
+	default:
+		return "", fmt.Errorf("unsupported endpoint selection strategy %q (want sticky|scored)", s)
+	}
+}
+
+// ewmaWeight is the weight given to the newest sample in the latency and
+// error rate moving averages. 0.2 means roughly the last 10 requests
+// dominate the score, which reacts within a minute at the ~5s ledger
+// close cadence without flapping on a single slow response.
+const ewmaWeight = 0.2
+
+// unmeasuredFailingScore ranks endpoints that only ever failed behind
+// every endpoint that answered at least once.
+const unmeasuredFailingScore = 1e9
+
+// EndpointStats is a point-in-time copy of what the pool knows about one
+// endpoint.
+type EndpointStats struct {
+	Endpoint     string
+	Latency      time.Duration
+	ErrorRate    float64
+	HeadLedger   uint64
+	OldestLedger uint64
+	Requests     uint64
+	Errors       uint64
+	Score        float64
+}
+
+func (s EndpointStats) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("endpoint", s.Endpoint)
+	enc.AddDuration("latency", s.Latency)
+	enc.AddFloat64("error_rate", s.ErrorRate)
+	enc.AddUint64("head_ledger", s.HeadLedger)
+	enc.AddUint64("oldest_ledger", s.OldestLedger)
+	enc.AddUint64("requests", s.Requests)
+	enc.AddUint64("errors", s.Errors)
+	enc.AddFloat64("score", s.Score)
+	return nil
+}
+
+type endpointStatsList []EndpointStats
+
+func (l endpointStatsList) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, s := range l {
+		if err := enc.AppendObject(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type endpointState struct {
+	client *Client
+
+	latency        time.Duration
+	latencySamples uint64
+	errorRate      float64
+	headLedger     uint64
+	requests       uint64
+	errors         uint64
+
+	// Retention window last reported by getLedgers, zero until known.
+	oldestLedger uint64
+	latestLedger uint64
+
+	network networkStatus
+}
+
+// networkStatus is what the pool knows of the network an endpoint
+// serves, see RequireNetwork.
+type networkStatus int
+
+const (
+	networkVerified networkStatus = iota
+	networkUnverified
+	networkMismatch
+)
+
+// pruned reports whether the endpoint is known to have already dropped
+// ledger from its retention window.
+func (e *endpointState) pruned(ledger uint64) bool {
+	return e.oldestLedger != 0 && ledger < e.oldestLedger
+}
+
+// EndpointPool tracks latency, error rate and head height of every rpc
+// endpoint and, with SelectionStrategyScored, picks the one getLedgers
+// is routed to. It is safe for concurrent use.
+type EndpointPool struct {
+	strategy   SelectionStrategy
+	endpoints  []*endpointState
+	selected   *Client
+	passphrase string
+
+	mu     sync.Mutex
+	logger *zap.Logger
+}
+
+func NewEndpointPool(strategy SelectionStrategy, clients []*Client, logger *zap.Logger) *EndpointPool {
+	p := &EndpointPool{
+		strategy: strategy,
+		logger:   logger,
+	}
+	for _, client := range clients {
+		p.endpoints = append(p.endpoints, &endpointState{client: client})
+	}
+	return p
+}
+
+func (p *EndpointPool) Strategy() SelectionStrategy {
+	return p.strategy
+}
+
+// RequireNetwork holds back unverified, the clients whose network could
+// not be read at startup, until RefreshHeads reads with getNetwork that
+// they serve passphrase. Pick does not return them before, nor ever when
+// they serve another network.
+func (p *EndpointPool) RequireNetwork(passphrase string, unverified []*Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.passphrase = passphrase
+	for _, client := range unverified {
+		if e := p.find(client); e != nil {
+			e.network = networkUnverified
+		}
+	}
+}
+
+// Pick returns the client getLedgers should be sent to for
+// requestBlockNum. With the sticky strategy this is fallback, the client
+// the caller selected, unless its known retention window no longer
+// covers requestBlockNum or its network is not verified. When no
+// endpoint retains requestBlockNum any more, Pick fails with
+// *LedgerOutOfRangeError.
+func (p *EndpointPool) Pick(fallback *Client, requestBlockNum uint64) (*Client, error) {
+	if len(p.endpoints) == 0 {
+		p.markSelected(fallback)
+		return fallback, nil
+	}
+
+	p.mu.Lock()
+	if p.strategy != SelectionStrategyScored {
+		if e := p.find(fallback); e == nil || (!e.pruned(requestBlockNum) && e.network == networkVerified) {
+			p.mu.Unlock()
+			p.markSelected(fallback)
+			return fallback, nil
+		}
+	}
+
+	var best, highest, oldest *endpointState
+	bestScore := math.Inf(1)
+	for _, e := range p.endpoints {
+		if e.network != networkVerified {
+			continue
+		}
+		if e.pruned(requestBlockNum) {
+			if oldest == nil || e.oldestLedger < oldest.oldestLedger {
+				oldest = e
+			}
+			continue
+		}
+		if highest == nil || e.headLedger > highest.headLedger {
+			highest = e
+		}
+		// An endpoint whose known head is below the requested ledger
+		// cannot serve it yet. A zero head means we never heard from it,
+		// so give it a chance rather than starving it forever.
+		if e.headLedger != 0 && e.headLedger < requestBlockNum {
+			continue
+		}
+		if score := p.score(e); score < bestScore {
+			best, bestScore = e, score
+		}
+	}
+	if best == nil {
+		best = highest
+	}
+	p.mu.Unlock()
+
+	if best == nil {
+		return nil, &LedgerOutOfRangeError{
+			Ledger:       requestBlockNum,
+			OldestLedger: oldest.oldestLedger,
+			LatestLedger: oldest.latestLedger,
+		}
+	}
+
+	p.markSelected(best.client)
+	return best.client, nil
+}
+
+// score is lower-is-better: the latency average in seconds, inflated by
+// the error rate so an endpoint failing half of its calls looks six times
+// slower than a healthy one.
+func (p *EndpointPool) score(e *endpointState) float64 {
+	if e.latencySamples == 0 {
+		if e.requests == 0 {
+			// Never-contacted endpoints win once so they get measured
+			// instead of being permanently shadowed by a known one.
+			return 0
+		}
+		// Only failures so far, keep it as a last resort.
+		return unmeasuredFailingScore
+	}
+	return e.latency.Seconds() * (1 + 10*e.errorRate)
+}
+
+func (p *EndpointPool) markSelected(client *Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.selected == client {
+		return
+	}
+	if p.selected != nil {
+		metrics.EndpointSelected.WithLabelValues(p.selected.Endpoint()).Set(0)
+		p.logger.Info("switching rpc endpoint",
+			zap.String("from", p.selected.Endpoint()),
+			zap.String("to", client.Endpoint()),
+			zap.String("strategy", string(p.strategy)),
+		)
+	}
+	metrics.EndpointSelected.WithLabelValues(client.Endpoint()).Set(1)
+	p.selected = client
+}
+
+// Observe records the outcome of one request sent to client. A zero
+// latency only counts towards the error rate, which is what callers pass
+// for cheap calls like getLatestLedger that would skew the getLedgers
+// latency average.
+func (p *EndpointPool) Observe(client *Client, latency time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e := p.find(client)
+	if e == nil {
+		return
+	}
+
+	failure := 0.0
+	if err != nil {
+		failure = 1.0
+		e.errors++
+		metrics.EndpointErrors.WithLabelValues(client.Endpoint()).Inc()
+	}
+	if latency > 0 {
+		if e.latencySamples == 0 {
+			e.latency = latency
+		} else {
+			e.latency = time.Duration(ewmaWeight*float64(latency) + (1-ewmaWeight)*float64(e.latency))
+		}
+		e.latencySamples++
+	}
+	if e.requests == 0 {
+		e.errorRate = failure
+	} else {
+		e.errorRate = ewmaWeight*failure + (1-ewmaWeight)*e.errorRate
+	}
+	e.requests++
+
+	metrics.EndpointRequests.WithLabelValues(client.Endpoint()).Inc()
+	metrics.EndpointLatency.WithLabelValues(client.Endpoint()).Set(e.latency.Seconds())
+	metrics.EndpointErrorRate.WithLabelValues(client.Endpoint()).Set(e.errorRate)
+}
+
+// ObserveHead records the latest ledger client reported.
+func (p *EndpointPool) ObserveHead(client *Client, headLedger uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e := p.find(client)
+	if e == nil {
+		return
+	}
+	e.headLedger = headLedger
+	metrics.EndpointHeadLedger.WithLabelValues(client.Endpoint()).Set(float64(headLedger))
+}
+
+// Head returns the latest ledger client last reported, 0 when unknown.
+func (p *EndpointPool) Head(client *Client) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e := p.find(client); e != nil {
+		return e.headLedger
+	}
+	return 0
+}
+
+// ObserveWindow records the retention window client reported.
+func (p *EndpointPool) ObserveWindow(client *Client, oldestLedger, latestLedger uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e := p.find(client)
+	if e == nil {
+		return
+	}
+	e.oldestLedger = oldestLedger
+	e.latestLedger = latestLedger
+	if latestLedger > e.headLedger {
+		e.headLedger = latestLedger
+		metrics.EndpointHeadLedger.WithLabelValues(client.Endpoint()).Set(float64(latestLedger))
+	}
+	metrics.EndpointOldestLedger.WithLabelValues(client.Endpoint()).Set(float64(oldestLedger))
+}
+
+// RefreshHeads calls getLatestLedger on every endpoint, after checking
+// the network of the ones not verified yet, see RequireNetwork. Failures
+// count against the endpoint's error rate.
+func (p *EndpointPool) RefreshHeads(ctx context.Context) {
+	for _, e := range p.endpoints {
+		if !p.checkNetwork(ctx, e) {
+			continue
+		}
+		latest, err := e.client.GetLatestLedger(ctx)
+		if err != nil {
+			p.logger.Debug("refreshing rpc endpoint head", zap.String("endpoint", e.client.Endpoint()), zap.Error(err))
+			p.Observe(e.client, 0, err)
+			continue
+		}
+		p.ObserveHead(e.client, uint64(latest.Sequence))
+	}
+}
+
+// checkNetwork returns whether e serves the network of the pool, asking
+// it with getNetwork while it is not verified.
+func (p *EndpointPool) checkNetwork(ctx context.Context, e *endpointState) bool {
+	p.mu.Lock()
+	status := e.network
+	p.mu.Unlock()
+	switch status {
+	case networkVerified:
+		return true
+	case networkMismatch:
+		return false
+	}
+
+	network, err := e.client.GetNetwork(ctx)
+	if err != nil {
+		p.logger.Debug("unable to read the network of rpc endpoint, not using it yet", zap.String("endpoint", e.client.Endpoint()), zap.Error(err))
+		p.Observe(e.client, 0, err)
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if network.Passphrase != p.passphrase {
+		e.network = networkMismatch
+		p.logger.Error("rpc endpoint belongs to another network, not using it",
+			zap.String("endpoint", e.client.Endpoint()),
+			zap.String("network_passphrase", network.Passphrase),
+			zap.String("expected_network_passphrase", p.passphrase),
+		)
+		return false
+	}
+	e.network = networkVerified
+	p.logger.Info("rpc endpoint belongs to the expected network, using it", zap.String("endpoint", e.client.Endpoint()))
+	return true
+}
+
+// Run refreshes endpoint heads every interval until ctx is done.
+func (p *EndpointPool) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.RefreshHeads(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *EndpointPool) Stats() []EndpointStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]EndpointStats, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		stats = append(stats, EndpointStats{
+			Endpoint:     e.client.Endpoint(),
+			Latency:      e.latency,
+			ErrorRate:    e.errorRate,
+			HeadLedger:   e.headLedger,
+			OldestLedger: e.oldestLedger,
+			Requests:     e.requests,
+			Errors:       e.errors,
+			Score:        p.score(e),
+		})
+	}
+	return stats
+}
+
+func (p *EndpointPool) find(client *Client) *endpointState {
+	for _, e := range p.endpoints {
+		if e.client == client {
+			return e
+		}
+	}
+	return nil
+}
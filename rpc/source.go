@@ -0,0 +1,131 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stellar/go-stellar-sdk/xdr"
+	"go.uber.org/zap"
+)
+
+// ledgerFetchMaxBackoff caps the wait between two rounds of LedgerSource
+// over the endpoints. Each request is already retried by the client, see
+// RetryPolicy.
+const ledgerFetchMaxBackoff = 30 * time.Second
+
+// LedgerSource serves ledgers from rpc endpoints to the shared fetch
+// loop of package source. It sticks to one endpoint and moves to the
+// next one when a ledger cannot be fetched from it, like the sticky
+// rolling strategy of the blockpoller. With an endpoint pool set on the
+// fetcher, getLedgers goes where the pool picks. A LedgerSource is not
+// safe for concurrent use.
+type LedgerSource struct {
+	fetcher        *Fetcher
+	clients        []*Client
+	current        int
+	attemptTimeout time.Duration
+	logger         *zap.Logger
+}
+
+// NewLedgerSource returns a LedgerSource getting ledgers through fetcher
+// from clients. attemptTimeout, when positive, bounds each request of an
+// attempt on one endpoint, not the wait for the ledger to close. The
+// LedgerSource closes fetcher.
+func NewLedgerSource(fetcher *Fetcher, clients []*Client, attemptTimeout time.Duration) *LedgerSource {
+	return &LedgerSource{
+		fetcher:        fetcher,
+		clients:        clients,
+		attemptTimeout: attemptTimeout,
+		logger:         fetcher.logger,
+	}
+}
+
+// PrepareRange checks an endpoint still retains startLedger, when the
+// fetcher has an endpoint pool, so a pruned resume ledger fails at
+// startup rather than on the first fetch.
+func (s *LedgerSource) PrepareRange(ctx context.Context, startLedger uint64) error {
+	if len(s.clients) == 0 {
+		return errors.New("no rpc endpoint to fetch ledgers from")
+	}
+	if s.fetcher.endpointPool == nil {
+		return nil
+	}
+	return s.fetcher.endpointPool.CheckRetention(ctx, startLedger)
+}
+
+// GetLedgerCloseMeta returns ledger sequence, trying the endpoints in
+// turn, starting from the one that served the previous ledger. Rounds
+// over the endpoints are retried with a capped backoff, like the
+// blockpoller did, so an outage of every endpoint only delays the
+// ledger. It fails when ctx is done or when every endpoint of a round
+// failed with an error retrying will not fix, e.g. a pruned ledger.
+func (s *LedgerSource) GetLedgerCloseMeta(ctx context.Context, sequence uint64) (xdr.LedgerCloseMeta, error) {
+	backoff := max(s.fetcher.latestBlockRetryInterval, 100*time.Millisecond)
+	for round := 1; ; round++ {
+		var lastErr error
+		fatal := 0
+		for range s.clients {
+			client := s.clients[s.current]
+			meta, err := s.attempt(ctx, client, sequence)
+			if err == nil {
+				return meta, nil
+			}
+			if ctx.Err() != nil {
+				return xdr.LedgerCloseMeta{}, ctx.Err()
+			}
+			if isFatalFetchError(err) {
+				fatal++
+			}
+
+			s.logger.Warn("unable to fetch ledger from rpc endpoint, trying the next one",
+				zap.String("endpoint", client.Endpoint()),
+				zap.Uint64("ledger", sequence),
+				zap.Int("round", round),
+				zap.Error(err),
+			)
+			lastErr = err
+			s.current = (s.current + 1) % len(s.clients)
+		}
+		if fatal == len(s.clients) {
+			return xdr.LedgerCloseMeta{}, fmt.Errorf("fetching ledger %d from %d rpc endpoints: %w", sequence, len(s.clients), lastErr)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return xdr.LedgerCloseMeta{}, ctx.Err()
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, ledgerFetchMaxBackoff)
+	}
+}
+
+// isFatalFetchError tells whether err, failing an attempt, will fail
+// the next ones on the same endpoint too. Requests timing out are
+// retried.
+func isFatalFetchError(err error) bool {
+	return !IsRetryable(err) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// attempt waits for ledger sequence to close on client, then gets it.
+// Only the requests are bounded by attemptTimeout.
+func (s *LedgerSource) attempt(ctx context.Context, client *Client, sequence uint64) (xdr.LedgerCloseMeta, error) {
+	if err := s.fetcher.waitForLedger(ctx, client, sequence, s.attemptTimeout); err != nil {
+		return xdr.LedgerCloseMeta{}, err
+	}
+
+	if s.attemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.attemptTimeout)
+		defer cancel()
+	}
+	return s.fetcher.GetLedgerCloseMeta(ctx, client, sequence)
+}
+
+// Close closes the fetcher.
+func (s *LedgerSource) Close() error {
+	return s.fetcher.Close()
+}
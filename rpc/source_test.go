@@ -0,0 +1,176 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stellar/go-stellar-sdk/xdr"
+	"github.com/stretchr/testify/require"
+)
+
+// ledgerServer answers getLatestLedger with a head advancing by one
+// ledger every headEvery polls, and getLedgers with a one-ledger page,
+// after failing the first requests with their fault.
+type ledgerServer struct {
+	mu        sync.Mutex
+	head      uint64
+	headEvery int
+	polls     int
+	faults    []http.HandlerFunc
+	requests  int
+}
+
+func newLedgerSource(t *testing.T, s *ledgerServer, attemptTimeout time.Duration) *LedgerSource {
+	t.Helper()
+
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+
+	c := NewClient(server.URL, testLog, testTracer)
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, MaxRetryAfter: time.Second})
+	c.SetCircuitBreaker(0, 0)
+
+	f := NewFetcher(0, 10*time.Millisecond, 200, passphraseFor(c.rpcEndpoint), testLog)
+	source := NewLedgerSource(f, []*Client{c}, attemptTimeout)
+	t.Cleanup(func() { _ = source.Close() })
+	return source
+}
+
+func (s *ledgerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Method string `json:"method"`
+		Params struct {
+			StartLedger uint64 `json:"startLedger"`
+		} `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests++
+	var fault http.HandlerFunc
+	if len(s.faults) > 0 {
+		fault, s.faults = s.faults[0], s.faults[1:]
+	}
+	if request.Method == "getLatestLedger" && fault == nil {
+		s.polls++
+		if s.headEvery > 0 && s.polls%s.headEvery == 0 {
+			s.head++
+		}
+	}
+	head := s.head
+	s.mu.Unlock()
+
+	if fault != nil {
+		fault(w, r)
+		return
+	}
+
+	switch request.Method {
+	case "getLatestLedger":
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":{"id":"ab","protocolVersion":23,"sequence":%d}}`, head)
+	case "getLedgers":
+		meta, err := xdr.MarshalBase64(xdr.LedgerCloseMeta{V: 0, V0: &xdr.LedgerCloseMetaV0{
+			LedgerHeader: xdr.LedgerHeaderHistoryEntry{Header: xdr.LedgerHeader{LedgerSeq: xdr.Uint32(request.Params.StartLedger)}},
+		}})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":{"ledgers":[{"hash":"ab","sequence":%d,"ledgerCloseTime":"1700000000","metadataXdr":%q}],"latestLedger":%d,"oldestLedger":1,"cursor":"%d"}}`,
+			request.Params.StartLedger, meta, head, request.Params.StartLedger)
+	default:
+		http.Error(w, "unexpected method "+request.Method, http.StatusBadRequest)
+	}
+}
+
+func (s *ledgerServer) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func Test_LedgerSource_WaitsForHeadBeyondAttemptTimeout(t *testing.T) {
+	// The head reaches ledger 100 after 20 polls 10ms apart, past the
+	// 50ms bound of a request.
+	s := &ledgerServer{head: 99, headEvery: 20}
+	source := newLedgerSource(t, s, 50*time.Millisecond)
+
+	meta, err := source.GetLedgerCloseMeta(context.Background(), 100)
+	require.NoError(t, err)
+	require.Equal(t, uint32(100), meta.LedgerSequence())
+}
+
+func Test_Fetcher_WaitsForTheHeadOfTheEndpointUsed(t *testing.T) {
+	newServerClient := func(s *ledgerServer) *Client {
+		server := httptest.NewServer(s)
+		t.Cleanup(server.Close)
+		return NewClient(server.URL, testLog, testTracer)
+	}
+	advanced := &ledgerServer{head: 200}
+	lagging := &ledgerServer{head: 149, headEvery: 3}
+	advancedClient, laggingClient := newServerClient(advanced), newServerClient(lagging)
+
+	f := NewFetcher(0, time.Millisecond, 200, "", testLog)
+	t.Cleanup(func() { _ = f.Close() })
+	f.SetEndpointPool(NewEndpointPool(SelectionStrategySticky, []*Client{advancedClient, laggingClient}, testLog))
+
+	require.NoError(t, f.waitForLedger(context.Background(), advancedClient, 150, 0))
+	require.Equal(t, 1, advanced.Requests())
+
+	// After failing over, the head of the advanced endpoint does not
+	// spare waiting for the lagging one to close ledger 150.
+	require.NoError(t, f.waitForLedger(context.Background(), laggingClient, 150, 0))
+	require.Equal(t, 3, lagging.Requests())
+
+	// Known heads spare polling again.
+	require.NoError(t, f.waitForLedger(context.Background(), laggingClient, 150, 0))
+	require.NoError(t, f.waitForLedger(context.Background(), advancedClient, 199, 0))
+	require.Equal(t, 3, lagging.Requests())
+	require.Equal(t, 1, advanced.Requests())
+}
+
+func Test_LedgerSource_RetriesUntilEndpointsRecover(t *testing.T) {
+	// Each round fails twice with the retry policy of newLedgerSource,
+	// so 8 failures are 4 rounds.
+	s := &ledgerServer{head: 100}
+	for range 8 {
+		s.faults = append(s.faults, httpStatus(http.StatusServiceUnavailable))
+	}
+	source := newLedgerSource(t, s, time.Second)
+
+	meta, err := source.GetLedgerCloseMeta(context.Background(), 100)
+	require.NoError(t, err)
+	require.Equal(t, uint32(100), meta.LedgerSequence())
+	require.Equal(t, 10, s.Requests())
+}
+
+func Test_LedgerSource_StopsOnContextCancel(t *testing.T) {
+	s := &ledgerServer{head: 100}
+	for range 1000 {
+		s.faults = append(s.faults, httpStatus(http.StatusServiceUnavailable))
+	}
+	source := newLedgerSource(t, s, time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err := source.GetLedgerCloseMeta(ctx, 100)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func Test_LedgerSource_StopsOnFatalErrors(t *testing.T) {
+	s := &ledgerServer{head: 100, faults: []http.HandlerFunc{rpcError(-32602)}}
+	source := newLedgerSource(t, s, time.Second)
+
+	_, err := source.GetLedgerCloseMeta(context.Background(), 100)
+	require.ErrorContains(t, err, "fetching ledger 100 from 1 rpc endpoints")
+	require.Equal(t, 1, s.Requests())
+}
@@ -0,0 +1,66 @@
+package rpc
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MetricsBackend labels the metrics of the rpc backend.
+const MetricsBackend = "rpc"
+
+// statisticsInterval is the period of the "block fetch statistics" log.
+const statisticsInterval = 10 * time.Second
+
+// fetchStats accumulates the timings the "block fetch statistics" log
+// reports over a period. The same timings are exported as histograms,
+// see metrics.FetchDuration and metrics.ConvertDuration.
+type fetchStats struct {
+	mu             sync.Mutex
+	blocks         int
+	acquisition    time.Duration
+	conversion     time.Duration
+	total          time.Duration
+	interCalls     int
+	interCallDelay time.Duration
+}
+
+func (s *fetchStats) record(acquisition, conversion, total, interCallDelay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blocks++
+	s.acquisition += acquisition
+	s.conversion += conversion
+	s.total += total
+	if interCallDelay > 0 {
+		s.interCalls++
+		s.interCallDelay += interCallDelay
+	}
+}
+
+// flush returns the averages of the period as log fields and starts a
+// new period.
+func (s *fetchStats) flush() []zap.Field {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fields := []zap.Field{
+		zap.Int("blocks_fetched_in_period", s.blocks),
+		zap.Duration("avg_acquisition_time", average(s.acquisition, s.blocks)),
+		zap.Duration("avg_conversion_time", average(s.conversion, s.blocks)),
+		zap.Duration("avg_total_time", average(s.total, s.blocks)),
+		zap.Duration("avg_inter_call_delay", average(s.interCallDelay, s.interCalls)),
+	}
+	s.blocks, s.acquisition, s.conversion, s.total = 0, 0, 0, 0
+	s.interCalls, s.interCallDelay = 0, 0
+	return fields
+}
+
+func average(sum time.Duration, count int) time.Duration {
+	if count == 0 {
+		return 0
+	}
+	return sum / time.Duration(count)
+}
@@ -0,0 +1,54 @@
+#!/usr/bin/env bash
+# Record the responses of a live stellar-rpc into testdata/compat/v<version>,
+# the version being the one getVersionInfo reports.
+#
+#   rpc/testdata/compat/record.sh https://<endpoint>
+#
+# A stellar/quickstart container of the release to record works too, e.g.
+#   docker run --rm -d -p 8000:8000 stellar/quickstart:<tag> --local --enable-stellar-rpc
+#   rpc/testdata/compat/record.sh http://localhost:8000/rpc
+#
+# Lists are trimmed to their first element, payloads are kept as sent.
+
+set -euo pipefail
+SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+ENDPOINT="${1:?usage: record.sh <stellar-rpc endpoint>}"
+
+call() {
+  local method="$1" params="${2:-}"
+  local body="{\"jsonrpc\":\"2.0\",\"id\":1,\"method\":\"$method\"${params:+,\"params\":$params}}"
+  curl -sf -X POST -H 'Content-Type: application/json' -d "$body" "$ENDPOINT"
+}
+
+version_info="$(call getVersionInfo)"
+version="$(jq -r '.result.version // empty' <<<"$version_info")"
+if [[ -z "$version" ]]; then
+  echo "getVersionInfo of $ENDPOINT has no version: $version_info" >&2
+  exit 1
+fi
+version="v${version%%-*}"
+out="$SCRIPT_DIR/$version"
+mkdir -p "$out"
+
+latest_ledger="$(call getLatestLedger)"
+latest="$(jq -r '.result.sequence' <<<"$latest_ledger")"
+# A few ledgers back, so that the page is full and has transactions
+# on a busy network.
+start=$((latest - 10))
+
+jq . <<<"$version_info" >"$out/getVersionInfo.json"
+jq . <<<"$latest_ledger" >"$out/getLatestLedger.json"
+call getNetwork | jq . >"$out/getNetwork.json"
+call getHealth | jq . >"$out/getHealth.json"
+call getLedgers "{\"startLedger\":$start,\"pagination\":{\"limit\":1}}" |
+  jq '.result.ledgers |= .[:1]' >"$out/getLedgers.json"
+call getTransactions "{\"startLedger\":$start,\"pagination\":{\"limit\":1}}" |
+  jq '.result.transactions |= .[:1]' >"$out/getTransactions.json"
+
+hash="$(jq -r '.result.transactions[0].txHash // empty' "$out/getTransactions.json")"
+if [[ -n "$hash" ]]; then
+  call getTransaction "{\"hash\":\"$hash\"}" | jq . >"$out/getTransaction.json"
+fi
+
+printf 'endpoint: %s\nrecorded: %s\n' "${ENDPOINT%%\?*}" "$(date -u +%Y-%m-%dT%H:%M:%SZ)" >"$out/RECORDED"
+echo ">> recorded $version from $ENDPOINT to $out"
@@ -0,0 +1,76 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/streamingfast/firehose-stellar/metrics"
+	"github.com/streamingfast/firehose-stellar/types"
+	"go.uber.org/zap"
+)
+
+// ProtocolVersion returns the latest protocol version the endpoint
+// reported through getLatestLedger, 0 until it is known.
+func (c *Client) ProtocolVersion() int {
+	return int(c.protocolVersion.Load())
+}
+
+// VersionInfo returns what getVersionInfo reported on the last
+// LoadVersionInfo call, nil until then.
+func (c *Client) VersionInfo() *types.GetVersionInfoResult {
+	return c.versionInfo.Load()
+}
+
+// LoadVersionInfo asks the endpoint which stellar-rpc and captive-core
+// versions it runs and records them. Response schemas vary with those
+// versions, so a change makes the next responses checked for unknown
+// fields again.
+func (c *Client) LoadVersionInfo(ctx context.Context) (*types.GetVersionInfoResult, error) {
+	info, err := c.GetVersionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := c.versionInfo.Swap(info)
+	if previous != nil && previous.Version == info.Version && previous.CaptiveCoreVersion == info.CaptiveCoreVersion {
+		return info, nil
+	}
+
+	if previous != nil {
+		metrics.EndpointInfo.DeleteLabelValues(c.Endpoint(), previous.Version, previous.CaptiveCoreVersion)
+		c.schema.Reset()
+	}
+	metrics.EndpointInfo.WithLabelValues(c.Endpoint(), info.Version, info.CaptiveCoreVersion).Set(1)
+	if info.ProtocolVersion != 0 {
+		c.observeProtocolVersion(info.ProtocolVersion)
+	}
+
+	c.logger.Info("rpc endpoint version",
+		zap.String("endpoint", c.Endpoint()),
+		zap.String("version", info.Version),
+		zap.String("commit_hash", info.CommitHash),
+		zap.String("captive_core_version", info.CaptiveCoreVersion),
+		zap.Int("protocol_version", info.ProtocolVersion),
+	)
+	return info, nil
+}
+
+func (c *Client) observeProtocolVersion(version int) {
+	if version == 0 {
+		return
+	}
+
+	previous := c.protocolVersion.Swap(int64(version))
+	if previous == int64(version) {
+		return
+	}
+
+	metrics.EndpointProtocolVersion.WithLabelValues(c.Endpoint()).Set(float64(version))
+	if previous != 0 {
+		c.logger.Info("rpc endpoint protocol version changed",
+			zap.String("endpoint", c.Endpoint()),
+			zap.Int64("from", previous),
+			zap.Int("to", version),
+		)
+		c.schema.Reset()
+	}
+}
@@ -0,0 +1,321 @@
+// Package source runs the fetch loop shared by every backend. A backend
+// only implements LedgerSource; the Driver resumes from the persisted
+// cursor, checks each ledger follows the previous one, converts and
+// fires it, saves the cursor and reports metrics, health and traces the
+// same way for all of them.
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stellar/go-stellar-sdk/xdr"
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/firehose-stellar/cursor"
+	"github.com/streamingfast/firehose-stellar/health"
+	"github.com/streamingfast/firehose-stellar/metrics"
+	"github.com/streamingfast/firehose-stellar/tracing"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// LedgerSource serves consecutive ledgers, e.g. a captive-core backend
+// or rpc endpoints.
+type LedgerSource interface {
+	// PrepareRange readies the source to serve ledgers from startLedger
+	// onwards. For captive-core, this is catching up to it.
+	PrepareRange(ctx context.Context, startLedger uint64) error
+
+	// GetLedgerCloseMeta returns ledger sequence, blocking until it is
+	// available or ctx is done. Ledgers are requested in order. Steps
+	// worth tracing are started as children of the span in ctx.
+	GetLedgerCloseMeta(ctx context.Context, sequence uint64) (xdr.LedgerCloseMeta, error)
+
+	Close() error
+}
+
+// Converter turns a ledger into the block fired, a
+// *captivecore.Fetcher outside of tests.
+type Converter interface {
+	ConvertLedgerCloseMetaToBstreamBlock(ledgerMetadata *xdr.LedgerCloseMeta) (*pbbstream.Block, error)
+}
+
+// BlockHandler fires blocks, the blockpoller FireBlockHandler outside of
+// tests.
+type BlockHandler interface {
+	Init()
+	Handle(blk *pbbstream.Block) error
+}
+
+// Config is what the Driver needs besides its source.
+type Config struct {
+	// Backend labels the metrics and traces, e.g. captivecore.MetricsBackend.
+	Backend string
+
+	// StartBlock is the first streamable block, where a run without a
+	// cursor starts.
+	StartBlock uint64
+
+	// Cursor is where the cursor is resumed from and saved, nil disables
+	// it.
+	Cursor cursor.Store
+
+	// NetworkPassphrase of the ledgers, recorded with the cursor. A run
+	// refuses a cursor recorded for another network.
+	NetworkPassphrase string
+
+	// IgnoreCursor starts from StartBlock even when a cursor is saved.
+	IgnoreCursor bool
+
+	// Reporter, when set, follows the source state, the fired blocks
+	// and the cursor.
+	Reporter *health.Reporter
+}
+
+// Driver fires the ledgers of a LedgerSource, one after the other, from
+// where the previous run stopped.
+type Driver struct {
+	source    LedgerSource
+	converter Converter
+	handler   BlockHandler
+	config    Config
+	logger    *zap.Logger
+	traces    *tracing.Ledgers
+
+	// resumedFrom is the cursor the run resumed after, until the first
+	// ledger is checked against it.
+	resumedFrom *cursor.BlockRef
+	// recordedNetwork is the network passphrase the cursor was recorded
+	// for, empty for a cursor predating it.
+	recordedNetwork string
+}
+
+// NewDriver returns a Driver firing the ledgers of source, converted by
+// converter, through handler.
+func NewDriver(source LedgerSource, converter Converter, handler BlockHandler, config Config, logger *zap.Logger) *Driver {
+	if config.Cursor == nil {
+		config.Cursor = cursor.NewDirStore("")
+	}
+	return &Driver{
+		source:    source,
+		converter: converter,
+		handler:   handler,
+		config:    config,
+		logger:    logger,
+		traces:    tracing.NewLedgers(config.Backend),
+	}
+}
+
+// resume returns the ledger the run starts at: the one after the last
+// fired block when the cursor is past StartBlock, StartBlock otherwise.
+// It fails when the cursor was recorded for another network, and records
+// the network otherwise.
+func (d *Driver) resume(ctx context.Context) (uint64, error) {
+	if d.config.IgnoreCursor {
+		return d.config.StartBlock, d.recordNetwork(ctx)
+	}
+
+	recorded, err := d.config.Cursor.LoadNetwork(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("loading cursor network: %w", err)
+	}
+	d.recordedNetwork = recorded
+	if recorded != "" && d.config.NetworkPassphrase != "" && recorded != d.config.NetworkPassphrase {
+		return 0, fmt.Errorf("state dir %s holds the cursor of network %q, not %q: point the state dir elsewhere, or ignore the cursor to start over from the first streamable block", d.config.Cursor, recorded, d.config.NetworkPassphrase)
+	}
+	if recorded == "" {
+		if err := d.recordNetwork(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	persisted, report, err := d.config.Cursor.Load(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("loading cursor: %w", err)
+	}
+	if persisted == nil {
+		return d.config.StartBlock, nil
+	}
+	if len(report.Invalid) > 0 {
+		d.logger.Warn("persisted cursor is corrupted, resuming from the newest valid one of its history",
+			zap.String("cursor_file", report.Path),
+			zap.Uint64("last_fired_block", persisted.LastFiredBlock.Num),
+			zap.Errors("invalid_cursors", report.Invalid),
+		)
+	}
+	if d.config.Reporter != nil {
+		d.config.Reporter.CursorSaved(persisted.LastFiredBlock.Num, persisted.LastFiredBlock.Id)
+	}
+
+	resumeFrom := persisted.LastFiredBlock.Num + 1
+	if resumeFrom <= d.config.StartBlock {
+		d.logger.Info("persisted cursor is below first streamable block, ignoring",
+			zap.Uint64("last_fired_block", persisted.LastFiredBlock.Num),
+			zap.Uint64("first_streamable_block", d.config.StartBlock),
+		)
+		return d.config.StartBlock, nil
+	}
+	d.logger.Info("resuming from persisted cursor",
+		zap.Stringer("state_dir", d.config.Cursor),
+		zap.Uint64("last_fired_block", persisted.LastFiredBlock.Num),
+		zap.Uint64("resume_block", resumeFrom),
+	)
+	d.resumedFrom = &persisted.LastFiredBlock.BlockRef
+	return resumeFrom, nil
+}
+
+func (d *Driver) recordNetwork(ctx context.Context) error {
+	if d.config.NetworkPassphrase == "" {
+		return nil
+	}
+	if err := d.config.Cursor.SaveNetwork(ctx, d.config.NetworkPassphrase); err != nil {
+		return fmt.Errorf("recording cursor network: %w", err)
+	}
+	return nil
+}
+
+// Run prepares the source from where the previous run stopped and fires
+// every ledger from there until ctx is done or a ledger fails. The
+// source and the cursor store are not closed.
+func (d *Driver) Run(ctx context.Context) error {
+	seq, err := d.resume(ctx)
+	if err != nil {
+		return err
+	}
+
+	d.setState(health.StatePreparing)
+	defer d.setState(health.StateStopped)
+	if err := d.source.PrepareRange(ctx, seq); err != nil {
+		return d.prepareError(seq, err)
+	}
+	d.setState(health.StateStreaming)
+
+	d.handler.Init()
+
+	var previous *pbbstream.Block
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		blk, err := d.next(ctx, seq, previous)
+		if err != nil {
+			return err
+		}
+		previous = blk
+		seq++
+	}
+}
+
+// prunedLedgerError is implemented by the error of a LedgerSource that
+// no longer serves the start ledger, e.g. *rpc.LedgerOutOfRangeError.
+type prunedLedgerError interface {
+	error
+	FirstRetainedLedger() uint64
+}
+
+// prepareError returns the error of PrepareRange from ledger seq. When
+// seq has been pruned, it tells how to move it, which depends on where
+// it comes from: the cursor, or the first streamable block.
+func (d *Driver) prepareError(seq uint64, err error) error {
+	var pruned prunedLedgerError
+	if !errors.As(err, &pruned) {
+		return err
+	}
+
+	first := pruned.FirstRetainedLedger()
+	if d.resumedFrom != nil {
+		return fmt.Errorf("ledger %d, where the cursor of %s resumes, is no longer served: move the cursor with 'firestellar cursor set %s <block-num>' to at least block %d, or drop it with 'firestellar cursor reset %s': %w",
+			seq, d.config.Cursor, d.config.Cursor, first-1, d.config.Cursor, err)
+	}
+	return fmt.Errorf("ledger %d, the first streamable block, is no longer served: raise <first-streamable-block> to at least %d: %w", seq, first, err)
+}
+
+// next fetches, converts, fires ledger seq and saves the cursor on it.
+func (d *Driver) next(ctx context.Context, seq uint64, previous *pbbstream.Block) (blk *pbbstream.Block, err error) {
+	ledgerCtx := d.traces.Start(ctx, seq)
+	defer func() { d.traces.End(seq, err) }()
+
+	meta, err := d.source.GetLedgerCloseMeta(ledgerCtx, seq)
+	if err != nil {
+		return nil, fmt.Errorf("get ledger %d: %w", seq, err)
+	}
+
+	convertStart := time.Now()
+	convertCtx, convertSpan := tracing.Start(ledgerCtx, tracing.SpanConvert, seq)
+	blk, err = d.converter.ConvertLedgerCloseMetaToBstreamBlock(&meta)
+	if err != nil {
+		tracing.End(convertSpan, err)
+		return nil, fmt.Errorf("convert ledger %d: %w", seq, err)
+	}
+	// Only counted once converted: the count panics on a LedgerCloseMeta
+	// version the converter rejects.
+	tracing.SetTransactionCount(convertCtx, trace.SpanFromContext(ledgerCtx), meta.CountTransactions())
+	convertSpan.End()
+	metrics.ConvertDuration.WithLabelValues(d.config.Backend).Observe(time.Since(convertStart).Seconds())
+
+	if err := d.checkContinuity(seq, blk, previous); err != nil {
+		return nil, err
+	}
+
+	d.logger.Info("processing block", zap.Uint64("seq", seq), zap.String("hash", blk.Id))
+	_, fireSpan := tracing.Start(ledgerCtx, tracing.SpanFireBlock, seq)
+	err = d.handler.Handle(blk)
+	tracing.End(fireSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("handling block %d: %w", blk.Number, err)
+	}
+	blockTime := blk.Timestamp.AsTime()
+	metrics.ObserveFiredBlock(d.config.Backend, blk.Number, blockTime)
+	if d.config.Reporter != nil {
+		d.config.Reporter.BlockFired(blk.Number, blk.Id, blockTime)
+	}
+
+	_, saveSpan := tracing.Start(ledgerCtx, tracing.SpanSaveCursor, seq)
+	err = d.config.Cursor.Save(ledgerCtx, blk)
+	tracing.End(saveSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("saving cursor at block %d: %w", blk.Number, err)
+	}
+	if d.config.Reporter != nil {
+		d.config.Reporter.CursorSaved(blk.Number, blk.Id)
+	}
+
+	return blk, nil
+}
+
+// checkContinuity fails when blk is not ledger seq or does not build on
+// previous, the block fired before it in this run, or for the first
+// block after a resume, on the cursor.
+func (d *Driver) checkContinuity(seq uint64, blk, previous *pbbstream.Block) error {
+	if blk.Number != seq {
+		return fmt.Errorf("requested ledger %d but got ledger %d", seq, blk.Number)
+	}
+	if previous != nil && blk.ParentId != previous.Id {
+		return fmt.Errorf("ledger %d does not follow ledger %d: its previous ledger hash is %s, want %s", blk.Number, previous.Number, blk.ParentId, previous.Id)
+	}
+
+	resumedFrom := d.resumedFrom
+	d.resumedFrom = nil
+	if previous == nil && resumedFrom != nil && blk.ParentId != resumedFrom.Id {
+		recorded := d.recordedNetwork
+		if recorded == "" {
+			recorded = "none recorded"
+		}
+		return fmt.Errorf(
+			"ledger %d does not follow the cursor of state dir %s: its previous ledger hash is %s but the cursor's last fired block %d is %s, "+
+				"the state dir belongs to another network or chain history (its network passphrase: %q, this network's: %q); "+
+				"point the state dir elsewhere, or ignore the cursor to start over from the first streamable block",
+			blk.Number, d.config.Cursor, blk.ParentId, resumedFrom.Num, resumedFrom.Id, recorded, d.config.NetworkPassphrase,
+		)
+	}
+	return nil
+}
+
+func (d *Driver) setState(state health.State) {
+	if d.config.Reporter != nil {
+		d.config.Reporter.SetState(state)
+	}
+}
@@ -0,0 +1,285 @@
+package source
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stellar/go-stellar-sdk/xdr"
+	pbbstream "github.com/streamingfast/bstream/pb/sf/bstream/v1"
+	"github.com/streamingfast/firehose-stellar/cursor"
+	"github.com/streamingfast/firehose-stellar/health"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeSource serves empty ledgers chained by hash, the ones listed in
+// forks with a previous ledger hash that breaks the chain.
+type fakeSource struct {
+	prepared   []uint64
+	prepareErr error
+	forks      map[uint64]bool
+	closed     bool
+}
+
+func ledgerHash(seq uint64) xdr.Hash {
+	var hash xdr.Hash
+	binary.BigEndian.PutUint64(hash[:8], seq)
+	return hash
+}
+
+func (s *fakeSource) PrepareRange(_ context.Context, startLedger uint64) error {
+	s.prepared = append(s.prepared, startLedger)
+	return s.prepareErr
+}
+
+// prunedError is the error of a source that only serves ledgers from
+// first on.
+type prunedError struct {
+	first uint64
+}
+
+func (e *prunedError) Error() string {
+	return fmt.Sprintf("ledgers before %d are pruned", e.first)
+}
+
+func (e *prunedError) FirstRetainedLedger() uint64 {
+	return e.first
+}
+
+func (s *fakeSource) GetLedgerCloseMeta(_ context.Context, sequence uint64) (xdr.LedgerCloseMeta, error) {
+	previous := ledgerHash(sequence - 1)
+	if s.forks[sequence] {
+		previous = ledgerHash(sequence + 1000)
+	}
+	return xdr.LedgerCloseMeta{V: 1, V1: &xdr.LedgerCloseMetaV1{
+		LedgerHeader: xdr.LedgerHeaderHistoryEntry{
+			Hash:   ledgerHash(sequence),
+			Header: xdr.LedgerHeader{LedgerSeq: xdr.Uint32(sequence), PreviousLedgerHash: previous},
+		},
+	}}, nil
+}
+
+func (s *fakeSource) Close() error {
+	s.closed = true
+	return nil
+}
+
+type headerConverter struct{}
+
+func (headerConverter) ConvertLedgerCloseMetaToBstreamBlock(meta *xdr.LedgerCloseMeta) (*pbbstream.Block, error) {
+	header := meta.LedgerHeaderHistoryEntry()
+	return &pbbstream.Block{
+		Number:    uint64(header.Header.LedgerSeq),
+		Id:        hex.EncodeToString(header.Hash[:]),
+		ParentId:  hex.EncodeToString(header.Header.PreviousLedgerHash[:]),
+		Timestamp: timestamppb.Now(),
+	}, nil
+}
+
+// firedBlocks records the blocks fired, cancelling the run once it fired
+// until.
+type firedBlocks struct {
+	numbers []uint64
+	until   uint64
+	cancel  context.CancelFunc
+	err     error
+}
+
+func (h *firedBlocks) Init() {}
+
+func (h *firedBlocks) Handle(blk *pbbstream.Block) error {
+	if h.err != nil {
+		return h.err
+	}
+	h.numbers = append(h.numbers, blk.Number)
+	if blk.Number >= h.until {
+		h.cancel()
+	}
+	return nil
+}
+
+func runDriver(t *testing.T, source *fakeSource, handler *firedBlocks, config Config) error {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler.cancel = cancel
+	config.Backend = "test"
+	return NewDriver(source, headerConverter{}, handler, config, zap.NewNop()).Run(ctx)
+}
+
+func saveCursor(t *testing.T, stateDir string, num uint64) {
+	t.Helper()
+
+	hash := ledgerHash(num)
+	require.NoError(t, cursor.Save(stateDir, &pbbstream.Block{Number: num, Id: hex.EncodeToString(hash[:])}))
+}
+
+func Test_Driver_Run(t *testing.T) {
+	stateDir := t.TempDir()
+	source := &fakeSource{}
+	handler := &firedBlocks{until: 104}
+	reporter := health.NewReporter("test", 0)
+
+	err := runDriver(t, source, handler, Config{StartBlock: 100, Cursor: cursor.NewDirStore(stateDir), Reporter: reporter})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, []uint64{100}, source.prepared)
+	require.Equal(t, []uint64{100, 101, 102, 103, 104}, handler.numbers)
+	require.False(t, source.closed, "the caller owns the source")
+
+	persisted, err := cursor.Load(stateDir)
+	require.NoError(t, err)
+	require.Equal(t, uint64(104), persisted.LastFiredBlock.Num)
+
+	status := reporter.Status()
+	require.Equal(t, health.StateStopped, status.State)
+	require.Equal(t, uint64(104), status.Cursor.Num)
+}
+
+func Test_Driver_Resume(t *testing.T) {
+	tests := []struct {
+		name         string
+		cursor       uint64
+		ignoreCursor bool
+		expected     uint64
+	}{
+		{"no cursor", 0, false, 100},
+		{"cursor past start block", 150, false, 151},
+		{"cursor below start block", 50, false, 100},
+		{"cursor ignored", 150, true, 100},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stateDir := t.TempDir()
+			if test.cursor != 0 {
+				saveCursor(t, stateDir, test.cursor)
+			}
+			source := &fakeSource{}
+			handler := &firedBlocks{until: test.expected}
+
+			err := runDriver(t, source, handler, Config{StartBlock: 100, Cursor: cursor.NewDirStore(stateDir), IgnoreCursor: test.ignoreCursor})
+			require.ErrorIs(t, err, context.Canceled)
+			require.Equal(t, []uint64{test.expected}, source.prepared)
+			require.Equal(t, []uint64{test.expected}, handler.numbers)
+		})
+	}
+}
+
+func Test_Driver_Failures(t *testing.T) {
+	t.Run("broken chain", func(t *testing.T) {
+		stateDir := t.TempDir()
+		handler := &firedBlocks{until: 200}
+
+		err := runDriver(t, &fakeSource{forks: map[uint64]bool{102: true}}, handler, Config{StartBlock: 100, Cursor: cursor.NewDirStore(stateDir)})
+		require.ErrorContains(t, err, fmt.Sprintf("ledger 102 does not follow ledger 101: its previous ledger hash is %x", ledgerHash(1102)))
+		require.Equal(t, []uint64{100, 101}, handler.numbers)
+
+		persisted, err := cursor.Load(stateDir)
+		require.NoError(t, err)
+		require.Equal(t, uint64(101), persisted.LastFiredBlock.Num, "the cursor stays on the last good block")
+	})
+
+	t.Run("handler failure", func(t *testing.T) {
+		stateDir := t.TempDir()
+		fireErr := errors.New("stdout closed")
+
+		err := runDriver(t, &fakeSource{}, &firedBlocks{until: 200, err: fireErr}, Config{StartBlock: 100, Cursor: cursor.NewDirStore(stateDir)})
+		require.ErrorIs(t, err, fireErr)
+
+		persisted, err := cursor.Load(stateDir)
+		require.NoError(t, err)
+		require.Nil(t, persisted, "a block not fired is not saved")
+	})
+}
+
+func Test_Driver_PrunedStartLedger(t *testing.T) {
+	pruned := &prunedError{first: 500}
+
+	t.Run("first streamable block", func(t *testing.T) {
+		source := &fakeSource{prepareErr: pruned}
+
+		err := runDriver(t, source, &firedBlocks{until: 200}, Config{StartBlock: 100, Cursor: cursor.NewDirStore(t.TempDir())})
+		require.ErrorIs(t, err, pruned)
+		require.ErrorContains(t, err, "ledger 100, the first streamable block, is no longer served: raise <first-streamable-block> to at least 500")
+	})
+
+	t.Run("cursor", func(t *testing.T) {
+		stateDir := t.TempDir()
+		saveCursor(t, stateDir, 150)
+		source := &fakeSource{prepareErr: pruned}
+
+		err := runDriver(t, source, &firedBlocks{until: 200}, Config{StartBlock: 100, Cursor: cursor.NewDirStore(stateDir)})
+		require.ErrorIs(t, err, pruned)
+		require.ErrorContains(t, err, fmt.Sprintf("ledger 151, where the cursor of %s resumes, is no longer served: move the cursor with 'firestellar cursor set %s <block-num>' to at least block 499", stateDir, stateDir))
+	})
+
+	t.Run("other failure", func(t *testing.T) {
+		crash := errors.New("stellar-core exited")
+
+		err := runDriver(t, &fakeSource{prepareErr: crash}, &firedBlocks{until: 200}, Config{StartBlock: 100, Cursor: cursor.NewDirStore(t.TempDir())})
+		require.Equal(t, crash, err)
+	})
+}
+
+func Test_Driver_ResumeChecks(t *testing.T) {
+	const testnet = "Test SDF Network ; September 2015"
+	const pubnet = "Public Global Stellar Network ; September 2015"
+
+	t.Run("network recorded", func(t *testing.T) {
+		stateDir := t.TempDir()
+
+		err := runDriver(t, &fakeSource{}, &firedBlocks{until: 100}, Config{StartBlock: 100, Cursor: cursor.NewDirStore(stateDir), NetworkPassphrase: testnet})
+		require.ErrorIs(t, err, context.Canceled)
+
+		recorded, err := cursor.LoadNetwork(stateDir)
+		require.NoError(t, err)
+		require.Equal(t, testnet, recorded)
+	})
+
+	t.Run("other network", func(t *testing.T) {
+		stateDir := t.TempDir()
+		saveCursor(t, stateDir, 150)
+		require.NoError(t, cursor.SaveNetwork(stateDir, pubnet))
+		source := &fakeSource{}
+
+		err := runDriver(t, source, &firedBlocks{until: 200}, Config{StartBlock: 100, Cursor: cursor.NewDirStore(stateDir), NetworkPassphrase: testnet})
+		require.ErrorContains(t, err, fmt.Sprintf("state dir %s holds the cursor of network %q, not %q", stateDir, pubnet, testnet))
+		require.Empty(t, source.prepared)
+	})
+
+	t.Run("other chain history", func(t *testing.T) {
+		stateDir := t.TempDir()
+		hash := ledgerHash(9999)
+		require.NoError(t, cursor.Save(stateDir, &pbbstream.Block{Number: 150, Id: hex.EncodeToString(hash[:])}))
+		require.NoError(t, cursor.SaveNetwork(stateDir, testnet))
+		handler := &firedBlocks{until: 200}
+
+		err := runDriver(t, &fakeSource{}, handler, Config{StartBlock: 100, Cursor: cursor.NewDirStore(stateDir), NetworkPassphrase: testnet})
+		require.ErrorContains(t, err, fmt.Sprintf("ledger 151 does not follow the cursor of state dir %s: its previous ledger hash is %x but the cursor's last fired block 150 is %x", stateDir, ledgerHash(150), hash))
+		require.ErrorContains(t, err, fmt.Sprintf("its network passphrase: %q", testnet))
+		require.Empty(t, handler.numbers)
+
+		persisted, err := cursor.Load(stateDir)
+		require.NoError(t, err)
+		require.Equal(t, hex.EncodeToString(hash[:]), persisted.LastFiredBlock.Id, "the cursor is left untouched")
+	})
+
+	t.Run("cursor ignored", func(t *testing.T) {
+		stateDir := t.TempDir()
+		saveCursor(t, stateDir, 150)
+		require.NoError(t, cursor.SaveNetwork(stateDir, pubnet))
+
+		err := runDriver(t, &fakeSource{}, &firedBlocks{until: 100}, Config{StartBlock: 100, Cursor: cursor.NewDirStore(stateDir), NetworkPassphrase: testnet, IgnoreCursor: true})
+		require.ErrorIs(t, err, context.Canceled)
+
+		recorded, err := cursor.LoadNetwork(stateDir)
+		require.NoError(t, err)
+		require.Equal(t, testnet, recorded)
+	})
+}
@@ -136,6 +136,6 @@ func (f *InProcessRPCFetcher) FetchBlock(ctx context.Context, ledger uint64) (*p
 	return unmarshalStellarBlock(bstreamBlock)
 }
 
-// Close is a no-op for the rpc fetcher — there's no long-lived process
-// or connection to release. Provided to satisfy the Fetcher interface.
-func (f *InProcessRPCFetcher) Close() error { return nil }
+// Close stops the rpc fetcher's background statistics logging. There's
+// no long-lived process or connection to release.
+func (f *InProcessRPCFetcher) Close() error { return f.fetcher.Close() }
@@ -0,0 +1,161 @@
+// Package toid encodes and decodes total order ids (TOIDs), the 64 bits
+// ids Horizon and stellar-rpc give ledgers, transactions, operations and
+// events. A TOID packs, from the most significant bit:
+//
+//   - the ledger sequence, on 32 bits, the top one always 0 so that the
+//     TOID is a positive int64;
+//   - the transaction application order, on 20 bits, 1 for the first
+//     transaction of the ledger and 0 for the ledger itself;
+//   - the operation order, on 12 bits, 1 for the first operation of the
+//     transaction and 0 for the transaction itself.
+//
+// Sorting TOIDs sorts what they identify in the order the network
+// applied it. stellar-rpc event ids append the index of the event to the
+// TOID of the operation that emitted it, see EventID.
+package toid
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	LedgerBits      = 32
+	TransactionBits = 20
+	OperationBits   = 12
+
+	// MaxLedgerSequence keeps the top bit of a TOID to 0.
+	MaxLedgerSequence   = math.MaxInt32
+	MaxTransactionOrder = 1<<TransactionBits - 1
+	MaxOperationOrder   = 1<<OperationBits - 1
+)
+
+// ErrOverflow is returned when a part of an id does not fit its bits.
+var ErrOverflow = errors.New("toid overflow")
+
+// ID is a decoded TOID.
+type ID struct {
+	LedgerSequence   uint32
+	TransactionOrder uint32
+	OperationOrder   uint32
+}
+
+// New returns the id of the operation operationOrder of the transaction
+// transactionOrder of ledger ledgerSequence, failing with ErrOverflow when
+// one of them does not fit its bits.
+func New(ledgerSequence, transactionOrder, operationOrder uint32) (ID, error) {
+	id := ID{LedgerSequence: ledgerSequence, TransactionOrder: transactionOrder, OperationOrder: operationOrder}
+	return id, id.Validate()
+}
+
+// Ledger returns the id of a ledger.
+func Ledger(sequence uint32) (ID, error) {
+	return New(sequence, 0, 0)
+}
+
+// Transaction returns the id of a transaction from its application
+// order, 1-based like pbstellar.Transaction.ApplicationOrder.
+func Transaction(ledgerSequence, applicationOrder uint32) (ID, error) {
+	return New(ledgerSequence, applicationOrder, 0)
+}
+
+// Operation returns the Horizon id of an operation from its 0-based
+// index in the transaction envelope.
+func Operation(ledgerSequence, applicationOrder uint32, index int) (ID, error) {
+	if index < 0 || index >= MaxOperationOrder {
+		return ID{}, fmt.Errorf("operation index %d: %w", index, ErrOverflow)
+	}
+	return New(ledgerSequence, applicationOrder, uint32(index)+1)
+}
+
+// Validate fails with ErrOverflow when a part of id does not fit its bits.
+func (id ID) Validate() error {
+	if id.LedgerSequence > MaxLedgerSequence {
+		return fmt.Errorf("ledger sequence %d is over %d: %w", id.LedgerSequence, MaxLedgerSequence, ErrOverflow)
+	}
+	if id.TransactionOrder > MaxTransactionOrder {
+		return fmt.Errorf("transaction order %d is over %d: %w", id.TransactionOrder, MaxTransactionOrder, ErrOverflow)
+	}
+	if id.OperationOrder > MaxOperationOrder {
+		return fmt.Errorf("operation order %d is over %d: %w", id.OperationOrder, MaxOperationOrder, ErrOverflow)
+	}
+	return nil
+}
+
+// Uint64 encodes id, which must be valid.
+func (id ID) Uint64() uint64 {
+	return uint64(id.LedgerSequence)<<(TransactionBits+OperationBits) |
+		uint64(id.TransactionOrder)<<OperationBits |
+		uint64(id.OperationOrder)
+}
+
+// String encodes id in decimal, like Horizon ids and paging tokens.
+func (id ID) String() string {
+	return strconv.FormatUint(id.Uint64(), 10)
+}
+
+// FromUint64 decodes an encoded TOID, failing with ErrOverflow when its
+// top bit is set.
+func FromUint64(value uint64) (ID, error) {
+	if value > math.MaxInt64 {
+		return ID{}, fmt.Errorf("toid %d is over %d: %w", value, uint64(math.MaxInt64), ErrOverflow)
+	}
+	return ID{
+		LedgerSequence:   uint32(value >> (TransactionBits + OperationBits)),
+		TransactionOrder: uint32(value>>OperationBits) & MaxTransactionOrder,
+		OperationOrder:   uint32(value) & MaxOperationOrder,
+	}, nil
+}
+
+// Parse decodes a TOID in decimal, zero-padded or not.
+func Parse(value string) (ID, error) {
+	encoded, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return ID{}, fmt.Errorf("invalid toid %q: %w", value, err)
+	}
+	return FromUint64(encoded)
+}
+
+// EventID is the id stellar-rpc gives an event: the TOID of the
+// operation that emitted it, with a 0-based operation order unlike
+// Horizon's, and the index of the event among the ones of the
+// transaction.
+type EventID struct {
+	ID
+	EventIndex uint32
+}
+
+// Event returns the id of the event eventIndex emitted by the operation
+// at 0-based operationIndex of a transaction.
+func Event(ledgerSequence, applicationOrder, operationIndex, eventIndex uint32) (EventID, error) {
+	id, err := New(ledgerSequence, applicationOrder, operationIndex)
+	return EventID{ID: id, EventIndex: eventIndex}, err
+}
+
+// String encodes id like stellar-rpc, e.g. 0237311318360358912-0000000005.
+func (id EventID) String() string {
+	return fmt.Sprintf("%019d-%010d", id.Uint64(), id.EventIndex)
+}
+
+// ParseEventID decodes an event id. The event index may be omitted, as
+// in stellar-rpc cursors pointing at an operation.
+func ParseEventID(value string) (EventID, error) {
+	toid, index, hasIndex := strings.Cut(value, "-")
+	id, err := Parse(toid)
+	if err != nil {
+		return EventID{}, err
+	}
+
+	event := EventID{ID: id}
+	if hasIndex {
+		eventIndex, err := strconv.ParseUint(index, 10, 32)
+		if err != nil {
+			return EventID{}, fmt.Errorf("invalid event index %q: %w", index, err)
+		}
+		event.EventIndex = uint32(eventIndex)
+	}
+	return event, nil
+}
@@ -0,0 +1,80 @@
+package toid
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ID(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       ID
+		expected string
+	}{
+		{"ledger", ID{LedgerSequence: 1}, "4294967296"},
+		{"transaction", ID{LedgerSequence: 1, TransactionOrder: 1}, "4294971392"},
+		{"operation", ID{LedgerSequence: 1, TransactionOrder: 1, OperationOrder: 1}, "4294971393"},
+		{"pubnet transaction", ID{LedgerSequence: 55253347, TransactionOrder: 200}, "237311318360358912"},
+		{"largest", ID{LedgerSequence: MaxLedgerSequence, TransactionOrder: MaxTransactionOrder, OperationOrder: MaxOperationOrder}, "9223372036854775807"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, err := New(test.id.LedgerSequence, test.id.TransactionOrder, test.id.OperationOrder)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, id.String())
+
+			parsed, err := Parse(test.expected)
+			require.NoError(t, err)
+			require.Equal(t, test.id, parsed)
+
+			decoded, err := FromUint64(id.Uint64())
+			require.NoError(t, err)
+			require.Equal(t, test.id, decoded)
+		})
+	}
+}
+
+func Test_ID_Overflow(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"ledger", func() error { _, err := Ledger(MaxLedgerSequence + 1); return err }()},
+		{"transaction", func() error { _, err := Transaction(1, MaxTransactionOrder+1); return err }()},
+		{"operation", func() error { _, err := New(1, 1, MaxOperationOrder+1); return err }()},
+		{"operation index", func() error { _, err := Operation(1, 1, MaxOperationOrder); return err }()},
+		{"top bit", func() error { _, err := FromUint64(math.MaxInt64 + 1); return err }()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.ErrorIs(t, test.err, ErrOverflow)
+		})
+	}
+
+	id, err := Operation(1, 1, MaxOperationOrder-1)
+	require.NoError(t, err)
+	require.Equal(t, uint32(MaxOperationOrder), id.OperationOrder)
+}
+
+func Test_EventID(t *testing.T) {
+	id, err := Event(55253347, 200, 0, 5)
+	require.NoError(t, err)
+	require.Equal(t, "0237311318360358912-0000000005", id.String())
+
+	parsed, err := ParseEventID("0237311318360358912-0000000005")
+	require.NoError(t, err)
+	require.Equal(t, id, parsed)
+
+	parsed, err = ParseEventID("237311309769850881")
+	require.NoError(t, err)
+	require.Equal(t, EventID{ID: ID{LedgerSequence: 55253345, TransactionOrder: 60, OperationOrder: 1}}, parsed)
+
+	for _, invalid := range []string{"", "invalid-cursor", "1-", "1-x", "-1"} {
+		_, err := ParseEventID(invalid)
+		require.Error(t, err, invalid)
+	}
+}
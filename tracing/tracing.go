@@ -0,0 +1,201 @@
+// Package tracing traces each ledger through the fetchers with
+// OpenTelemetry: waiting for it, getting it, converting it, firing the
+// block and saving the cursor, so the latency between a ledger closing
+// and its block reaching firecore can be broken down.
+//
+// Every ledger is one trace, rooted at a "ledger" span the steps are
+// children of. Spans carry the ledger sequence and, once known, its
+// transaction count.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const instrumentationName = "github.com/streamingfast/firehose-stellar"
+
+// Span names.
+const (
+	SpanLedger        = "ledger"
+	SpanWaitForLedger = "wait for ledger"
+	SpanGetLedger     = "get ledger"
+	SpanConvert       = "convert"
+	SpanFireBlock     = "fire block"
+	SpanSaveCursor    = "save cursor"
+)
+
+// Span attributes.
+const (
+	LedgerSequence   = attribute.Key("stellar.ledger.sequence")
+	TransactionCount = attribute.Key("stellar.ledger.tx_count")
+	Backend          = attribute.Key("firestellar.backend")
+)
+
+// Exporters accepted by Setup.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Tracer returns the firestellar tracer. It goes through the global
+// tracer provider, a no-op one until Setup installs an exporter.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Setup installs the global tracer provider exporting to exporter:
+//
+//   - "none" (or empty) keeps tracing disabled;
+//   - "stdout" pretty-prints spans on stderr, stdout carries the blocks
+//     firecore reads;
+//   - "otlp" sends spans over OTLP/HTTP, configured by the standard
+//     OTEL_EXPORTER_OTLP_* environment variables (endpoint defaults to
+//     localhost:4318).
+//
+// sampleRatio is the fraction of ledgers traced. The returned func
+// flushes and stops the exporter.
+func Setup(ctx context.Context, exporter string, sampleRatio float64, logger *zap.Logger) (shutdown func(context.Context) error, err error) {
+	var spanExporter sdktrace.SpanExporter
+	switch exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr), stdouttrace.WithPrettyPrint())
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported tracing exporter %q (want %s|%s|%s)", exporter, ExporterNone, ExporterStdout, ExporterOTLP)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating %s tracing exporter: %w", exporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "firestellar"))),
+	)
+	otel.SetTracerProvider(provider)
+	logger.Info("tracing enabled", zap.String("exporter", exporter), zap.Float64("sample_ratio", sampleRatio))
+
+	return provider.Shutdown, nil
+}
+
+// Start starts the step name of ledger seq, a child of the ledger span
+// in ctx.
+func Start(ctx context.Context, name string, seq uint64) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(LedgerSequence.Int64(int64(seq))))
+}
+
+// End ends span, marking it failed when err is set.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// SetTransactionCount records on the spans of ctx, the step and its
+// ledger, how many transactions the ledger holds.
+func SetTransactionCount(ctx context.Context, ledger trace.Span, count int) {
+	attr := TransactionCount.Int(count)
+	trace.SpanFromContext(ctx).SetAttributes(attr)
+	ledger.SetAttributes(attr)
+}
+
+// maxOpenLedgers bounds the ledger spans Ledgers keeps, for ledgers
+// fetched but never fired, e.g. when the poller stops.
+const maxOpenLedgers = 1024
+
+// Ledgers holds the span of each ledger being fetched until its block
+// is fired and its cursor saved. Steps running without the fetch
+// context, like the block handler, find their parent span there. It is
+// safe for concurrent use.
+type Ledgers struct {
+	backend string
+
+	mu    sync.Mutex
+	spans map[uint64]trace.Span
+}
+
+// NewLedgers returns Ledgers labelling its spans with backend.
+func NewLedgers(backend string) *Ledgers {
+	return &Ledgers{backend: backend, spans: make(map[uint64]trace.Span)}
+}
+
+// Start starts the span of ledger seq. A span already open for seq, left
+// by a failed attempt, is ended first.
+func (l *Ledgers) Start(ctx context.Context, seq uint64) context.Context {
+	ctx, span := Tracer().Start(ctx, SpanLedger, trace.WithAttributes(
+		LedgerSequence.Int64(int64(seq)),
+		Backend.String(l.backend),
+	))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if previous, found := l.spans[seq]; found {
+		previous.End()
+	}
+	l.spans[seq] = span
+
+	if len(l.spans) > maxOpenLedgers {
+		open := make([]uint64, 0, len(l.spans))
+		for s := range l.spans {
+			open = append(open, s)
+		}
+		sort.Slice(open, func(i, j int) bool { return open[i] < open[j] })
+		for _, s := range open[:len(open)-maxOpenLedgers] {
+			l.spans[s].End()
+			delete(l.spans, s)
+		}
+	}
+
+	return ctx
+}
+
+// Span returns the open span of ledger seq, a no-op span when there is
+// none.
+func (l *Ledgers) Span(seq uint64) trace.Span {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if span, found := l.spans[seq]; found {
+		return span
+	}
+	return trace.SpanFromContext(context.Background())
+}
+
+// Context returns a context carrying the open span of ledger seq, to
+// start its steps from.
+func (l *Ledgers) Context(seq uint64) context.Context {
+	return trace.ContextWithSpan(context.Background(), l.Span(seq))
+}
+
+// End ends the span of ledger seq, marking it failed when err is set.
+func (l *Ledgers) End(seq uint64, err error) {
+	l.mu.Lock()
+	span, found := l.spans[seq]
+	delete(l.spans, seq)
+	l.mu.Unlock()
+
+	if found {
+		End(span, err)
+	}
+}
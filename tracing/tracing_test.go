@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, attr := range span.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	return attrs
+}
+
+func Test_Ledgers_Steps(t *testing.T) {
+	recorder := recordSpans(t)
+	ledgers := NewLedgers("test")
+
+	ctx := ledgers.Start(context.Background(), 100)
+	_, get := Start(ctx, SpanGetLedger, 100)
+	get.End()
+
+	convertCtx, convert := Start(ctx, SpanConvert, 100)
+	SetTransactionCount(convertCtx, ledgers.Span(100), 3)
+	convert.End()
+
+	// The block handler only knows the ledger sequence.
+	_, fire := Start(ledgers.Context(100), SpanFireBlock, 100)
+	End(fire, errors.New("boom"))
+	ledgers.End(100, nil)
+
+	ended := recorder.Ended()
+	require.Len(t, ended, 4)
+	root := ended[3]
+	require.Equal(t, SpanLedger, root.Name())
+	require.Equal(t, int64(100), attributes(root)[LedgerSequence].AsInt64())
+	require.Equal(t, "test", attributes(root)[Backend].AsString())
+	require.Equal(t, int64(3), attributes(root)[TransactionCount].AsInt64())
+
+	for i, name := range []string{SpanGetLedger, SpanConvert, SpanFireBlock} {
+		require.Equal(t, name, ended[i].Name())
+		require.Equal(t, root.SpanContext().SpanID(), ended[i].Parent().SpanID())
+		require.Equal(t, int64(100), attributes(ended[i])[LedgerSequence].AsInt64())
+	}
+	require.Equal(t, int64(3), attributes(ended[1])[TransactionCount].AsInt64())
+	require.Equal(t, codes.Error, ended[2].Status().Code)
+
+	// Nothing left open, ending again is a no-op.
+	ledgers.End(100, nil)
+	require.Len(t, recorder.Ended(), 4)
+	require.False(t, ledgers.Span(100).SpanContext().IsValid())
+}
+
+func Test_Ledgers_Bounded(t *testing.T) {
+	recorder := recordSpans(t)
+	ledgers := NewLedgers("test")
+
+	// A retried ledger ends the span of the failed attempt.
+	ledgers.Start(context.Background(), 1)
+	ledgers.Start(context.Background(), 1)
+	require.Len(t, recorder.Ended(), 1)
+
+	for seq := uint64(2); seq <= maxOpenLedgers+1; seq++ {
+		ledgers.Start(context.Background(), seq)
+	}
+	require.Len(t, recorder.Ended(), 2)
+	require.Equal(t, int64(1), attributes(recorder.Ended()[1])[LedgerSequence].AsInt64())
+	require.Len(t, ledgers.spans, maxOpenLedgers)
+}
+
+func Test_Setup(t *testing.T) {
+	shutdown, err := Setup(context.Background(), "", 1, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, shutdown(context.Background()))
+
+	_, err = Setup(context.Background(), "jaeger", 1, zap.NewNop())
+	require.EqualError(t, err, `unsupported tracing exporter "jaeger" (want none|stdout|otlp)`)
+
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	shutdown, err = Setup(context.Background(), ExporterStdout, 1, zap.NewNop())
+	require.NoError(t, err)
+	require.NotEqual(t, previous, otel.GetTracerProvider())
+	require.NoError(t, shutdown(context.Background()))
+}
@@ -0,0 +1,211 @@
+package txfilter
+
+import (
+	"fmt"
+
+	"github.com/stellar/go-stellar-sdk/xdr"
+	"github.com/streamingfast/firehose-stellar/decoder"
+	pbstellar "github.com/streamingfast/firehose-stellar/pb/sf/stellar/type/v1"
+)
+
+// facts is what the criteria of a Filter look at in a transaction.
+type facts struct {
+	accounts       map[string]bool
+	operationTypes []xdr.OperationType
+	contractIds    map[string]bool
+	eventTopics    map[string]bool
+	// assets are canonical, native or CODE:ISSUER.
+	assets []string
+}
+
+func collect(trx *pbstellar.Transaction) (*facts, error) {
+	f := &facts{
+		accounts:    map[string]bool{},
+		contractIds: map[string]bool{},
+		eventTopics: map[string]bool{},
+	}
+
+	var envelope xdr.TransactionEnvelope
+	if err := envelope.UnmarshalBinary(trx.EnvelopeXdr); err != nil {
+		return nil, fmt.Errorf("decoding envelope: %w", err)
+	}
+
+	source := envelope.SourceAccount()
+	f.addMuxedAccount(source)
+	if envelope.IsFeeBump() {
+		f.addMuxedAccount(envelope.FeeBumpAccount())
+	}
+	for _, operation := range envelope.Operations() {
+		operationSource := source
+		if operation.SourceAccount != nil {
+			operationSource = *operation.SourceAccount
+			f.addMuxedAccount(operationSource)
+		}
+		f.operationTypes = append(f.operationTypes, operation.Body.Type)
+		f.addOperation(operation.Body, operationSource.ToAccountId())
+	}
+
+	if trx.Events != nil {
+		for _, operationEvents := range trx.Events.ContractEventsXdr {
+			for _, raw := range operationEvents.Events {
+				var event xdr.ContractEvent
+				if err := event.UnmarshalBinary(raw); err != nil {
+					return nil, fmt.Errorf("decoding contract event: %w", err)
+				}
+				f.addEvent(event)
+			}
+		}
+	}
+
+	return f, nil
+}
+
+func (f *facts) addOperation(body xdr.OperationBody, source xdr.AccountId) {
+	switch body.Type {
+	case xdr.OperationTypeCreateAccount:
+		f.addAccountId(body.CreateAccountOp.Destination)
+		f.addAsset(xdr.MustNewNativeAsset())
+	case xdr.OperationTypePayment:
+		f.addMuxedAccount(body.PaymentOp.Destination)
+		f.addAsset(body.PaymentOp.Asset)
+	case xdr.OperationTypePathPaymentStrictReceive:
+		op := body.PathPaymentStrictReceiveOp
+		f.addMuxedAccount(op.Destination)
+		f.addAsset(op.SendAsset, op.DestAsset)
+		f.addAsset(op.Path...)
+	case xdr.OperationTypePathPaymentStrictSend:
+		op := body.PathPaymentStrictSendOp
+		f.addMuxedAccount(op.Destination)
+		f.addAsset(op.SendAsset, op.DestAsset)
+		f.addAsset(op.Path...)
+	case xdr.OperationTypeManageSellOffer:
+		f.addAsset(body.ManageSellOfferOp.Selling, body.ManageSellOfferOp.Buying)
+	case xdr.OperationTypeManageBuyOffer:
+		f.addAsset(body.ManageBuyOfferOp.Selling, body.ManageBuyOfferOp.Buying)
+	case xdr.OperationTypeCreatePassiveSellOffer:
+		f.addAsset(body.CreatePassiveSellOfferOp.Selling, body.CreatePassiveSellOfferOp.Buying)
+	case xdr.OperationTypeChangeTrust:
+		if line := body.ChangeTrustOp.Line; line.Type != xdr.AssetTypeAssetTypePoolShare {
+			f.addAsset(line.ToAsset())
+		} else if pool := line.LiquidityPool.ConstantProduct; pool != nil {
+			f.addAsset(pool.AssetA, pool.AssetB)
+		}
+	case xdr.OperationTypeAllowTrust:
+		f.addAccountId(body.AllowTrustOp.Trustor)
+		f.addAsset(body.AllowTrustOp.Asset.ToAsset(source))
+	case xdr.OperationTypeAccountMerge:
+		f.addMuxedAccount(*body.Destination)
+		f.addAsset(xdr.MustNewNativeAsset())
+	case xdr.OperationTypeCreateClaimableBalance:
+		for _, claimant := range body.CreateClaimableBalanceOp.Claimants {
+			if claimant.V0 != nil {
+				f.addAccountId(claimant.V0.Destination)
+			}
+		}
+		f.addAsset(body.CreateClaimableBalanceOp.Asset)
+	case xdr.OperationTypeBeginSponsoringFutureReserves:
+		f.addAccountId(body.BeginSponsoringFutureReservesOp.SponsoredId)
+	case xdr.OperationTypeRevokeSponsorship:
+		op := body.RevokeSponsorshipOp
+		if op.Signer != nil {
+			f.addAccountId(op.Signer.AccountId)
+		} else if op.LedgerKey != nil && op.LedgerKey.Account != nil {
+			f.addAccountId(op.LedgerKey.Account.AccountId)
+		}
+	case xdr.OperationTypeClawback:
+		f.addMuxedAccount(body.ClawbackOp.From)
+		f.addAsset(body.ClawbackOp.Asset)
+	case xdr.OperationTypeSetTrustLineFlags:
+		f.addAccountId(body.SetTrustLineFlagsOp.Trustor)
+		f.addAsset(body.SetTrustLineFlagsOp.Asset)
+	case xdr.OperationTypeInvokeHostFunction:
+		op := body.InvokeHostFunctionOp
+		if op.HostFunction.InvokeContract != nil {
+			f.addScAddress(op.HostFunction.InvokeContract.ContractAddress)
+		}
+		for _, auth := range op.Auth {
+			for _, credentials := range []*xdr.SorobanAddressCredentials{auth.Credentials.Address, auth.Credentials.AddressV2} {
+				if credentials != nil {
+					f.addScAddress(credentials.Address)
+				}
+			}
+			f.addInvocation(auth.RootInvocation)
+		}
+	}
+}
+
+// addInvocation adds the contracts of invocation and its
+// sub-invocations.
+func (f *facts) addInvocation(invocation xdr.SorobanAuthorizedInvocation) {
+	if invocation.Function.ContractFn != nil {
+		f.addScAddress(invocation.Function.ContractFn.ContractAddress)
+	}
+	for _, sub := range invocation.SubInvocations {
+		f.addInvocation(sub)
+	}
+}
+
+func (f *facts) addEvent(event xdr.ContractEvent) {
+	if event.ContractId != nil {
+		f.addScAddress(xdr.ScAddress{Type: xdr.ScAddressTypeScAddressTypeContract, ContractId: event.ContractId})
+	}
+	if event.Body.V0 == nil {
+		return
+	}
+	for _, topic := range event.Body.V0.Topics {
+		switch topic.Type {
+		case xdr.ScValTypeScvSymbol:
+			f.eventTopics[string(*topic.Sym)] = true
+		case xdr.ScValTypeScvString:
+			f.eventTopics[string(*topic.Str)] = true
+			// Stellar Asset Contract events end with their asset.
+			if validateAsset(string(*topic.Str)) == nil {
+				f.assets = append(f.assets, string(*topic.Str))
+			}
+		case xdr.ScValTypeScvAddress:
+			f.eventTopics[decoder.RenderScAddress(*topic.Address)] = true
+			// Participants of transfers, mints, burns and the like.
+			if topic.Address.Type != xdr.ScAddressTypeScAddressTypeContract {
+				f.addScAddress(*topic.Address)
+			}
+		default:
+			f.eventTopics[decoder.RenderScVal(topic)] = true
+		}
+	}
+}
+
+func (f *facts) addAccountId(id xdr.AccountId) {
+	if address, err := id.GetAddress(); err == nil {
+		f.accounts[address] = true
+	}
+}
+
+// addMuxedAccount adds the M... address of account, when muxed, and its
+// G... address.
+func (f *facts) addMuxedAccount(account xdr.MuxedAccount) {
+	if address, err := account.GetAddress(); err == nil {
+		f.accounts[address] = true
+	}
+	f.addAccountId(account.ToAccountId())
+}
+
+func (f *facts) addScAddress(address xdr.ScAddress) {
+	switch address.Type {
+	case xdr.ScAddressTypeScAddressTypeAccount:
+		f.addAccountId(*address.AccountId)
+	case xdr.ScAddressTypeScAddressTypeMuxedAccount:
+		muxed := address.MuxedAccount
+		f.addMuxedAccount(xdr.MuxedAccount{
+			Type:     xdr.CryptoKeyTypeKeyTypeMuxedEd25519,
+			Med25519: &xdr.MuxedAccountMed25519{Id: muxed.Id, Ed25519: muxed.Ed25519},
+		})
+	case xdr.ScAddressTypeScAddressTypeContract:
+		f.contractIds[decoder.RenderScAddress(address)] = true
+	}
+}
+
+func (f *facts) addAsset(assets ...xdr.Asset) {
+	for _, asset := range assets {
+		f.assets = append(f.assets, decoder.RenderAsset(asset))
+	}
+}
@@ -0,0 +1,220 @@
+// Package txfilter selects the transactions of Stellar blocks by hash,
+// account, operation type, contract, event topic, asset and status, for
+// tool-decode-block.
+package txfilter
+
+import (
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/stellar/go-stellar-sdk/strkey"
+	"github.com/stellar/go-stellar-sdk/xdr"
+	pbstellar "github.com/streamingfast/firehose-stellar/pb/sf/stellar/type/v1"
+)
+
+// Config lists the accepted values of each criterion. A transaction
+// matches a criterion when it matches one of its values, and a Filter
+// when it matches all its criteria; an empty criterion matches all.
+type Config struct {
+	// Hashes are hex-encoded transaction hashes.
+	Hashes []string
+	// Accounts are G... or M... addresses. A G... address matches its
+	// muxed accounts too, an M... address only itself. An account
+	// matches the transactions it is the source, fee source or operation
+	// source of, and the ones it takes part in: destinations, trustors,
+	// claimants, sponsored accounts, Soroban authorizers and addresses in
+	// the topics of contract events, like the from and to of a transfer.
+	Accounts []string
+	// OperationTypes are operation type names in snake_case, like
+	// payment or invoke_host_function.
+	OperationTypes []string
+	// ContractIds are C... addresses, matching the transactions invoking
+	// the contract, directly or in their authorized sub-invocations, and
+	// the ones in which it emitted an event.
+	ContractIds []string
+	// EventTopics match a contract event with a topic of that value:
+	// symbols and strings by their text, other values as rendered by
+	// decoder.RenderScVal.
+	EventTopics []string
+	// Assets are native, CODE:ISSUER, or CODE for that code from any
+	// issuer, matching the assets operations move, trade or trust, and
+	// the assets of Stellar Asset Contract events.
+	Assets []string
+	// Statuses are transaction statuses, success or failed.
+	Statuses []string
+}
+
+// Filter matches transactions against a Config.
+type Filter struct {
+	hashes         []string
+	accounts       []string
+	operationTypes []xdr.OperationType
+	contractIds    []string
+	eventTopics    []string
+	assets         []string
+	statuses       []pbstellar.TransactionStatus
+}
+
+// New validates config and returns its Filter.
+func New(config Config) (*Filter, error) {
+	filter := &Filter{eventTopics: config.EventTopics}
+
+	for _, hash := range config.Hashes {
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != 32 {
+			return nil, fmt.Errorf("transaction hash %q is not 32 hex-encoded bytes", hash)
+		}
+		filter.hashes = append(filter.hashes, strings.ToLower(hash))
+	}
+
+	for _, account := range config.Accounts {
+		if !strkey.IsValidEd25519PublicKey(account) && !strkey.IsValidMuxedAccountEd25519PublicKey(account) {
+			return nil, fmt.Errorf("account %q is not a G... or M... address", account)
+		}
+		filter.accounts = append(filter.accounts, account)
+	}
+
+	for _, name := range config.OperationTypes {
+		operationType, found := operationTypesByName[name]
+		if !found {
+			return nil, fmt.Errorf("unknown operation type %q, one of %s", name, strings.Join(operationTypeNames(), ", "))
+		}
+		filter.operationTypes = append(filter.operationTypes, operationType)
+	}
+
+	for _, contractId := range config.ContractIds {
+		if _, err := strkey.Decode(strkey.VersionByteContract, contractId); err != nil {
+			return nil, fmt.Errorf("contract id %q is not a C... address: %w", contractId, err)
+		}
+		filter.contractIds = append(filter.contractIds, contractId)
+	}
+
+	for _, asset := range config.Assets {
+		if err := validateAsset(asset); err != nil {
+			return nil, err
+		}
+		filter.assets = append(filter.assets, asset)
+	}
+
+	for _, status := range config.Statuses {
+		value, found := pbstellar.TransactionStatus_value[strings.ToUpper(status)]
+		if !found || value == int32(pbstellar.TransactionStatus_UNKNOWN) {
+			return nil, fmt.Errorf("unknown status %q, one of success, failed", status)
+		}
+		filter.statuses = append(filter.statuses, pbstellar.TransactionStatus(value))
+	}
+
+	return filter, nil
+}
+
+// IsEmpty tells whether the filter matches all transactions.
+func (f *Filter) IsEmpty() bool {
+	return len(f.hashes) == 0 && len(f.accounts) == 0 && len(f.operationTypes) == 0 && len(f.contractIds) == 0 &&
+		len(f.eventTopics) == 0 && len(f.assets) == 0 && len(f.statuses) == 0
+}
+
+// Match tells whether trx matches the filter, failing when its XDR
+// cannot be decoded.
+func (f *Filter) Match(trx *pbstellar.Transaction) (bool, error) {
+	if len(f.hashes) > 0 && !slices.Contains(f.hashes, hex.EncodeToString(trx.Hash)) {
+		return false, nil
+	}
+	if len(f.statuses) > 0 && !slices.Contains(f.statuses, trx.Status) {
+		return false, nil
+	}
+	if len(f.accounts) == 0 && len(f.operationTypes) == 0 && len(f.contractIds) == 0 && len(f.eventTopics) == 0 && len(f.assets) == 0 {
+		return true, nil
+	}
+
+	facts, err := collect(trx)
+	if err != nil {
+		return false, fmt.Errorf("transaction %x: %w", trx.Hash, err)
+	}
+
+	return matchAny(f.accounts, facts.accounts) &&
+		(len(f.operationTypes) == 0 || slices.ContainsFunc(f.operationTypes, func(operationType xdr.OperationType) bool {
+			return slices.Contains(facts.operationTypes, operationType)
+		})) &&
+		matchAny(f.contractIds, facts.contractIds) &&
+		matchAny(f.eventTopics, facts.eventTopics) &&
+		(len(f.assets) == 0 || slices.ContainsFunc(f.assets, func(asset string) bool {
+			return slices.ContainsFunc(facts.assets, func(candidate string) bool { return assetMatches(asset, candidate) })
+		})), nil
+}
+
+// matchAny tells whether wanted is empty or one of its values is in set.
+func matchAny(wanted []string, set map[string]bool) bool {
+	if len(wanted) == 0 {
+		return true
+	}
+	for _, value := range wanted {
+		if set[value] {
+			return true
+		}
+	}
+	return false
+}
+
+// assetMatches tells whether the canonical asset candidate matches the
+// filter value asset, a code alone matching any issuer.
+func assetMatches(asset, candidate string) bool {
+	if asset == candidate {
+		return true
+	}
+	code, _, found := strings.Cut(candidate, ":")
+	return found && !strings.Contains(asset, ":") && asset == code
+}
+
+func validateAsset(asset string) error {
+	if asset == "native" {
+		return nil
+	}
+	code, issuer, hasIssuer := strings.Cut(asset, ":")
+	if len(code) == 0 || len(code) > 12 || strings.IndexFunc(code, func(r rune) bool { return r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r) }) >= 0 {
+		return fmt.Errorf("asset %q is not native, CODE or CODE:ISSUER", asset)
+	}
+	if hasIssuer && !strkey.IsValidEd25519PublicKey(issuer) {
+		return fmt.Errorf("asset %q issuer is not a G... address", asset)
+	}
+	return nil
+}
+
+var operationTypesByName = func() map[string]xdr.OperationType {
+	byName := map[string]xdr.OperationType{}
+	for value := int32(0); xdr.OperationTypeCreateAccount.ValidEnum(value); value++ {
+		operationType := xdr.OperationType(value)
+		byName[OperationTypeName(operationType)] = operationType
+	}
+	return byName
+}()
+
+// OperationTypeName returns the snake_case name of operationType, like
+// Horizon's, e.g. path_payment_strict_send.
+func OperationTypeName(operationType xdr.OperationType) string {
+	return snakeCase(strings.TrimPrefix(operationType.String(), "OperationType"))
+}
+
+func operationTypeNames() []string {
+	names := make([]string, 0, len(operationTypesByName))
+	for name := range operationTypesByName {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func snakeCase(name string) string {
+	var out strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				out.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
@@ -0,0 +1,185 @@
+package txfilter
+
+import (
+	"testing"
+
+	"github.com/stellar/go-stellar-sdk/strkey"
+	"github.com/stellar/go-stellar-sdk/xdr"
+	pbstellar "github.com/streamingfast/firehose-stellar/pb/sf/stellar/type/v1"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testAccount  = "GAAZI4TCR3TY5OJHCTJC2A4QSY6CJWJH5IAJTGKIN2ER7LBNVKOCCWN7"
+	testIssuer   = "GBRPYHIL2CI3FNQ4BXLFMNDLFJUNPU2HY3ZMFSHONUCEOASW7QC7OX2H"
+	testContract = "CA3D5KRYM6CB7OWQ6TWYRR3Z4T7GNZLKERYNZGGA5SOAOPIFY6YQGAXE"
+	testHash     = "aa00000000000000000000000000000000000000000000000000000000000000"
+)
+
+func testContractId(t *testing.T) xdr.ContractId {
+	raw, err := strkey.Decode(strkey.VersionByteContract, testContract)
+	require.NoError(t, err)
+	var id xdr.ContractId
+	copy(id[:], raw)
+	return id
+}
+
+// muxedIssuer returns testIssuer muxed with id.
+func muxedIssuer(t *testing.T, id uint64) xdr.MuxedAccount {
+	muxed, err := xdr.MuxedAccountFromAccountId(testIssuer, id)
+	require.NoError(t, err)
+	return muxed
+}
+
+func transaction(t *testing.T, source xdr.MuxedAccount, operations []xdr.Operation, events ...xdr.ContractEvent) *pbstellar.Transaction {
+	envelope := xdr.TransactionEnvelope{
+		Type: xdr.EnvelopeTypeEnvelopeTypeTx,
+		V1: &xdr.TransactionV1Envelope{Tx: xdr.Transaction{
+			SourceAccount: source,
+			Operations:    operations,
+		}},
+	}
+	envelopeXdr, err := envelope.MarshalBinary()
+	require.NoError(t, err)
+
+	var hash [32]byte
+	hash[0] = 0xaa
+	trx := &pbstellar.Transaction{
+		Hash:        hash[:],
+		Status:      pbstellar.TransactionStatus_SUCCESS,
+		EnvelopeXdr: envelopeXdr,
+		Events:      &pbstellar.Events{},
+	}
+	for _, event := range events {
+		raw, err := event.MarshalBinary()
+		require.NoError(t, err)
+		trx.Events.ContractEventsXdr = append(trx.Events.ContractEventsXdr, &pbstellar.ContractEvent{Events: [][]byte{raw}})
+	}
+	return trx
+}
+
+func payment(t *testing.T) xdr.Operation {
+	var destination xdr.MuxedAccount
+	require.NoError(t, destination.SetEd25519Address(testAccount))
+	return xdr.Operation{Body: xdr.OperationBody{
+		Type: xdr.OperationTypePayment,
+		PaymentOp: &xdr.PaymentOp{
+			Destination: destination,
+			Asset:       xdr.MustNewCreditAsset("USDC", testIssuer),
+			Amount:      100,
+		},
+	}}
+}
+
+func invokeContract(t *testing.T) xdr.Operation {
+	id := testContractId(t)
+	return xdr.Operation{Body: xdr.OperationBody{
+		Type: xdr.OperationTypeInvokeHostFunction,
+		InvokeHostFunctionOp: &xdr.InvokeHostFunctionOp{HostFunction: xdr.HostFunction{
+			Type: xdr.HostFunctionTypeHostFunctionTypeInvokeContract,
+			InvokeContract: &xdr.InvokeContractArgs{
+				ContractAddress: xdr.ScAddress{Type: xdr.ScAddressTypeScAddressTypeContract, ContractId: &id},
+				FunctionName:    "transfer",
+			},
+		}},
+	}}
+}
+
+// transferEvent is a Stellar Asset Contract transfer of native to
+// testAccount.
+func transferEvent(t *testing.T) xdr.ContractEvent {
+	id := testContractId(t)
+	account := xdr.MustAddress(testAccount)
+	symbol := xdr.ScSymbol("transfer")
+	asset := xdr.ScString("native")
+	return xdr.ContractEvent{
+		ContractId: &id,
+		Type:       xdr.ContractEventTypeContract,
+		Body: xdr.ContractEventBody{V0: &xdr.ContractEventV0{
+			Topics: []xdr.ScVal{
+				{Type: xdr.ScValTypeScvSymbol, Sym: &symbol},
+				{Type: xdr.ScValTypeScvAddress, Address: &xdr.ScAddress{Type: xdr.ScAddressTypeScAddressTypeAccount, AccountId: &account}},
+				{Type: xdr.ScValTypeScvString, Str: &asset},
+			},
+			Data: xdr.ScVal{Type: xdr.ScValTypeScvVoid},
+		}},
+	}
+}
+
+func Test_New(t *testing.T) {
+	for _, test := range []struct {
+		config        Config
+		expectedError string
+	}{
+		{Config{Hashes: []string{"aa"}}, `transaction hash "aa" is not 32 hex-encoded bytes`},
+		{Config{Accounts: []string{testContract}}, "is not a G... or M... address"},
+		{Config{OperationTypes: []string{"pay"}}, `unknown operation type "pay", one of account_merge, allow_trust,`},
+		{Config{ContractIds: []string{testAccount}}, "is not a C... address"},
+		{Config{Assets: []string{"USDC:" + testContract}}, "issuer is not a G... address"},
+		{Config{Assets: []string{"TOOLONGASSETCODE"}}, "is not native, CODE or CODE:ISSUER"},
+		{Config{Statuses: []string{"unknown"}}, `unknown status "unknown"`},
+	} {
+		_, err := New(test.config)
+		require.ErrorContains(t, err, test.expectedError)
+	}
+
+	filter, err := New(Config{})
+	require.NoError(t, err)
+	require.True(t, filter.IsEmpty())
+}
+
+func Test_OperationTypeName(t *testing.T) {
+	require.Equal(t, "path_payment_strict_send", OperationTypeName(xdr.OperationTypePathPaymentStrictSend))
+	require.Equal(t, "invoke_host_function", OperationTypeName(xdr.OperationTypeInvokeHostFunction))
+}
+
+func Test_Filter_Match(t *testing.T) {
+	muxedSource, otherMuxedSource := muxedIssuer(t, 7), muxedIssuer(t, 8)
+	paymentTrx := transaction(t, muxedSource, []xdr.Operation{payment(t)})
+	invokeTrx := transaction(t, xdr.MustMuxedAddress(testIssuer), []xdr.Operation{invokeContract(t)}, transferEvent(t))
+	invokeTrx.Status = pbstellar.TransactionStatus_FAILED
+
+	for _, test := range []struct {
+		name            string
+		config          Config
+		expectedMatches []bool
+	}{
+		{"empty", Config{}, []bool{true, true}},
+		{"hash", Config{Hashes: []string{testHash}}, []bool{true, true}},
+		{"other hash", Config{Hashes: []string{"bb" + testHash[2:]}}, []bool{false, false}},
+		{"status", Config{Statuses: []string{"failed"}}, []bool{false, true}},
+		{"source account", Config{Accounts: []string{testIssuer}}, []bool{true, true}},
+		{"muxed source account", Config{Accounts: []string{muxedSource.Address()}}, []bool{true, false}},
+		{"other muxed source account", Config{Accounts: []string{otherMuxedSource.Address()}}, []bool{false, false}},
+		{"destination and event participant", Config{Accounts: []string{testAccount}}, []bool{true, true}},
+		{"operation type", Config{OperationTypes: []string{"payment"}}, []bool{true, false}},
+		{"operation types", Config{OperationTypes: []string{"payment", "invoke_host_function"}}, []bool{true, true}},
+		{"contract id", Config{ContractIds: []string{testContract}}, []bool{false, true}},
+		{"event topic", Config{EventTopics: []string{"transfer"}}, []bool{false, true}},
+		{"address event topic", Config{EventTopics: []string{testAccount}}, []bool{false, true}},
+		{"asset", Config{Assets: []string{"USDC:" + testIssuer}}, []bool{true, false}},
+		{"asset code", Config{Assets: []string{"USDC"}}, []bool{true, false}},
+		{"event asset", Config{Assets: []string{"native"}}, []bool{false, true}},
+		{"and", Config{Accounts: []string{testAccount}, OperationTypes: []string{"payment"}}, []bool{true, false}},
+		{"and mismatch", Config{Assets: []string{"USDC"}, Statuses: []string{"failed"}}, []bool{false, false}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			filter, err := New(test.config)
+			require.NoError(t, err)
+
+			for i, trx := range []*pbstellar.Transaction{paymentTrx, invokeTrx} {
+				matched, err := filter.Match(trx)
+				require.NoError(t, err)
+				require.Equal(t, test.expectedMatches[i], matched, "transaction %d", i)
+			}
+		})
+	}
+}
+
+func Test_Filter_Match_InvalidEnvelope(t *testing.T) {
+	filter, err := New(Config{OperationTypes: []string{"payment"}})
+	require.NoError(t, err)
+
+	_, err = filter.Match(&pbstellar.Transaction{Hash: []byte{0xaa}, EnvelopeXdr: []byte{0x01}})
+	require.ErrorContains(t, err, "transaction aa: decoding envelope")
+}
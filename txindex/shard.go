@@ -0,0 +1,409 @@
+package txindex
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A transaction is in the shard file of the first ShardBits bits of its
+// hash, one of ShardCount.
+const (
+	ShardBits  = 12
+	ShardCount = 1 << ShardBits
+)
+
+const (
+	shardMagic      = "FSTXSHD1"
+	shardHeaderSize = len(shardMagic) + 2 + 4
+	shardEntrySize  = hashSize + 8 + 4
+	shardBlockStart = hashSize
+	shardPosStart   = hashSize + 8
+)
+
+// ShardOf returns the shard of the transaction hash.
+func ShardOf(hash []byte) int {
+	return int(binary.BigEndian.Uint16(hash) >> (16 - ShardBits))
+}
+
+// ShardFileName returns the name of the file of shard.
+func ShardFileName(shard int) string {
+	return fmt.Sprintf("shards/%03x%s", shard, Extension)
+}
+
+// SegmentFileName returns the name of the segment of shard UpdateShards
+// writes for the bundles from first to last, their first blocks.
+func SegmentFileName(shard int, first, last uint64) string {
+	return fmt.Sprintf("%s%010d-%010d%s", segmentPrefix(shard), first, last, Extension)
+}
+
+func segmentPrefix(shard int) string {
+	return fmt.Sprintf("shards/%03x/", shard)
+}
+
+// CompactionLockFileName is the file CompactShards holds in the store
+// while it runs.
+const CompactionLockFileName = "shards/compact.lock"
+
+// ErrCompactionLocked is returned by CompactShards when the store holds
+// a CompactionLockFileName.
+var ErrCompactionLocked = errors.New("another compaction holds the index")
+
+// Shard is a parsed shard file: its entries are the 32 bytes of the
+// hash, the block number, uint64 big-endian, and the position of the
+// transaction, uint32 big-endian, sorted by hash.
+type Shard struct {
+	shard   int
+	entries []byte
+}
+
+// ParseShard parses the shard file data.
+func ParseShard(data []byte) (*Shard, error) {
+	if len(data) < shardHeaderSize || string(data[:len(shardMagic)]) != shardMagic {
+		return nil, fmt.Errorf("not a transaction index shard file")
+	}
+	shard := int(binary.BigEndian.Uint16(data[len(shardMagic):]))
+	count := binary.BigEndian.Uint32(data[len(shardMagic)+2:])
+	if expected := shardHeaderSize + int(count)*shardEntrySize; len(data) != expected {
+		return nil, fmt.Errorf("transaction index shard %03x is %d bytes, expected %d for %d entries", shard, len(data), expected, count)
+	}
+	return &Shard{shard: shard, entries: data[shardHeaderSize:]}, nil
+}
+
+// Len returns the number of transactions of the shard.
+func (s *Shard) Len() int {
+	return len(s.entries) / shardEntrySize
+}
+
+// Lookup returns the location of the transaction hash, false when it is
+// not in the shard.
+func (s *Shard) Lookup(hash []byte) (Location, bool) {
+	if len(hash) != hashSize {
+		return Location{}, false
+	}
+	n := sort.Search(s.Len(), func(n int) bool {
+		return bytes.Compare(s.entry(n)[:hashSize], hash) >= 0
+	})
+	if n == s.Len() || !bytes.Equal(s.entry(n)[:hashSize], hash) {
+		return Location{}, false
+	}
+	entry := s.entry(n)
+	return Location{
+		BlockNum: binary.BigEndian.Uint64(entry[shardBlockStart:]),
+		Position: binary.BigEndian.Uint32(entry[shardPosStart:]),
+	}, true
+}
+
+func (s *Shard) entry(n int) []byte {
+	return s.entries[n*shardEntrySize : (n+1)*shardEntrySize]
+}
+
+func (s *Shard) all() [][]byte {
+	out := make([][]byte, s.Len())
+	for n := range out {
+		out[n] = s.entry(n)
+	}
+	return out
+}
+
+// shardBytes returns the shard file of entries, sorted by hash.
+func shardBytes(shard int, entries [][]byte) []byte {
+	out := make([]byte, shardHeaderSize, shardHeaderSize+len(entries)*shardEntrySize)
+	copy(out, shardMagic)
+	binary.BigEndian.PutUint16(out[len(shardMagic):], uint16(shard))
+	binary.BigEndian.PutUint32(out[len(shardMagic)+2:], uint32(len(entries)))
+	for _, entry := range entries {
+		out = append(out, entry...)
+	}
+	return out
+}
+
+// shardEntries returns the entries of the transactions of indexes by
+// shard, sorted by hash.
+func shardEntries(indexes []*Index) map[int][][]byte {
+	out := map[int][][]byte{}
+	for _, index := range indexes {
+		for n := range index.Len() {
+			hash, location := index.at(n)
+			entry := make([]byte, shardEntrySize)
+			copy(entry, hash)
+			binary.BigEndian.PutUint64(entry[shardBlockStart:], location.BlockNum)
+			binary.BigEndian.PutUint32(entry[shardPosStart:], location.Position)
+			shard := ShardOf(hash)
+			out[shard] = append(out[shard], entry)
+		}
+	}
+	for _, entries := range out {
+		slices.SortFunc(entries, compareEntries)
+	}
+	return out
+}
+
+func compareEntries(a, b []byte) int {
+	return bytes.Compare(a[:hashSize], b[:hashSize])
+}
+
+// mergeEntries merges the sorted entries of added into the sorted ones
+// of existing, those of added replacing the existing ones of the same
+// hash, so that indexing a bundle again does not duplicate it.
+func mergeEntries(existing, added [][]byte) [][]byte {
+	out := make([][]byte, 0, len(existing)+len(added))
+	for len(existing) > 0 && len(added) > 0 {
+		switch cmp := compareEntries(existing[0], added[0]); {
+		case cmp < 0:
+			out, existing = append(out, existing[0]), existing[1:]
+		case cmp > 0:
+			out, added = append(out, added[0]), added[1:]
+		default:
+			out, existing, added = append(out, added[0]), existing[1:], added[1:]
+		}
+	}
+	out = append(out, existing...)
+	return append(out, added...)
+}
+
+// ReadShard reads shard from store: its file merged with its segments,
+// empty when store holds neither yet.
+func ReadShard(ctx context.Context, store ObjectStore, shard int) (*Shard, error) {
+	parsed, _, err := readShard(ctx, store, shard)
+	return parsed, err
+}
+
+// readShard returns shard and the names of its segments, oldest first.
+func readShard(ctx context.Context, store ObjectStore, shard int) (*Shard, []string, error) {
+	file, err := readShardFile(ctx, store, shard, ShardFileName(shard))
+	if err != nil {
+		return nil, nil, err
+	}
+	segments, err := listSegments(ctx, store, shard)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(segments) == 0 {
+		return file, nil, nil
+	}
+	entries := file.all()
+	for _, name := range segments {
+		segment, err := readShardFile(ctx, store, shard, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = mergeEntries(entries, segment.all())
+	}
+	return &Shard{shard: shard, entries: slices.Concat(entries...)}, segments, nil
+}
+
+// listSegments returns the names of the segments of shard in store,
+// oldest first.
+func listSegments(ctx context.Context, store ObjectStore, shard int) ([]string, error) {
+	prefix := segmentPrefix(shard)
+	var segments []string
+	err := store.Walk(ctx, prefix, func(filename string) error {
+		if strings.HasPrefix(filename, prefix) && strings.HasSuffix(filename, Extension) {
+			segments = append(segments, filename)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing the segments of shard %03x: %w", shard, err)
+	}
+	slices.Sort(segments)
+	return segments, nil
+}
+
+// readShardFile reads the shard file name of shard, empty when store does
+// not hold it.
+func readShardFile(ctx context.Context, store ObjectStore, shard int, name string) (*Shard, error) {
+	exists, err := store.FileExists(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("checking %s: %w", name, err)
+	}
+	if !exists {
+		return &Shard{shard: shard}, nil
+	}
+
+	reader, err := store.OpenObject(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	parsed, err := ParseShard(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", name, err)
+	}
+	if parsed.shard != shard {
+		return nil, fmt.Errorf("parsing %s: holds shard %03x", name, parsed.shard)
+	}
+	return parsed, nil
+}
+
+// UpdateShards adds the transactions of indexes to the shards of store:
+// it writes a segment of each shard they touch, concurrency at a time,
+// named after the first and last bundles of indexes, see
+// SegmentFileName. Existing shard files are neither read nor rewritten,
+// so updates over other bundles may run at the same time, and updating
+// the same bundles again replaces their segments. A lookup reads every
+// segment of its shard, so they are merged back into the shard files
+// with CompactShards.
+func UpdateShards(ctx context.Context, store ObjectStore, indexes []*Index, concurrency int) error {
+	if len(indexes) == 0 {
+		return nil
+	}
+	first, last := indexes[0].Base(), indexes[0].Base()
+	for _, index := range indexes {
+		first, last = min(first, index.Base()), max(last, index.Base())
+	}
+
+	byShard := shardEntries(indexes)
+	return forEachShard(ctx, concurrency, func(shard int) bool {
+		return len(byShard[shard]) > 0
+	}, func(ctx context.Context, shard int) error {
+		name := SegmentFileName(shard, first, last)
+		if err := store.WriteObject(ctx, name, bytes.NewReader(shardBytes(shard, byShard[shard]))); err != nil {
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// CompactShards merges the segments of each shard of store into its
+// shard file, concurrency shards at a time, and returns the number of
+// segments merged. It holds CompactionLockFileName while it runs and
+// fails with ErrCompactionLocked when another compaction does, or died
+// holding it. dstore has no conditional write, so two compactions
+// starting at the same time may both take the lock: a segment written
+// by one update after the other compaction read it would then be lost.
+//
+// Updates may run at the same time, their segments are merged by the
+// next compaction, except updates indexing bundles again: the segment
+// they replace would be deleted.
+func CompactShards(ctx context.Context, store ObjectStore, concurrency int) (int, error) {
+	exists, err := store.FileExists(ctx, CompactionLockFileName)
+	if err != nil {
+		return 0, fmt.Errorf("checking %s: %w", CompactionLockFileName, err)
+	}
+	if exists {
+		return 0, fmt.Errorf("%w: %s, delete it if that compaction died", ErrCompactionLocked, describeLock(ctx, store))
+	}
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("pid %d on %s since %s", os.Getpid(), hostname, time.Now().UTC().Format(time.RFC3339))
+	if err := store.WriteObject(ctx, CompactionLockFileName, strings.NewReader(owner)); err != nil {
+		return 0, fmt.Errorf("writing %s: %w", CompactionLockFileName, err)
+	}
+
+	var (
+		lock   sync.Mutex
+		merged int
+	)
+	err = forEachShard(ctx, concurrency, func(int) bool { return true }, func(ctx context.Context, shard int) error {
+		count, err := compactShard(ctx, store, shard)
+		lock.Lock()
+		merged += count
+		lock.Unlock()
+		return err
+	})
+	// A failed compaction leaves its segments, releasing the lock is
+	// safe.
+	if deleteErr := store.DeleteObject(context.WithoutCancel(ctx), CompactionLockFileName); deleteErr != nil && err == nil {
+		err = fmt.Errorf("deleting %s: %w", CompactionLockFileName, deleteErr)
+	}
+	return merged, err
+}
+
+// describeLock returns the owner recorded in the CompactionLockFileName
+// of store.
+func describeLock(ctx context.Context, store ObjectStore) string {
+	reader, err := store.OpenObject(ctx, CompactionLockFileName)
+	if err != nil {
+		return CompactionLockFileName
+	}
+	defer reader.Close()
+	owner, err := io.ReadAll(reader)
+	if err != nil || len(owner) == 0 {
+		return CompactionLockFileName
+	}
+	return fmt.Sprintf("%s held by %s", CompactionLockFileName, owner)
+}
+
+// compactShard writes the file of shard merged with its segments, then
+// deletes them, and returns their number.
+func compactShard(ctx context.Context, store ObjectStore, shard int) (int, error) {
+	merged, segments, err := readShard(ctx, store, shard)
+	if err != nil || len(segments) == 0 {
+		return 0, err
+	}
+	name := ShardFileName(shard)
+	if err := store.WriteObject(ctx, name, bytes.NewReader(shardBytes(shard, merged.all()))); err != nil {
+		return 0, fmt.Errorf("writing %s: %w", name, err)
+	}
+	for _, segment := range segments {
+		if err := store.DeleteObject(ctx, segment); err != nil {
+			return 0, fmt.Errorf("deleting %s: %w", segment, err)
+		}
+	}
+	return len(segments), nil
+}
+
+// forEachShard calls f for the shards include returns true for,
+// concurrency at a time, and returns the first error, canceling the ctx
+// of the others.
+func forEachShard(ctx context.Context, concurrency int, include func(shard int) bool, f func(ctx context.Context, shard int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	work := make(chan int)
+	var (
+		lock     sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for range max(concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shard := range work {
+				if err := f(ctx, shard); err != nil {
+					lock.Lock()
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+
+feed:
+	for shard := range ShardCount {
+		if !include(shard) {
+			continue
+		}
+		select {
+		case work <- shard:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
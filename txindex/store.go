@@ -0,0 +1,85 @@
+package txindex
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotFound is returned by Find when the index does not hold the
+// transaction.
+var ErrNotFound = errors.New("transaction not found in index")
+
+// ObjectStore is the part of dstore.Store the index files are read from
+// and written to.
+type ObjectStore interface {
+	OpenObject(ctx context.Context, name string) (io.ReadCloser, error)
+	WriteObject(ctx context.Context, base string, f io.Reader) error
+	FileExists(ctx context.Context, base string) (bool, error)
+	DeleteObject(ctx context.Context, base string) error
+	Walk(ctx context.Context, prefix string, f func(filename string) error) error
+}
+
+// Write writes the index file of builder to store, replacing the one of
+// the same bundle.
+func Write(ctx context.Context, store ObjectStore, builder *Builder) error {
+	name := FileName(builder.Base())
+	if err := store.WriteObject(ctx, name, bytes.NewReader(builder.Bytes())); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	return nil
+}
+
+// Exists tells whether store holds the index file of the bundle starting
+// at base, which a rebuild of the index can skip: it is written once the
+// transactions of the bundle are in the shard files.
+func Exists(ctx context.Context, store ObjectStore, base uint64) (bool, error) {
+	exists, err := store.FileExists(ctx, FileName(base))
+	if err != nil {
+		return false, fmt.Errorf("checking %s: %w", FileName(base), err)
+	}
+	return exists, nil
+}
+
+// Read reads the index file of the bundle starting at base from store.
+func Read(ctx context.Context, store ObjectStore, base uint64) (*Index, error) {
+	return readFile(ctx, store, FileName(base))
+}
+
+func readFile(ctx context.Context, store ObjectStore, name string) (*Index, error) {
+	reader, err := store.OpenObject(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	index, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", name, err)
+	}
+	return index, nil
+}
+
+// Find looks the transaction hash up in store and returns its location,
+// or ErrNotFound. It reads the shard of the hash, its file and its
+// segments, see UpdateShards.
+func Find(ctx context.Context, store ObjectStore, hash []byte) (Location, error) {
+	if len(hash) != hashSize {
+		return Location{}, fmt.Errorf("transaction hash %x is %d bytes, expected %d", hash, len(hash), hashSize)
+	}
+	shard, err := ReadShard(ctx, store, ShardOf(hash))
+	if err != nil {
+		return Location{}, err
+	}
+	location, found := shard.Lookup(hash)
+	if !found {
+		return Location{}, ErrNotFound
+	}
+	return location, nil
+}
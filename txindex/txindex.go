@@ -0,0 +1,190 @@
+// Package txindex maps transaction hashes to the block and position of
+// their transaction, so that a transaction is found without scanning the
+// bundles.
+//
+// Transactions are indexed one merged-blocks bundle at a time into a
+// bundle index file, then added to the shard of their hash as a segment
+// of the batch of bundles, see UpdateShards, which CompactShards later
+// merges into the shard file. A lookup reads the shard file of the hash
+// and its segments, see Find.
+//
+// An index file is named after the first block of its bundle, like the
+// bundle, with the .txidx extension: 0060000000.txidx. It holds:
+//
+//   - the magic "FSTXIDX1";
+//   - the first block of the bundle, uint64 big-endian;
+//   - the number of entries, uint32 big-endian;
+//   - the entries, sorted by hash, of 38 bytes each: the 32 bytes of the
+//     hash, the offset of the block in the bundle, uint16 big-endian, and
+//     the position of the transaction in pbstellar.Block.Transactions,
+//     uint32 big-endian.
+//
+// Shard files, shards/000.txidx to shards/fff.txidx, are laid out the
+// same way with the magic "FSTXSHD1", the shard, uint16 big-endian, and
+// entries of 44 bytes holding the block number as a uint64. So are their
+// segments, shards/000/0060000000-0060099900.txidx, named after the
+// first and last bundles of their batch. Entries being sorted and
+// fixed-size, Index.Lookup and Shard.Lookup are binary searches over the
+// file as read.
+package txindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BundleSize is the number of blocks of a merged-blocks bundle.
+const BundleSize = 100
+
+// Extension is the extension of index files.
+const Extension = ".txidx"
+
+const (
+	magic       = "FSTXIDX1"
+	headerSize  = len(magic) + 8 + 4
+	hashSize    = 32
+	entrySize   = hashSize + 2 + 4
+	maxEntries  = 1<<32 - 1
+	offsetStart = hashSize
+	posStart    = hashSize + 2
+)
+
+// Location is where a transaction is: the number of its block and its
+// position in the transactions of the block.
+type Location struct {
+	BlockNum uint64
+	Position uint32
+}
+
+// FileName returns the name of the index file of the bundle starting at
+// base.
+func FileName(base uint64) string {
+	return fmt.Sprintf("%010d%s", base, Extension)
+}
+
+// ParseFileName returns the first block of the bundle of the index file
+// name, false when name is not one.
+func ParseFileName(name string) (uint64, bool) {
+	digits, found := strings.CutSuffix(name, Extension)
+	if !found || len(digits) != 10 {
+		return 0, false
+	}
+	base, err := strconv.ParseUint(digits, 10, 64)
+	return base, err == nil && base%BundleSize == 0
+}
+
+// Builder collects the transactions of a bundle into an index file.
+type Builder struct {
+	base    uint64
+	entries [][]byte
+}
+
+// NewBuilder returns a Builder for the bundle starting at base.
+func NewBuilder(base uint64) (*Builder, error) {
+	if base%BundleSize != 0 {
+		return nil, fmt.Errorf("block %d does not start a bundle of %d blocks", base, BundleSize)
+	}
+	return &Builder{base: base}, nil
+}
+
+// Base returns the first block of the bundle.
+func (b *Builder) Base() uint64 {
+	return b.base
+}
+
+// Add indexes the transaction hash at position in block blockNum.
+func (b *Builder) Add(hash []byte, blockNum uint64, position uint32) error {
+	if len(hash) != hashSize {
+		return fmt.Errorf("transaction hash %x is %d bytes, expected %d", hash, len(hash), hashSize)
+	}
+	if blockNum < b.base || blockNum >= b.base+BundleSize {
+		return fmt.Errorf("block %d is not in the bundle of blocks %d to %d", blockNum, b.base, b.base+BundleSize-1)
+	}
+	if uint64(len(b.entries)) == maxEntries {
+		return fmt.Errorf("bundle %d has more than %d transactions", b.base, maxEntries)
+	}
+
+	entry := make([]byte, entrySize)
+	copy(entry, hash)
+	binary.BigEndian.PutUint16(entry[offsetStart:], uint16(blockNum-b.base))
+	binary.BigEndian.PutUint32(entry[posStart:], position)
+	b.entries = append(b.entries, entry)
+	return nil
+}
+
+// Bytes returns the index file of the transactions added so far.
+func (b *Builder) Bytes() []byte {
+	slices.SortFunc(b.entries, func(a, b []byte) int { return bytes.Compare(a[:hashSize], b[:hashSize]) })
+
+	out := make([]byte, headerSize, headerSize+len(b.entries)*entrySize)
+	copy(out, magic)
+	binary.BigEndian.PutUint64(out[len(magic):], b.base)
+	binary.BigEndian.PutUint32(out[len(magic)+8:], uint32(len(b.entries)))
+	for _, entry := range b.entries {
+		out = append(out, entry...)
+	}
+	return out
+}
+
+// Index is a parsed index file.
+type Index struct {
+	base    uint64
+	entries []byte
+}
+
+// Parse parses the index file data.
+func Parse(data []byte) (*Index, error) {
+	if len(data) < headerSize || string(data[:len(magic)]) != magic {
+		return nil, fmt.Errorf("not a transaction index file")
+	}
+	base := binary.BigEndian.Uint64(data[len(magic):])
+	count := binary.BigEndian.Uint32(data[len(magic)+8:])
+	if expected := headerSize + int(count)*entrySize; len(data) != expected {
+		return nil, fmt.Errorf("transaction index of bundle %d is %d bytes, expected %d for %d entries", base, len(data), expected, count)
+	}
+	return &Index{base: base, entries: data[headerSize:]}, nil
+}
+
+// Base returns the first block of the bundle.
+func (i *Index) Base() uint64 {
+	return i.base
+}
+
+// Len returns the number of transactions of the bundle.
+func (i *Index) Len() int {
+	return len(i.entries) / entrySize
+}
+
+// Lookup returns the location of the transaction hash, false when it is
+// not in the bundle.
+func (i *Index) Lookup(hash []byte) (Location, bool) {
+	if len(hash) != hashSize {
+		return Location{}, false
+	}
+	n := sort.Search(i.Len(), func(n int) bool {
+		return bytes.Compare(i.entry(n)[:hashSize], hash) >= 0
+	})
+	if n == i.Len() || !bytes.Equal(i.entry(n)[:hashSize], hash) {
+		return Location{}, false
+	}
+	_, location := i.at(n)
+	return location, true
+}
+
+// at returns the hash and location of entry n.
+func (i *Index) at(n int) ([]byte, Location) {
+	entry := i.entry(n)
+	return entry[:hashSize], Location{
+		BlockNum: i.base + uint64(binary.BigEndian.Uint16(entry[offsetStart:])),
+		Position: binary.BigEndian.Uint32(entry[posStart:]),
+	}
+}
+
+func (i *Index) entry(n int) []byte {
+	return i.entries[n*entrySize : (n+1)*entrySize]
+}
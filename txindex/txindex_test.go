@@ -0,0 +1,264 @@
+package txindex
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memoryStore is an in-memory ObjectStore.
+type memoryStore struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{objects: map[string][]byte{}}
+}
+
+func (s *memoryStore) OpenObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data, found := s.objects[name]
+	if !found {
+		return nil, errors.New("not found")
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memoryStore) WriteObject(ctx context.Context, base string, f io.Reader) error {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.objects[base] = data
+	return nil
+}
+
+func (s *memoryStore) FileExists(ctx context.Context, base string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, found := s.objects[base]
+	return found, nil
+}
+
+func (s *memoryStore) DeleteObject(ctx context.Context, base string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.objects, base)
+	return nil
+}
+
+func (s *memoryStore) Walk(ctx context.Context, prefix string, f func(filename string) error) error {
+	s.lock.Lock()
+	names := make([]string, 0, len(s.objects))
+	for name := range s.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	s.lock.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		if err := f(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hash(first, last byte) []byte {
+	out := make([]byte, 32)
+	out[0], out[31] = first, last
+	return out
+}
+
+func Test_FileName(t *testing.T) {
+	require.Equal(t, "0060000000.txidx", FileName(60000000))
+
+	base, ok := ParseFileName("0060000000.txidx")
+	require.True(t, ok)
+	require.Equal(t, uint64(60000000), base)
+
+	for _, name := range []string{"0060000000.dbin.zst", "0060000042.txidx", "60000000.txidx", "006000000a.txidx"} {
+		_, ok := ParseFileName(name)
+		require.False(t, ok, name)
+	}
+}
+
+func Test_Builder(t *testing.T) {
+	_, err := NewBuilder(42)
+	require.ErrorContains(t, err, "block 42 does not start a bundle of 100 blocks")
+
+	builder, err := NewBuilder(1000)
+	require.NoError(t, err)
+	require.ErrorContains(t, builder.Add([]byte{0xaa}, 1000, 0), "is 1 bytes, expected 32")
+	require.ErrorContains(t, builder.Add(hash(1, 0), 1100, 0), "block 1100 is not in the bundle of blocks 1000 to 1099")
+
+	require.NoError(t, builder.Add(hash(0xcc, 1), 1099, 7))
+	require.NoError(t, builder.Add(hash(0xaa, 2), 1000, 0))
+	require.NoError(t, builder.Add(hash(0xbb, 3), 1042, 65536))
+
+	data := builder.Bytes()
+	require.Len(t, data, headerSize+3*entrySize)
+
+	index, err := Parse(data)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1000), index.Base())
+	require.Equal(t, 3, index.Len())
+
+	for _, test := range []struct {
+		hash     []byte
+		expected Location
+	}{
+		{hash(0xaa, 2), Location{BlockNum: 1000, Position: 0}},
+		{hash(0xbb, 3), Location{BlockNum: 1042, Position: 65536}},
+		{hash(0xcc, 1), Location{BlockNum: 1099, Position: 7}},
+	} {
+		location, found := index.Lookup(test.hash)
+		require.True(t, found)
+		require.Equal(t, test.expected, location)
+	}
+
+	for _, missing := range [][]byte{hash(0x00, 0), hash(0xbb, 4), hash(0xff, 0), {0xaa}} {
+		_, found := index.Lookup(missing)
+		require.False(t, found)
+	}
+}
+
+func Test_Parse_Invalid(t *testing.T) {
+	_, err := Parse([]byte("FSTXIDX0"))
+	require.ErrorContains(t, err, "not a transaction index file")
+
+	builder, err := NewBuilder(0)
+	require.NoError(t, err)
+	require.NoError(t, builder.Add(hash(1, 1), 0, 0))
+	data := builder.Bytes()
+
+	_, err = Parse(data[:len(data)-1])
+	require.ErrorContains(t, err, "transaction index of bundle 0 is 57 bytes, expected 58 for 1 entries")
+}
+
+func Test_ShardOf(t *testing.T) {
+	require.Equal(t, 0, ShardOf(hash(0x00, 0xff)))
+	require.Equal(t, 0xab, ShardOf(append([]byte{0x0a, 0xbf}, make([]byte, 30)...)))
+	require.Equal(t, 0xff0, ShardOf(hash(0xff, 0xff)))
+	require.Equal(t, "shards/0ab.txidx", ShardFileName(0xab))
+	require.Equal(t, "shards/0ab/0060000000-0060099900.txidx", SegmentFileName(0xab, 60000000, 60099900))
+}
+
+func Test_Find(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+
+	var indexes []*Index
+	for base := uint64(0); base < 2000; base += BundleSize {
+		builder, err := NewBuilder(base)
+		require.NoError(t, err)
+		require.NoError(t, builder.Add(hash(byte(base/BundleSize), 0xff), base+5, uint32(base/BundleSize)))
+		index, err := Parse(builder.Bytes())
+		require.NoError(t, err)
+		indexes = append(indexes, index)
+	}
+	require.NoError(t, UpdateShards(ctx, store, indexes[:10], 4))
+	require.NoError(t, UpdateShards(ctx, store, indexes[10:], 4))
+	// Indexing bundles again replaces their transactions.
+	require.NoError(t, UpdateShards(ctx, store, indexes[12:14], 4))
+
+	shard, err := ReadShard(ctx, store, ShardOf(hash(13, 0xff)))
+	require.NoError(t, err)
+	require.Equal(t, 1, shard.Len())
+
+	location, err := Find(ctx, store, hash(13, 0xff))
+	require.NoError(t, err)
+	require.Equal(t, Location{BlockNum: 1305, Position: 13}, location)
+
+	_, err = Find(ctx, store, hash(13, 0x00))
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = Find(ctx, store, hash(0xee, 0x00))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	store.objects[ShardFileName(ShardOf(hash(7, 0)))] = []byte("FSTXSHD1")
+	_, err = Find(ctx, store, hash(7, 0xff))
+	require.ErrorContains(t, err, "parsing shards/070.txidx: not a transaction index shard file")
+}
+
+func Test_CompactShards(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+
+	var indexes []*Index
+	for base := uint64(0); base < 400; base += BundleSize {
+		builder, err := NewBuilder(base)
+		require.NoError(t, err)
+		// Bundle 300 is in the shard of bundle 0.
+		require.NoError(t, builder.Add(hash(byte(base/BundleSize%3), byte(base/BundleSize)), base+1, 0))
+		index, err := Parse(builder.Bytes())
+		require.NoError(t, err)
+		indexes = append(indexes, index)
+	}
+	require.NoError(t, UpdateShards(ctx, store, indexes[:2], 4))
+	require.NoError(t, UpdateShards(ctx, store, indexes[2:], 4))
+	require.Contains(t, store.objects, SegmentFileName(0, 0, 100))
+	require.Contains(t, store.objects, SegmentFileName(0, 200, 300))
+	require.NotContains(t, store.objects, ShardFileName(0))
+
+	merged, err := CompactShards(ctx, store, 4)
+	require.NoError(t, err)
+	require.Equal(t, 4, merged)
+	require.NotContains(t, store.objects, CompactionLockFileName)
+	for name := range store.objects {
+		require.NotContains(t, name, "-", "segment %s left", name)
+	}
+
+	shard, err := ReadShard(ctx, store, 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, shard.Len())
+	location, err := Find(ctx, store, hash(0, 3))
+	require.NoError(t, err)
+	require.Equal(t, Location{BlockNum: 301}, location)
+
+	// Segments written since are merged with the shard file.
+	require.NoError(t, UpdateShards(ctx, store, indexes[1:2], 4))
+	merged, err = CompactShards(ctx, store, 4)
+	require.NoError(t, err)
+	require.Equal(t, 1, merged)
+	shard, err = ReadShard(ctx, store, ShardOf(hash(1, 1)))
+	require.NoError(t, err)
+	require.Equal(t, 1, shard.Len())
+
+	store.objects[CompactionLockFileName] = []byte("pid 42 on host")
+	_, err = CompactShards(ctx, store, 4)
+	require.ErrorIs(t, err, ErrCompactionLocked)
+	require.ErrorContains(t, err, "held by pid 42 on host")
+}
+
+func Test_Exists(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+
+	exists, err := Exists(ctx, store, 1000)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	builder, err := NewBuilder(1000)
+	require.NoError(t, err)
+	require.NoError(t, Write(ctx, store, builder))
+
+	exists, err = Exists(ctx, store, 1000)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	index, err := Read(ctx, store, 1000)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1000), index.Base())
+}
@@ -6,3 +6,52 @@ type RPCEvents struct {
 	TransactionEventsXdr []string   `json:"transactionEventsXdr"`
 	ContractEventsXdr    [][]string `json:"contractEventsXdr"`
 }
+
+// Event types accepted by EventFilter.Type.
+const (
+	EventTypeContract = "contract"
+	EventTypeSystem   = "system"
+)
+
+// EventFilter matches events of Type emitted by any of ContractIDs whose
+// topics match any of Topics. Each topic filter is a list of base64 XDR
+// ScVal segments, "*" matches any single segment and a trailing "**"
+// any number of remaining ones.
+type EventFilter struct {
+	Type        string     `json:"type,omitempty"`
+	ContractIDs []string   `json:"contractIds,omitempty"`
+	Topics      [][]string `json:"topics,omitempty"`
+}
+
+// GetEventsParams selects events from StartLedger (inclusive) up to
+// EndLedger (exclusive, optional). When resuming with a pagination
+// cursor, StartLedger and EndLedger must be left at 0.
+type GetEventsParams struct {
+	StartLedger uint64        `json:"startLedger,omitempty"`
+	EndLedger   uint64        `json:"endLedger,omitempty"`
+	Filters     []EventFilter `json:"filters,omitempty"`
+	Pagination  *Pagination   `json:"pagination,omitempty"`
+}
+
+type Event struct {
+	Type                     string   `json:"type"`
+	Ledger                   uint64   `json:"ledger"`
+	LedgerClosedAt           string   `json:"ledgerClosedAt"`
+	ContractID               string   `json:"contractId"`
+	ID                       string   `json:"id"`
+	OperationIndex           uint32   `json:"operationIndex"`
+	TransactionIndex         uint32   `json:"transactionIndex"`
+	TxHash                   string   `json:"txHash"`
+	InSuccessfulContractCall bool     `json:"inSuccessfulContractCall"`
+	Topic                    []string `json:"topic"`
+	Value                    string   `json:"value"`
+}
+
+type GetEventsResult struct {
+	Events                []Event `json:"events"`
+	LatestLedger          uint64  `json:"latestLedger"`
+	LatestLedgerCloseTime string  `json:"latestLedgerCloseTime"`
+	OldestLedger          uint64  `json:"oldestLedger"`
+	OldestLedgerCloseTime string  `json:"oldestLedgerCloseTime"`
+	Cursor                string  `json:"cursor"`
+}
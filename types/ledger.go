@@ -1,5 +1,7 @@
 package types
 
+import "fmt"
+
 var _ error = (*RPCError)(nil)
 
 type RPCError struct {
@@ -38,9 +40,19 @@ type GetLatestLedgerResult struct {
 	ProtocolVersion int    `json:"protocolVersion"`
 	Sequence        int    `json:"sequence"` // this is the only actual field of interest for this call
 
-	// CloseTime       string `json:"closeTime"`
-	// HeaderXdr       string `json:"headerXdr"`
-	// MetadataXdr     string `json:"metadataXdr"`
+	// Sent by stellar-rpc >= 23, declared so they are not reported as
+	// unknown fields.
+	CloseTime   string `json:"closeTime,omitempty"`
+	HeaderXdr   string `json:"headerXdr,omitempty"`
+	MetadataXdr string `json:"metadataXdr,omitempty"`
+}
+
+// Validate checks the fields the fetcher relies on.
+func (r *GetLatestLedgerResult) Validate() error {
+	if r.Sequence <= 0 {
+		return fmt.Errorf("getLatestLedger: missing sequence")
+	}
+	return nil
 }
 
 type LedgerRequest struct {
@@ -78,7 +90,35 @@ type GetLedgersResult struct {
 	Ledgers               []Ledger `json:"ledgers"`
 	LatestLedger          uint64   `json:"latestLedger"`
 	LatestLedgerCloseTime uint64   `json:"latestLedgerCloseTime"`
-	Oldestledger          uint64   `json:"oldestLedger"`
+	OldestLedger          uint64   `json:"oldestLedger"`
 	OldestLedgerCloseTime uint64   `json:"oldestLedgerCloseTime"`
 	Cursor                string   `json:"cursor"`
 }
+
+// Validate checks the fields the fetcher relies on.
+func (l *Ledger) Validate() error {
+	switch {
+	case l.Sequence == 0:
+		return fmt.Errorf("ledger: missing sequence")
+	case l.Hash == "":
+		return fmt.Errorf("ledger %d: missing hash", l.Sequence)
+	case l.LedgerCloseTime == "":
+		return fmt.Errorf("ledger %d: missing ledgerCloseTime", l.Sequence)
+	case l.MetadataXdr == "":
+		return fmt.Errorf("ledger %d: missing metadataXdr", l.Sequence)
+	}
+	return nil
+}
+
+// Validate checks the fields the fetcher relies on.
+func (r *GetLedgersResult) Validate() error {
+	if r.LatestLedger == 0 {
+		return fmt.Errorf("getLedgers: missing latestLedger")
+	}
+	for i := range r.Ledgers {
+		if err := r.Ledgers[i].Validate(); err != nil {
+			return fmt.Errorf("getLedgers: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package types
+
+type GetLedgerEntriesParams struct {
+	// Keys are base64 encoded xdr.LedgerKey values.
+	Keys []string `json:"keys"`
+}
+
+type LedgerEntry struct {
+	Key                   string  `json:"key"`
+	Xdr                   string  `json:"xdr"`
+	LastModifiedLedgerSeq uint32  `json:"lastModifiedLedgerSeq"`
+	LiveUntilLedgerSeq    *uint32 `json:"liveUntilLedgerSeq,omitempty"`
+	ExtXdr                string  `json:"extXdr,omitempty"`
+}
+
+// GetLedgerEntriesResult only lists the requested keys that exist.
+type GetLedgerEntriesResult struct {
+	Entries      []LedgerEntry `json:"entries"`
+	LatestLedger uint64        `json:"latestLedger"`
+}
@@ -0,0 +1,84 @@
+package types
+
+import "encoding/json"
+
+type GetNetworkResult struct {
+	FriendbotURL    string `json:"friendbotUrl,omitempty"`
+	Passphrase      string `json:"passphrase"`
+	ProtocolVersion int    `json:"protocolVersion"`
+}
+
+type GetHealthResult struct {
+	Status                string `json:"status"`
+	LatestLedger          uint64 `json:"latestLedger"`
+	OldestLedger          uint64 `json:"oldestLedger"`
+	LedgerRetentionWindow uint64 `json:"ledgerRetentionWindow"`
+}
+
+type GetVersionInfoResult struct {
+	Version            string `json:"version"`
+	CommitHash         string `json:"commitHash"`
+	BuildTimestamp     string `json:"buildTimestamp"`
+	CaptiveCoreVersion string `json:"captiveCoreVersion"`
+	ProtocolVersion    int    `json:"protocolVersion"`
+}
+
+// UnmarshalJSON also accepts the snake_case field names stellar-rpc used
+// before v22 (commit_hash, build_time_stamp, captive_core_version and
+// protocol_version).
+func (r *GetVersionInfoResult) UnmarshalJSON(data []byte) error {
+	type current GetVersionInfoResult
+	var decoded struct {
+		current
+		LegacyCommitHash         string `json:"commit_hash"`
+		LegacyBuildTimestamp     string `json:"build_time_stamp"`
+		LegacyCaptiveCoreVersion string `json:"captive_core_version"`
+		LegacyProtocolVersion    int    `json:"protocol_version"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*r = GetVersionInfoResult(decoded.current)
+	if r.CommitHash == "" {
+		r.CommitHash = decoded.LegacyCommitHash
+	}
+	if r.BuildTimestamp == "" {
+		r.BuildTimestamp = decoded.LegacyBuildTimestamp
+	}
+	if r.CaptiveCoreVersion == "" {
+		r.CaptiveCoreVersion = decoded.LegacyCaptiveCoreVersion
+	}
+	if r.ProtocolVersion == 0 {
+		r.ProtocolVersion = decoded.LegacyProtocolVersion
+	}
+	return nil
+}
+
+// FeeDistribution holds the inclusion fee percentiles, in stroops, of
+// the transactions in the last LedgerCount ledgers. stellar-rpc encodes
+// the fees and the transaction count as decimal strings.
+type FeeDistribution struct {
+	Max              string `json:"max"`
+	Min              string `json:"min"`
+	Mode             string `json:"mode"`
+	P10              string `json:"p10"`
+	P20              string `json:"p20"`
+	P30              string `json:"p30"`
+	P40              string `json:"p40"`
+	P50              string `json:"p50"`
+	P60              string `json:"p60"`
+	P70              string `json:"p70"`
+	P80              string `json:"p80"`
+	P90              string `json:"p90"`
+	P95              string `json:"p95"`
+	P99              string `json:"p99"`
+	TransactionCount string `json:"transactionCount"`
+	LedgerCount      uint32 `json:"ledgerCount"`
+}
+
+type GetFeeStatsResult struct {
+	SorobanInclusionFee FeeDistribution `json:"sorobanInclusionFee"`
+	InclusionFee        FeeDistribution `json:"inclusionFee"`
+	LatestLedger        uint64          `json:"latestLedger"`
+}
@@ -2,7 +2,7 @@ package types
 
 type Pagination struct {
 	Limit  int    `json:"limit"`
-	Cursor string `json:"cursor"`
+	Cursor string `json:"cursor,omitempty"`
 }
 
 func NewPagination(limit int, cursor string) *Pagination {
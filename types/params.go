@@ -1,7 +1,7 @@
 package types
 
 type Params struct {
-	StartLedger uint64      `json:"startLedger"`
+	StartLedger uint64      `json:"startLedger,omitempty"`
 	Pagination  *Pagination `json:"pagination"`
 }
 
@@ -10,6 +10,8 @@ func NewParams(startLedger uint64, pagination *Pagination) Params {
 		Pagination: pagination,
 	}
 
+	// stellar-rpc rejects requests carrying both a cursor and a start
+	// ledger, the cursor already encodes where to resume from.
 	if pagination.Cursor == "" {
 		params.StartLedger = startLedger
 	}
@@ -0,0 +1,28 @@
+package types
+
+// Request is a stellar-rpc JSON-RPC 2.0 request. Methods without
+// parameters leave Params nil so the field is omitted.
+type Request struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int    `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+func NewRequest(method string, params any) Request {
+	return Request{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  method,
+		Params:  params,
+	}
+}
+
+// Response is a stellar-rpc JSON-RPC 2.0 response carrying a Result of
+// type R, or an Error.
+type Response[R any] struct {
+	JSONRPC string    `json:"jsonrpc"`
+	ID      int       `json:"id"`
+	Error   *RPCError `json:"error,omitempty"`
+	Result  R         `json:"result"`
+}
@@ -0,0 +1,46 @@
+package types
+
+type SimulateTransactionParams struct {
+	// Transaction is a base64 encoded xdr.TransactionEnvelope holding a
+	// single InvokeHostFunction, ExtendFootprintTTL or RestoreFootprint
+	// operation.
+	Transaction    string          `json:"transaction"`
+	ResourceConfig *ResourceConfig `json:"resourceConfig,omitempty"`
+	// AuthMode is one of "enforce", "record" or "record_allow_nonroot",
+	// left empty stellar-rpc picks based on the transaction.
+	AuthMode string `json:"authMode,omitempty"`
+}
+
+type ResourceConfig struct {
+	InstructionLeeway uint64 `json:"instructionLeeway"`
+}
+
+type SimulateHostFunctionResult struct {
+	Auth []string `json:"auth"`
+	Xdr  string   `json:"xdr"`
+}
+
+type RestorePreamble struct {
+	TransactionData string `json:"transactionData"`
+	MinResourceFee  string `json:"minResourceFee"`
+}
+
+type LedgerEntryChange struct {
+	Type   string `json:"type"`
+	Key    string `json:"key"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// SimulateTransactionResult reports a failed simulation through Error,
+// the JSON-RPC call itself still succeeds.
+type SimulateTransactionResult struct {
+	LatestLedger    uint64                       `json:"latestLedger"`
+	MinResourceFee  string                       `json:"minResourceFee,omitempty"`
+	TransactionData string                       `json:"transactionData,omitempty"`
+	Results         []SimulateHostFunctionResult `json:"results,omitempty"`
+	Events          []string                     `json:"events,omitempty"`
+	RestorePreamble *RestorePreamble             `json:"restorePreamble,omitempty"`
+	StateChanges    []LedgerEntryChange          `json:"stateChanges,omitempty"`
+	Error           string                       `json:"error,omitempty"`
+}
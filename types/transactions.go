@@ -1,19 +1,21 @@
 package types
 
 import (
+	"fmt"
+
 	xdrTypes "github.com/stellar/go-stellar-sdk/xdr"
 	pbstellar "github.com/streamingfast/firehose-stellar/pb/sf/stellar/type/v1"
 )
 
-type GetTransactionsRquest struct {
+type GetTransactionsRequest struct {
 	JSONRPC string `json:"jsonrpc"`
 	ID      int    `json:"id"`
 	Method  string `json:"method"`
 	Params  Params `json:"params"`
 }
 
-func NewGetTransactionsRquest(startLedger uint64, pagination *Pagination) GetTransactionsRquest {
-	return GetTransactionsRquest{
+func NewGetTransactionsRequest(startLedger uint64, pagination *Pagination) GetTransactionsRequest {
+	return GetTransactionsRequest{
 		JSONRPC: "2.0",
 		ID:      1,
 		Method:  "getTransactions",
@@ -56,19 +58,44 @@ type GetTransactionResponse struct {
 }
 
 type GetTransactionResult struct {
-	LatestLedger          uint64     `json:"latestLedger"`
-	LatestLedgerCloseTime string     `json:"latestLedgerCloseTime"`
-	OldestLedger          uint64     `json:"oldestLedger"`
-	OldestLedgerCloseTime string     `json:"oldestLedgerCloseTime"`
-	Status                string     `json:"status"`
-	TxHash                string     `json:"txHash"`
-	ApplicationOrder      int        `json:"applicationOrder"`
-	FeeBump               bool       `json:"feeBump"`
-	EnvelopeXdr           string     `json:"envelopeXdr"`
-	ResultXdr             string     `json:"resultXdr"`
-	Events                *RPCEvents `json:"events"`
-	Ledger                uint64     `json:"ledger"`
-	CreatedAt             string     `json:"createdAt"`
+	LatestLedger          uint64 `json:"latestLedger"`
+	LatestLedgerCloseTime string `json:"latestLedgerCloseTime"`
+	OldestLedger          uint64 `json:"oldestLedger"`
+	OldestLedgerCloseTime string `json:"oldestLedgerCloseTime"`
+	Status                string `json:"status"`
+	TxHash                string `json:"txHash"`
+	ApplicationOrder      int    `json:"applicationOrder"`
+	FeeBump               bool   `json:"feeBump"`
+	EnvelopeXdr           string `json:"envelopeXdr"`
+	ResultXdr             string `json:"resultXdr"`
+	ResultMetaXdr         string `json:"resultMetaXdr,omitempty"`
+	// deprecated, check the Events field instead
+	DiagnosticEventsXdr []string   `json:"diagnosticEventsXdr,omitempty"`
+	Events              *RPCEvents `json:"events"`
+	Ledger              uint64     `json:"ledger"`
+	CreatedAt           string     `json:"createdAt"`
+}
+
+// Transaction statuses reported by getTransaction and getTransactions.
+const (
+	TransactionStatusSuccess  = "SUCCESS"
+	TransactionStatusFailed   = "FAILED"
+	TransactionStatusNotFound = "NOT_FOUND"
+)
+
+// Validate checks the fields the fetcher relies on. A NOT_FOUND result
+// only carries the status and the retention window.
+func (r *GetTransactionResult) Validate() error {
+	switch r.Status {
+	case "":
+		return fmt.Errorf("getTransaction: missing status")
+	case TransactionStatusNotFound:
+		return nil
+	}
+	if r.EnvelopeXdr == "" || r.ResultXdr == "" {
+		return fmt.Errorf("getTransaction: missing envelopeXdr or resultXdr for status %s", r.Status)
+	}
+	return nil
 }
 
 type Transaction struct {
@@ -78,6 +105,7 @@ type Transaction struct {
 	FeeBump          bool   `json:"feeBump"`
 	EnvelopeXdr      string `json:"envelopeXdr"`
 	ResultXdr        string `json:"resultXdr"`
+	ResultMetaXdr    string `json:"resultMetaXdr,omitempty"`
 	// deprecated, check the Events field instead
 	DiagnosticEventsXdr []string   `json:"diagnosticEventsXdr"`
 	Events              *RPCEvents `json:"events"`
@@ -85,6 +113,18 @@ type Transaction struct {
 	CreatedAt           uint64     `json:"createdAt"`
 }
 
+// Validate checks the fields the fetcher relies on. txHash is only sent
+// since stellar-rpc v22, it can be recomputed from the envelope.
+func (t *Transaction) Validate() error {
+	switch {
+	case t.Status == "":
+		return fmt.Errorf("transaction in ledger %d: missing status", t.Ledger)
+	case t.EnvelopeXdr == "" || t.ResultXdr == "":
+		return fmt.Errorf("transaction in ledger %d: missing envelopeXdr or resultXdr", t.Ledger)
+	}
+	return nil
+}
+
 type GetTransactionsResult struct {
 	Transactions               []Transaction `json:"transactions"`
 	LatestLedger               uint64        `json:"latestLedger"`
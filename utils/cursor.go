@@ -1,47 +1,40 @@
 package utils
 
 import (
-	"fmt"
-	"strconv"
-	"strings"
+	"github.com/streamingfast/firehose-stellar/toid"
 )
 
+// Cursor is a decoded stellar-rpc cursor: the TOID of an operation and,
+// for events, the index of the event. See package toid.
 type Cursor struct {
 	LedgerNumber     uint32
-	TransactionIndex uint16
-	OperationIndex   uint16
+	TransactionIndex uint32
+	OperationIndex   uint32
 	Suffix           uint64
 }
 
 func DecodeCursor(cursor string) (*Cursor, error) {
-	parts := strings.Split(cursor, "-")
-
-	// Parse the primary part (TOID)
-	toid, err := strconv.ParseUint(parts[0], 10, 64)
+	id, err := toid.ParseEventID(cursor)
 	if err != nil {
-		return nil, fmt.Errorf("invalid TOID: %s", parts[0])
+		return nil, err
 	}
 
-	// Extract the components from the TOID
-	ledgerNumber := uint32(toid >> 32)
-	transactionIndex := uint16((toid >> 16) & 0xFFFF)
-	operationIndex := uint16(toid & 0xFFFF)
+	return &Cursor{
+		LedgerNumber:     id.LedgerSequence,
+		TransactionIndex: id.TransactionOrder,
+		OperationIndex:   id.OperationOrder,
+		Suffix:           uint64(id.EventIndex),
+	}, nil
+}
 
-	c := &Cursor{
-		LedgerNumber:     ledgerNumber,
-		TransactionIndex: transactionIndex,
-		OperationIndex:   operationIndex,
+// String encodes c back into a stellar-rpc cursor.
+func (c *Cursor) String() string {
+	id := toid.EventID{
+		ID:         toid.ID{LedgerSequence: c.LedgerNumber, TransactionOrder: c.TransactionIndex, OperationOrder: c.OperationIndex},
+		EventIndex: uint32(c.Suffix),
 	}
-
-	var suffix uint64
-	if len(parts) == 2 {
-		// Parse the suffix if it exists (in the case of events)
-		suffix, err = strconv.ParseUint(parts[1], 10, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid suffix: %s", parts[1])
-		}
-		c.Suffix = suffix
+	if c.Suffix == 0 {
+		return id.ID.String()
 	}
-
-	return c, nil
+	return id.String()
 }
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -18,8 +19,8 @@ func Test_DecodeCursor(t *testing.T) {
 			cursor: "0237311318360358912-0000000005",
 			expected: &Cursor{
 				LedgerNumber:     55253347,
-				TransactionIndex: 12,
-				OperationIndex:   32768,
+				TransactionIndex: 200,
+				OperationIndex:   0,
 				Suffix:           5,
 			},
 		},
@@ -28,8 +29,8 @@ func Test_DecodeCursor(t *testing.T) {
 			cursor: "237311309769850881",
 			expected: &Cursor{
 				LedgerNumber:     55253345,
-				TransactionIndex: 3,
-				OperationIndex:   49153,
+				TransactionIndex: 60,
+				OperationIndex:   1,
 				Suffix:           0,
 			},
 		},
@@ -53,6 +54,7 @@ func Test_DecodeCursor(t *testing.T) {
 			require.Equal(t, test.expected.TransactionIndex, c.TransactionIndex)
 			require.Equal(t, test.expected.OperationIndex, c.OperationIndex)
 			require.Equal(t, test.expected.Suffix, c.Suffix)
+			require.Equal(t, strings.TrimLeft(test.cursor, "0"), strings.TrimLeft(c.String(), "0"))
 		})
 	}
 }